@@ -0,0 +1,688 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"time"
+
+	"drm-app/app/expr"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the complete application configuration. Values are resolved from the built-in
+// defaults, then an optional YAML or TOML file, then environment variables, then command-line
+// flags; each source overrides the previous one.
+type Config struct {
+	Server      ServerConfig      `yaml:"server" toml:"server"`
+	Database    DatabaseConfig    `yaml:"database" toml:"database"`
+	Auth        AuthConfig        `yaml:"auth" toml:"auth"`
+	Policy      PolicyConfig      `yaml:"policy" toml:"policy"`
+	LLM         LLMConfig         `yaml:"llm" toml:"llm"`
+	Logging     LoggingConfig     `yaml:"logging" toml:"logging"`
+	Idempotency IdempotencyConfig `yaml:"idempotency" toml:"idempotency"`
+}
+
+type ServerConfig struct {
+	Addr        string        `yaml:"addr" toml:"addr" env:"SERVER_ADDR"`
+	AppName     string        `yaml:"app_name" toml:"app_name" env:"SERVER_APP_NAME"`
+	ReadTimeout time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+	IdleTimeout time.Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT"`
+	// ShutdownDelay keeps serving after readiness flips so load balancers can stop routing first
+	ShutdownDelay time.Duration `yaml:"shutdown_delay" toml:"shutdown_delay" env:"SERVER_SHUTDOWN_DELAY"`
+	// ShutdownTimeout bounds how long in-flight requests and queued deliveries may take to drain
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SERVER_SHUTDOWN_TIMEOUT"`
+}
+
+type DatabaseConfig struct {
+	Host            string        `yaml:"host" toml:"host" env:"DB_HOST"`
+	Port            int           `yaml:"port" toml:"port" env:"DB_PORT"`
+	User            string        `yaml:"user" toml:"user" env:"DB_USER"`
+	Password        string        `yaml:"password" toml:"password" env:"DB_PASSWORD"`
+	Name            string        `yaml:"name" toml:"name" env:"DB_NAME"`
+	SSLMode         string        `yaml:"sslmode" toml:"sslmode" env:"DB_SSLMODE"`
+	MaxConns        int32         `yaml:"max_conns" toml:"max_conns" env:"DB_MAX_CONNS"`
+	MinConns        int32         `yaml:"min_conns" toml:"min_conns" env:"DB_MIN_CONNS"`
+	MaxConnLifetime time.Duration `yaml:"max_conn_lifetime" toml:"max_conn_lifetime" env:"DB_MAX_CONN_LIFETIME"`
+	MaxConnIdleTime time.Duration `yaml:"max_conn_idle_time" toml:"max_conn_idle_time" env:"DB_MAX_CONN_IDLE_TIME"`
+	ConnectTimeout  time.Duration `yaml:"connect_timeout" toml:"connect_timeout" env:"DB_CONNECT_TIMEOUT"`
+	ConnectRetries  int           `yaml:"connect_retries" toml:"connect_retries" env:"DB_CONNECT_RETRIES"`
+	RetryDelay      time.Duration `yaml:"retry_delay" toml:"retry_delay" env:"DB_RETRY_DELAY"`
+}
+
+// AuthConfig lists the static bearer tokens and service signing keys accepted by the AuthAgent
+// and configures the accounts users sign in to with a password or through an OpenID Connect
+// provider
+type AuthConfig struct {
+	Tokens   []TokenConfig `yaml:"tokens" toml:"tokens"`
+	Signing  SigningConfig `yaml:"signing" toml:"signing"`
+	Accounts AccountConfig `yaml:"accounts" toml:"accounts"`
+	OIDC     OIDCConfig    `yaml:"oidc" toml:"oidc"`
+}
+
+// SigningConfig configures requests services sign with a shared secret instead of sending a
+// token. Signatures are accepted while their timestamp is within ClockSkew of the server's
+// clock, and each nonce only once in that window. In memory, at most NonceCacheSize nonces are
+// remembered; Policy.LimitStore "postgres" shares them between replicas instead.
+type SigningConfig struct {
+	ClockSkew      time.Duration      `yaml:"clock_skew" toml:"clock_skew" env:"AUTH_SIGNING_CLOCK_SKEW"`
+	NonceCacheSize int                `yaml:"nonce_cache_size" toml:"nonce_cache_size" env:"AUTH_SIGNING_NONCE_CACHE_SIZE"`
+	Keys           []ServiceKeyConfig `yaml:"keys" toml:"keys"`
+}
+
+// ServiceKeyConfig is the secret of one service. Requests signed with it act as the user
+// described as for tokens.
+type ServiceKeyConfig struct {
+	KeyID      string            `yaml:"key_id" toml:"key_id"`
+	Secret     string            `yaml:"secret" toml:"secret"`
+	UserID     string            `yaml:"user_id" toml:"user_id"`
+	Name       string            `yaml:"name" toml:"name"`
+	Tenant     string            `yaml:"tenant" toml:"tenant"`
+	Role       string            `yaml:"role" toml:"role"`
+	Roles      []string          `yaml:"roles" toml:"roles"`
+	Attributes map[string]string `yaml:"attributes" toml:"attributes"`
+}
+
+// MinServiceSecretLength is the length below which service secrets are too easy to guess
+const MinServiceSecretLength = 32
+
+// AccountConfig configures password accounts. When Registration is on, anyone may register
+// an account, which joins Tenant with Role. Signing
+// in issues an access token valid for AccessTTL and a refresh token valid for RefreshTTL;
+// MaxFailedLogins wrong passwords in a row lock the account for LockoutDuration.
+type AccountConfig struct {
+	Registration      bool          `yaml:"registration" toml:"registration" env:"AUTH_REGISTRATION"`
+	Tenant            string        `yaml:"tenant" toml:"tenant" env:"AUTH_ACCOUNT_TENANT"`
+	Role              string        `yaml:"role" toml:"role" env:"AUTH_ACCOUNT_ROLE"`
+	MinPasswordLength int           `yaml:"min_password_length" toml:"min_password_length" env:"AUTH_MIN_PASSWORD_LENGTH"`
+	AccessTTL         time.Duration `yaml:"access_ttl" toml:"access_ttl" env:"AUTH_ACCESS_TTL"`
+	RefreshTTL        time.Duration `yaml:"refresh_ttl" toml:"refresh_ttl" env:"AUTH_REFRESH_TTL"`
+	MaxFailedLogins   int           `yaml:"max_failed_logins" toml:"max_failed_logins" env:"AUTH_MAX_FAILED_LOGINS"`
+	LockoutDuration   time.Duration `yaml:"lockout_duration" toml:"lockout_duration" env:"AUTH_LOCKOUT_DURATION"`
+}
+
+// OIDCConfig configures sign-in through an OpenID Connect provider with the authorization code
+// flow. Users are provisioned in Tenant on their first sign-in and get the roles Roles maps the
+// values of their GroupsClaim to, or DefaultRole when none is mapped; users with neither are
+// refused. Their sessions last as configured for accounts.
+type OIDCConfig struct {
+	Enabled      bool   `yaml:"enabled" toml:"enabled" env:"OIDC_ENABLED"`
+	Issuer       string `yaml:"issuer" toml:"issuer" env:"OIDC_ISSUER"`
+	ClientID     string `yaml:"client_id" toml:"client_id" env:"OIDC_CLIENT_ID"`
+	ClientSecret string `yaml:"client_secret" toml:"client_secret" env:"OIDC_CLIENT_SECRET"`
+	// RedirectURL is where the provider sends users back to, the /auth/oidc/callback endpoint
+	RedirectURL string              `yaml:"redirect_url" toml:"redirect_url" env:"OIDC_REDIRECT_URL"`
+	Scopes      []string            `yaml:"scopes" toml:"scopes"`
+	GroupsClaim string              `yaml:"groups_claim" toml:"groups_claim" env:"OIDC_GROUPS_CLAIM"`
+	Roles       map[string][]string `yaml:"roles" toml:"roles"`
+	DefaultRole string              `yaml:"default_role" toml:"default_role" env:"OIDC_DEFAULT_ROLE"`
+	Tenant      string              `yaml:"tenant" toml:"tenant" env:"OIDC_TENANT"`
+}
+
+// TokenConfig is one bearer token. Its user belongs to Tenant, or to the default tenant, and
+// holds Role, or every role in Roles; per-role settings such as limits and page sizes come
+// from the first role that has them. Attributes describe the user to policy conditions, e.g.
+// department: sales.
+type TokenConfig struct {
+	Token      string            `yaml:"token" toml:"token"`
+	UserID     string            `yaml:"user_id" toml:"user_id"`
+	Name       string            `yaml:"name" toml:"name"`
+	Tenant     string            `yaml:"tenant" toml:"tenant"`
+	Role       string            `yaml:"role" toml:"role"`
+	Roles      []string          `yaml:"roles" toml:"roles"`
+	Attributes map[string]string `yaml:"attributes" toml:"attributes"`
+}
+
+// DefaultTenant owns the data and users of deployments that do not name tenants
+const DefaultTenant = "default"
+
+// tenantName restricts tenant names to lowercase letters, digits, dashes and underscores
+var tenantName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// serviceKeyID restricts key IDs to what fits in a header unquoted
+var serviceKeyID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// TenantName returns the tenant of the token's user
+func (t TokenConfig) TenantName() string {
+	if t.Tenant == "" {
+		return DefaultTenant
+	}
+	return t.Tenant
+}
+
+// RoleList returns the roles of the token's user in order
+func (t TokenConfig) RoleList() []string {
+	if t.Role != "" {
+		return append([]string{t.Role}, t.Roles...)
+	}
+	return t.Roles
+}
+
+// Identity returns the service's user as described by a token without one
+func (k ServiceKeyConfig) Identity() TokenConfig {
+	return TokenConfig{UserID: k.UserID, Name: k.Name, Tenant: k.Tenant, Role: k.Role, Roles: k.Roles, Attributes: k.Attributes}
+}
+
+// Wildcard matches every entity or action in policy rules
+const Wildcard = "*"
+
+// ConditionVariables are the variables policy conditions may read: the user with its id,
+// roles and attributes, the command data, and the stored record the command targets
+var ConditionVariables = []string{"user", "data", "record"}
+
+// reservedAttributes are user fields that attributes may not shadow in conditions
+var reservedAttributes = map[string]bool{"id": true, "roles": true}
+
+// CompileCondition compiles a policy condition
+func CompileCondition(source string) (*expr.Program, error) {
+	return expr.Compile(source, ConditionVariables...)
+}
+
+// PolicyConfig maps role -> entity -> allowed actions, role -> inherited roles, role -> entity
+// -> denied actions, role -> entity -> action -> condition, role -> request limits, role ->
+// list page sizes and role -> most records one bulk update or delete may change. A role has
+// its own permissions and those of the roles it inherits, less any action denied to it or to
+// a role it inherits. A permission with a condition only allows commands the condition holds for.
+type PolicyConfig struct {
+	Roles      map[string]map[string][]string          `yaml:"roles" toml:"roles"`
+	Inherits   map[string][]string                     `yaml:"inherits" toml:"inherits"`
+	Deny       map[string]map[string][]string          `yaml:"deny" toml:"deny"`
+	Conditions map[string]map[string]map[string]string `yaml:"conditions" toml:"conditions"`
+	// Tenants replace the access rules, request limits, page sizes and bulk limits here for the
+	// users of individual tenants
+	Tenants    map[string]TenantPolicyConfig `yaml:"tenants" toml:"tenants"`
+	Limits     map[string]RateLimitConfig    `yaml:"limits" toml:"limits"`
+	PageSizes  map[string]PageSizeConfig     `yaml:"page_sizes" toml:"page_sizes"`
+	BulkLimits map[string]int                `yaml:"bulk_limits" toml:"bulk_limits"`
+	// LimitStore keeps rate limit and quota counters and the nonces of signed requests:
+	// "memory" for a single instance, "postgres" to share them between replicas
+	LimitStore string `yaml:"limit_store" toml:"limit_store" env:"POLICY_LIMIT_STORE"`
+}
+
+// TenantPolicyConfig holds the access rules, request limits, page sizes and bulk limits of one
+// tenant. Each set it defines replaces the deployment-wide one for the tenant's users; the
+// others are shared.
+type TenantPolicyConfig struct {
+	Roles      map[string]map[string][]string          `yaml:"roles" toml:"roles"`
+	Inherits   map[string][]string                     `yaml:"inherits" toml:"inherits"`
+	Deny       map[string]map[string][]string          `yaml:"deny" toml:"deny"`
+	Conditions map[string]map[string]map[string]string `yaml:"conditions" toml:"conditions"`
+	Limits     map[string]RateLimitConfig              `yaml:"limits" toml:"limits"`
+	PageSizes  map[string]PageSizeConfig               `yaml:"page_sizes" toml:"page_sizes"`
+	BulkLimits map[string]int                          `yaml:"bulk_limits" toml:"bulk_limits"`
+}
+
+// ForTenant returns the policy that applies to the users of tenant
+func (p PolicyConfig) ForTenant(tenant string) PolicyConfig {
+	effective := p
+	effective.Tenants = nil
+	override, exists := p.Tenants[tenant]
+	if !exists {
+		return effective
+	}
+	if override.Roles != nil {
+		effective.Roles = override.Roles
+		// Inheritance names the deployment-wide roles, so tenants with roles of their own
+		// also inherit only as they configure
+		effective.Inherits = override.Inherits
+	} else if override.Inherits != nil {
+		effective.Inherits = override.Inherits
+	}
+	if override.Deny != nil {
+		effective.Deny = override.Deny
+	}
+	if override.Conditions != nil {
+		effective.Conditions = override.Conditions
+	}
+	if override.Limits != nil {
+		effective.Limits = override.Limits
+	}
+	if override.PageSizes != nil {
+		effective.PageSizes = override.PageSizes
+	}
+	if override.BulkLimits != nil {
+		effective.BulkLimits = override.BulkLimits
+	}
+	return effective
+}
+
+// RateLimitConfig is a token bucket refilled at RequestsPerMinute and holding up to Burst
+// requests, plus a daily cap on creates. Zero values mean unlimited; roles without an entry
+// are not limited.
+type RateLimitConfig struct {
+	RequestsPerMinute float64 `yaml:"requests_per_minute" toml:"requests_per_minute"`
+	Burst             int     `yaml:"burst" toml:"burst"`
+	DailyCreates      int     `yaml:"daily_creates" toml:"daily_creates"`
+}
+
+// PageSizeConfig sets how many records a list read returns when no limit is given, and the
+// most it may return. Roles without an entry use the built-in sizes of 50 and 100.
+type PageSizeConfig struct {
+	Default int `yaml:"default" toml:"default"`
+	Max     int `yaml:"max" toml:"max"`
+}
+
+type LLMConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled" env:"LLM_ENABLED"`
+	// Host is the Ollama base URL; when empty the client falls back to OLLAMA_HOST
+	Host             string        `yaml:"host" toml:"host" env:"LLM_HOST"`
+	Model            string        `yaml:"model" toml:"model" env:"LLM_MODEL"`
+	Timeout          time.Duration `yaml:"timeout" toml:"timeout" env:"LLM_TIMEOUT"`
+	HeartbeatTimeout time.Duration `yaml:"heartbeat_timeout" toml:"heartbeat_timeout" env:"LLM_HEARTBEAT_TIMEOUT"`
+}
+
+// IdempotencyConfig sets how long responses to Idempotency-Key requests are kept for replay
+type IdempotencyConfig struct {
+	Window time.Duration `yaml:"window" toml:"window" env:"IDEMPOTENCY_WINDOW"`
+}
+
+type LoggingConfig struct {
+	Level string `yaml:"level" toml:"level" env:"LOG_LEVEL"`
+}
+
+var (
+	validActions = map[string]bool{
+		"create": true, "read": true, "update": true, "delete": true,
+		"aggregate": true, "search": true, "import": true, "export": true,
+		"upsert": true, "bulk_update": true, "bulk_delete": true, "impersonate": true,
+	}
+	validSSLModes = map[string]bool{
+		"disable": true, "allow": true, "prefer": true, "require": true, "verify-ca": true, "verify-full": true,
+	}
+)
+
+// Actions lists the actions policy rules may name, in order
+func Actions() []string {
+	actions := make([]string, 0, len(validActions))
+	for action := range validActions {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	return actions
+}
+
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			AppName:         "DRM Core",
+			ReadTimeout:     30 * time.Second,
+			IdleTimeout:     2 * time.Minute,
+			ShutdownDelay:   0,
+			ShutdownTimeout: 20 * time.Second,
+		},
+		Database: DatabaseConfig{
+			Host:            "localhost",
+			Port:            5432,
+			User:            "postgres",
+			Name:            "postgres",
+			SSLMode:         "disable",
+			MaxConns:        10,
+			MinConns:        0,
+			MaxConnLifetime: time.Hour,
+			MaxConnIdleTime: 30 * time.Minute,
+			ConnectTimeout:  10 * time.Second,
+			ConnectRetries:  5,
+			RetryDelay:      2 * time.Second,
+		},
+		Auth: AuthConfig{
+			Tokens: []TokenConfig{
+				{Token: "admin-token", UserID: "1", Name: "Admin", Role: "admin"},
+				{Token: "user-token", UserID: "2", Name: "User", Role: "user"},
+				{Token: "guest-token", UserID: "3", Name: "Guest", Role: "guest"},
+			},
+			Signing: SigningConfig{
+				ClockSkew:      5 * time.Minute,
+				NonceCacheSize: 100000,
+			},
+			Accounts: AccountConfig{
+				Registration:      false,
+				Tenant:            DefaultTenant,
+				Role:              "user",
+				MinPasswordLength: 10,
+				AccessTTL:         15 * time.Minute,
+				RefreshTTL:        30 * 24 * time.Hour,
+				MaxFailedLogins:   5,
+				LockoutDuration:   15 * time.Minute,
+			},
+			OIDC: OIDCConfig{
+				Scopes:      []string{"openid", "profile", "email"},
+				GroupsClaim: "groups",
+				Tenant:      DefaultTenant,
+			},
+		},
+		Policy: PolicyConfig{
+			Roles: map[string]map[string][]string{
+				"admin": {
+					"user":    {"create", "delete", "aggregate", "import", "export", "upsert", "bulk_update", "bulk_delete", "impersonate"},
+					"product": {"create", "update", "delete", "aggregate", "import", "export", "upsert", "bulk_update", "bulk_delete"},
+					"order":   {"update", "delete", "aggregate", "import", "export", "bulk_update", "bulk_delete"},
+					"webhook": {"create", "read", "update", "delete"},
+					"system":  {"read"},
+					"policy":  {"read"},
+				},
+				"user": {
+					"user":  {"read", "update"},
+					"order": {"create", "read"},
+				},
+				"guest": {
+					"product": {"read", "search"},
+				},
+			},
+			Inherits: map[string][]string{
+				"admin": {"user"},
+				"user":  {"guest"},
+			},
+			Limits: map[string]RateLimitConfig{
+				"admin": {}, // unlimited rather than the limits admin inherits from user
+				"user":  {RequestsPerMinute: 120, Burst: 30, DailyCreates: 500},
+				"guest": {RequestsPerMinute: 30, Burst: 10},
+			},
+			PageSizes: map[string]PageSizeConfig{
+				"admin": {Default: 50, Max: 1000},
+				"user":  {Default: 50, Max: 200},
+				"guest": {Default: 20, Max: 50},
+			},
+			BulkLimits: map[string]int{
+				"admin": 1000,
+			},
+			LimitStore: "memory",
+		},
+		LLM: LLMConfig{
+			Enabled:          true,
+			Model:            "llama3.2:1b",
+			Timeout:          5 * time.Second,
+			HeartbeatTimeout: 2 * time.Second,
+		},
+		Logging: LoggingConfig{
+			Level: "info",
+		},
+		Idempotency: IdempotencyConfig{
+			Window: 24 * time.Hour,
+		},
+	}
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("server.addr %q is not a valid listen address: %w", c.Server.Addr, err))
+	}
+	check(c.Server.ReadTimeout >= 0, "server.read_timeout must not be negative")
+	check(c.Server.IdleTimeout >= 0, "server.idle_timeout must not be negative")
+	check(c.Server.ShutdownDelay >= 0, "server.shutdown_delay must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+
+	db := c.Database
+	check(db.Host != "", "database.host is required")
+	check(db.Port > 0 && db.Port <= 65535, "database.port %d is out of range", db.Port)
+	check(db.User != "", "database.user is required")
+	check(db.Password != "", "database.password is required (DB_PASSWORD)")
+	check(db.Name != "", "database.name is required")
+	check(validSSLModes[db.SSLMode], "database.sslmode %q is not one of disable, allow, prefer, require, verify-ca, verify-full", db.SSLMode)
+	check(db.MaxConns > 0, "database.max_conns must be positive")
+	check(db.MinConns >= 0 && db.MinConns <= db.MaxConns, "database.min_conns must be between 0 and database.max_conns")
+	check(db.MaxConnLifetime > 0, "database.max_conn_lifetime must be positive")
+	check(db.MaxConnIdleTime > 0, "database.max_conn_idle_time must be positive")
+	check(db.ConnectTimeout > 0, "database.connect_timeout must be positive")
+	check(db.ConnectRetries > 0, "database.connect_retries must be at least 1")
+	check(db.RetryDelay >= 0, "database.retry_delay must not be negative")
+
+	check(len(c.Policy.Roles) > 0, "policy.roles must define at least one role")
+	errs = append(errs, validateAccessRules("policy", "policy", c.Policy.Roles, TenantPolicyConfig{
+		Roles: c.Policy.Roles, Inherits: c.Policy.Inherits, Deny: c.Policy.Deny, Conditions: c.Policy.Conditions,
+	})...)
+	for tenant, rules := range c.Policy.Tenants {
+		prefix := "policy.tenants." + tenant
+		check(tenantName.MatchString(tenant), "%s: tenant names are lowercase letters, digits, - and _", prefix)
+		rolesAt := "policy"
+		if rules.Roles != nil {
+			check(len(rules.Roles) > 0, "%s.roles must define at least one role", prefix)
+			rolesAt = prefix
+		}
+		errs = append(errs, validateAccessRules(prefix, rolesAt, c.Policy.ForTenant(tenant).Roles, rules)...)
+	}
+
+	checkSizes := func(prefix string, limits map[string]RateLimitConfig, pageSizes map[string]PageSizeConfig, bulkLimits map[string]int) {
+		for role, limit := range limits {
+			check(limit.RequestsPerMinute >= 0, "%s.limits.%s.requests_per_minute must not be negative", prefix, role)
+			check(limit.RequestsPerMinute == 0 || limit.Burst >= 1, "%s.limits.%s.burst must be at least 1", prefix, role)
+			check(limit.DailyCreates >= 0, "%s.limits.%s.daily_creates must not be negative", prefix, role)
+		}
+		for role, size := range pageSizes {
+			check(size.Max >= 1, "%s.page_sizes.%s.max must be at least 1", prefix, role)
+			check(size.Default >= 1 && size.Default <= size.Max, "%s.page_sizes.%s.default must be between 1 and max", prefix, role)
+		}
+		for role, limit := range bulkLimits {
+			check(limit >= 1, "%s.bulk_limits.%s must be at least 1", prefix, role)
+		}
+	}
+	checkSizes("policy", c.Policy.Limits, c.Policy.PageSizes, c.Policy.BulkLimits)
+	for tenant, rules := range c.Policy.Tenants {
+		checkSizes("policy.tenants."+tenant, rules.Limits, rules.PageSizes, rules.BulkLimits)
+	}
+	check(c.Policy.LimitStore == "memory" || c.Policy.LimitStore == "postgres",
+		"policy.limit_store %q is not one of memory, postgres", c.Policy.LimitStore)
+
+	userTenants, userSources := map[string]string{}, map[string]string{}
+	checkUser := func(path, source string, user TokenConfig) {
+		check(user.UserID != "", "%s.user_id is required", path)
+		tenant := user.TenantName()
+		check(tenantName.MatchString(tenant), "%s.tenant %q must be lowercase letters, digits, - and _", path, tenant)
+		// Logs, change events and policy checks name users by ID alone, so a configured ID
+		// belongs to one tenant and to either tokens or service keys
+		if other, seen := userTenants[user.UserID]; seen && other != tenant {
+			errs = append(errs, fmt.Errorf("%s: user_id %q already belongs to tenant %q", path, user.UserID, other))
+		}
+		if other, seen := userSources[user.UserID]; seen && other != source {
+			errs = append(errs, fmt.Errorf("%s: user_id %q is already used by %s", path, user.UserID, other))
+		}
+		userTenants[user.UserID] = tenant
+		userSources[user.UserID] = source
+		check(len(user.RoleList()) > 0, "%s.role or roles is required", path)
+		roles := c.Policy.ForTenant(tenant).Roles
+		for _, role := range user.RoleList() {
+			_, roleExists := roles[role]
+			check(roleExists, "%s: role %q has no policy", path, role)
+		}
+		for name := range user.Attributes {
+			check(!reservedAttributes[name], "%s.attributes: %q is reserved", path, name)
+		}
+	}
+
+	tokens := map[string]bool{}
+	for i, token := range c.Auth.Tokens {
+		check(token.Token != "", "auth.tokens[%d].token is required", i)
+		check(!tokens[token.Token], "auth.tokens[%d].token is a duplicate", i)
+		checkUser(fmt.Sprintf("auth.tokens[%d]", i), "auth.tokens", token)
+		tokens[token.Token] = true
+	}
+
+	signing := c.Auth.Signing
+	check(signing.ClockSkew > 0, "auth.signing.clock_skew must be positive")
+	check(signing.NonceCacheSize >= 1, "auth.signing.nonce_cache_size must be at least 1")
+	keyIDs := map[string]bool{}
+	for i, key := range signing.Keys {
+		check(serviceKeyID.MatchString(key.KeyID), "auth.signing.keys[%d].key_id %q must be letters, digits, ., - and _", i, key.KeyID)
+		check(!keyIDs[key.KeyID], "auth.signing.keys[%d].key_id %q is a duplicate", i, key.KeyID)
+		check(len(key.Secret) >= MinServiceSecretLength, "auth.signing.keys[%d].secret must be at least %d characters", i, MinServiceSecretLength)
+		checkUser(fmt.Sprintf("auth.signing.keys[%d]", i), "auth.signing.keys", key.Identity())
+		keyIDs[key.KeyID] = true
+	}
+
+	accounts := c.Auth.Accounts
+	check(tenantName.MatchString(accounts.Tenant), "auth.accounts.tenant %q must be lowercase letters, digits, - and _", accounts.Tenant)
+	if accounts.Registration {
+		_, roleExists := c.Policy.ForTenant(accounts.Tenant).Roles[accounts.Role]
+		check(roleExists, "auth.accounts.role %q has no policy", accounts.Role)
+	}
+	check(accounts.MinPasswordLength >= 8, "auth.accounts.min_password_length must be at least 8")
+	check(accounts.AccessTTL > 0, "auth.accounts.access_ttl must be positive")
+	check(accounts.RefreshTTL >= accounts.AccessTTL, "auth.accounts.refresh_ttl must be at least auth.accounts.access_ttl")
+	check(accounts.MaxFailedLogins >= 1, "auth.accounts.max_failed_logins must be at least 1")
+	check(accounts.LockoutDuration > 0, "auth.accounts.lockout_duration must be positive")
+
+	if oidc := c.Auth.OIDC; oidc.Enabled {
+		check(isAbsoluteURL(oidc.Issuer), "auth.oidc.issuer %q must be an absolute URL", oidc.Issuer)
+		check(oidc.ClientID != "", "auth.oidc.client_id is required")
+		check(isAbsoluteURL(oidc.RedirectURL), "auth.oidc.redirect_url %q must be an absolute URL", oidc.RedirectURL)
+		check(slices.Contains(oidc.Scopes, "openid"), "auth.oidc.scopes must include openid")
+		check(oidc.GroupsClaim != "", "auth.oidc.groups_claim is required")
+		check(tenantName.MatchString(oidc.Tenant), "auth.oidc.tenant %q must be lowercase letters, digits, - and _", oidc.Tenant)
+		roles := c.Policy.ForTenant(oidc.Tenant).Roles
+		for group, mapped := range oidc.Roles {
+			for _, role := range mapped {
+				_, roleExists := roles[role]
+				check(roleExists, "auth.oidc.roles.%s: role %q has no policy", group, role)
+			}
+		}
+		if oidc.DefaultRole != "" {
+			_, roleExists := roles[oidc.DefaultRole]
+			check(roleExists, "auth.oidc.default_role %q has no policy", oidc.DefaultRole)
+		}
+	}
+
+	if c.LLM.Enabled {
+		check(c.LLM.Model != "", "llm.model is required when the LLM is enabled")
+		check(c.LLM.Timeout > 0, "llm.timeout must be positive")
+		check(c.LLM.HeartbeatTimeout > 0, "llm.heartbeat_timeout must be positive")
+		if c.LLM.Host != "" {
+			check(isAbsoluteURL(c.LLM.Host), "llm.host %q must be an absolute URL", c.LLM.Host)
+		}
+	}
+
+	check(c.Idempotency.Window > 0, "idempotency.window must be positive")
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("logging.level %q is not one of debug, info, warn, error", c.Logging.Level))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Masked returns a copy of the configuration that is safe to print or log
+func (c *Config) Masked() *Config {
+	masked := *c
+	masked.Database.Password = MaskSecret(c.Database.Password)
+	masked.Auth.OIDC.ClientSecret = MaskSecret(c.Auth.OIDC.ClientSecret)
+
+	masked.Auth.Tokens = make([]TokenConfig, len(c.Auth.Tokens))
+	for i, token := range c.Auth.Tokens {
+		token.Token = MaskSecret(token.Token)
+		masked.Auth.Tokens[i] = token
+	}
+	masked.Auth.Signing.Keys = make([]ServiceKeyConfig, len(c.Auth.Signing.Keys))
+	for i, key := range c.Auth.Signing.Keys {
+		key.Secret = MaskSecret(key.Secret)
+		masked.Auth.Signing.Keys[i] = key
+	}
+
+	return &masked
+}
+
+// Print writes the masked configuration as YAML
+func (c *Config) Print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.Masked()); err != nil {
+		return fmt.Errorf("failed to print configuration: %w", err)
+	}
+	return encoder.Close()
+}
+
+func isAbsoluteURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+func MaskSecret(secret string) string {
+	if len(secret) == 0 {
+		return "<empty>"
+	}
+	if len(secret) <= 3 {
+		return "***"
+	}
+	return secret[:2] + "***"
+}
+
+// validateAccessRules checks the rule sets of rules, reported under prefix, against roles,
+// the roles defined at rolesAt
+func validateAccessRules(prefix, rolesAt string, roles map[string]map[string][]string, rules TenantPolicyConfig) []error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	for _, set := range []struct {
+		name  string
+		roles map[string]map[string][]string
+	}{{"roles", rules.Roles}, {"deny", rules.Deny}} {
+		for role, entities := range set.roles {
+			_, roleExists := roles[role]
+			check(roleExists, "%s.%s.%s: role %q is not defined in %s.roles", prefix, set.name, role, role, rolesAt)
+			for entity, actions := range entities {
+				for _, action := range actions {
+					check(validActions[action] || action == Wildcard, "%s.%s.%s.%s: unknown action %q", prefix, set.name, role, entity, action)
+				}
+			}
+		}
+	}
+	for role, parents := range rules.Inherits {
+		_, roleExists := roles[role]
+		check(roleExists, "%s.inherits.%s: role %q is not defined in %s.roles", prefix, role, role, rolesAt)
+		for _, parent := range parents {
+			_, parentExists := roles[parent]
+			check(parentExists, "%s.inherits.%s: inherited role %q is not defined in %s.roles", prefix, role, parent, rolesAt)
+		}
+		check(!inheritsFrom(rules.Inherits, role, role, map[string]bool{}), "%s.inherits.%s: role inherits from itself", prefix, role)
+	}
+
+	for role, entities := range rules.Conditions {
+		_, roleExists := roles[role]
+		check(roleExists, "%s.conditions.%s: role %q is not defined in %s.roles", prefix, role, role, rolesAt)
+		for entity, actions := range entities {
+			for action, condition := range actions {
+				check(validActions[action] || action == Wildcard, "%s.conditions.%s.%s: unknown action %q", prefix, role, entity, action)
+				if _, err := CompileCondition(condition); err != nil {
+					errs = append(errs, fmt.Errorf("%s.conditions.%s.%s.%s: %w", prefix, role, entity, action, err))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// inheritsFrom reports whether role reaches target through inherited roles
+func inheritsFrom(inherits map[string][]string, role, target string, seen map[string]bool) bool {
+	for _, parent := range inherits[role] {
+		if parent == target {
+			return true
+		}
+		if !seen[parent] {
+			seen[parent] = true
+			if inheritsFrom(inherits, parent, target, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
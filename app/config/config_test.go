@@ -0,0 +1,454 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type ConfigTestSuite struct {
+	suite.Suite
+}
+
+func (s *ConfigTestSuite) SetupTest() {
+	for _, setting := range settings(Default()) {
+		if setting.env != "" {
+			s.T().Setenv(setting.env, "")
+		}
+	}
+	s.T().Setenv(FileEnv, "")
+	s.T().Setenv("DB_PASSWORD", "postgres-secret")
+}
+
+func (s *ConfigTestSuite) writeFile(name, content string) string {
+	path := filepath.Join(s.T().TempDir(), name)
+	s.Require().NoError(os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func (s *ConfigTestSuite) TestDefaultsAreValid() {
+	cfg, err := Load(nil)
+
+	s.Require().NoError(err)
+	s.NoError(cfg.Validate())
+	assert.Equal(s.T(), ":8080", cfg.Server.Addr)
+	assert.Equal(s.T(), "llama3.2:1b", cfg.LLM.Model)
+	assert.Equal(s.T(), 5*time.Second, cfg.LLM.Timeout)
+	assert.Equal(s.T(), "postgres-secret", cfg.Database.Password)
+}
+
+func (s *ConfigTestSuite) TestYAMLFile() {
+	path := s.writeFile("drm.yaml", `
+server:
+  addr: ":9090"
+database:
+  sslmode: require
+  max_conns: 25
+  connect_timeout: 3s
+llm:
+  model: mistral
+policy:
+  roles:
+    reader:
+      product: [read]
+  limits:
+    reader:
+      requests_per_minute: 5
+      burst: 1
+  page_sizes:
+    reader: {default: 10, max: 25}
+  bulk_limits:
+    reader: 5
+auth:
+  tokens:
+    - token: reader-token
+      user_id: "7"
+      name: Reader
+      role: reader
+`)
+
+	cfg, err := Load([]string{"-config", path})
+
+	s.Require().NoError(err)
+	s.NoError(cfg.Validate())
+	assert.Equal(s.T(), ":9090", cfg.Server.Addr)
+	assert.Equal(s.T(), "require", cfg.Database.SSLMode)
+	assert.Equal(s.T(), int32(25), cfg.Database.MaxConns)
+	assert.Equal(s.T(), 3*time.Second, cfg.Database.ConnectTimeout)
+	assert.Equal(s.T(), "mistral", cfg.LLM.Model)
+	assert.Equal(s.T(), "localhost", cfg.Database.Host)
+	assert.Equal(s.T(), map[string]map[string][]string{"reader": {"product": {"read"}}}, cfg.Policy.Roles)
+	assert.Equal(s.T(), map[string]RateLimitConfig{"reader": {RequestsPerMinute: 5, Burst: 1}}, cfg.Policy.Limits)
+	assert.Equal(s.T(), map[string]PageSizeConfig{"reader": {Default: 10, Max: 25}}, cfg.Policy.PageSizes)
+	assert.Equal(s.T(), map[string]int{"reader": 5}, cfg.Policy.BulkLimits)
+	assert.Len(s.T(), cfg.Auth.Tokens, 1)
+}
+
+func (s *ConfigTestSuite) TestTOMLFile() {
+	path := s.writeFile("drm.toml", `
+[server]
+addr = ":7070"
+
+[llm]
+enabled = false
+timeout = "10s"
+`)
+
+	cfg, err := Load([]string{"-config", path})
+
+	s.Require().NoError(err)
+	assert.Equal(s.T(), ":7070", cfg.Server.Addr)
+	assert.False(s.T(), cfg.LLM.Enabled)
+	assert.Equal(s.T(), 10*time.Second, cfg.LLM.Timeout)
+	assert.Contains(s.T(), cfg.Policy.Roles, "admin")
+}
+
+func (s *ConfigTestSuite) TestFileFromEnvironment() {
+	s.T().Setenv(FileEnv, s.writeFile("drm.yml", "logging:\n  level: debug\n"))
+
+	cfg, err := Load(nil)
+
+	s.Require().NoError(err)
+	assert.Equal(s.T(), "debug", cfg.Logging.Level)
+}
+
+func (s *ConfigTestSuite) TestUnknownFileKey() {
+	yamlPath := s.writeFile("drm.yaml", "server:\n  port: 80\n")
+	_, err := Load([]string{"-config", yamlPath})
+	s.Error(err)
+
+	tomlPath := s.writeFile("drm.toml", "[server]\nport = 80\n")
+	_, err = Load([]string{"-config", tomlPath})
+	s.ErrorContains(err, "server.port")
+}
+
+func (s *ConfigTestSuite) TestUnsupportedFileFormat() {
+	_, err := Load([]string{"-config", s.writeFile("drm.json", "{}")})
+	s.ErrorContains(err, "unsupported config file format")
+}
+
+func (s *ConfigTestSuite) TestPrecedence() {
+	path := s.writeFile("drm.yaml", "database:\n  host: file-host\n  port: 6000\n  name: file-db\n")
+	s.T().Setenv("DB_HOST", "env-host")
+	s.T().Setenv("DB_PORT", "6001")
+
+	cfg, err := Load([]string{"-config", path, "-database.host", "flag-host"})
+
+	s.Require().NoError(err)
+	assert.Equal(s.T(), "flag-host", cfg.Database.Host)
+	assert.Equal(s.T(), 6001, cfg.Database.Port)
+	assert.Equal(s.T(), "file-db", cfg.Database.Name)
+}
+
+func (s *ConfigTestSuite) TestTypedOverrides() {
+	s.T().Setenv("LLM_TIMEOUT", "750ms")
+
+	cfg, err := Load([]string{"-llm.enabled=false", "-database.max_conns", "3"})
+
+	s.Require().NoError(err)
+	assert.Equal(s.T(), 750*time.Millisecond, cfg.LLM.Timeout)
+	assert.False(s.T(), cfg.LLM.Enabled)
+	assert.Equal(s.T(), int32(3), cfg.Database.MaxConns)
+}
+
+func (s *ConfigTestSuite) TestIdempotencyWindow() {
+	assert.Equal(s.T(), 24*time.Hour, Default().Idempotency.Window)
+
+	s.T().Setenv("IDEMPOTENCY_WINDOW", "2h")
+	cfg, err := Load(nil)
+	s.Require().NoError(err)
+	assert.Equal(s.T(), 2*time.Hour, cfg.Idempotency.Window)
+
+	cfg.Idempotency.Window = 0
+	s.ErrorContains(cfg.Validate(), "idempotency.window must be positive")
+}
+
+func (s *ConfigTestSuite) TestInvalidOverride() {
+	s.T().Setenv("DB_PORT", "not-a-port")
+
+	_, err := Load(nil)
+
+	s.ErrorContains(err, "DB_PORT")
+}
+
+func (s *ConfigTestSuite) TestValidationReportsAllErrors() {
+	cfg := Default()
+	cfg.Database.SSLMode = "sometimes"
+	cfg.Database.MinConns = 50
+	cfg.Auth.Tokens = append(cfg.Auth.Tokens, TokenConfig{Token: "x-token", UserID: "9", Role: "superuser"})
+	cfg.Policy.Roles["guest"]["order"] = []string{"approve"}
+	cfg.Policy.Limits["guest"] = RateLimitConfig{RequestsPerMinute: 10}
+	cfg.Policy.PageSizes["guest"] = PageSizeConfig{Default: 100, Max: 50}
+	cfg.Policy.BulkLimits["admin"] = 0
+	cfg.Policy.LimitStore = "redis"
+	cfg.Policy.Tenants = map[string]TenantPolicyConfig{"acme": {
+		BulkLimits: map[string]int{"user": -1},
+		Limits:     map[string]RateLimitConfig{"user": {DailyCreates: -1}},
+	}}
+
+	err := cfg.Validate()
+
+	s.Require().Error(err)
+	s.ErrorContains(err, "database.password is required")
+	s.ErrorContains(err, "database.sslmode")
+	s.ErrorContains(err, "database.min_conns")
+	s.ErrorContains(err, `role "superuser" has no policy`)
+	s.ErrorContains(err, `unknown action "approve"`)
+	s.ErrorContains(err, "policy.limits.guest.burst")
+	s.ErrorContains(err, "policy.page_sizes.guest.default")
+	s.ErrorContains(err, "policy.bulk_limits.admin")
+	s.ErrorContains(err, "policy.tenants.acme.bulk_limits.user")
+	s.ErrorContains(err, "policy.tenants.acme.limits.user.daily_creates")
+	s.ErrorContains(err, "policy.limit_store")
+}
+
+func (s *ConfigTestSuite) TestRoleHierarchyFile() {
+	path := s.writeFile("drm.yaml", `
+policy:
+  roles:
+    viewer:
+      "*": [read]
+    support:
+      user: [update]
+  inherits:
+    support: [viewer]
+  deny:
+    support:
+      order: ["*"]
+auth:
+  tokens:
+    - {token: support-token, user_id: "8", name: Support, roles: [support, viewer]}
+`)
+
+	cfg, err := Load([]string{"-config", path})
+
+	s.Require().NoError(err)
+	s.NoError(cfg.Validate())
+	assert.Equal(s.T(), map[string][]string{"support": {"viewer"}}, cfg.Policy.Inherits)
+	assert.Equal(s.T(), map[string]map[string][]string{"support": {"order": {"*"}}}, cfg.Policy.Deny)
+	assert.Equal(s.T(), []string{"support", "viewer"}, cfg.Auth.Tokens[0].RoleList())
+}
+
+func (s *ConfigTestSuite) TestRoleHierarchyValidation() {
+	cfg := Default()
+	cfg.Policy.Inherits["guest"] = []string{"admin"}
+	cfg.Policy.Inherits["auditor"] = []string{"ghost"}
+	cfg.Policy.Deny = map[string]map[string][]string{"nobody": {"order": {"read"}}, "user": {"order": {"approve"}}}
+	cfg.Auth.Tokens = append(cfg.Auth.Tokens, TokenConfig{Token: "x-token", UserID: "9"})
+
+	err := cfg.Validate()
+
+	s.Require().Error(err)
+	s.ErrorContains(err, "policy.inherits.guest: role inherits from itself")
+	s.ErrorContains(err, `policy.inherits.auditor: role "auditor" is not defined`)
+	s.ErrorContains(err, `inherited role "ghost" is not defined`)
+	s.ErrorContains(err, `policy.deny.nobody: role "nobody" is not defined`)
+	s.ErrorContains(err, `policy.deny.user.order: unknown action "approve"`)
+	s.ErrorContains(err, "auth.tokens[3].role or roles is required")
+}
+
+func (s *ConfigTestSuite) TestConditionsFile() {
+	path := s.writeFile("drm.yaml", `
+policy:
+  conditions:
+    user:
+      order:
+        update: record.status == "pending"
+auth:
+  tokens:
+    - {token: manager-token, user_id: "5", name: Manager, role: user, attributes: {department: sales}}
+`)
+
+	cfg, err := Load([]string{"-config", path})
+
+	s.Require().NoError(err)
+	s.NoError(cfg.Validate())
+	assert.Equal(s.T(), `record.status == "pending"`, cfg.Policy.Conditions["user"]["order"]["update"])
+	assert.Equal(s.T(), map[string]string{"department": "sales"}, cfg.Auth.Tokens[0].Attributes)
+}
+
+func (s *ConfigTestSuite) TestConditionsValidation() {
+	cfg := Default()
+	cfg.Policy.Conditions = map[string]map[string]map[string]string{
+		"nobody": {"order": {"read": "true"}},
+		"user":   {"order": {"approve": "true", "update": `record.status = "pending"`, "read": `owner == user.id`}},
+	}
+	cfg.Auth.Tokens[1].Attributes = map[string]string{"roles": "admin"}
+
+	err := cfg.Validate()
+
+	s.Require().Error(err)
+	s.ErrorContains(err, `policy.conditions.nobody: role "nobody" is not defined`)
+	s.ErrorContains(err, `policy.conditions.user.order: unknown action "approve"`)
+	s.ErrorContains(err, "policy.conditions.user.order.update: syntax error at 14: unexpected character '='")
+	s.ErrorContains(err, `policy.conditions.user.order.read: syntax error at 0: unknown variable "owner"`)
+	s.ErrorContains(err, `auth.tokens[1].attributes: "roles" is reserved`)
+}
+
+func (s *ConfigTestSuite) TestTenantsFile() {
+	path := s.writeFile("drm.yaml", `
+policy:
+  tenants:
+    acme:
+      roles:
+        clerk:
+          order: [create, read]
+auth:
+  tokens:
+    - {token: acme-token, user_id: "20", name: Acme Clerk, tenant: acme, role: clerk}
+`)
+
+	cfg, err := Load([]string{"-config", path})
+
+	s.Require().NoError(err)
+	s.NoError(cfg.Validate())
+	assert.Equal(s.T(), "acme", cfg.Auth.Tokens[0].TenantName())
+	assert.Equal(s.T(), DefaultTenant, TokenConfig{}.TenantName())
+
+	acme := cfg.Policy.ForTenant("acme")
+	assert.Equal(s.T(), map[string]map[string][]string{"clerk": {"order": {"create", "read"}}}, acme.Roles)
+	assert.Nil(s.T(), acme.Inherits)
+	assert.Equal(s.T(), Default().Policy.Roles, cfg.Policy.ForTenant("other").Roles)
+}
+
+func (s *ConfigTestSuite) TestTenantsValidation() {
+	cfg := Default()
+	cfg.Policy.Tenants = map[string]TenantPolicyConfig{
+		"Acme":   {Deny: map[string]map[string][]string{"nobody": {"order": {"read"}}}},
+		"globex": {Roles: map[string]map[string][]string{"clerk": {"order": {"approve"}}}},
+	}
+	cfg.Auth.Tokens = append(cfg.Auth.Tokens,
+		TokenConfig{Token: "globex-token", UserID: "30", Tenant: "globex", Role: "user"},
+		TokenConfig{Token: "shared-token", UserID: "2", Tenant: "globex", Role: "clerk"},
+		TokenConfig{Token: "bad-token", UserID: "31", Tenant: "Bad Tenant", Role: "user"},
+	)
+
+	err := cfg.Validate()
+
+	s.Require().Error(err)
+	s.ErrorContains(err, "policy.tenants.Acme: tenant names are lowercase")
+	s.ErrorContains(err, `policy.tenants.Acme.deny.nobody: role "nobody" is not defined in policy.roles`)
+	s.ErrorContains(err, `policy.tenants.globex.roles.clerk.order: unknown action "approve"`)
+	s.ErrorContains(err, `auth.tokens[3]: role "user" has no policy`)
+	s.ErrorContains(err, `auth.tokens[4]: user_id "2" already belongs to tenant "default"`)
+	s.ErrorContains(err, `auth.tokens[5].tenant "Bad Tenant" must be lowercase`)
+}
+
+func (s *ConfigTestSuite) TestAccounts() {
+	s.T().Setenv("AUTH_REGISTRATION", "true")
+	s.T().Setenv("AUTH_ACCESS_TTL", "5m")
+	cfg, err := Load([]string{"-auth.accounts.max_failed_logins", "3"})
+
+	s.Require().NoError(err)
+	s.NoError(cfg.Validate())
+	assert.True(s.T(), cfg.Auth.Accounts.Registration)
+	assert.Equal(s.T(), 5*time.Minute, cfg.Auth.Accounts.AccessTTL)
+	assert.Equal(s.T(), 3, cfg.Auth.Accounts.MaxFailedLogins)
+	assert.Equal(s.T(), "user", cfg.Auth.Accounts.Role)
+
+	cfg.Auth.Accounts.Role = "member"
+	cfg.Auth.Accounts.MinPasswordLength = 4
+	cfg.Auth.Accounts.RefreshTTL = time.Minute
+	cfg.Auth.Accounts.MaxFailedLogins = 0
+	err = cfg.Validate()
+
+	s.Require().Error(err)
+	s.ErrorContains(err, `auth.accounts.role "member" has no policy`)
+	s.ErrorContains(err, "auth.accounts.min_password_length must be at least 8")
+	s.ErrorContains(err, "auth.accounts.refresh_ttl must be at least auth.accounts.access_ttl")
+	s.ErrorContains(err, "auth.accounts.max_failed_logins must be at least 1")
+}
+
+func (s *ConfigTestSuite) TestOIDC() {
+	s.T().Setenv("OIDC_ENABLED", "true")
+	s.T().Setenv("OIDC_ISSUER", "https://id.example.com")
+	s.T().Setenv("OIDC_CLIENT_SECRET", "oidc-secret")
+	cfg, err := Load([]string{"-auth.oidc.client_id", "drm", "-auth.oidc.redirect_url", "https://drm.example.com/auth/oidc/callback"})
+
+	s.Require().NoError(err)
+	s.NoError(cfg.Validate())
+	assert.Equal(s.T(), []string{"openid", "profile", "email"}, cfg.Auth.OIDC.Scopes)
+	assert.Equal(s.T(), "groups", cfg.Auth.OIDC.GroupsClaim)
+	assert.Equal(s.T(), "oi***", cfg.Masked().Auth.OIDC.ClientSecret)
+
+	cfg.Auth.OIDC.Issuer = "id.example.com"
+	cfg.Auth.OIDC.ClientID = ""
+	cfg.Auth.OIDC.Scopes = []string{"profile"}
+	cfg.Auth.OIDC.Roles = map[string][]string{"drm-admins": {"superuser"}}
+	cfg.Auth.OIDC.DefaultRole = "visitor"
+	err = cfg.Validate()
+
+	s.Require().Error(err)
+	s.ErrorContains(err, `auth.oidc.issuer "id.example.com" must be an absolute URL`)
+	s.ErrorContains(err, "auth.oidc.client_id is required")
+	s.ErrorContains(err, "auth.oidc.scopes must include openid")
+	s.ErrorContains(err, `auth.oidc.roles.drm-admins: role "superuser" has no policy`)
+	s.ErrorContains(err, `auth.oidc.default_role "visitor" has no policy`)
+}
+
+func (s *ConfigTestSuite) TestServiceKeys() {
+	s.T().Setenv("AUTH_SIGNING_CLOCK_SKEW", "90s")
+	cfg, err := Load(nil)
+	s.Require().NoError(err)
+	assert.Equal(s.T(), 90*time.Second, cfg.Auth.Signing.ClockSkew)
+	assert.Equal(s.T(), 100000, cfg.Auth.Signing.NonceCacheSize)
+
+	cfg.Auth.Signing.Keys = []ServiceKeyConfig{
+		{KeyID: "billing", Secret: "billing-secret-0123456789abcdefghij", UserID: "900", Name: "Billing", Role: "admin"},
+	}
+	s.NoError(cfg.Validate())
+	assert.Equal(s.T(), "bi***", cfg.Masked().Auth.Signing.Keys[0].Secret)
+	assert.Equal(s.T(), "billing-secret-0123456789abcdefghij", cfg.Auth.Signing.Keys[0].Secret)
+
+	cfg.Auth.Signing.ClockSkew = 0
+	cfg.Auth.Signing.Keys = append(cfg.Auth.Signing.Keys,
+		ServiceKeyConfig{KeyID: "billing", Secret: "short", UserID: "1", Tenant: "acme", Role: "superuser"},
+		ServiceKeyConfig{KeyID: "has space", Secret: "reports-secret-0123456789abcdefghij"},
+		ServiceKeyConfig{KeyID: "reports", Secret: "reports-secret-0123456789abcdefghij", UserID: "2", Role: "user"},
+	)
+	err = cfg.Validate()
+
+	s.Require().Error(err)
+	s.ErrorContains(err, "auth.signing.clock_skew must be positive")
+	s.ErrorContains(err, `auth.signing.keys[1].key_id "billing" is a duplicate`)
+	s.ErrorContains(err, "auth.signing.keys[1].secret must be at least 32 characters")
+	s.ErrorContains(err, `auth.signing.keys[1]: user_id "1" already belongs to tenant "default"`)
+	s.ErrorContains(err, `auth.signing.keys[1]: user_id "1" is already used by auth.tokens`)
+	s.ErrorContains(err, `auth.signing.keys[1]: role "superuser" has no policy`)
+	s.ErrorContains(err, `auth.signing.keys[2].key_id "has space" must be letters, digits, ., - and _`)
+	s.ErrorContains(err, "auth.signing.keys[2].user_id is required")
+	s.ErrorContains(err, "auth.signing.keys[2].role or roles is required")
+	s.ErrorContains(err, `auth.signing.keys[3]: user_id "2" is already used by auth.tokens`)
+	s.NotContains(err.Error(), "auth.signing.keys[3]: user_id \"2\" already belongs")
+}
+
+func (s *ConfigTestSuite) TestPrintMasksSecrets() {
+	cfg, err := Load(nil)
+	s.Require().NoError(err)
+
+	var out bytes.Buffer
+	s.Require().NoError(cfg.Print(&out))
+
+	assert.Contains(s.T(), out.String(), "password: po***")
+	assert.Contains(s.T(), out.String(), "token: ad***")
+	assert.NotContains(s.T(), out.String(), "postgres-secret")
+	assert.NotContains(s.T(), out.String(), "admin-token")
+	assert.Contains(s.T(), out.String(), "timeout: 5s")
+	assert.Equal(s.T(), "postgres-secret", cfg.Database.Password)
+	assert.Equal(s.T(), "admin-token", cfg.Auth.Tokens[0].Token)
+}
+
+func (s *ConfigTestSuite) TestMaskSecret() {
+	assert.Equal(s.T(), "<empty>", MaskSecret(""))
+	assert.Equal(s.T(), "***", MaskSecret("abc"))
+	assert.Equal(s.T(), "pa***", MaskSecret("password"))
+}
+
+func TestConfigTestSuite(t *testing.T) {
+	suite.Run(t, new(ConfigTestSuite))
+}
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the environment variable that points at a config file when -config is not given
+const FileEnv = "DRM_CONFIG"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setting is one scalar configuration value that can be overridden from the environment or
+// a flag. Its path is the dotted file key, e.g. database.sslmode, which doubles as the flag name.
+type setting struct {
+	path  string
+	env   string
+	value reflect.Value
+}
+
+type override struct {
+	path string
+	raw  string
+}
+
+// Load resolves the configuration from defaults, the config file, the environment and the
+// given command-line arguments. It does not validate the result.
+func Load(args []string) (*Config, error) {
+	flags := flag.NewFlagSet("drm-app", flag.ContinueOnError)
+	path := flags.String("config", os.Getenv(FileEnv), "path to a YAML or TOML config file (env "+FileEnv+")")
+
+	var overrides []override
+	for _, s := range settings(Default()) {
+		s := s
+		usage := "overrides " + s.path
+		if s.env != "" {
+			usage += " (env " + s.env + ")"
+		}
+		record := func(raw string) error {
+			overrides = append(overrides, override{path: s.path, raw: raw})
+			return nil
+		}
+		if s.value.Kind() == reflect.Bool {
+			flags.BoolFunc(s.path, usage, record)
+		} else {
+			flags.Func(s.path, usage, record)
+		}
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+
+	cfg := Default()
+	if *path != "" {
+		if err := loadFile(cfg, *path); err != nil {
+			return nil, err
+		}
+	}
+
+	byPath := map[string]setting{}
+	for _, s := range settings(cfg) {
+		byPath[s.path] = s
+		if s.env == "" {
+			continue
+		}
+		if raw, ok := os.LookupEnv(s.env); ok && raw != "" {
+			if err := s.set(raw); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+
+	for _, o := range overrides {
+		if err := byPath[o.path].set(o.raw); err != nil {
+			return nil, fmt.Errorf("-%s: %w", o.path, err)
+		}
+	}
+
+	return cfg, nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// Decoders merge maps into existing ones; a file that defines roles, inherited roles, deny
+	// rules, limits, page sizes or bulk limits replaces the defaults
+	roles, inherits, deny := cfg.Policy.Roles, cfg.Policy.Inherits, cfg.Policy.Deny
+	limits, pageSizes, bulkLimits := cfg.Policy.Limits, cfg.Policy.PageSizes, cfg.Policy.BulkLimits
+	cfg.Policy.Roles, cfg.Policy.Inherits, cfg.Policy.Deny = nil, nil, nil
+	cfg.Policy.Limits, cfg.Policy.PageSizes, cfg.Policy.BulkLimits = nil, nil, nil
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(content), cfg)
+		if err != nil {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("failed to parse config file %s: unknown key %s", path, undecoded[0])
+		}
+	default:
+		return fmt.Errorf("unsupported config file format %q, use .yaml, .yml or .toml", filepath.Ext(path))
+	}
+
+	if cfg.Policy.Roles == nil {
+		cfg.Policy.Roles = roles
+		// The default inheritance refers to the default roles, so it goes when they are replaced
+		if cfg.Policy.Inherits == nil {
+			cfg.Policy.Inherits = inherits
+		}
+	}
+	if cfg.Policy.Deny == nil {
+		cfg.Policy.Deny = deny
+	}
+	if cfg.Policy.Limits == nil {
+		cfg.Policy.Limits = limits
+	}
+	if cfg.Policy.PageSizes == nil {
+		cfg.Policy.PageSizes = pageSizes
+	}
+	if cfg.Policy.BulkLimits == nil {
+		cfg.Policy.BulkLimits = bulkLimits
+	}
+	return nil
+}
+
+func settings(cfg *Config) []setting {
+	var result []setting
+	collectSettings(reflect.ValueOf(cfg).Elem(), "", &result)
+	return result
+}
+
+func collectSettings(v reflect.Value, prefix string, result *[]setting) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		path := prefix + field.Tag.Get("yaml")
+
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			collectSettings(v.Field(i), path+".", result)
+		case reflect.Map, reflect.Slice:
+			// Structured values are only configurable from a file
+		default:
+			*result = append(*result, setting{path: path, env: field.Tag.Get("env"), value: v.Field(i)})
+		}
+	}
+}
+
+func (s setting) set(raw string) error {
+	var err error
+	switch {
+	case s.value.Type() == durationType:
+		var d time.Duration
+		if d, err = time.ParseDuration(raw); err == nil {
+			s.value.SetInt(int64(d))
+		}
+	case s.value.Kind() == reflect.String:
+		s.value.SetString(raw)
+	case s.value.Kind() == reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(raw); err == nil {
+			s.value.SetBool(b)
+		}
+	case s.value.CanInt():
+		var n int64
+		if n, err = strconv.ParseInt(raw, 10, s.value.Type().Bits()); err == nil {
+			s.value.SetInt(n)
+		}
+	default:
+		err = fmt.Errorf("unsupported type %s", s.value.Type())
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid value %q for %s: %w", raw, s.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var ErrInvalidAggregation = errors.New("invalid aggregation")
+
+// aggregateFunctions are the functions an aggregate command may apply; all but count take a numeric field
+var aggregateFunctions = map[string]bool{"count": true, "sum": true, "avg": true, "min": true, "max": true}
+
+// Aggregation describes an aggregate command such as "sum total_amount of orders group by status"
+type Aggregation struct {
+	Function string      `json:"function"`
+	Field    string      `json:"field,omitempty"`
+	GroupBy  string      `json:"group_by,omitempty"`
+	Where    []Condition `json:"where,omitempty"`
+}
+
+// AggregateResult holds Value for an ungrouped aggregation and Groups, ordered by key, for a
+// grouped one. Value is left out when there were no values to average, or to take the
+// minimum or maximum of.
+type AggregateResult struct {
+	Function string           `json:"function"`
+	Field    string           `json:"field,omitempty"`
+	GroupBy  string           `json:"group_by,omitempty"`
+	Value    *float64         `json:"value,omitempty"`
+	Groups   []AggregateGroup `json:"groups,omitempty"`
+}
+
+type AggregateGroup struct {
+	Key   *string  `json:"key"`
+	Value *float64 `json:"value,omitempty"`
+}
+
+func ValidateAggregation(entity string, aggregation *Aggregation) error {
+	if aggregation == nil {
+		return fmt.Errorf("%w: no aggregate function given", ErrInvalidAggregation)
+	}
+	if !aggregateFunctions[aggregation.Function] {
+		return fmt.Errorf("%w: unknown function %q", ErrInvalidAggregation, aggregation.Function)
+	}
+
+	kind, exists := filterFields[entity][aggregation.Field]
+	switch {
+	case aggregation.Function == "count" && aggregation.Field != "":
+		return fmt.Errorf("%w: count takes no field", ErrInvalidAggregation)
+	case aggregation.Function != "count" && (!exists || kind != numberField):
+		return fmt.Errorf("%w: %s needs a numeric field of %s", ErrInvalidAggregation, aggregation.Function, entity)
+	}
+
+	if aggregation.GroupBy != "" {
+		if _, exists := filterFields[entity][aggregation.GroupBy]; !exists {
+			return fmt.Errorf("%w: %s cannot be grouped by %q", ErrInvalidAggregation, entity, aggregation.GroupBy)
+		}
+	}
+
+	return ValidateConditions(entity, aggregation.Where)
+}
+
+// aggregateRecords computes an aggregation over in-memory records the way the SQL aggregates
+// do: missing values are skipped and groups are ordered by key with the missing key last
+func aggregateRecords(entity string, records []map[string]interface{}, aggregation *Aggregation) (*AggregateResult, error) {
+	if err := ValidateAggregation(entity, aggregation); err != nil {
+		return nil, err
+	}
+
+	type accumulator struct {
+		key    *string
+		values []float64
+		count  int
+	}
+	groups := map[string]*accumulator{}
+	var order []*accumulator
+
+	for _, record := range records {
+		matched, err := matchConditions(entity, record, aggregation.Where)
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			continue
+		}
+
+		var key *string
+		id := "\x00"
+		if aggregation.GroupBy != "" && record[aggregation.GroupBy] != nil {
+			value := fmt.Sprint(record[aggregation.GroupBy])
+			key, id = &value, value
+		}
+
+		group, exists := groups[id]
+		if !exists {
+			group = &accumulator{key: key}
+			groups[id] = group
+			order = append(order, group)
+		}
+
+		group.count++
+		if aggregation.Field != "" {
+			if value, ok := convertValue(numberField, record[aggregation.Field]); ok {
+				group.values = append(group.values, value.(float64))
+			}
+		}
+	}
+
+	compute := func(group *accumulator) *float64 {
+		var result float64
+		switch aggregation.Function {
+		case "count":
+			result = float64(group.count)
+		case "sum":
+			for _, value := range group.values {
+				result += value
+			}
+		default:
+			if len(group.values) == 0 {
+				return nil
+			}
+			result = group.values[0]
+			for _, value := range group.values[1:] {
+				switch aggregation.Function {
+				case "avg":
+					result += value
+				case "min":
+					result = min(result, value)
+				case "max":
+					result = max(result, value)
+				}
+			}
+			if aggregation.Function == "avg" {
+				result /= float64(len(group.values))
+			}
+		}
+		return &result
+	}
+
+	result := &AggregateResult{Function: aggregation.Function, Field: aggregation.Field, GroupBy: aggregation.GroupBy}
+	if aggregation.GroupBy == "" {
+		total := &accumulator{}
+		if len(order) > 0 {
+			total = order[0]
+		}
+		result.Value = compute(total)
+		return result, nil
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := order[i].key, order[j].key
+		if a == nil || b == nil {
+			return b == nil && a != nil
+		}
+		return *a < *b
+	})
+	result.Groups = make([]AggregateGroup, len(order))
+	for i, group := range order {
+		result.Groups[i] = AggregateGroup{Key: group.key, Value: compute(group)}
+	}
+	return result, nil
+}
@@ -0,0 +1,123 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrBulkLimitExceeded = errors.New("bulk limit exceeded")
+	ErrPreviewMismatch   = errors.New("matched records differ from the confirmed preview")
+)
+
+// upsertKeys names the unique field an upsert matches existing records on
+var upsertKeys = map[string]string{"user": "email", "product": "name"}
+
+// UpsertResult is the record an upsert wrote and whether it was created or updated
+type UpsertResult struct {
+	Operation string                 `json:"operation"`
+	Record    map[string]interface{} `json:"record"`
+}
+
+// ValidateUpsert checks that entity can be upserted and data carries its key
+func ValidateUpsert(entity string, data map[string]interface{}) error {
+	key, exists := upsertKeys[entity]
+	if !exists {
+		return fmt.Errorf("%s has no unique field to upsert on", entity)
+	}
+	if value, ok := data[key].(string); !ok || value == "" {
+		return fmt.Errorf("upsert of %s needs %s", entity, key)
+	}
+	return nil
+}
+
+// Bulk selects the records a bulk_update or bulk_delete applies to. Without Confirm the
+// command only previews how many records match; with it the change is applied only if
+// exactly that many match.
+type Bulk struct {
+	Where   []Condition `json:"where"`
+	Confirm *int        `json:"confirm,omitempty"`
+	// MaxAffected is the most records the user's role may change at once, set by the engine
+	MaxAffected int `json:"-"`
+}
+
+// BulkResult reports a bulk preview or the change applied
+type BulkResult struct {
+	Preview     bool `json:"preview"`
+	Matched     int  `json:"matched"`
+	Affected    int  `json:"affected"`
+	MaxAffected int  `json:"max_affected"`
+	// Records are the changed records as updated, or as they were before being deleted
+	Records []map[string]interface{} `json:"-"`
+}
+
+// ValidateBulk checks the filter of a bulk command and, for bulk_update, the fields it sets.
+// A filter is required so that a whole table cannot be changed by omission.
+func ValidateBulk(entity, action string, bulk *Bulk, data map[string]interface{}) error {
+	if bulk == nil || len(bulk.Where) == 0 {
+		return fmt.Errorf("%w: %s needs at least one condition", ErrInvalidFilter, action)
+	}
+	if err := ValidateConditions(entity, bulk.Where); err != nil {
+		return err
+	}
+	if bulk.Confirm != nil && *bulk.Confirm < 0 {
+		return fmt.Errorf("confirmed count must not be negative")
+	}
+
+	if action != "bulk_update" {
+		return nil
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("no data provided for update")
+	}
+	_, err := assignments(entity, data)
+	return err
+}
+
+// assignments converts the fields a bulk update sets to the values bound for their columns,
+// in a stable column order
+func assignments(entity string, data map[string]interface{}) ([]Condition, error) {
+	var set []Condition
+	for _, field := range importColumns[entity] {
+		value, exists := data[field]
+		if !exists {
+			continue
+		}
+		if kind, filterable := filterFields[entity][field]; filterable {
+			converted, ok := convertValue(kind, value)
+			if !ok {
+				return nil, fmt.Errorf("%v is not a valid value for %s", value, field)
+			}
+			value = converted
+		}
+		set = append(set, Condition{Field: field, Op: "=", Value: value})
+	}
+
+	if len(set) != len(data) {
+		for field := range data {
+			if _, exists := importFields[entity][field]; !exists {
+				return nil, fmt.Errorf("%s field %q cannot be bulk updated", entity, field)
+			}
+		}
+	}
+	return set, nil
+}
+
+// checkBulk applies the role limit to the confirmed count before anything is written
+func checkBulk(bulk *Bulk) error {
+	if bulk.Confirm != nil && *bulk.Confirm > bulk.MaxAffected {
+		return fmt.Errorf("%w: %d records, at most %d allowed", ErrBulkLimitExceeded, *bulk.Confirm, bulk.MaxAffected)
+	}
+	return nil
+}
+
+// checkAffected compares the records a bulk change matched with the confirmed preview
+func checkAffected(bulk *Bulk, affected int) error {
+	if affected > bulk.MaxAffected {
+		return fmt.Errorf("%w: %d records, at most %d allowed", ErrBulkLimitExceeded, affected, bulk.MaxAffected)
+	}
+	if affected != *bulk.Confirm {
+		return fmt.Errorf("%w: %d now match, %d confirmed", ErrPreviewMismatch, affected, *bulk.Confirm)
+	}
+	return nil
+}
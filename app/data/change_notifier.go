@@ -0,0 +1,51 @@
+package data
+
+import (
+	"context"
+	"sync"
+)
+
+// ChangeNotifier carries change events between application replicas
+type ChangeNotifier interface {
+	Notify(ctx context.Context, event ChangeEvent) error
+	// Listen calls deliver for every event published by any replica until ctx is done
+	Listen(ctx context.Context, deliver func(ChangeEvent)) error
+}
+
+// LocalChangeNotifier delivers events in-process; used by tests and single-instance setups
+type LocalChangeNotifier struct {
+	mu        sync.RWMutex
+	listeners map[int]func(ChangeEvent)
+	nextID    int
+}
+
+func NewLocalChangeNotifier() *LocalChangeNotifier {
+	return &LocalChangeNotifier{
+		listeners: make(map[int]func(ChangeEvent)),
+	}
+}
+
+func (n *LocalChangeNotifier) Notify(ctx context.Context, event ChangeEvent) error {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	for _, deliver := range n.listeners {
+		deliver(event)
+	}
+	return nil
+}
+
+func (n *LocalChangeNotifier) Listen(ctx context.Context, deliver func(ChangeEvent)) error {
+	n.mu.Lock()
+	n.nextID++
+	id := n.nextID
+	n.listeners[id] = deliver
+	n.mu.Unlock()
+
+	<-ctx.Done()
+
+	n.mu.Lock()
+	delete(n.listeners, id)
+	n.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,102 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrEmailTaken = errors.New("email is already registered")
+
+// Credential is the password of a user that signs in with email and password. Only the hash
+// of the password is stored.
+type Credential struct {
+	UserID       string     `json:"user_id"`
+	Tenant       string     `json:"tenant"`
+	Name         string     `json:"name"`
+	Email        string     `json:"email"`
+	Role         string     `json:"role"`
+	PasswordHash string     `json:"-"`
+	FailedLogins int        `json:"failed_logins"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty"`
+}
+
+// Identity links a user to their subject at an OpenID Connect provider
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Tenant        string
+	Name          string
+	Email         string
+	EmailVerified bool
+	UserID        string
+}
+
+// Account is a user that signs in with a password or through a provider, with the roles
+// they hold
+type Account struct {
+	UserID string
+	Tenant string
+	Name   string
+	Roles  []string
+}
+
+// Session is a signed-in user's pair of access and refresh tokens with the roles the user
+// signed in with. Only hashes of the tokens are stored.
+type Session struct {
+	ID               string
+	Tenant           string
+	UserID           string
+	Name             string
+	Roles            []string
+	AccessHash       string
+	AccessExpiresAt  time.Time
+	RefreshHash      string
+	RefreshExpiresAt time.Time
+	CreatedAt        time.Time
+}
+
+// PendingLogin is a sign-in sent to an OpenID Connect provider, kept by its state parameter
+// until the provider sends the user back
+type PendingLogin struct {
+	State     string
+	Verifier  string
+	Nonce     string
+	ExpiresAt time.Time
+}
+
+type CredentialStore interface {
+	// Register creates the user record of credential together with its password and sets
+	// UserID. It returns ErrEmailTaken when the tenant already has a user with the email.
+	Register(ctx context.Context, credential *Credential) error
+	// Credential returns the credential of the user of tenant with email, or ErrNotFound
+	Credential(ctx context.Context, tenant, email string) (*Credential, error)
+	// RecordFailedLogin counts a wrong password. The lockAfter-th one in a row locks the
+	// account until lockedUntil and starts the count again; locked reports whether it did.
+	RecordFailedLogin(ctx context.Context, tenant, userID string, lockAfter int, lockedUntil time.Time) (locked bool, err error)
+	ResetFailedLogins(ctx context.Context, tenant, userID string) error
+	// ProvisionIdentity sets UserID and Name to those of the user linked to the identity. The
+	// first sign-in links the tenant's user with the identity's email if the provider verified
+	// it, or creates a user; an unverified email already in use returns ErrEmailTaken.
+	ProvisionIdentity(ctx context.Context, identity *Identity) error
+	// Account returns the tenant's user with userID if they have a password or have signed in
+	// through a provider, with the role of their password or the roles of their latest session,
+	// or ErrNotFound
+	Account(ctx context.Context, tenant, userID string) (*Account, error)
+
+	CreateSession(ctx context.Context, session *Session) error
+	// SessionByAccess and SessionByRefresh find the unrevoked session holding a token hash,
+	// expired or not, or return ErrNotFound
+	SessionByAccess(ctx context.Context, accessHash string) (*Session, error)
+	SessionByRefresh(ctx context.Context, refreshHash string) (*Session, error)
+	// RotateSession stores the new tokens of session if its refresh token is still
+	// refreshHash, and returns ErrNotFound if another refresh or a revocation came first
+	RotateSession(ctx context.Context, session *Session, refreshHash string) error
+	RevokeSession(ctx context.Context, id string) error
+
+	// SavePendingLogin keeps a sign-in until its callback, on whichever instance that arrives
+	SavePendingLogin(ctx context.Context, login *PendingLogin) error
+	// TakePendingLogin deletes the sign-in with state and returns it unless it expired by now,
+	// so each state works once. Unknown and expired states return ErrNotFound.
+	TakePendingLogin(ctx context.Context, state string, now time.Time) (*PendingLogin, error)
+}
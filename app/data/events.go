@@ -0,0 +1,65 @@
+package data
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ChangeEvent describes a successful create, update or delete of an entity
+type ChangeEvent struct {
+	ID             string      `json:"id"`
+	Type           string      `json:"type"`
+	Entity         string      `json:"entity"`
+	Action         string      `json:"action"`
+	RecordID       string      `json:"record_id"`
+	UserID         string      `json:"user_id"`
+	ImpersonatorID string      `json:"impersonator_id,omitempty"` // the user acting as UserID, if any
+	Tenant         string      `json:"tenant"`
+	Data           interface{} `json:"data"`
+	OccurredAt     time.Time   `json:"occurred_at"`
+}
+
+func NewChangeEvent(command *Command, result interface{}) ChangeEvent {
+	return ChangeEvent{
+		ID:             NewEventID(),
+		Type:           command.Entity + "." + command.Action,
+		Entity:         command.Entity,
+		Action:         command.Action,
+		RecordID:       recordID(command, result),
+		UserID:         command.UserID,
+		ImpersonatorID: command.ImpersonatorID,
+		Tenant:         command.Tenant,
+		Data:           result,
+		OccurredAt:     time.Now().UTC(),
+	}
+}
+
+func NewEventID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// recordID finds the ID of the affected record, preferring the executed result
+// over the command data since creates only learn their ID from the store.
+func recordID(command *Command, result interface{}) string {
+	if raw, err := json.Marshal(result); err == nil {
+		var fields map[string]interface{}
+		if json.Unmarshal(raw, &fields) == nil {
+			if id, ok := fields["id"]; ok && id != nil {
+				return fmt.Sprintf("%v", id)
+			}
+		}
+	}
+
+	if id, ok := command.Data["id"]; ok && id != nil {
+		return fmt.Sprintf("%v", id)
+	}
+
+	return ""
+}
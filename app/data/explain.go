@@ -0,0 +1,33 @@
+package data
+
+import (
+	"context"
+	"fmt"
+
+	"drm-app/app/db"
+)
+
+// StatementExplainer reports the SQL statements a command executes without committing them
+type StatementExplainer interface {
+	ExplainCommand(ctx context.Context, command *Command) ([]db.Statement, error)
+}
+
+// ExplainCommand runs command with a statement recorder in place, which records every
+// statement it issues, including reads that depend on earlier results such as page totals and
+// expanded records, and rolls its tenant transaction back. The statements are returned with
+// the error execution would fail with.
+func (p *PostgresDataAgent) ExplainCommand(ctx context.Context, command *Command) ([]db.Statement, error) {
+	ctx, recorder := db.WithStatementRecorder(ctx)
+	_, err := p.ExecuteCommand(ctx, command)
+
+	statements := recorder.Statements()
+	if len(statements) == 0 && err == nil {
+		err = fmt.Errorf("%s %s runs no SQL", command.Action, command.Entity)
+	}
+	return statements, err
+}
+
+// ExplainCommand reports the statements of the PostgreSQL agent every command ends up running
+func (p *PostgresLLMDataAgent) ExplainCommand(ctx context.Context, command *Command) ([]db.Statement, error) {
+	return NewPostgresDataAgent(p.db).ExplainCommand(ctx, command)
+}
@@ -0,0 +1,180 @@
+package data
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// Condition compares one field of a record with a value, e.g. status = pending
+type Condition struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+}
+
+// sqlOperators maps the filter operators to SQL
+var sqlOperators = map[string]string{"=": "=", "!=": "<>", ">": ">", ">=": ">=", "<": "<", "<=": "<="}
+
+type fieldKind int
+
+const (
+	textField fieldKind = iota
+	intField
+	numberField
+	timeField
+	// jsonField values are JSON documents; they are imported but cannot be filtered on
+	jsonField
+)
+
+// filterFields lists the fields conditions and groupings may use, with how their values compare
+var filterFields = map[string]map[string]fieldKind{
+	"user": {
+		"id": intField, "name": textField, "email": textField, "created_at": timeField, "updated_at": timeField,
+	},
+	"product": {
+		"id": intField, "name": textField, "price": numberField, "description": textField,
+		"created_at": timeField, "updated_at": timeField,
+	},
+	"order": {
+		"id": intField, "user_id": intField, "total_amount": numberField, "status": textField,
+		"created_at": timeField, "updated_at": timeField,
+	},
+}
+
+// ValidateConditions checks that every condition names a filterable field, a known operator
+// and a value of the field's type
+func ValidateConditions(entity string, conditions []Condition) error {
+	for _, condition := range conditions {
+		if _, err := condition.sqlValue(entity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqlValue converts the condition value to the Go type bound for its column
+func (c Condition) sqlValue(entity string) (interface{}, error) {
+	kind, exists := filterFields[entity][c.Field]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s cannot be filtered by %q", ErrInvalidFilter, entity, c.Field)
+	}
+	if _, exists := sqlOperators[c.Op]; !exists {
+		return nil, fmt.Errorf("%w: unknown operator %q", ErrInvalidFilter, c.Op)
+	}
+
+	value, ok := convertValue(kind, c.Value)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v is not a valid value for %s", ErrInvalidFilter, c.Value, c.Field)
+	}
+	return value, nil
+}
+
+func convertValue(kind fieldKind, value interface{}) (interface{}, bool) {
+	switch kind {
+	case intField:
+		id, ok := referenceID(value)
+		return int64(id), ok
+	case numberField:
+		switch v := value.(type) {
+		case float64:
+			return v, true
+		case int:
+			return float64(v), true
+		case string:
+			number, err := strconv.ParseFloat(v, 64)
+			return number, err == nil
+		}
+		return nil, false
+	case timeField:
+		switch v := value.(type) {
+		case time.Time:
+			return v, true
+		case string:
+			return parseTime(v)
+		}
+		return nil, false
+	default:
+		if value == nil {
+			return nil, false
+		}
+		return fmt.Sprint(value), true
+	}
+}
+
+// parseTime accepts dates (2025-03-10) and RFC 3339 timestamps in any letter case
+func parseTime(value string) (time.Time, bool) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, true
+	}
+	t, err := time.Parse(time.RFC3339, strings.ToUpper(value))
+	return t, err == nil
+}
+
+// whereClause renders conditions as a SQL WHERE clause, appending their values to args
+func whereClause(entity string, conditions []Condition, args *[]interface{}) (string, error) {
+	if len(conditions) == 0 {
+		return "", nil
+	}
+
+	parts := make([]string, len(conditions))
+	for i, condition := range conditions {
+		value, err := condition.sqlValue(entity)
+		if err != nil {
+			return "", err
+		}
+		*args = append(*args, value)
+		parts[i] = fmt.Sprintf("%s %s $%d", condition.Field, sqlOperators[condition.Op], len(*args))
+	}
+	return " WHERE " + strings.Join(parts, " AND "), nil
+}
+
+// matchConditions evaluates conditions against an in-memory record
+func matchConditions(entity string, record map[string]interface{}, conditions []Condition) (bool, error) {
+	for _, condition := range conditions {
+		want, err := condition.sqlValue(entity)
+		if err != nil {
+			return false, err
+		}
+
+		have, ok := convertValue(filterFields[entity][condition.Field], record[condition.Field])
+		if !ok || !compare(have, want, condition.Op) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func compare(have, want interface{}, op string) bool {
+	var order int
+	switch h := have.(type) {
+	case int64:
+		order = cmp.Compare(h, want.(int64))
+	case float64:
+		order = cmp.Compare(h, want.(float64))
+	case time.Time:
+		order = h.Compare(want.(time.Time))
+	default:
+		order = strings.Compare(have.(string), want.(string))
+	}
+
+	switch op {
+	case "=":
+		return order == 0
+	case "!=":
+		return order != 0
+	case ">":
+		return order > 0
+	case ">=":
+		return order >= 0
+	case "<":
+		return order < 0
+	default:
+		return order <= 0
+	}
+}
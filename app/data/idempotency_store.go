@@ -0,0 +1,46 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+const (
+	IdempotencyStatusPending   = "pending"
+	IdempotencyStatusCompleted = "completed"
+)
+
+// IdempotencyRecord remembers the outcome of a request sent with an Idempotency-Key.
+// Keys are scoped to the user that sent them, identified by Scope.
+type IdempotencyRecord struct {
+	Scope       string          `json:"scope"`
+	Key         string          `json:"key"`
+	Fingerprint string          `json:"fingerprint"`
+	Status      string          `json:"status"`
+	Response    json.RawMessage `json:"response,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	ExpiresAt   time.Time       `json:"expires_at"`
+	// LegacyScope is the bare user ID keys were scoped to before scopes named the tenant and
+	// identity source. A live record stored under it is returned by Reserve in place of
+	// reserving the key, so requests retried across the upgrade are not run twice.
+	LegacyScope string `json:"-"`
+}
+
+type IdempotencyStore interface {
+	// Reserve stores record as pending unless a live record already holds the key, in which
+	// case that record is returned instead. Expired records, and pending ones created before
+	// staleBefore, are replaced.
+	Reserve(ctx context.Context, record *IdempotencyRecord, staleBefore time.Time) (existing *IdempotencyRecord, err error)
+	Complete(ctx context.Context, scope, key string, response json.RawMessage) error
+	// Release drops a pending reservation so the key can be retried
+	Release(ctx context.Context, scope, key string) error
+}
+
+// live reports whether the record still holds its key at now: it has not expired, and is not a
+// pending record created before staleBefore whose request has presumably gone away
+func (r *IdempotencyRecord) live(now, staleBefore time.Time) bool {
+	expired := !r.ExpiresAt.After(now)
+	stale := r.Status == IdempotencyStatusPending && r.CreatedAt.Before(staleBefore)
+	return !expired && !stale
+}
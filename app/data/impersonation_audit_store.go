@@ -0,0 +1,30 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// ImpersonationEntry is the audit record of one request made while acting as another user,
+// whether it was allowed or not
+type ImpersonationEntry struct {
+	ID                 int    `json:"id"`
+	Tenant             string `json:"tenant"`
+	ImpersonatorID     string `json:"impersonator_id"`
+	ImpersonatorSource string `json:"impersonator_source"`
+	UserID             string `json:"user_id"`
+	// UserSource is empty when the user to act as was not found
+	UserSource string `json:"user_source,omitempty"`
+	Entity     string `json:"entity"`
+	Action     string `json:"action"`
+	// Stage is where the request ended, such as impersonation when acting as the user was refused
+	Stage     string    `json:"stage"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ImpersonationAuditStore interface {
+	RecordImpersonation(ctx context.Context, entry *ImpersonationEntry) error
+}
@@ -6,11 +6,22 @@ import (
 )
 
 type Command struct {
-	Action   string                 `json:"action"`
-	Entity   string                 `json:"entity"`
-	Data     map[string]interface{} `json:"data"`
-	UserID   string                 `json:"user_id"`
-	UserRole string                 `json:"user_role"`
+	Action string                 `json:"action"`
+	Entity string                 `json:"entity"`
+	Data   map[string]interface{} `json:"data"`
+	UserID string                 `json:"user_id"`
+	// ImpersonatorID is the user acting as UserID, when the command is issued on their behalf
+	ImpersonatorID string `json:"impersonator_id,omitempty"`
+	// Tenant owns the records the command reads and writes
+	Tenant string `json:"tenant"`
+	// UserRoles are the roles of the user issuing the command, in order
+	UserRoles []string `json:"user_roles"`
+	// UserAttributes describe the user to policy conditions
+	UserAttributes map[string]string `json:"user_attributes,omitempty"`
+	// Aggregation is set for the aggregate action
+	Aggregation *Aggregation `json:"aggregation,omitempty"`
+	// Bulk is set for the bulk_update and bulk_delete actions
+	Bulk *Bulk `json:"bulk,omitempty"`
 }
 
 type DataExecutor interface {
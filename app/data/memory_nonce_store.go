@@ -0,0 +1,53 @@
+package data
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryNonceStore keeps nonces in process memory, at most max of them. It is suited to a
+// single instance; behind a load balancer a request can be replayed against another replica.
+type MemoryNonceStore struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+	max  int
+}
+
+func NewMemoryNonceStore(max int) *MemoryNonceStore {
+	return &MemoryNonceStore{
+		seen: make(map[string]time.Time),
+		max:  max,
+	}
+}
+
+func (s *MemoryNonceStore) Claim(ctx context.Context, keyID, nonce string, expiresAt, now time.Time) (bool, error) {
+	nonce = keyID + "\x00" + nonce
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if expires, seen := s.seen[nonce]; seen && now.Before(expires) {
+		return false, nil
+	}
+	if len(s.seen) >= s.max {
+		for seen, expires := range s.seen {
+			if !now.Before(expires) {
+				delete(s.seen, seen)
+			}
+		}
+	}
+	// Forgetting live nonces would let requests be replayed, so refuse new ones instead
+	if len(s.seen) >= s.max {
+		return false, ErrNonceStoreFull
+	}
+	s.seen[nonce] = expiresAt
+	return true, nil
+}
+
+// Len returns the number of nonces remembered, live or not
+func (s *MemoryNonceStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.seen)
+}
@@ -0,0 +1,87 @@
+package data
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// bucketSweepInterval is how often full buckets are dropped
+const bucketSweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket will have refilled, after which it is the same as a new one
+	full time.Time
+}
+
+// MemoryRateLimitStore keeps counters in process memory. It is suited to a single instance;
+// replicas each enforce their own limits. Buckets are dropped once they have refilled, so
+// memory follows the callers of the last few minutes rather than every caller ever seen.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	day       string
+	quotas    map[string]int
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets: make(map[string]*bucket),
+		quotas:  make(map[string]int),
+	}
+}
+
+func (s *MemoryRateLimitStore) TakeToken(ctx context.Context, key string, limit RateLimit, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, exists := s.buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = b
+	}
+
+	tokens, allowed, retryAfter := refill(b.tokens, b.last, now, limit)
+	b.tokens = tokens
+	if now.After(b.last) {
+		b.last = now
+	}
+	b.full = b.last.Add(time.Duration((float64(limit.Burst) - tokens) / limit.Rate * float64(time.Second)))
+
+	if now.Sub(s.lastSweep) >= bucketSweepInterval {
+		for key, b := range s.buckets {
+			if !now.Before(b.full) {
+				delete(s.buckets, key)
+			}
+		}
+		s.lastSweep = now
+	}
+	return allowed, retryAfter, nil
+}
+
+// Buckets returns how many token buckets are kept
+func (s *MemoryRateLimitStore) Buckets() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buckets)
+}
+
+func (s *MemoryRateLimitStore) ConsumeQuota(ctx context.Context, key string, day string, limit int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Only the current day is ever checked, so earlier counters can go
+	if day != s.day {
+		s.day = day
+		s.quotas = make(map[string]int)
+	}
+
+	if s.quotas[key] >= limit {
+		return false, nil
+	}
+	s.quotas[key]++
+	return true, nil
+}
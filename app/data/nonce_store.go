@@ -0,0 +1,18 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNonceStoreFull is returned when a nonce store cannot remember another nonce without
+// forgetting one that is still live
+var ErrNonceStoreFull = errors.New("nonce store is full")
+
+// NonceStore remembers the nonces of signed requests so each is accepted once
+type NonceStore interface {
+	// Claim records the nonce of keyID until expiresAt and reports whether it was not already
+	// live at now
+	Claim(ctx context.Context, keyID, nonce string, expiresAt, now time.Time) (fresh bool, err error)
+}
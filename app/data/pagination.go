@@ -0,0 +1,187 @@
+package data
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultPageSize = 50
+	// MaxPageSize bounds any single list read, whatever the role allows
+	MaxPageSize = 1000
+)
+
+// Reserved Command.Data keys that control list reads
+const (
+	LimitKey        = "limit"
+	CursorKey       = "cursor"
+	IncludeTotalKey = "include_total"
+)
+
+var ErrInvalidPage = errors.New("invalid page request")
+
+// Cursors are lowercase base32 so they survive the intent parser lowercasing queries
+var cursorEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// PageRequest selects one page of a list read ordered by id
+type PageRequest struct {
+	Limit        int
+	After        *int
+	Before       *int
+	IncludeTotal bool
+}
+
+// PageInfo describes where a page sits in the list. Next and Prev are opaque cursors for
+// the neighbouring pages and are empty at either end.
+type PageInfo struct {
+	Limit int    `json:"limit"`
+	Next  string `json:"next,omitempty"`
+	Prev  string `json:"prev,omitempty"`
+	Total *int   `json:"total,omitempty"`
+}
+
+// Page is the result of a list read
+type Page struct {
+	Items interface{} `json:"items"`
+	PageInfo
+}
+
+type cursor struct {
+	Entity string `json:"e"`
+	ID     int    `json:"id"`
+	Before bool   `json:"b,omitempty"`
+	// Offset positions search cursors, see SearchRequest
+	Offset int `json:"o,omitempty"`
+}
+
+func encodeCursor(entity string, id int, before bool) string {
+	return encodeCursorPayload(cursor{Entity: entity, ID: id, Before: before})
+}
+
+func encodeCursorPayload(c cursor) string {
+	payload, _ := json.Marshal(c)
+	return strings.ToLower(cursorEncoding.EncodeToString(payload))
+}
+
+func decodeCursor(entity, value string) (*cursor, error) {
+	invalid := fmt.Errorf("%w: cursor is malformed or belongs to another list", ErrInvalidPage)
+
+	payload, err := cursorEncoding.DecodeString(strings.ToUpper(value))
+	if err != nil {
+		return nil, invalid
+	}
+
+	var c cursor
+	if err := json.Unmarshal(payload, &c); err != nil || c.Entity != entity {
+		return nil, invalid
+	}
+	return &c, nil
+}
+
+// ParsePageRequest reads the limit, cursor and include_total keys of a list read. A missing
+// limit means DefaultPageSize; larger limits are capped at MaxPageSize.
+func ParsePageRequest(entity string, data map[string]interface{}) (PageRequest, error) {
+	page := PageRequest{Limit: DefaultPageSize}
+
+	if value, ok := data[LimitKey]; ok {
+		limit, err := ParsePageLimit(value)
+		if err != nil {
+			return page, err
+		}
+		page.Limit = min(limit, MaxPageSize)
+	}
+
+	if value, ok := data[CursorKey].(string); ok && value != "" {
+		c, err := decodeCursor(entity, value)
+		if err != nil {
+			return page, err
+		}
+		if c.Before {
+			page.Before = &c.ID
+		} else {
+			page.After = &c.ID
+		}
+	}
+
+	switch value := data[IncludeTotalKey].(type) {
+	case bool:
+		page.IncludeTotal = value
+	case string:
+		page.IncludeTotal = value == "true"
+	}
+
+	return page, nil
+}
+
+// ParsePageLimit accepts a limit given as a JSON number, an int or a numeric string
+func ParsePageLimit(value interface{}) (int, error) {
+	var limit int
+	switch v := value.(type) {
+	case int:
+		limit = v
+	case float64:
+		if v != float64(int(v)) {
+			return 0, fmt.Errorf("%w: limit must be a whole number", ErrInvalidPage)
+		}
+		limit = int(v)
+	case string:
+		parsed, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("%w: limit must be a whole number", ErrInvalidPage)
+		}
+		limit = parsed
+	default:
+		return 0, fmt.Errorf("%w: limit must be a whole number", ErrInvalidPage)
+	}
+
+	if limit < 1 {
+		return 0, fmt.Errorf("%w: limit must be at least 1", ErrInvalidPage)
+	}
+	return limit, nil
+}
+
+// newPage builds a page from rows fetched in scan order: ascending ids after the cursor, or
+// descending ids before it. rows holds up to Limit+1 entries; the extra one only signals
+// that the list continues in the scan direction.
+func newPage[T any](entity string, rows []T, id func(T) int, request PageRequest) *Page {
+	more := len(rows) > request.Limit
+	if more {
+		rows = rows[:request.Limit]
+	}
+
+	backward := request.Before != nil
+	if backward {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	page := &Page{Items: rows, PageInfo: PageInfo{Limit: request.Limit}}
+	if rows == nil {
+		page.Items = []T{}
+	}
+
+	if len(rows) > 0 {
+		first, last := id(rows[0]), id(rows[len(rows)-1])
+		if backward || more {
+			page.Next = encodeCursor(entity, last, false)
+		}
+		if (backward && more) || request.After != nil {
+			page.Prev = encodeCursor(entity, first, true)
+		}
+	} else {
+		// An empty page past either end still links back towards the data
+		if request.After != nil {
+			page.Prev = encodeCursor(entity, *request.After+1, true)
+		}
+		if backward {
+			page.Next = encodeCursor(entity, *request.Before-1, false)
+		}
+	}
+
+	return page
+}
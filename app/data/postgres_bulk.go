@@ -0,0 +1,181 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// upsert inserts the record or, when its unique key exists, updates the fields it carries.
+// xmax is zero only for freshly inserted rows, which tells the two apart.
+func (p *PostgresDataAgent) upsert(ctx context.Context, entity string, data map[string]interface{}) (interface{}, error) {
+	if err := ValidateUpsert(entity, data); err != nil {
+		return nil, err
+	}
+
+	var (
+		query    string
+		args     []interface{}
+		row      interface{}
+		inserted bool
+	)
+	switch entity {
+	case "user":
+		var user User
+		query = `INSERT INTO users (name, email) VALUES ($1, $2)
+			ON CONFLICT (tenant_id, email) DO UPDATE SET name = EXCLUDED.name, updated_at = $3
+			RETURNING id, name, email, created_at, updated_at, xmax = 0`
+		args = []interface{}{data["name"], data["email"], time.Now()}
+		err := p.db.Conn(ctx).QueryRowContext(ctx, query, args...).Scan(
+			&user.ID, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt, &inserted,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upsert user: %w", err)
+		}
+		row = user
+	case "product":
+		set := []string{"price = EXCLUDED.price", "updated_at = $4"}
+		if _, exists := data["description"]; exists {
+			set = append(set, "description = EXCLUDED.description")
+		}
+		description, _ := data["description"].(string)
+
+		var product Product
+		query = fmt.Sprintf(`INSERT INTO products (name, price, description) VALUES ($1, $2, $3)
+			ON CONFLICT (tenant_id, name) DO UPDATE SET %s
+			RETURNING id, name, price, description, created_at, updated_at, xmax = 0`, strings.Join(set, ", "))
+		args = []interface{}{data["name"], data["price"], description, time.Now()}
+		err := p.db.Conn(ctx).QueryRowContext(ctx, query, args...).Scan(
+			&product.ID, &product.Name, &product.Price, &product.Description, &product.CreatedAt, &product.UpdatedAt, &inserted,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upsert product: %w", err)
+		}
+		row = product
+	default:
+		return nil, fmt.Errorf("unsupported entity: %s", entity)
+	}
+
+	return newUpsertResult(row, inserted)
+}
+
+func newUpsertResult(row interface{}, inserted bool) (*UpsertResult, error) {
+	record, err := toRecord(row)
+	if err != nil {
+		return nil, err
+	}
+	result := &UpsertResult{Operation: "update", Record: record}
+	if inserted {
+		result.Operation = "create"
+	}
+	return result, nil
+}
+
+// bulk previews or applies a bulk_update or bulk_delete. The change runs in the command's
+// tenant transaction, which is rolled back unless it affected exactly the confirmed number of
+// records.
+func (p *PostgresDataAgent) bulk(ctx context.Context, command *Command) (interface{}, error) {
+	bulk := command.Bulk
+	if err := ValidateBulk(command.Entity, command.Action, bulk, command.Data); err != nil {
+		return nil, err
+	}
+	if err := checkBulk(bulk); err != nil {
+		return nil, err
+	}
+	table := entityTables[command.Entity]
+	result := &BulkResult{MaxAffected: bulk.MaxAffected}
+
+	if bulk.Confirm == nil {
+		args := []interface{}{}
+		where, err := whereClause(command.Entity, bulk.Where, &args)
+		if err != nil {
+			return nil, err
+		}
+		query := fmt.Sprintf(`SELECT count(*) FROM %s%s`, table, where)
+		if err := p.db.Conn(ctx).GetContext(ctx, &result.Matched, query, args...); err != nil {
+			return nil, fmt.Errorf("failed to preview %s: %w", command.Action, err)
+		}
+		result.Preview = true
+		return result, nil
+	}
+
+	args := []interface{}{}
+	statement := fmt.Sprintf(`DELETE FROM %s`, table)
+	if command.Action == "bulk_update" {
+		set, err := assignments(command.Entity, command.Data)
+		if err != nil {
+			return nil, err
+		}
+		parts := make([]string, 0, len(set)+1)
+		for _, assignment := range set {
+			value := assignment.Value
+			if _, filterable := filterFields[command.Entity][assignment.Field]; !filterable {
+				// JSON columns such as order items
+				encoded, err := json.Marshal(value)
+				if err != nil {
+					return nil, fmt.Errorf("failed to marshal %s: %w", assignment.Field, err)
+				}
+				value = string(encoded)
+			}
+			args = append(args, value)
+			parts = append(parts, fmt.Sprintf("%s = $%d", assignment.Field, len(args)))
+		}
+		args = append(args, time.Now())
+		parts = append(parts, fmt.Sprintf("updated_at = $%d", len(args)))
+		statement = fmt.Sprintf(`UPDATE %s SET %s`, table, strings.Join(parts, ", "))
+	}
+
+	where, err := whereClause(command.Entity, bulk.Where, &args)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := p.db.Conn(ctx).QueryxContext(ctx, statement+where+` RETURNING `+entityColumns[command.Entity], args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply %s: %w", command.Action, err)
+	}
+	defer rows.Close()
+
+	switch command.Entity {
+	case "user":
+		result.Records, err = scanRecords[User](rows)
+	case "product":
+		result.Records, err = scanRecords[Product](rows)
+	case "order":
+		result.Records, err = scanRecords[Order](rows)
+	default:
+		err = fmt.Errorf("unsupported entity: %s", command.Entity)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply %s: %w", command.Action, err)
+	}
+
+	affected := len(result.Records)
+	if err := checkAffected(bulk, affected); err != nil {
+		return nil, err
+	}
+	result.Matched = affected
+	result.Affected = affected
+	return result, nil
+}
+
+// scanRecords reads every row into a T and returns them as records
+func scanRecords[T any](rows *sqlx.Rows) ([]map[string]interface{}, error) {
+	records := []map[string]interface{}{}
+	for rows.Next() {
+		var row T
+		if err := rows.StructScan(&row); err != nil {
+			return nil, err
+		}
+		record, err := toRecord(row)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
@@ -0,0 +1,91 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"drm-app/app/db"
+)
+
+const (
+	ChangeChannel = "drm_changes"
+
+	// Postgres rejects NOTIFY payloads of 8000 bytes or more
+	maxNotifyPayload = 7900
+)
+
+// PostgresChangeNotifier fans change events out to every replica using LISTEN/NOTIFY
+type PostgresChangeNotifier struct {
+	db *db.Database
+}
+
+func NewPostgresChangeNotifier(database *db.Database) *PostgresChangeNotifier {
+	return &PostgresChangeNotifier{
+		db: database,
+	}
+}
+
+func (n *PostgresChangeNotifier) Notify(ctx context.Context, event ChangeEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal change event: %w", err)
+	}
+
+	// Oversized records are announced without their data; subscribers can read them by record_id
+	if len(payload) > maxNotifyPayload {
+		event.Data = nil
+		if payload, err = json.Marshal(event); err != nil {
+			return fmt.Errorf("failed to marshal change event: %w", err)
+		}
+	}
+
+	if _, err := n.db.Pool.Exec(ctx, `SELECT pg_notify($1, $2)`, ChangeChannel, string(payload)); err != nil {
+		return fmt.Errorf("failed to notify change: %w", err)
+	}
+	return nil
+}
+
+func (n *PostgresChangeNotifier) Listen(ctx context.Context, deliver func(ChangeEvent)) error {
+	for {
+		err := n.listen(ctx, deliver)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		slog.WarnContext(ctx, "change listener disconnected, reconnecting", "error", err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+func (n *PostgresChangeNotifier) listen(ctx context.Context, deliver func(ChangeEvent)) error {
+	conn, err := n.db.Pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire listener connection: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+ChangeChannel); err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", ChangeChannel, err)
+	}
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var event ChangeEvent
+		if err := json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+			slog.WarnContext(ctx, "ignoring malformed change notification", "error", err)
+			continue
+		}
+		deliver(event)
+	}
+}
@@ -0,0 +1,320 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"drm-app/app/db"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	// uniqueViolation is the PostgreSQL error code of a duplicate key
+	uniqueViolation = "23505"
+	// loginSweepInterval is how often sign-ins that never came back are deleted
+	loginSweepInterval = time.Minute
+)
+
+// PostgresCredentialStore keeps credentials and sessions as the owner of the tables, so every
+// query names its tenant itself
+type PostgresCredentialStore struct {
+	db        *db.Database
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewPostgresCredentialStore(database *db.Database) *PostgresCredentialStore {
+	return &PostgresCredentialStore{
+		db: database,
+	}
+}
+
+func (s *PostgresCredentialStore) Register(ctx context.Context, credential *Credential) error {
+	query := `
+		WITH created AS (
+			INSERT INTO users (tenant_id, name, email) VALUES ($1, $2, $3) RETURNING id
+		)
+		INSERT INTO user_credentials (tenant_id, user_id, role, password_hash)
+		SELECT $1, id, $4, $5 FROM created
+		RETURNING user_id`
+
+	var userID int
+	err := s.db.DB.QueryRowContext(ctx, query,
+		credential.Tenant, credential.Name, credential.Email, credential.Role, credential.PasswordHash,
+	).Scan(&userID)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return ErrEmailTaken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to register user: %w", err)
+	}
+
+	credential.UserID = strconv.Itoa(userID)
+	return nil
+}
+
+func (s *PostgresCredentialStore) Credential(ctx context.Context, tenant, email string) (*Credential, error) {
+	query := `
+		SELECT c.user_id, c.tenant_id, u.name, u.email, c.role, c.password_hash, c.failed_logins, c.locked_until
+		FROM user_credentials c JOIN users u ON u.tenant_id = c.tenant_id AND u.id = c.user_id
+		WHERE c.tenant_id = $1 AND lower(u.email) = lower($2)`
+
+	var credential Credential
+	err := s.db.DB.QueryRowContext(ctx, query, tenant, email).Scan(
+		&credential.UserID, &credential.Tenant, &credential.Name, &credential.Email, &credential.Role,
+		&credential.PasswordHash, &credential.FailedLogins, &credential.LockedUntil,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credential: %w", err)
+	}
+	return &credential, nil
+}
+
+// userKey converts the ID of a user to its key in the users table
+func userKey(userID string) (int, error) {
+	id, err := strconv.Atoi(userID)
+	if err != nil {
+		return 0, fmt.Errorf("invalid user id %q: %w", userID, ErrNotFound)
+	}
+	return id, nil
+}
+
+func (s *PostgresCredentialStore) RecordFailedLogin(ctx context.Context, tenant, userID string, lockAfter int, lockedUntil time.Time) (bool, error) {
+	id, err := userKey(userID)
+	if err != nil {
+		return false, err
+	}
+	query := `
+		UPDATE user_credentials SET
+			failed_logins = CASE WHEN failed_logins + 1 >= $3 THEN 0 ELSE failed_logins + 1 END,
+			locked_until = CASE WHEN failed_logins + 1 >= $3 THEN $4 ELSE locked_until END
+		WHERE tenant_id = $1 AND user_id = $2
+		RETURNING failed_logins = 0`
+
+	var locked bool
+	err = s.db.DB.QueryRowContext(ctx, query, tenant, id, lockAfter, lockedUntil).Scan(&locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrNotFound
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to record failed login: %w", err)
+	}
+	return locked, nil
+}
+
+func (s *PostgresCredentialStore) ResetFailedLogins(ctx context.Context, tenant, userID string) error {
+	id, err := userKey(userID)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.DB.ExecContext(ctx,
+		`UPDATE user_credentials SET failed_logins = 0 WHERE tenant_id = $1 AND user_id = $2`, tenant, id)
+	if err != nil {
+		return fmt.Errorf("failed to reset failed logins: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresCredentialStore) ProvisionIdentity(ctx context.Context, identity *Identity) error {
+	tx, err := s.db.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin provisioning: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRowContext(ctx, `
+		SELECT u.id, u.name FROM user_identities i JOIN users u ON u.tenant_id = i.tenant_id AND u.id = i.user_id
+		WHERE i.issuer = $1 AND i.subject = $2 AND i.tenant_id = $3`,
+		identity.Issuer, identity.Subject, identity.Tenant,
+	).Scan(&userID, &identity.Name)
+	if err == nil {
+		identity.UserID = strconv.Itoa(userID)
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to read identity: %w", err)
+	}
+
+	var existingName string
+	err = tx.QueryRowContext(ctx, `SELECT id, name FROM users WHERE tenant_id = $1 AND $2 <> '' AND lower(email) = lower($2)`,
+		identity.Tenant, identity.Email).Scan(&userID, &existingName)
+	switch {
+	case err == nil && !identity.EmailVerified:
+		return ErrEmailTaken
+	case err == nil:
+		identity.Name = existingName
+	case errors.Is(err, sql.ErrNoRows):
+		err = tx.QueryRowContext(ctx, `INSERT INTO users (tenant_id, name, email) VALUES ($1, $2, NULLIF($3, '')) RETURNING id`,
+			identity.Tenant, identity.Name, identity.Email).Scan(&userID)
+		if err != nil {
+			return fmt.Errorf("failed to provision user: %w", err)
+		}
+	default:
+		return fmt.Errorf("failed to read user: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO user_identities (issuer, subject, tenant_id, user_id) VALUES ($1, $2, $3, $4)`,
+		identity.Issuer, identity.Subject, identity.Tenant, userID)
+	if err != nil {
+		return fmt.Errorf("failed to link identity: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit provisioning: %w", err)
+	}
+
+	identity.UserID = strconv.Itoa(userID)
+	return nil
+}
+
+func (s *PostgresCredentialStore) Account(ctx context.Context, tenant, userID string) (*Account, error) {
+	id, err := userKey(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	account := &Account{UserID: userID, Tenant: tenant}
+	var roles string
+	err = s.db.DB.QueryRowContext(ctx, `
+		SELECT u.name, COALESCE(c.role, latest.roles) FROM users u
+		LEFT JOIN user_credentials c ON c.tenant_id = u.tenant_id AND c.user_id = u.id
+		LEFT JOIN LATERAL (
+			SELECT roles FROM sessions s WHERE s.tenant_id = u.tenant_id AND s.user_id = u.id
+			ORDER BY s.created_at DESC LIMIT 1
+		) latest ON true
+		WHERE u.tenant_id = $1 AND u.id = $2 AND (c.user_id IS NOT NULL OR latest.roles IS NOT NULL)`,
+		tenant, id,
+	).Scan(&account.Name, &roles)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read account: %w", err)
+	}
+	account.Roles = splitActions(roles)
+	return account, nil
+}
+
+func (s *PostgresCredentialStore) CreateSession(ctx context.Context, session *Session) error {
+	id, err := userKey(session.UserID)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.DB.ExecContext(ctx, `
+		INSERT INTO sessions (id, tenant_id, user_id, roles, access_hash, access_expires_at, refresh_hash, refresh_expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		session.ID, session.Tenant, id, strings.Join(session.Roles, ","), session.AccessHash, session.AccessExpiresAt,
+		session.RefreshHash, session.RefreshExpiresAt, session.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresCredentialStore) SessionByAccess(ctx context.Context, accessHash string) (*Session, error) {
+	return s.session(ctx, "access_hash", accessHash)
+}
+
+func (s *PostgresCredentialStore) SessionByRefresh(ctx context.Context, refreshHash string) (*Session, error) {
+	return s.session(ctx, "refresh_hash", refreshHash)
+}
+
+func (s *PostgresCredentialStore) session(ctx context.Context, column, hash string) (*Session, error) {
+	query := fmt.Sprintf(`
+		SELECT s.id, s.tenant_id, s.user_id, u.name, s.roles, s.access_hash, s.access_expires_at,
+			s.refresh_hash, s.refresh_expires_at, s.created_at
+		FROM sessions s JOIN users u ON u.tenant_id = s.tenant_id AND u.id = s.user_id
+		WHERE s.%s = $1 AND s.revoked_at IS NULL`, column)
+
+	var session Session
+	var roles string
+	err := s.db.DB.QueryRowContext(ctx, query, hash).Scan(
+		&session.ID, &session.Tenant, &session.UserID, &session.Name, &roles, &session.AccessHash,
+		&session.AccessExpiresAt, &session.RefreshHash, &session.RefreshExpiresAt, &session.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session: %w", err)
+	}
+	session.Roles = splitActions(roles)
+	return &session, nil
+}
+
+func (s *PostgresCredentialStore) RotateSession(ctx context.Context, session *Session, refreshHash string) error {
+	result, err := s.db.DB.ExecContext(ctx, `
+		UPDATE sessions SET access_hash = $3, access_expires_at = $4, refresh_hash = $5, refresh_expires_at = $6
+		WHERE id = $1 AND refresh_hash = $2 AND revoked_at IS NULL`,
+		session.ID, refreshHash, session.AccessHash, session.AccessExpiresAt, session.RefreshHash, session.RefreshExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to rotate session: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresCredentialStore) RevokeSession(ctx context.Context, id string) error {
+	_, err := s.db.DB.ExecContext(ctx, `UPDATE sessions SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresCredentialStore) SavePendingLogin(ctx context.Context, login *PendingLogin) error {
+	s.sweepLogins(ctx, time.Now())
+
+	_, err := s.db.DB.ExecContext(ctx,
+		`INSERT INTO oidc_logins (state, verifier, nonce, expires_at) VALUES ($1, $2, $3, $4)`,
+		login.State, login.Verifier, login.Nonce, login.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to save sign-in: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresCredentialStore) TakePendingLogin(ctx context.Context, state string, now time.Time) (*PendingLogin, error) {
+	login := PendingLogin{State: state}
+	err := s.db.DB.QueryRowContext(ctx,
+		`DELETE FROM oidc_logins WHERE state = $1 RETURNING verifier, nonce, expires_at`, state,
+	).Scan(&login.Verifier, &login.Nonce, &login.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to take sign-in: %w", err)
+	}
+	if !now.Before(login.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return &login, nil
+}
+
+// sweepLogins deletes expired sign-ins at most once per interval. Failures are left to the next
+// sweep, since expired sign-ins are refused anyway.
+func (s *PostgresCredentialStore) sweepLogins(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastSweep) < loginSweepInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSweep = now
+	s.mu.Unlock()
+
+	s.db.DB.ExecContext(ctx, `DELETE FROM oidc_logins WHERE expires_at <= $1`, now)
+}
@@ -11,6 +11,16 @@ import (
 	"drm-app/app/db"
 )
 
+const (
+	userColumns    = `id, name, email, created_at, updated_at`
+	productColumns = `id, name, price, description, created_at, updated_at`
+	orderColumns   = `id, user_id, items, total_amount, status, created_at, updated_at`
+)
+
+var entityTables = map[string]string{"user": "users", "product": "products", "order": "orders"}
+
+var entityColumns = map[string]string{"user": userColumns, "product": productColumns, "order": orderColumns}
+
 type PostgresDataAgent struct {
 	db *db.Database
 }
@@ -21,7 +31,22 @@ func NewPostgresDataAgent(database *db.Database) *PostgresDataAgent {
 	}
 }
 
+// ExecuteCommand runs command in a transaction limited to the tenant of ctx
 func (p *PostgresDataAgent) ExecuteCommand(ctx context.Context, command *Command) (interface{}, error) {
+	tenant, err := TenantFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	err = p.db.InTenant(ctx, tenant, func(ctx context.Context) error {
+		var err error
+		result, err = p.execute(ctx, command)
+		return err
+	})
+	return result, err
+}
+
+func (p *PostgresDataAgent) execute(ctx context.Context, command *Command) (interface{}, error) {
 	switch command.Action {
 	case "create":
 		return p.create(ctx, command.Entity, command.Data)
@@ -31,6 +56,14 @@ func (p *PostgresDataAgent) ExecuteCommand(ctx context.Context, command *Command
 		return p.update(ctx, command.Entity, command.Data)
 	case "delete":
 		return p.delete(ctx, command.Entity, command.Data)
+	case "aggregate":
+		return p.aggregate(ctx, command.Entity, command.Aggregation)
+	case "search":
+		return p.search(ctx, command.Entity, command.Data)
+	case "upsert":
+		return p.upsert(ctx, command.Entity, command.Data)
+	case "bulk_update", "bulk_delete":
+		return p.bulk(ctx, command)
 	default:
 		return nil, fmt.Errorf("unsupported action: %s", command.Action)
 	}
@@ -50,18 +83,66 @@ func (p *PostgresDataAgent) create(ctx context.Context, entity string, data map[
 }
 
 func (p *PostgresDataAgent) read(ctx context.Context, entity string, data map[string]interface{}) (interface{}, error) {
+	options, err := ParseReadOptions(entity, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var result interface{}
+	switch entity {
+	case "user":
+		result, err = p.readUser(ctx, data)
+	case "product":
+		result, err = p.readProduct(ctx, data)
+	case "order":
+		result, err = p.readOrder(ctx, data)
+	default:
+		return nil, fmt.Errorf("unsupported entity: %s", entity)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return shapeResult(ctx, entity, result, options, p.loadRecords)
+}
+
+// loadRecords fetches the records an expand refers to in one query per entity
+func (p *PostgresDataAgent) loadRecords(ctx context.Context, entity string, ids []int) (map[int]map[string]interface{}, error) {
 	switch entity {
 	case "user":
-		return p.readUser(ctx, data)
+		return selectByIDs(ctx, p, "users", userColumns, ids, func(user User) int { return user.ID })
 	case "product":
-		return p.readProduct(ctx, data)
+		return selectByIDs(ctx, p, "products", productColumns, ids, func(product Product) int { return product.ID })
 	case "order":
-		return p.readOrder(ctx, data)
+		return selectByIDs(ctx, p, "orders", orderColumns, ids, func(order Order) int { return order.ID })
 	default:
 		return nil, fmt.Errorf("unsupported entity: %s", entity)
 	}
 }
 
+func selectByIDs[T any](ctx context.Context, p *PostgresDataAgent, table, columns string, ids []int, id func(T) int) (map[int]map[string]interface{}, error) {
+	keys := make([]int64, len(ids))
+	for i, id := range ids {
+		keys[i] = int64(id)
+	}
+
+	var rows []T
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE id = ANY($1)`, columns, table)
+	if err := p.db.Conn(ctx).SelectContext(ctx, &rows, query, keys); err != nil {
+		return nil, err
+	}
+
+	records := make(map[int]map[string]interface{}, len(rows))
+	for _, row := range rows {
+		record, err := toRecord(row)
+		if err != nil {
+			return nil, err
+		}
+		records[id(row)] = record
+	}
+	return records, nil
+}
+
 func (p *PostgresDataAgent) update(ctx context.Context, entity string, data map[string]interface{}) (interface{}, error) {
 	switch entity {
 	case "user":
@@ -88,6 +169,129 @@ func (p *PostgresDataAgent) delete(ctx context.Context, entity string, data map[
 	}
 }
 
+// selectPage reads one page of table by keyset on id: rows after the cursor in ascending
+// order, or before it in descending order, fetching one extra row to detect further pages
+func selectPage[T any](ctx context.Context, p *PostgresDataAgent, entity, table, columns string, request PageRequest, id func(T) int) (*Page, error) {
+	query := fmt.Sprintf(`SELECT %s FROM %s`, columns, table)
+	args := []interface{}{}
+	order := "ASC"
+
+	switch {
+	case request.After != nil:
+		query += ` WHERE id > $1`
+		args = append(args, *request.After)
+	case request.Before != nil:
+		query += ` WHERE id < $1`
+		args = append(args, *request.Before)
+		order = "DESC"
+	}
+	query += fmt.Sprintf(` ORDER BY id %s LIMIT %d`, order, request.Limit+1)
+
+	var rows []T
+	if err := p.db.Conn(ctx).SelectContext(ctx, &rows, query, args...); err != nil {
+		return nil, err
+	}
+
+	page := newPage(entity, rows, id, request)
+
+	if request.IncludeTotal {
+		var total int
+		if err := p.db.Conn(ctx).GetContext(ctx, &total, fmt.Sprintf(`SELECT count(*) FROM %s`, table)); err != nil {
+			return nil, err
+		}
+		page.Total = &total
+	}
+
+	return page, nil
+}
+
+// aggregate runs an aggregation as a single SQL aggregate query. Identifiers come from the
+// field lists checked by ValidateAggregation; values are bound as parameters.
+func (p *PostgresDataAgent) aggregate(ctx context.Context, entity string, aggregation *Aggregation) (interface{}, error) {
+	if err := ValidateAggregation(entity, aggregation); err != nil {
+		return nil, err
+	}
+
+	expression := "COUNT(*)::float8"
+	switch aggregation.Function {
+	case "sum":
+		expression = fmt.Sprintf("COALESCE(SUM(%s), 0)::float8", aggregation.Field)
+	case "avg", "min", "max":
+		expression = fmt.Sprintf("%s(%s)::float8", strings.ToUpper(aggregation.Function), aggregation.Field)
+	}
+
+	args := []interface{}{}
+	where, err := whereClause(entity, aggregation.Where, &args)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &AggregateResult{Function: aggregation.Function, Field: aggregation.Field, GroupBy: aggregation.GroupBy}
+
+	if aggregation.GroupBy == "" {
+		query := fmt.Sprintf(`SELECT %s FROM %s%s`, expression, entityTables[entity], where)
+		if err := p.db.Conn(ctx).QueryRowContext(ctx, query, args...).Scan(&result.Value); err != nil {
+			return nil, fmt.Errorf("failed to aggregate %s: %w", entity, err)
+		}
+		return result, nil
+	}
+
+	query := fmt.Sprintf(`SELECT %[1]s::text, %[2]s FROM %[3]s%[4]s GROUP BY %[1]s ORDER BY %[1]s`,
+		aggregation.GroupBy, expression, entityTables[entity], where)
+	rows, err := p.db.Conn(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate %s: %w", entity, err)
+	}
+	defer rows.Close()
+
+	result.Groups = []AggregateGroup{}
+	for rows.Next() {
+		var group AggregateGroup
+		if err := rows.Scan(&group.Key, &group.Value); err != nil {
+			return nil, fmt.Errorf("failed to aggregate %s: %w", entity, err)
+		}
+		result.Groups = append(result.Groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to aggregate %s: %w", entity, err)
+	}
+
+	return result, nil
+}
+
+// search ranks products against the search text with the GIN-indexed search_vector column.
+// websearch_to_tsquery accepts free text, "quoted phrases", "or" and -exclusions without
+// raising syntax errors.
+func (p *PostgresDataAgent) search(ctx context.Context, entity string, data map[string]interface{}) (interface{}, error) {
+	request, err := ParseSearchRequest(entity, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var hits []ProductHit
+	query := fmt.Sprintf(`SELECT %s, ts_rank(search_vector, q) AS rank,
+		ts_headline('english', concat_ws(' ', name, description), q, 'StartSel=%s, StopSel=%s, MaxFragments=2') AS snippet
+		FROM products, websearch_to_tsquery('english', $1) AS q
+		WHERE search_vector @@ q
+		ORDER BY rank DESC, id ASC LIMIT $2 OFFSET $3`, productColumns, highlightStart, highlightStop)
+	if err := p.db.Conn(ctx).SelectContext(ctx, &hits, query, request.Text, request.Limit+1, request.Offset); err != nil {
+		return nil, fmt.Errorf("failed to search products: %w", err)
+	}
+
+	page := newSearchPage(entity, hits, request)
+
+	if request.IncludeTotal {
+		var total int
+		query := `SELECT count(*) FROM products WHERE search_vector @@ websearch_to_tsquery('english', $1)`
+		if err := p.db.Conn(ctx).GetContext(ctx, &total, query, request.Text); err != nil {
+			return nil, fmt.Errorf("failed to search products: %w", err)
+		}
+		page.Total = &total
+	}
+
+	return page, nil
+}
+
 // User operations
 func (p *PostgresDataAgent) createUser(ctx context.Context, data map[string]interface{}) (interface{}, error) {
 	name, ok := data["name"].(string)
@@ -102,7 +306,7 @@ func (p *PostgresDataAgent) createUser(ctx context.Context, data map[string]inte
 
 	var user User
 	query := `INSERT INTO users (name, email) VALUES ($1, $2) RETURNING id, name, email, created_at, updated_at`
-	err := p.db.DB.QueryRowContext(ctx, query, name, email).Scan(
+	err := p.db.Conn(ctx).QueryRowContext(ctx, query, name, email).Scan(
 		&user.ID, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
@@ -121,7 +325,7 @@ func (p *PostgresDataAgent) readUser(ctx context.Context, data map[string]interf
 
 		var user User
 		query := `SELECT id, name, email, created_at, updated_at FROM users WHERE id = $1`
-		err = p.db.DB.QueryRowContext(ctx, query, id).Scan(
+		err = p.db.Conn(ctx).QueryRowContext(ctx, query, id).Scan(
 			&user.ID, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt,
 		)
 		if err != nil {
@@ -131,14 +335,17 @@ func (p *PostgresDataAgent) readUser(ctx context.Context, data map[string]interf
 		return user, nil
 	}
 
-	var users []User
-	query := `SELECT id, name, email, created_at, updated_at FROM users ORDER BY id`
-	err := p.db.DB.SelectContext(ctx, &users, query)
+	request, err := ParsePageRequest("user", data)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := selectPage(ctx, p, "user", "users", userColumns, request, func(user User) int { return user.ID })
 	if err != nil {
 		return nil, fmt.Errorf("failed to read users: %w", err)
 	}
 
-	return users, nil
+	return page, nil
 }
 
 func (p *PostgresDataAgent) updateUser(ctx context.Context, data map[string]interface{}) (interface{}, error) {
@@ -182,7 +389,7 @@ func (p *PostgresDataAgent) updateUser(ctx context.Context, data map[string]inte
 		strings.Join(setParts, ", "), argIndex)
 
 	var user User
-	err = p.db.DB.QueryRowContext(ctx, query, args...).Scan(
+	err = p.db.Conn(ctx).QueryRowContext(ctx, query, args...).Scan(
 		&user.ID, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
@@ -204,7 +411,7 @@ func (p *PostgresDataAgent) deleteUser(ctx context.Context, data map[string]inte
 	}
 
 	query := `DELETE FROM users WHERE id = $1`
-	result, err := p.db.DB.ExecContext(ctx, query, id)
+	result, err := p.db.Conn(ctx).ExecContext(ctx, query, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to delete user: %w", err)
 	}
@@ -237,7 +444,7 @@ func (p *PostgresDataAgent) createProduct(ctx context.Context, data map[string]i
 
 	var product Product
 	query := `INSERT INTO products (name, price, description) VALUES ($1, $2, $3) RETURNING id, name, price, description, created_at, updated_at`
-	err := p.db.DB.QueryRowContext(ctx, query, name, price, description).Scan(
+	err := p.db.Conn(ctx).QueryRowContext(ctx, query, name, price, description).Scan(
 		&product.ID, &product.Name, &product.Price, &product.Description, &product.CreatedAt, &product.UpdatedAt,
 	)
 	if err != nil {
@@ -256,7 +463,7 @@ func (p *PostgresDataAgent) readProduct(ctx context.Context, data map[string]int
 
 		var product Product
 		query := `SELECT id, name, price, description, created_at, updated_at FROM products WHERE id = $1`
-		err = p.db.DB.QueryRowContext(ctx, query, id).Scan(
+		err = p.db.Conn(ctx).QueryRowContext(ctx, query, id).Scan(
 			&product.ID, &product.Name, &product.Price, &product.Description, &product.CreatedAt, &product.UpdatedAt,
 		)
 		if err != nil {
@@ -266,14 +473,17 @@ func (p *PostgresDataAgent) readProduct(ctx context.Context, data map[string]int
 		return product, nil
 	}
 
-	var products []Product
-	query := `SELECT id, name, price, description, created_at, updated_at FROM products ORDER BY id`
-	err := p.db.DB.SelectContext(ctx, &products, query)
+	request, err := ParsePageRequest("product", data)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := selectPage(ctx, p, "product", "products", productColumns, request, func(product Product) int { return product.ID })
 	if err != nil {
 		return nil, fmt.Errorf("failed to read products: %w", err)
 	}
 
-	return products, nil
+	return page, nil
 }
 
 func (p *PostgresDataAgent) updateProduct(ctx context.Context, data map[string]interface{}) (interface{}, error) {
@@ -323,7 +533,7 @@ func (p *PostgresDataAgent) updateProduct(ctx context.Context, data map[string]i
 		strings.Join(setParts, ", "), argIndex)
 
 	var product Product
-	err = p.db.DB.QueryRowContext(ctx, query, args...).Scan(
+	err = p.db.Conn(ctx).QueryRowContext(ctx, query, args...).Scan(
 		&product.ID, &product.Name, &product.Price, &product.Description, &product.CreatedAt, &product.UpdatedAt,
 	)
 	if err != nil {
@@ -345,7 +555,7 @@ func (p *PostgresDataAgent) deleteProduct(ctx context.Context, data map[string]i
 	}
 
 	query := `DELETE FROM products WHERE id = $1`
-	result, err := p.db.DB.ExecContext(ctx, query, id)
+	result, err := p.db.Conn(ctx).ExecContext(ctx, query, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to delete product: %w", err)
 	}
@@ -394,7 +604,7 @@ func (p *PostgresDataAgent) createOrder(ctx context.Context, data map[string]int
 
 	var order Order
 	query := `INSERT INTO orders (user_id, items, total_amount, status) VALUES ($1, $2, $3, $4) RETURNING id, user_id, items, total_amount, status, created_at, updated_at`
-	err = p.db.DB.QueryRowContext(ctx, query, userID, string(itemsJSON), totalAmount, status).Scan(
+	err = p.db.Conn(ctx).QueryRowContext(ctx, query, userID, string(itemsJSON), totalAmount, status).Scan(
 		&order.ID, &order.UserID, &order.Items, &order.TotalAmount, &order.Status, &order.CreatedAt, &order.UpdatedAt,
 	)
 	if err != nil {
@@ -413,7 +623,7 @@ func (p *PostgresDataAgent) readOrder(ctx context.Context, data map[string]inter
 
 		var order Order
 		query := `SELECT id, user_id, items, total_amount, status, created_at, updated_at FROM orders WHERE id = $1`
-		err = p.db.DB.QueryRowContext(ctx, query, id).Scan(
+		err = p.db.Conn(ctx).QueryRowContext(ctx, query, id).Scan(
 			&order.ID, &order.UserID, &order.Items, &order.TotalAmount, &order.Status, &order.CreatedAt, &order.UpdatedAt,
 		)
 		if err != nil {
@@ -423,14 +633,17 @@ func (p *PostgresDataAgent) readOrder(ctx context.Context, data map[string]inter
 		return order, nil
 	}
 
-	var orders []Order
-	query := `SELECT id, user_id, items, total_amount, status, created_at, updated_at FROM orders ORDER BY id`
-	err := p.db.DB.SelectContext(ctx, &orders, query)
+	request, err := ParsePageRequest("order", data)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := selectPage(ctx, p, "order", "orders", orderColumns, request, func(order Order) int { return order.ID })
 	if err != nil {
 		return nil, fmt.Errorf("failed to read orders: %w", err)
 	}
 
-	return orders, nil
+	return page, nil
 }
 
 func (p *PostgresDataAgent) updateOrder(ctx context.Context, data map[string]interface{}) (interface{}, error) {
@@ -484,7 +697,7 @@ func (p *PostgresDataAgent) updateOrder(ctx context.Context, data map[string]int
 		strings.Join(setParts, ", "), argIndex)
 
 	var order Order
-	err = p.db.DB.QueryRowContext(ctx, query, args...).Scan(
+	err = p.db.Conn(ctx).QueryRowContext(ctx, query, args...).Scan(
 		&order.ID, &order.UserID, &order.Items, &order.TotalAmount, &order.Status, &order.CreatedAt, &order.UpdatedAt,
 	)
 	if err != nil {
@@ -506,7 +719,7 @@ func (p *PostgresDataAgent) deleteOrder(ctx context.Context, data map[string]int
 	}
 
 	query := `DELETE FROM orders WHERE id = $1`
-	result, err := p.db.DB.ExecContext(ctx, query, id)
+	result, err := p.db.Conn(ctx).ExecContext(ctx, query, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to delete order: %w", err)
 	}
@@ -0,0 +1,126 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"drm-app/app/db"
+)
+
+// idempotencySweepInterval is how often expired idempotency keys are deleted
+const idempotencySweepInterval = time.Minute
+
+type PostgresIdempotencyStore struct {
+	db        *db.Database
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewPostgresIdempotencyStore(database *db.Database) *PostgresIdempotencyStore {
+	return &PostgresIdempotencyStore{
+		db: database,
+	}
+}
+
+func (s *PostgresIdempotencyStore) Reserve(ctx context.Context, record *IdempotencyRecord, staleBefore time.Time) (*IdempotencyRecord, error) {
+	s.sweep(ctx, record.CreatedAt)
+
+	if record.LegacyScope != "" {
+		legacy, err := s.read(ctx, record.LegacyScope, record.Key)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to read idempotency key: %w", err)
+		}
+		if legacy != nil && legacy.live(record.CreatedAt, staleBefore) {
+			return legacy, nil
+		}
+	}
+
+	// The upsert only takes over a key whose record has expired or whose pending owner
+	// has gone away; otherwise no row is returned and the live record is read back
+	query := `
+		INSERT INTO idempotency_keys (scope, key, fingerprint, status, created_at, expires_at)
+		VALUES ($1, $2, $3, 'pending', $4, $5)
+		ON CONFLICT (scope, key) DO UPDATE SET
+			fingerprint = EXCLUDED.fingerprint,
+			status = 'pending',
+			response = NULL,
+			created_at = EXCLUDED.created_at,
+			expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= EXCLUDED.created_at
+			OR (idempotency_keys.status = 'pending' AND idempotency_keys.created_at < $6)
+		RETURNING key`
+
+	var key string
+	err := s.db.DB.QueryRowContext(ctx, query,
+		record.Scope, record.Key, record.Fingerprint, record.CreatedAt, record.ExpiresAt, staleBefore,
+	).Scan(&key)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	existing, err := s.read(ctx, record.Scope, record.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read idempotency key: %w", err)
+	}
+	return existing, nil
+}
+
+func (s *PostgresIdempotencyStore) read(ctx context.Context, scope, key string) (*IdempotencyRecord, error) {
+	var existing IdempotencyRecord
+	var response []byte
+	err := s.db.DB.QueryRowContext(ctx, `
+		SELECT scope, key, fingerprint, status, response, created_at, expires_at
+		FROM idempotency_keys WHERE scope = $1 AND key = $2`,
+		scope, key,
+	).Scan(&existing.Scope, &existing.Key, &existing.Fingerprint, &existing.Status, &response,
+		&existing.CreatedAt, &existing.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	existing.Response = response
+	return &existing, nil
+}
+
+func (s *PostgresIdempotencyStore) Complete(ctx context.Context, scope, key string, response json.RawMessage) error {
+	result, err := s.db.DB.ExecContext(ctx,
+		`UPDATE idempotency_keys SET status = 'completed', response = $3 WHERE scope = $1 AND key = $2`,
+		scope, key, []byte(response))
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresIdempotencyStore) Release(ctx context.Context, scope, key string) error {
+	_, err := s.db.DB.ExecContext(ctx,
+		`DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2 AND status = 'pending'`, scope, key)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// sweep deletes expired keys at most once per interval, since a key that is never sent again would
+// otherwise be kept for ever. Failures are left to the next sweep, as expired rows are not replayed.
+func (s *PostgresIdempotencyStore) sweep(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastSweep) < idempotencySweepInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSweep = now
+	s.mu.Unlock()
+
+	s.db.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= $1`, now)
+}
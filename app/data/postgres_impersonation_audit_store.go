@@ -0,0 +1,35 @@
+package data
+
+import (
+	"context"
+	"fmt"
+
+	"drm-app/app/db"
+)
+
+type PostgresImpersonationAuditStore struct {
+	db *db.Database
+}
+
+func NewPostgresImpersonationAuditStore(database *db.Database) *PostgresImpersonationAuditStore {
+	return &PostgresImpersonationAuditStore{
+		db: database,
+	}
+}
+
+func (s *PostgresImpersonationAuditStore) RecordImpersonation(ctx context.Context, entry *ImpersonationEntry) error {
+	query := `
+		INSERT INTO impersonation_audit (tenant_id, impersonator_id, impersonator_source, user_id, user_source,
+			entity, action, stage, outcome, error, request_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id`
+
+	err := s.db.DB.QueryRowContext(ctx, query,
+		entry.Tenant, entry.ImpersonatorID, entry.ImpersonatorSource, entry.UserID, entry.UserSource,
+		entry.Entity, entry.Action, entry.Stage, entry.Outcome, entry.Error, entry.RequestID, entry.CreatedAt,
+	).Scan(&entry.ID)
+	if err != nil {
+		return fmt.Errorf("failed to record impersonation: %w", err)
+	}
+	return nil
+}
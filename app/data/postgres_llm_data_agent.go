@@ -3,52 +3,112 @@ package data
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/ollama/ollama/api"
+	"log/slog"
+	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
+	"drm-app/app/config"
 	"drm-app/app/db"
+	"drm-app/app/metrics"
+	"drm-app/app/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type PostgresLLMDataAgent struct {
 	db     *db.Database
 	client *api.Client
+	config config.LLMConfig
 }
 
-func NewPostgresLLMDataAgent(database *db.Database) *PostgresLLMDataAgent {
-	client, err := api.ClientFromEnvironment()
+func NewPostgresLLMDataAgent(database *db.Database, cfg config.LLMConfig) *PostgresLLMDataAgent {
+	agent := &PostgresLLMDataAgent{
+		db:     database,
+		config: cfg,
+	}
+	if !cfg.Enabled {
+		return agent
+	}
+
+	client, err := newOllamaClient(cfg.Host)
 	if err != nil {
-		client = nil
-	} else {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
-		defer cancel()
-
-		err = client.Heartbeat(ctx)
-		if err != nil {
-			client = nil
-		}
+		slog.Warn("ollama client unavailable, using fallback execution", "error", err)
+		return agent
 	}
 
-	return &PostgresLLMDataAgent{
-		db:     database,
-		client: client,
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.HeartbeatTimeout)
+	defer cancel()
+
+	if err := client.Heartbeat(ctx); err != nil {
+		slog.Warn("ollama not reachable, using fallback execution", "error", err)
+		return agent
+	}
+
+	agent.client = client
+	return agent
+}
+
+// newOllamaClient connects to host, or to OLLAMA_HOST when host is empty
+func newOllamaClient(host string) (*api.Client, error) {
+	if host == "" {
+		return api.ClientFromEnvironment()
+	}
+
+	base, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ollama host %q: %w", host, err)
+	}
+	return api.NewClient(base, http.DefaultClient), nil
+}
+
+// Enabled reports whether commands are sent to Ollama at all
+func (p *PostgresLLMDataAgent) Enabled() bool {
+	return p.config.Enabled
+}
+
+// Health reports whether Ollama is reachable. Commands still succeed without it via the fallback path.
+func (p *PostgresLLMDataAgent) Health(ctx context.Context) error {
+	if p.client == nil {
+		return fmt.Errorf("ollama client not connected, using fallback execution")
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, "ollama.heartbeat", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	err := p.client.Heartbeat(ctx)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (p *PostgresLLMDataAgent) Version(ctx context.Context) (string, error) {
+	if p.client == nil {
+		return "", fmt.Errorf("ollama client not connected")
 	}
+	return p.client.Version(ctx)
+}
+
+func (p *PostgresLLMDataAgent) Model() string {
+	return p.config.Model
 }
 
 func (p *PostgresLLMDataAgent) ExecuteCommand(ctx context.Context, command *Command) (interface{}, error) {
 	if p.client == nil {
-		return p.fallbackExecution(ctx, command)
+		return p.fallbackExecution(ctx, command, "client_unavailable")
 	}
 
 	prompt, err := p.buildPrompt(ctx, command)
 	if err != nil {
-		return p.fallbackExecution(ctx, command)
+		return p.fallbackExecution(ctx, command, "prompt_failed")
 	}
 
 	response, err := p.queryLLM(ctx, prompt)
 	if err != nil {
-		return p.fallbackExecution(ctx, command)
+		return p.fallbackExecution(ctx, command, "llm_error")
 	}
 
 	return p.executeFromLLMResponse(ctx, command, response)
@@ -72,7 +132,7 @@ Command details:
 - Entity: %s
 - Data: %s
 - UserID: %s
-- UserRole: %s
+- UserRoles: %s
 
 Instructions:
 1. Analyze the command and determine the appropriate action
@@ -85,61 +145,93 @@ Instructions:
 
 Respond only with valid JSON.`,
 		string(dataJSON), command.Action, command.Entity,
-		formatData(command.Data), command.UserID, command.UserRole)
+		formatData(command.Data), command.UserID, strings.Join(command.UserRoles, ", "))
 
 	return prompt, nil
 }
 
+// getCurrentDataState samples the records of the tenant of ctx
 func (p *PostgresLLMDataAgent) getCurrentDataState(ctx context.Context) (map[string]interface{}, error) {
+	tenant, err := TenantFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
 	currentData := map[string]interface{}{}
+	err = p.db.InTenant(ctx, tenant, func(ctx context.Context) error {
+		return p.sampleRecords(ctx, currentData)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return currentData, nil
+}
+
+func (p *PostgresLLMDataAgent) sampleRecords(ctx context.Context, currentData map[string]interface{}) error {
 
 	// Get sample of users
 	var users []User
 	query := `SELECT id, name, email, created_at, updated_at FROM users ORDER BY id LIMIT 5`
-	err := p.db.DB.SelectContext(ctx, &users, query)
+	err := p.db.Conn(ctx).SelectContext(ctx, &users, query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get users: %w", err)
+		return fmt.Errorf("failed to get users: %w", err)
 	}
 	currentData["users"] = users
 
 	// Get sample of products
 	var products []Product
 	query = `SELECT id, name, price, description, created_at, updated_at FROM products ORDER BY id LIMIT 5`
-	err = p.db.DB.SelectContext(ctx, &products, query)
+	err = p.db.Conn(ctx).SelectContext(ctx, &products, query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get products: %w", err)
+		return fmt.Errorf("failed to get products: %w", err)
 	}
 	currentData["products"] = products
 
 	// Get sample of orders
 	var orders []Order
 	query = `SELECT id, user_id, items, total_amount, status, created_at, updated_at FROM orders ORDER BY id LIMIT 5`
-	err = p.db.DB.SelectContext(ctx, &orders, query)
+	err = p.db.Conn(ctx).SelectContext(ctx, &orders, query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get orders: %w", err)
+		return fmt.Errorf("failed to get orders: %w", err)
 	}
 	currentData["orders"] = orders
 
-	return currentData, nil
+	return nil
 }
 
 func (p *PostgresLLMDataAgent) queryLLM(ctx context.Context, prompt string) (string, error) {
-	timeout := time.Second * 5
-	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	timeoutCtx, cancel := context.WithTimeout(ctx, p.config.Timeout)
 	defer cancel()
 
+	timeoutCtx, span := tracing.Tracer().Start(timeoutCtx, "ollama.generate",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("llm.model", p.config.Model), attribute.Int("llm.prompt_length", len(prompt))),
+	)
+	defer span.End()
+
 	req := &api.GenerateRequest{
-		Model:  "llama3.2:1b",
+		Model:  p.config.Model,
 		Prompt: prompt,
 		Stream: &[]bool{false}[0],
 	}
 
 	var response strings.Builder
+	start := time.Now()
 	err := p.client.Generate(timeoutCtx, req, func(resp api.GenerateResponse) error {
 		response.WriteString(resp.Response)
 		return nil
 	})
 
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+		if errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
+			outcome = "timeout"
+		}
+	}
+	metrics.LLMRequestDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+	span.SetAttributes(attribute.String("llm.outcome", outcome))
+	tracing.RecordError(span, err)
+
 	if err != nil {
 		return "", err
 	}
@@ -156,7 +248,7 @@ func (p *PostgresLLMDataAgent) executeFromLLMResponse(ctx context.Context, comma
 	}
 
 	if err := json.Unmarshal([]byte(llmResponse), &response); err != nil {
-		return p.fallbackExecution(ctx, command)
+		return p.fallbackExecution(ctx, command, "invalid_response")
 	}
 
 	if !response.Success {
@@ -168,7 +260,11 @@ func (p *PostgresLLMDataAgent) executeFromLLMResponse(ctx context.Context, comma
 	return pgAgent.ExecuteCommand(ctx, command)
 }
 
-func (p *PostgresLLMDataAgent) fallbackExecution(ctx context.Context, command *Command) (interface{}, error) {
+func (p *PostgresLLMDataAgent) fallbackExecution(ctx context.Context, command *Command, reason string) (interface{}, error) {
+	metrics.LLMFallbacks.WithLabelValues(reason).Inc()
+	trace.SpanFromContext(ctx).AddEvent("llm fallback", trace.WithAttributes(attribute.String("llm.fallback_reason", reason)))
+	slog.InfoContext(ctx, "llm fallback", "reason", reason, "entity", command.Entity, "action", command.Action)
+
 	// Use the PostgreSQL agent to execute the command
 	pgAgent := NewPostgresDataAgent(p.db)
 	return pgAgent.ExecuteCommand(ctx, command)
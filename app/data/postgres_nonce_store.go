@@ -0,0 +1,65 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"drm-app/app/db"
+)
+
+// nonceSweepInterval is how often expired nonces are deleted
+const nonceSweepInterval = time.Minute
+
+// PostgresNonceStore shares nonces between replicas, so a signed request is accepted once
+// whichever instance receives it. The primary key decides between concurrent claims.
+type PostgresNonceStore struct {
+	db        *db.Database
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewPostgresNonceStore(database *db.Database) *PostgresNonceStore {
+	return &PostgresNonceStore{
+		db: database,
+	}
+}
+
+func (s *PostgresNonceStore) Claim(ctx context.Context, keyID, nonce string, expiresAt, now time.Time) (bool, error) {
+	s.sweep(ctx, now)
+
+	// The upsert only takes over a nonce that has expired but not been swept yet; otherwise
+	// no row is returned and the nonce is still live
+	query := `
+		INSERT INTO request_nonces (key_id, nonce, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (key_id, nonce) DO UPDATE SET expires_at = EXCLUDED.expires_at
+		WHERE request_nonces.expires_at <= $4
+		RETURNING nonce`
+
+	var claimed string
+	err := s.db.DB.QueryRowContext(ctx, query, keyID, nonce, expiresAt, now).Scan(&claimed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to claim nonce: %w", err)
+	}
+	return true, nil
+}
+
+// sweep deletes expired nonces at most once per interval. Failures are left to the next sweep,
+// since expired rows only take up space.
+func (s *PostgresNonceStore) sweep(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastSweep) < nonceSweepInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSweep = now
+	s.mu.Unlock()
+
+	s.db.DB.ExecContext(ctx, `DELETE FROM request_nonces WHERE expires_at <= $1`, now)
+}
@@ -0,0 +1,108 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"drm-app/app/db"
+)
+
+// limitSweepInterval is how often refilled buckets and past quota days are deleted
+const limitSweepInterval = time.Minute
+
+// PostgresRateLimitStore shares counters between replicas. Buckets are updated under a row
+// lock so concurrent requests from different instances cannot spend the same token.
+type PostgresRateLimitStore struct {
+	db        *db.Database
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewPostgresRateLimitStore(database *db.Database) *PostgresRateLimitStore {
+	return &PostgresRateLimitStore{
+		db: database,
+	}
+}
+
+func (s *PostgresRateLimitStore) TakeToken(ctx context.Context, key string, limit RateLimit, now time.Time) (bool, time.Duration, error) {
+	s.sweep(ctx, now)
+
+	tx, err := s.db.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to begin rate limit transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO rate_limit_buckets (key, tokens, updated_at) VALUES ($1, $2, $3) ON CONFLICT (key) DO NOTHING`,
+		key, float64(limit.Burst), now)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to create rate limit bucket: %w", err)
+	}
+
+	var tokens float64
+	var last time.Time
+	err = tx.QueryRowContext(ctx,
+		`SELECT tokens, updated_at FROM rate_limit_buckets WHERE key = $1 FOR UPDATE`, key).Scan(&tokens, &last)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to read rate limit bucket: %w", err)
+	}
+
+	tokens, allowed, retryAfter := refill(tokens, last, now, limit)
+	if now.After(last) {
+		last = now
+	}
+	full := last.Add(time.Duration((float64(limit.Burst) - tokens) / limit.Rate * float64(time.Second)))
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE rate_limit_buckets SET tokens = $2, updated_at = $3, full_at = $4 WHERE key = $1`, key, tokens, last, full)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to update rate limit bucket: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, 0, fmt.Errorf("failed to commit rate limit bucket: %w", err)
+	}
+	return allowed, retryAfter, nil
+}
+
+func (s *PostgresRateLimitStore) ConsumeQuota(ctx context.Context, key string, day string, limit int) (bool, error) {
+	s.sweep(ctx, time.Now())
+
+	// The conditional upsert only returns a row while the counter is below the limit
+	query := `
+		INSERT INTO usage_quotas (key, day, used) VALUES ($1, $2, 1)
+		ON CONFLICT (key, day) DO UPDATE SET used = usage_quotas.used + 1
+		WHERE usage_quotas.used < $3
+		RETURNING used`
+
+	var used int
+	err := s.db.DB.QueryRowContext(ctx, query, key, day, limit).Scan(&used)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to consume quota: %w", err)
+	}
+	return true, nil
+}
+
+// sweep deletes buckets that have refilled and quota counters of earlier UTC days, at most once per
+// interval. Failures are left to the next sweep, since a full bucket and an old counter are never
+// read again.
+func (s *PostgresRateLimitStore) sweep(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastSweep) < limitSweepInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSweep = now
+	s.mu.Unlock()
+
+	s.db.DB.ExecContext(ctx, `DELETE FROM rate_limit_buckets WHERE full_at <= $1`, now)
+	s.db.DB.ExecContext(ctx, `DELETE FROM usage_quotas WHERE day < $1`, now.UTC().Format(time.DateOnly))
+}
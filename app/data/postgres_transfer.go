@@ -0,0 +1,133 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// ImportRecords streams the records into the entity table of the tenant of ctx with a single
+// COPY, so an import is inserted completely or not at all. The inserted rows are read back
+// once committed.
+func (p *PostgresDataAgent) ImportRecords(ctx context.Context, entity string, next func() (map[string]interface{}, error)) ([]map[string]interface{}, error) {
+	columns, exists := importColumns[entity]
+	if !exists {
+		return nil, fmt.Errorf("unsupported entity: %s", entity)
+	}
+	tenant, err := TenantFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	source := &copySource{entity: entity, next: next}
+	ids, err := p.db.CopyInTenant(ctx, tenant, entityTables[entity], columns, source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import %s: %w", entity, err)
+	}
+
+	var loaded map[int]map[string]interface{}
+	err = p.db.InTenant(ctx, tenant, func(ctx context.Context) error {
+		loaded, err = p.loadRecords(ctx, entity, ids)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read imported %s records: %w", entity, err)
+	}
+
+	records := make([]map[string]interface{}, 0, len(ids))
+	for _, id := range ids {
+		if record, exists := loaded[id]; exists {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// copySource adapts an import's record stream to pgx.CopyFromSource
+type copySource struct {
+	entity string
+	next   func() (map[string]interface{}, error)
+	values []interface{}
+	err    error
+}
+
+func (s *copySource) Next() bool {
+	record, err := s.next()
+	if err == io.EOF {
+		return false
+	}
+	if err == nil {
+		s.values, err = copyRow(s.entity, record)
+	}
+	if err != nil {
+		s.err = err
+		return false
+	}
+	return true
+}
+
+func (s *copySource) Values() ([]interface{}, error) {
+	return s.values, nil
+}
+
+func (s *copySource) Err() error {
+	return s.err
+}
+
+// ExportRecords reads the matching rows of the tenant of ctx with one query, emitting them as
+// they are scanned
+func (p *PostgresDataAgent) ExportRecords(ctx context.Context, entity string, conditions []Condition, emit func(map[string]interface{}) error) error {
+	tenant, err := TenantFrom(ctx)
+	if err != nil {
+		return err
+	}
+	return p.db.InTenant(ctx, tenant, func(ctx context.Context) error {
+		return p.exportRecords(ctx, entity, conditions, emit)
+	})
+}
+
+func (p *PostgresDataAgent) exportRecords(ctx context.Context, entity string, conditions []Condition, emit func(map[string]interface{}) error) error {
+	switch entity {
+	case "user":
+		return exportRows[User](ctx, p, entity, userColumns, conditions, emit)
+	case "product":
+		return exportRows[Product](ctx, p, entity, productColumns, conditions, emit)
+	case "order":
+		return exportRows[Order](ctx, p, entity, orderColumns, conditions, emit)
+	default:
+		return fmt.Errorf("unsupported entity: %s", entity)
+	}
+}
+
+func exportRows[T any](ctx context.Context, p *PostgresDataAgent, entity, columns string, conditions []Condition, emit func(map[string]interface{}) error) error {
+	args := []interface{}{}
+	where, err := whereClause(entity, conditions, &args)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM %s%s ORDER BY id`, columns, entityTables[entity], where)
+	rows, err := p.db.Conn(ctx).QueryxContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to export %s: %w", entity, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row T
+		if err := rows.StructScan(&row); err != nil {
+			return fmt.Errorf("failed to export %s: %w", entity, err)
+		}
+		record, err := toRecord(row)
+		if err != nil {
+			return err
+		}
+		if err := emit(record); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to export %s: %w", entity, err)
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"drm-app/app/db"
+)
+
+type PostgresWebhookStore struct {
+	db *db.Database
+}
+
+func NewPostgresWebhookStore(database *db.Database) *PostgresWebhookStore {
+	return &PostgresWebhookStore{
+		db: database,
+	}
+}
+
+const subscriptionColumns = `id, tenant_id, url, entity, actions, secret, active, created_at, updated_at`
+
+const deliveryColumns = `id, subscription_id, event_id, event_type, payload, status, attempts, last_status_code, COALESCE(last_error, ''), next_attempt_at, round_attempts, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSubscription(row rowScanner) (*WebhookSubscription, error) {
+	var subscription WebhookSubscription
+	var actions string
+	err := row.Scan(
+		&subscription.ID, &subscription.Tenant, &subscription.URL, &subscription.Entity, &actions, &subscription.Secret,
+		&subscription.Active, &subscription.CreatedAt, &subscription.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	subscription.Actions = splitActions(actions)
+	return &subscription, nil
+}
+
+func scanDelivery(row rowScanner) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	err := row.Scan(
+		&delivery.ID, &delivery.SubscriptionID, &delivery.EventID, &delivery.EventType, &delivery.Payload,
+		&delivery.Status, &delivery.Attempts, &delivery.LastStatusCode, &delivery.LastError,
+		&delivery.NextAttemptAt, &delivery.RoundAttempts, &delivery.CreatedAt, &delivery.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func splitActions(actions string) []string {
+	if actions == "" {
+		return nil
+	}
+	return strings.Split(actions, ",")
+}
+
+func (s *PostgresWebhookStore) CreateSubscription(ctx context.Context, subscription *WebhookSubscription) error {
+	query := `INSERT INTO webhook_subscriptions (tenant_id, url, entity, actions, secret, active) VALUES ($1, $2, $3, $4, $5, $6) RETURNING ` + subscriptionColumns
+	created, err := scanSubscription(s.db.DB.QueryRowContext(ctx, query,
+		subscription.Tenant, subscription.URL, subscription.Entity, strings.Join(subscription.Actions, ","), subscription.Secret, subscription.Active,
+	))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook subscription: %w", err)
+	}
+
+	*subscription = *created
+	return nil
+}
+
+func (s *PostgresWebhookStore) ListSubscriptions(ctx context.Context) ([]WebhookSubscription, error) {
+	rows, err := s.db.DB.QueryContext(ctx, `SELECT `+subscriptionColumns+` FROM webhook_subscriptions ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	subscriptions := []WebhookSubscription{}
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook subscription: %w", err)
+		}
+		subscriptions = append(subscriptions, *subscription)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+func (s *PostgresWebhookStore) GetSubscription(ctx context.Context, id int) (*WebhookSubscription, error) {
+	subscription, err := scanSubscription(s.db.DB.QueryRowContext(ctx,
+		`SELECT `+subscriptionColumns+` FROM webhook_subscriptions WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("webhook subscription %d: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook subscription: %w", err)
+	}
+	return subscription, nil
+}
+
+func (s *PostgresWebhookStore) DeleteSubscription(ctx context.Context, id int) error {
+	result, err := s.db.DB.ExecContext(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook subscription: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("webhook subscription %d: %w", id, ErrNotFound)
+	}
+
+	return nil
+}
+
+func (s *PostgresWebhookStore) MatchingSubscriptions(ctx context.Context, tenant, entity, action string) ([]WebhookSubscription, error) {
+	rows, err := s.db.DB.QueryContext(ctx,
+		`SELECT `+subscriptionColumns+` FROM webhook_subscriptions WHERE active AND tenant_id = $1 AND entity IN ('*', $2) ORDER BY id`, tenant, entity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find webhook subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subscriptions []WebhookSubscription
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook subscription: %w", err)
+		}
+		if subscription.Matches(tenant, entity, action) {
+			subscriptions = append(subscriptions, *subscription)
+		}
+	}
+
+	return subscriptions, rows.Err()
+}
+
+func (s *PostgresWebhookStore) CreateDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	query := `INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload, status, next_attempt_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING ` + deliveryColumns
+	created, err := scanDelivery(s.db.DB.QueryRowContext(ctx, query,
+		delivery.SubscriptionID, delivery.EventID, delivery.EventType, delivery.Payload, delivery.Status, delivery.NextAttemptAt,
+	))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook delivery: %w", err)
+	}
+
+	*delivery = *created
+	return nil
+}
+
+func (s *PostgresWebhookStore) GetDelivery(ctx context.Context, id int) (*WebhookDelivery, error) {
+	delivery, err := scanDelivery(s.db.DB.QueryRowContext(ctx,
+		`SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("webhook delivery %d: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook delivery: %w", err)
+	}
+	return delivery, nil
+}
+
+func (s *PostgresWebhookStore) ListDeliveries(ctx context.Context, subscriptionID int) ([]WebhookDelivery, error) {
+	return s.queryDeliveries(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE subscription_id = $1 ORDER BY id DESC`, subscriptionID)
+}
+
+// claimable matches the pending deliveries due at $3 that owner $2 may claim
+const claimable = `status = $1 AND next_attempt_at <= $3 AND (claimed_until IS NULL OR claimed_until <= $3 OR claimed_by = $2)`
+
+func (s *PostgresWebhookStore) ClaimDueDeliveries(ctx context.Context, owner string, now, until time.Time, limit int) ([]WebhookDelivery, error) {
+	// Rows another replica is claiming at the same moment are skipped rather than waited for
+	query := `
+		UPDATE webhook_deliveries SET claimed_by = $2, claimed_until = $4
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries WHERE ` + claimable + `
+			ORDER BY next_attempt_at, id LIMIT $5 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + deliveryColumns
+	deliveries, err := s.queryDeliveries(ctx, query, DeliveryStatusPending, owner, now, until, limit)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].NextAttemptAt.Before(deliveries[j].NextAttemptAt) })
+	return deliveries, nil
+}
+
+func (s *PostgresWebhookStore) ClaimDelivery(ctx context.Context, id int, owner string, now, until time.Time) (*WebhookDelivery, error) {
+	query := `UPDATE webhook_deliveries SET claimed_by = $2, claimed_until = $4 WHERE id = $5 AND ` + claimable + ` RETURNING ` + deliveryColumns
+	delivery, err := scanDelivery(s.db.DB.QueryRowContext(ctx, query, DeliveryStatusPending, owner, now, until, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook delivery: %w", err)
+	}
+	return delivery, nil
+}
+
+func (s *PostgresWebhookStore) queryDeliveries(ctx context.Context, query string, args ...interface{}) ([]WebhookDelivery, error) {
+	rows, err := s.db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (s *PostgresWebhookStore) UpdateDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	query := `UPDATE webhook_deliveries SET status = $1, attempts = $2, last_status_code = $3, last_error = $4, next_attempt_at = $5, round_attempts = $6,
+		claimed_by = NULL, claimed_until = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $7 RETURNING ` + deliveryColumns
+	updated, err := scanDelivery(s.db.DB.QueryRowContext(ctx, query,
+		delivery.Status, delivery.Attempts, delivery.LastStatusCode, delivery.LastError, delivery.NextAttemptAt, delivery.RoundAttempts, delivery.ID,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("webhook delivery %d: %w", delivery.ID, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+
+	*delivery = *updated
+	return nil
+}
+
+func (s *PostgresWebhookStore) RequeueDelivery(ctx context.Context, id int) (*WebhookDelivery, error) {
+	query := `UPDATE webhook_deliveries SET status = $1, next_attempt_at = CURRENT_TIMESTAMP, round_attempts = 0, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND status IN ($3, $4) RETURNING ` + deliveryColumns
+	delivery, err := scanDelivery(s.db.DB.QueryRowContext(ctx, query, DeliveryStatusPending, id, DeliveryStatusDelivered, DeliveryStatusFailed))
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err := s.GetDelivery(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("webhook delivery %d: %w", id, ErrDeliveryPending)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to requeue webhook delivery: %w", err)
+	}
+	return delivery, nil
+}
+
+func (s *PostgresWebhookStore) RecordAttempt(ctx context.Context, attempt *WebhookAttempt) error {
+	query := `INSERT INTO webhook_delivery_attempts (delivery_id, attempt, status_code, error, duration_ms) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`
+	err := s.db.DB.QueryRowContext(ctx, query,
+		attempt.DeliveryID, attempt.Attempt, attempt.StatusCode, attempt.Error, attempt.DurationMs,
+	).Scan(&attempt.ID, &attempt.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record webhook attempt: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresWebhookStore) ListAttempts(ctx context.Context, deliveryID int) ([]WebhookAttempt, error) {
+	rows, err := s.db.DB.QueryContext(ctx,
+		`SELECT id, delivery_id, attempt, status_code, COALESCE(error, ''), duration_ms, created_at FROM webhook_delivery_attempts WHERE delivery_id = $1 ORDER BY id`, deliveryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook attempts: %w", err)
+	}
+	defer rows.Close()
+
+	attempts := []WebhookAttempt{}
+	for rows.Next() {
+		var attempt WebhookAttempt
+		if err := rows.Scan(&attempt.ID, &attempt.DeliveryID, &attempt.Attempt, &attempt.StatusCode,
+			&attempt.Error, &attempt.DurationMs, &attempt.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook attempt: %w", err)
+		}
+		attempts = append(attempts, attempt)
+	}
+
+	return attempts, rows.Err()
+}
@@ -0,0 +1,301 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Reserved Command.Data keys that shape read results
+const (
+	FieldsKey = "fields"
+	ExpandKey = "expand"
+)
+
+var ErrInvalidProjection = errors.New("invalid fields or expand")
+
+// entityFields lists the fields a read may project for each entity
+var entityFields = map[string]map[string]bool{
+	"user":    {"id": true, "name": true, "email": true, "created_at": true, "updated_at": true},
+	"product": {"id": true, "name": true, "price": true, "description": true, "created_at": true, "updated_at": true},
+	"order":   {"id": true, "user_id": true, "items": true, "total_amount": true, "status": true, "created_at": true, "updated_at": true},
+}
+
+// relation follows a foreign key from a record to records of another entity
+type relation struct {
+	entity string
+	// field is the top-level field the related records are attached under
+	field string
+	// keys returns the related IDs record refers to
+	keys func(record map[string]interface{}) []int
+	// attach replaces the references in record with the loaded records
+	attach func(record map[string]interface{}, related map[int]map[string]interface{})
+}
+
+var relations = map[string]map[string]relation{
+	"order": {
+		// order.user_id -> user, attached as "user"
+		"user": {
+			entity: "user",
+			field:  "user",
+			keys: func(record map[string]interface{}) []int {
+				if id, ok := referenceID(record["user_id"]); ok {
+					return []int{id}
+				}
+				return nil
+			},
+			attach: func(record map[string]interface{}, related map[int]map[string]interface{}) {
+				id, _ := referenceID(record["user_id"])
+				record["user"] = nilIfMissing(related[id])
+			},
+		},
+		// order.items[].product_id -> product, attached to each item as "product"
+		"items.product": {
+			entity: "product",
+			field:  "items",
+			keys: func(record map[string]interface{}) []int {
+				var ids []int
+				for _, item := range orderItems(record) {
+					if id, ok := referenceID(item["product_id"]); ok {
+						ids = append(ids, id)
+					}
+				}
+				return ids
+			},
+			attach: func(record map[string]interface{}, related map[int]map[string]interface{}) {
+				items := orderItems(record)
+				expanded := make([]interface{}, len(items))
+				for i, item := range items {
+					id, _ := referenceID(item["product_id"])
+					item["product"] = nilIfMissing(related[id])
+					expanded[i] = item
+				}
+				record["items"] = expanded
+			},
+		},
+	},
+}
+
+// ReadOptions are the projection and expansion requested for a read
+type ReadOptions struct {
+	Fields []string
+	Expand []string
+}
+
+func (o ReadOptions) empty() bool {
+	return len(o.Fields) == 0 && len(o.Expand) == 0
+}
+
+// ParseReadOptions reads the fields and expand keys of a read. Each takes a list of names or
+// a comma-separated string.
+func ParseReadOptions(entity string, data map[string]interface{}) (ReadOptions, error) {
+	var options ReadOptions
+
+	fields, err := nameList(data[FieldsKey])
+	if err != nil {
+		return options, fmt.Errorf("%w: fields %v", ErrInvalidProjection, err)
+	}
+	for _, field := range fields {
+		if !entityFields[entity][field] {
+			return options, fmt.Errorf("%w: unknown field %q for %s", ErrInvalidProjection, field, entity)
+		}
+	}
+
+	expand, err := nameList(data[ExpandKey])
+	if err != nil {
+		return options, fmt.Errorf("%w: expand %v", ErrInvalidProjection, err)
+	}
+	for _, name := range expand {
+		if _, exists := relations[entity][name]; !exists {
+			return options, fmt.Errorf("%w: %s has no relation %q", ErrInvalidProjection, entity, name)
+		}
+	}
+
+	options.Fields = fields
+	options.Expand = expand
+	return options, nil
+}
+
+// ExpandedEntities lists the entities a read command expands into, so each can be checked
+// against the access policy. Unknown relations are skipped; the data agent rejects them.
+func ExpandedEntities(command *Command) []string {
+	names, _ := nameList(command.Data[ExpandKey])
+
+	seen := map[string]bool{}
+	var entities []string
+	for _, name := range names {
+		rel, exists := relations[command.Entity][name]
+		if exists && !seen[rel.entity] {
+			seen[rel.entity] = true
+			entities = append(entities, rel.entity)
+		}
+	}
+	return entities
+}
+
+func nameList(value interface{}) ([]string, error) {
+	var names []string
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		names = strings.Split(v, ",")
+	case []interface{}:
+		for _, item := range v {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("must be a list of names")
+			}
+			names = append(names, name)
+		}
+	case []string:
+		names = v
+	default:
+		return nil, fmt.Errorf("must be a list of names")
+	}
+
+	result := make([]string, 0, len(names))
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+// recordLoader fetches the records of entity with the given IDs, keyed by ID
+type recordLoader func(ctx context.Context, entity string, ids []int) (map[int]map[string]interface{}, error)
+
+// shapeResult expands and projects a read result, either a single record or a *Page. Related
+// records are fetched with one load per relation across the whole page.
+func shapeResult(ctx context.Context, entity string, result interface{}, options ReadOptions, load recordLoader) (interface{}, error) {
+	if options.empty() {
+		return result, nil
+	}
+
+	page, isPage := result.(*Page)
+	var records []map[string]interface{}
+	if isPage {
+		if err := convert(page.Items, &records); err != nil {
+			return nil, err
+		}
+	} else {
+		var record map[string]interface{}
+		if err := convert(result, &record); err != nil {
+			return nil, err
+		}
+		records = []map[string]interface{}{record}
+	}
+
+	keep := map[string]bool{"id": true}
+	for _, field := range options.Fields {
+		keep[field] = true
+	}
+
+	for _, name := range options.Expand {
+		rel := relations[entity][name]
+		keep[rel.field] = true
+
+		seen := map[int]bool{}
+		var ids []int
+		for _, record := range records {
+			for _, id := range rel.keys(record) {
+				if !seen[id] {
+					seen[id] = true
+					ids = append(ids, id)
+				}
+			}
+		}
+		sort.Ints(ids)
+
+		related := map[int]map[string]interface{}{}
+		if len(ids) > 0 {
+			loaded, err := load(ctx, rel.entity, ids)
+			if err != nil {
+				return nil, fmt.Errorf("failed to expand %s: %w", name, err)
+			}
+			related = loaded
+		}
+		for _, record := range records {
+			rel.attach(record, related)
+		}
+	}
+
+	if len(options.Fields) > 0 {
+		for _, record := range records {
+			for field := range record {
+				if !keep[field] {
+					delete(record, field)
+				}
+			}
+		}
+	}
+
+	if isPage {
+		return &Page{Items: records, PageInfo: page.PageInfo}, nil
+	}
+	return records[0], nil
+}
+
+// toRecord converts a typed row into its JSON field map
+func toRecord(row interface{}) (map[string]interface{}, error) {
+	var record map[string]interface{}
+	err := convert(row, &record)
+	return record, err
+}
+
+func convert(value interface{}, target interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to convert read result: %w", err)
+	}
+	if err := json.Unmarshal(encoded, target); err != nil {
+		return fmt.Errorf("failed to convert read result: %w", err)
+	}
+	return nil
+}
+
+// orderItems returns the items of an order record, which PostgresDataAgent stores as a JSON string
+func orderItems(record map[string]interface{}) []map[string]interface{} {
+	value := record["items"]
+	if encoded, ok := value.(string); ok {
+		var decoded interface{}
+		if json.Unmarshal([]byte(encoded), &decoded) != nil {
+			return nil
+		}
+		value = decoded
+	}
+
+	list, _ := value.([]interface{})
+	items := make([]map[string]interface{}, 0, len(list))
+	for _, entry := range list {
+		if item, ok := entry.(map[string]interface{}); ok {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func referenceID(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case float64:
+		return int(v), v == float64(int(v))
+	case int:
+		return v, true
+	case string:
+		id, err := strconv.Atoi(v)
+		return id, err == nil
+	}
+	return 0, false
+}
+
+func nilIfMissing(record map[string]interface{}) interface{} {
+	if record == nil {
+		return nil
+	}
+	return record
+}
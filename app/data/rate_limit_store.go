@@ -0,0 +1,37 @@
+package data
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// RateLimit is a token bucket that refills at Rate tokens per second up to Burst tokens
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitStore keeps token buckets and daily quota counters, keyed by caller
+type RateLimitStore interface {
+	// TakeToken removes one token from the bucket for key. When the bucket is empty it
+	// reports how long until the next token is available.
+	TakeToken(ctx context.Context, key string, limit RateLimit, now time.Time) (allowed bool, retryAfter time.Duration, err error)
+	// ConsumeQuota counts one use against key for day if fewer than limit have been used
+	ConsumeQuota(ctx context.Context, key string, day string, limit int) (allowed bool, err error)
+}
+
+// refill returns the bucket's tokens at now after taking one if possible. A bucket seen for
+// the first time starts full.
+func refill(tokens float64, last, now time.Time, limit RateLimit) (remaining float64, allowed bool, retryAfter time.Duration) {
+	if elapsed := now.Sub(last).Seconds(); elapsed > 0 {
+		tokens = math.Min(float64(limit.Burst), tokens+elapsed*limit.Rate)
+	}
+
+	if tokens >= 1 {
+		return tokens - 1, true, 0
+	}
+
+	wait := (1 - tokens) / limit.Rate
+	return tokens, false, time.Duration(math.Ceil(wait * float64(time.Second)))
+}
@@ -0,0 +1,200 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// SearchKey is the Command.Data key holding the text of a search
+const SearchKey = "q"
+
+// maxSearchLength bounds the search text in characters
+const maxSearchLength = 200
+
+// Snippet highlight markers, matching the StartSel/StopSel passed to ts_headline
+const (
+	highlightStart = "<mark>"
+	highlightStop  = "</mark>"
+)
+
+var ErrInvalidSearch = errors.New("invalid search")
+
+// searchableEntities lists the entities with a full-text index
+var searchableEntities = map[string]bool{"product": true}
+
+// SearchRequest selects one page of search hits ordered by rank. Ranked results have no
+// stable key to resume from, so search cursors carry an offset instead of an id.
+type SearchRequest struct {
+	Text         string
+	Limit        int
+	Offset       int
+	IncludeTotal bool
+}
+
+// ProductHit is a product matching a search with its rank and a highlighted snippet
+type ProductHit struct {
+	Product
+	Rank    float64 `json:"rank" db:"rank"`
+	Snippet string  `json:"snippet" db:"snippet"`
+}
+
+// ParseSearchRequest reads the search text and the paging keys of a search
+func ParseSearchRequest(entity string, data map[string]interface{}) (SearchRequest, error) {
+	request := SearchRequest{Limit: DefaultPageSize}
+
+	if !searchableEntities[entity] {
+		return request, fmt.Errorf("%w: %s cannot be searched", ErrInvalidSearch, entity)
+	}
+
+	text, _ := data[SearchKey].(string)
+	request.Text = strings.Join(strings.Fields(text), " ")
+	if request.Text == "" {
+		return request, fmt.Errorf("%w: search text is required", ErrInvalidSearch)
+	}
+	if utf8.RuneCountInString(request.Text) > maxSearchLength {
+		return request, fmt.Errorf("%w: search text is longer than %d characters", ErrInvalidSearch, maxSearchLength)
+	}
+
+	if value, ok := data[LimitKey]; ok {
+		limit, err := ParsePageLimit(value)
+		if err != nil {
+			return request, err
+		}
+		request.Limit = min(limit, MaxPageSize)
+	}
+
+	if value, ok := data[CursorKey].(string); ok && value != "" {
+		c, err := decodeCursor(searchCursorEntity(entity), value)
+		if err != nil {
+			return request, err
+		}
+		request.Offset = c.Offset
+	}
+
+	switch value := data[IncludeTotalKey].(type) {
+	case bool:
+		request.IncludeTotal = value
+	case string:
+		request.IncludeTotal = value == "true"
+	}
+
+	return request, nil
+}
+
+// searchCursorEntity keeps search cursors from being used to page a plain list and back
+func searchCursorEntity(entity string) string {
+	return "search:" + entity
+}
+
+// newSearchPage builds a page from hits fetched at request.Offset. hits holds up to Limit+1
+// entries; the extra one only signals that more hits follow.
+func newSearchPage[T any](entity string, hits []T, request SearchRequest) *Page {
+	more := len(hits) > request.Limit
+	if more {
+		hits = hits[:request.Limit]
+	}
+	if hits == nil {
+		hits = []T{}
+	}
+
+	page := &Page{Items: hits, PageInfo: PageInfo{Limit: request.Limit}}
+	if more {
+		page.Next = encodeSearchCursor(entity, request.Offset+request.Limit)
+	}
+	if request.Offset > 0 {
+		page.Prev = encodeSearchCursor(entity, max(request.Offset-request.Limit, 0))
+	}
+	return page
+}
+
+func encodeSearchCursor(entity string, offset int) string {
+	return encodeCursorPayload(cursor{Entity: searchCursorEntity(entity), Offset: offset})
+}
+
+// searchRecords is the substring search used where there is no full-text index: a record
+// matches when every term occurs in one of fields, and name matches rank above others
+func searchRecords(records []map[string]interface{}, fields []string, text string) []map[string]interface{} {
+	terms := strings.Fields(strings.ToLower(text))
+
+	var hits []map[string]interface{}
+	for _, record := range records {
+		rank := 0.0
+		matched := true
+		for _, term := range terms {
+			found := false
+			for i, field := range fields {
+				value, _ := record[field].(string)
+				if strings.Contains(strings.ToLower(value), term) {
+					found = true
+					rank += 1 / float64(i+1)
+					break
+				}
+			}
+			if !found {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		hit := make(map[string]interface{}, len(record)+2)
+		for key, value := range record {
+			hit[key] = value
+		}
+		hit["rank"] = rank / float64(len(terms))
+		hit["snippet"] = highlight(record, fields, terms)
+		hits = append(hits, hit)
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i]["rank"].(float64) > hits[j]["rank"].(float64)
+	})
+	return hits
+}
+
+// highlight joins the text fields of record and marks every occurrence of terms
+func highlight(record map[string]interface{}, fields []string, terms []string) string {
+	parts := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if value, _ := record[field].(string); value != "" {
+			parts = append(parts, value)
+		}
+	}
+	text := strings.Join(parts, " ")
+	lower := strings.ToLower(text)
+	if len(lower) != len(text) {
+		// Case mapping changed byte offsets; return the text unmarked rather than misplace marks
+		return text
+	}
+
+	marked := make([]bool, len(text))
+	for _, term := range terms {
+		for start := 0; ; {
+			index := strings.Index(lower[start:], term)
+			if index == -1 {
+				break
+			}
+			for i := start + index; i < start+index+len(term); i++ {
+				marked[i] = true
+			}
+			start += index + len(term)
+		}
+	}
+
+	var snippet strings.Builder
+	for i := 0; i < len(text); i++ {
+		if marked[i] && (i == 0 || !marked[i-1]) {
+			snippet.WriteString(highlightStart)
+		}
+		snippet.WriteByte(text[i])
+		if marked[i] && (i == len(text)-1 || !marked[i+1]) {
+			snippet.WriteString(highlightStop)
+		}
+	}
+	return snippet.String()
+}
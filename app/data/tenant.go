@@ -0,0 +1,25 @@
+package data
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNoTenant refuses data access from a context that names no tenant
+var ErrNoTenant = errors.New("no tenant in context")
+
+type tenantKey struct{}
+
+// WithTenant returns a context whose data access is limited to tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFrom returns the tenant of ctx
+func TenantFrom(ctx context.Context) (string, error) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	if !ok || tenant == "" {
+		return "", ErrNoTenant
+	}
+	return tenant, nil
+}
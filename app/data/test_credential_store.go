@@ -0,0 +1,266 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TestCredentialStore is an in-memory CredentialStore for testing. Registered users get
+// their records in agent.
+type TestCredentialStore struct {
+	agent *TestDataAgent
+
+	mu          sync.Mutex
+	credentials map[string]*Credential
+	identities  map[string]string
+	sessions    map[string]*Session
+	logins      map[string]*PendingLogin
+}
+
+func NewTestCredentialStore(agent *TestDataAgent) *TestCredentialStore {
+	return &TestCredentialStore{
+		agent:       agent,
+		credentials: make(map[string]*Credential),
+		identities:  make(map[string]string),
+		sessions:    make(map[string]*Session),
+		logins:      make(map[string]*PendingLogin),
+	}
+}
+
+func credentialID(tenant, userID string) string {
+	return tenant + "\x00" + userID
+}
+
+func (s *TestCredentialStore) Register(ctx context.Context, credential *Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.userWithEmail(ctx, credential.Tenant, credential.Email)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return ErrEmailTaken
+	}
+
+	credential.UserID, err = s.createUser(ctx, credential.Tenant, credential.Name, credential.Email)
+	if err != nil {
+		return fmt.Errorf("failed to register user: %w", err)
+	}
+	stored := *credential
+	s.credentials[credentialID(stored.Tenant, stored.UserID)] = &stored
+	return nil
+}
+
+// userWithEmail returns the record of the tenant's user with email, or nil
+func (s *TestCredentialStore) userWithEmail(ctx context.Context, tenant, email string) (map[string]interface{}, error) {
+	if email == "" {
+		return nil, nil
+	}
+	records, err := s.agent.forTenant(WithTenant(ctx, tenant))
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records.data["user"] {
+		user := record.(map[string]interface{})
+		if address, _ := user["email"].(string); strings.EqualFold(address, email) {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *TestCredentialStore) createUser(ctx context.Context, tenant, name, email string) (string, error) {
+	created, err := s.agent.ExecuteCommand(WithTenant(ctx, tenant), &Command{
+		Action: "create",
+		Entity: "user",
+		Data:   map[string]interface{}{"name": name, "email": email},
+		Tenant: tenant,
+	})
+	if err != nil {
+		return "", err
+	}
+	return created.(map[string]interface{})["id"].(string), nil
+}
+
+func (s *TestCredentialStore) Credential(ctx context.Context, tenant, email string) (*Credential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, credential := range s.credentials {
+		if credential.Tenant == tenant && strings.EqualFold(credential.Email, email) {
+			copied := *credential
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *TestCredentialStore) RecordFailedLogin(ctx context.Context, tenant, userID string, lockAfter int, lockedUntil time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	credential, exists := s.credentials[credentialID(tenant, userID)]
+	if !exists {
+		return false, ErrNotFound
+	}
+	credential.FailedLogins++
+	if credential.FailedLogins < lockAfter {
+		return false, nil
+	}
+	credential.FailedLogins = 0
+	credential.LockedUntil = &lockedUntil
+	return true, nil
+}
+
+func (s *TestCredentialStore) ResetFailedLogins(ctx context.Context, tenant, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if credential, exists := s.credentials[credentialID(tenant, userID)]; exists {
+		credential.FailedLogins = 0
+	}
+	return nil
+}
+
+func (s *TestCredentialStore) ProvisionIdentity(ctx context.Context, identity *Identity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := identity.Tenant + "\x00" + identity.Issuer + "\x00" + identity.Subject
+	if userID, exists := s.identities[key]; exists {
+		records, err := s.agent.forTenant(WithTenant(ctx, identity.Tenant))
+		if err != nil {
+			return err
+		}
+		if user, exists := records.data["user"][userID].(map[string]interface{}); exists {
+			identity.Name, _ = user["name"].(string)
+		}
+		identity.UserID = userID
+		return nil
+	}
+
+	existing, err := s.userWithEmail(ctx, identity.Tenant, identity.Email)
+	if err != nil {
+		return err
+	}
+	switch {
+	case existing != nil && !identity.EmailVerified:
+		return ErrEmailTaken
+	case existing != nil:
+		identity.UserID, _ = existing["id"].(string)
+		identity.Name, _ = existing["name"].(string)
+	default:
+		identity.UserID, err = s.createUser(ctx, identity.Tenant, identity.Name, identity.Email)
+		if err != nil {
+			return fmt.Errorf("failed to provision user: %w", err)
+		}
+	}
+
+	s.identities[key] = identity.UserID
+	return nil
+}
+
+func (s *TestCredentialStore) Account(ctx context.Context, tenant, userID string) (*Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if credential, exists := s.credentials[credentialID(tenant, userID)]; exists {
+		return &Account{UserID: userID, Tenant: tenant, Name: credential.Name, Roles: []string{credential.Role}}, nil
+	}
+
+	var latest *Session
+	for _, session := range s.sessions {
+		if session.Tenant == tenant && session.UserID == userID && (latest == nil || session.CreatedAt.After(latest.CreatedAt)) {
+			latest = session
+		}
+	}
+	if latest == nil {
+		return nil, ErrNotFound
+	}
+	return &Account{UserID: userID, Tenant: tenant, Name: latest.Name, Roles: latest.Roles}, nil
+}
+
+func (s *TestCredentialStore) CreateSession(ctx context.Context, session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *session
+	s.sessions[stored.ID] = &stored
+	return nil
+}
+
+func (s *TestCredentialStore) SessionByAccess(ctx context.Context, accessHash string) (*Session, error) {
+	return s.session(func(session *Session) bool { return session.AccessHash == accessHash })
+}
+
+func (s *TestCredentialStore) SessionByRefresh(ctx context.Context, refreshHash string) (*Session, error) {
+	return s.session(func(session *Session) bool { return session.RefreshHash == refreshHash })
+}
+
+func (s *TestCredentialStore) session(matches func(*Session) bool) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, session := range s.sessions {
+		if matches(session) {
+			copied := *session
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *TestCredentialStore) RotateSession(ctx context.Context, session *Session, refreshHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, exists := s.sessions[session.ID]
+	if !exists || stored.RefreshHash != refreshHash {
+		return ErrNotFound
+	}
+	stored.AccessHash = session.AccessHash
+	stored.AccessExpiresAt = session.AccessExpiresAt
+	stored.RefreshHash = session.RefreshHash
+	stored.RefreshExpiresAt = session.RefreshExpiresAt
+	return nil
+}
+
+func (s *TestCredentialStore) RevokeSession(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *TestCredentialStore) SavePendingLogin(ctx context.Context, login *PendingLogin) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *login
+	s.logins[stored.State] = &stored
+	return nil
+}
+
+func (s *TestCredentialStore) TakePendingLogin(ctx context.Context, state string, now time.Time) (*PendingLogin, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	login, exists := s.logins[state]
+	delete(s.logins, state)
+	if !exists || !now.Before(login.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return login, nil
+}
+
+// PendingLogins returns how many sign-ins are waiting for their callback
+func (s *TestCredentialStore) PendingLogins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.logins)
+}
@@ -3,16 +3,27 @@ package data
 import (
 	"context"
 	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
+
+	"drm-app/app/config"
 )
 
-// TestDataAgent is a simple in-memory implementation for testing
+// TestDataAgent is a simple in-memory implementation for testing. The default tenant starts
+// with seed records; other tenants start empty.
 type TestDataAgent struct {
 	data map[string]map[string]interface{}
+
+	mu      sync.Mutex
+	tenants map[string]*TestDataAgent
 }
 
 func NewTestDataAgent() *TestDataAgent {
 	return &TestDataAgent{
+		tenants: map[string]*TestDataAgent{},
 		data: map[string]map[string]interface{}{
 			"user": {
 				"1": map[string]interface{}{
@@ -49,16 +60,56 @@ func NewTestDataAgent() *TestDataAgent {
 	}
 }
 
+// forTenant returns the records of the tenant of ctx
+func (d *TestDataAgent) forTenant(ctx context.Context) (*TestDataAgent, error) {
+	tenant, err := TenantFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if tenant == config.DefaultTenant {
+		return d, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	store, exists := d.tenants[tenant]
+	if !exists {
+		store = &TestDataAgent{data: map[string]map[string]interface{}{"user": {}, "product": {}, "order": {}}}
+		d.tenants[tenant] = store
+	}
+	return store, nil
+}
+
 func (d *TestDataAgent) ExecuteCommand(ctx context.Context, command *Command) (interface{}, error) {
+	d, err := d.forTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
 	switch command.Action {
 	case "create":
 		return d.create(command.Entity, command.Data)
 	case "read":
-		return d.read(command.Entity, command.Data)
+		options, err := ParseReadOptions(command.Entity, command.Data)
+		if err != nil {
+			return nil, err
+		}
+		result, err := d.read(command.Entity, command.Data)
+		if err != nil {
+			return nil, err
+		}
+		return shapeResult(ctx, command.Entity, result, options, d.loadRecords)
 	case "update":
 		return d.update(command.Entity, command.Data)
 	case "delete":
 		return d.delete(command.Entity, command.Data)
+	case "aggregate":
+		return d.aggregate(command.Entity, command.Aggregation)
+	case "search":
+		return d.search(command.Entity, command.Data)
+	case "upsert":
+		return d.upsert(command.Entity, command.Data)
+	case "bulk_update", "bulk_delete":
+		return d.bulk(command)
 	default:
 		return nil, fmt.Errorf("unsupported action: %s", command.Action)
 	}
@@ -85,11 +136,112 @@ func (d *TestDataAgent) read(entity string, data map[string]interface{}) (interf
 		return nil, fmt.Errorf("item not found")
 	}
 
-	var results []interface{}
+	return d.list(entity, data)
+}
+
+// list pages through the entity in id order, like the keyset queries of PostgresDataAgent
+func (d *TestDataAgent) list(entity string, data map[string]interface{}) (interface{}, error) {
+	request, err := ParsePageRequest(entity, data)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(d.data[entity]))
+	for key := range d.data[entity] {
+		if id, err := strconv.Atoi(key); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	if request.Before != nil {
+		sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+	}
+
+	var rows []interface{}
+	for _, id := range ids {
+		if len(rows) > request.Limit {
+			break
+		}
+		if (request.After != nil && id <= *request.After) || (request.Before != nil && id >= *request.Before) {
+			continue
+		}
+		rows = append(rows, d.data[entity][strconv.Itoa(id)])
+	}
+
+	page := newPage(entity, rows, testItemID, request)
+	if request.IncludeTotal {
+		total := len(ids)
+		page.Total = &total
+	}
+	return page, nil
+}
+
+func (d *TestDataAgent) aggregate(entity string, aggregation *Aggregation) (interface{}, error) {
+	records := make([]map[string]interface{}, 0, len(d.data[entity]))
+	for _, item := range d.data[entity] {
+		if record, ok := item.(map[string]interface{}); ok {
+			records = append(records, record)
+		}
+	}
+	return aggregateRecords(entity, records, aggregation)
+}
+
+// search stands in for the full-text index with a case-insensitive substring match
+func (d *TestDataAgent) search(entity string, data map[string]interface{}) (interface{}, error) {
+	request, err := ParseSearchRequest(entity, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []map[string]interface{}
+	if err := convert(d.sorted(entity), &records); err != nil {
+		return nil, err
+	}
+
+	hits := searchRecords(records, []string{"name", "description"}, request.Text)
+	total := len(hits)
+	if request.Offset < len(hits) {
+		hits = hits[request.Offset:min(len(hits), request.Offset+request.Limit+1)]
+	} else {
+		hits = nil
+	}
+
+	page := newSearchPage(entity, hits, request)
+	if request.IncludeTotal {
+		page.Total = &total
+	}
+	return page, nil
+}
+
+func (d *TestDataAgent) loadRecords(ctx context.Context, entity string, ids []int) (map[int]map[string]interface{}, error) {
+	records := make(map[int]map[string]interface{}, len(ids))
+	for _, id := range ids {
+		if item, exists := d.data[entity][strconv.Itoa(id)]; exists {
+			record, err := toRecord(item)
+			if err != nil {
+				return nil, err
+			}
+			records[id] = record
+		}
+	}
+	return records, nil
+}
+
+// sorted returns the items of entity in id order
+func (d *TestDataAgent) sorted(entity string) []interface{} {
+	items := make([]interface{}, 0, len(d.data[entity]))
 	for _, item := range d.data[entity] {
-		results = append(results, item)
+		items = append(items, item)
 	}
-	return results, nil
+	sort.Slice(items, func(i, j int) bool { return testItemID(items[i]) < testItemID(items[j]) })
+	return items
+}
+
+func testItemID(item interface{}) int {
+	record, _ := item.(map[string]interface{})
+	id, _ := record["id"].(string)
+	value, _ := strconv.Atoi(id)
+	return value
 }
 
 func (d *TestDataAgent) update(entity string, data map[string]interface{}) (interface{}, error) {
@@ -125,3 +277,139 @@ func (d *TestDataAgent) delete(entity string, data map[string]interface{}) (inte
 	delete(d.data[entity], id)
 	return map[string]string{"message": "deleted successfully"}, nil
 }
+
+func (d *TestDataAgent) upsert(entity string, data map[string]interface{}) (interface{}, error) {
+	if err := ValidateUpsert(entity, data); err != nil {
+		return nil, err
+	}
+	key := upsertKeys[entity]
+
+	for id, item := range d.data[entity] {
+		record, _ := item.(map[string]interface{})
+		if record[key] == data[key] {
+			fields := map[string]interface{}{"id": id}
+			for field, value := range data {
+				fields[field] = value
+			}
+			updated, err := d.update(entity, fields)
+			if err != nil {
+				return nil, err
+			}
+			return newUpsertResult(updated, false)
+		}
+	}
+
+	created, err := d.create(entity, data)
+	if err != nil {
+		return nil, err
+	}
+	return newUpsertResult(created, true)
+}
+
+// bulk matches records in memory, checking the confirmed count before changing any
+func (d *TestDataAgent) bulk(command *Command) (interface{}, error) {
+	bulk := command.Bulk
+	if err := ValidateBulk(command.Entity, command.Action, bulk, command.Data); err != nil {
+		return nil, err
+	}
+	if err := checkBulk(bulk); err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, item := range d.sorted(command.Entity) {
+		record, err := toRecord(item)
+		if err != nil {
+			return nil, err
+		}
+		matched, err := matchConditions(command.Entity, record, bulk.Where)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			ids = append(ids, strconv.Itoa(testItemID(item)))
+		}
+	}
+
+	result := &BulkResult{Matched: len(ids), MaxAffected: bulk.MaxAffected}
+	if bulk.Confirm == nil {
+		result.Preview = true
+		return result, nil
+	}
+	if err := checkAffected(bulk, len(ids)); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		changed := d.data[command.Entity][id]
+		if command.Action == "bulk_delete" {
+			delete(d.data[command.Entity], id)
+		} else {
+			fields := map[string]interface{}{"id": id}
+			for field, value := range command.Data {
+				fields[field] = value
+			}
+			updated, err := d.update(command.Entity, fields)
+			if err != nil {
+				return nil, err
+			}
+			changed = updated
+		}
+		record, err := toRecord(changed)
+		if err != nil {
+			return nil, err
+		}
+		result.Records = append(result.Records, record)
+	}
+	result.Affected = len(ids)
+	return result, nil
+}
+
+func (d *TestDataAgent) ImportRecords(ctx context.Context, entity string, next func() (map[string]interface{}, error)) ([]map[string]interface{}, error) {
+	d, err := d.forTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var records []map[string]interface{}
+	for {
+		record, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	for _, record := range records {
+		if _, err := d.create(entity, record); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+func (d *TestDataAgent) ExportRecords(ctx context.Context, entity string, conditions []Condition, emit func(map[string]interface{}) error) error {
+	d, err := d.forTenant(ctx)
+	if err != nil {
+		return err
+	}
+	for _, item := range d.sorted(entity) {
+		record, err := toRecord(item)
+		if err != nil {
+			return err
+		}
+		matched, err := matchConditions(entity, record, conditions)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			continue
+		}
+		if err := emit(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
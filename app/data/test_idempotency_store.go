@@ -0,0 +1,72 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// TestIdempotencyStore is an in-memory IdempotencyStore for testing
+type TestIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]*IdempotencyRecord
+}
+
+func NewTestIdempotencyStore() *TestIdempotencyStore {
+	return &TestIdempotencyStore{
+		records: make(map[string]*IdempotencyRecord),
+	}
+}
+
+func idempotencyID(scope, key string) string {
+	return scope + "\x00" + key
+}
+
+func (s *TestIdempotencyStore) Reserve(ctx context.Context, record *IdempotencyRecord, staleBefore time.Time) (*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if legacy, exists := s.records[idempotencyID(record.LegacyScope, record.Key)]; exists && record.LegacyScope != "" {
+		if legacy.live(record.CreatedAt, staleBefore) {
+			copied := *legacy
+			return &copied, nil
+		}
+	}
+
+	id := idempotencyID(record.Scope, record.Key)
+	if existing, exists := s.records[id]; exists && existing.live(record.CreatedAt, staleBefore) {
+		copied := *existing
+		return &copied, nil
+	}
+
+	stored := *record
+	stored.Status = IdempotencyStatusPending
+	stored.Response = nil
+	s.records[id] = &stored
+	return nil, nil
+}
+
+func (s *TestIdempotencyStore) Complete(ctx context.Context, scope, key string, response json.RawMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, exists := s.records[idempotencyID(scope, key)]
+	if !exists {
+		return ErrNotFound
+	}
+	record.Status = IdempotencyStatusCompleted
+	record.Response = response
+	return nil
+}
+
+func (s *TestIdempotencyStore) Release(ctx context.Context, scope, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := idempotencyID(scope, key)
+	if record, exists := s.records[id]; exists && record.Status == IdempotencyStatusPending {
+		delete(s.records, id)
+	}
+	return nil
+}
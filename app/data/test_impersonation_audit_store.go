@@ -0,0 +1,32 @@
+package data
+
+import (
+	"context"
+	"sync"
+)
+
+// TestImpersonationAuditStore is an in-memory ImpersonationAuditStore for testing
+type TestImpersonationAuditStore struct {
+	mu      sync.Mutex
+	entries []ImpersonationEntry
+}
+
+func NewTestImpersonationAuditStore() *TestImpersonationAuditStore {
+	return &TestImpersonationAuditStore{}
+}
+
+func (s *TestImpersonationAuditStore) RecordImpersonation(ctx context.Context, entry *ImpersonationEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.ID = len(s.entries) + 1
+	s.entries = append(s.entries, *entry)
+	return nil
+}
+
+// Entries returns the recorded entries, oldest first
+func (s *TestImpersonationAuditStore) Entries() []ImpersonationEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ImpersonationEntry(nil), s.entries...)
+}
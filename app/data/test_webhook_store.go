@@ -0,0 +1,255 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TestWebhookStore is an in-memory WebhookStore for testing
+type TestWebhookStore struct {
+	mu            sync.Mutex
+	subscriptions map[int]*WebhookSubscription
+	deliveries    map[int]*WebhookDelivery
+	attempts      map[int][]WebhookAttempt
+	claims        map[int]deliveryClaim
+	nextID        int
+}
+
+type deliveryClaim struct {
+	owner string
+	until time.Time
+}
+
+func NewTestWebhookStore() *TestWebhookStore {
+	return &TestWebhookStore{
+		subscriptions: make(map[int]*WebhookSubscription),
+		deliveries:    make(map[int]*WebhookDelivery),
+		attempts:      make(map[int][]WebhookAttempt),
+		claims:        make(map[int]deliveryClaim),
+	}
+}
+
+func (s *TestWebhookStore) newID() int {
+	s.nextID++
+	return s.nextID
+}
+
+func (s *TestWebhookStore) CreateSubscription(ctx context.Context, subscription *WebhookSubscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscription.ID = s.newID()
+	subscription.CreatedAt = time.Now()
+	subscription.UpdatedAt = subscription.CreatedAt
+
+	stored := *subscription
+	s.subscriptions[stored.ID] = &stored
+	return nil
+}
+
+func (s *TestWebhookStore) ListSubscriptions(ctx context.Context) ([]WebhookSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscriptions := []WebhookSubscription{}
+	for _, subscription := range s.subscriptions {
+		subscriptions = append(subscriptions, *subscription)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool { return subscriptions[i].ID < subscriptions[j].ID })
+	return subscriptions, nil
+}
+
+func (s *TestWebhookStore) GetSubscription(ctx context.Context, id int) (*WebhookSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscription, exists := s.subscriptions[id]
+	if !exists {
+		return nil, fmt.Errorf("webhook subscription %d: %w", id, ErrNotFound)
+	}
+	result := *subscription
+	return &result, nil
+}
+
+func (s *TestWebhookStore) DeleteSubscription(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.subscriptions[id]; !exists {
+		return fmt.Errorf("webhook subscription %d: %w", id, ErrNotFound)
+	}
+	delete(s.subscriptions, id)
+	for deliveryID, delivery := range s.deliveries {
+		if delivery.SubscriptionID == id {
+			delete(s.deliveries, deliveryID)
+			delete(s.attempts, deliveryID)
+		}
+	}
+	return nil
+}
+
+func (s *TestWebhookStore) MatchingSubscriptions(ctx context.Context, tenant, entity, action string) ([]WebhookSubscription, error) {
+	subscriptions, _ := s.ListSubscriptions(ctx)
+
+	var matching []WebhookSubscription
+	for _, subscription := range subscriptions {
+		if subscription.Matches(tenant, entity, action) {
+			matching = append(matching, subscription)
+		}
+	}
+	return matching, nil
+}
+
+func (s *TestWebhookStore) CreateDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delivery.ID = s.newID()
+	delivery.CreatedAt = time.Now()
+	delivery.UpdatedAt = delivery.CreatedAt
+
+	stored := *delivery
+	s.deliveries[stored.ID] = &stored
+	return nil
+}
+
+func (s *TestWebhookStore) GetDelivery(ctx context.Context, id int) (*WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delivery, exists := s.deliveries[id]
+	if !exists {
+		return nil, fmt.Errorf("webhook delivery %d: %w", id, ErrNotFound)
+	}
+	result := *delivery
+	return &result, nil
+}
+
+func (s *TestWebhookStore) ListDeliveries(ctx context.Context, subscriptionID int) ([]WebhookDelivery, error) {
+	return s.filterDeliveries(func(d *WebhookDelivery) bool { return d.SubscriptionID == subscriptionID }), nil
+}
+
+func (s *TestWebhookStore) ClaimDueDeliveries(ctx context.Context, owner string, now, until time.Time, limit int) ([]WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := []*WebhookDelivery{}
+	for _, delivery := range s.deliveries {
+		if s.claimable(delivery, owner, now) {
+			due = append(due, delivery)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+		}
+		return due[i].ID < due[j].ID
+	})
+
+	claimed := []WebhookDelivery{}
+	for _, delivery := range due {
+		if len(claimed) == limit {
+			break
+		}
+		s.claims[delivery.ID] = deliveryClaim{owner: owner, until: until}
+		claimed = append(claimed, *delivery)
+	}
+	return claimed, nil
+}
+
+func (s *TestWebhookStore) ClaimDelivery(ctx context.Context, id int, owner string, now, until time.Time) (*WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delivery, exists := s.deliveries[id]
+	if !exists || !s.claimable(delivery, owner, now) {
+		return nil, nil
+	}
+	s.claims[id] = deliveryClaim{owner: owner, until: until}
+	result := *delivery
+	return &result, nil
+}
+
+// Claim sets the claim of owner on a delivery, as an agent of another replica would
+func (s *TestWebhookStore) Claim(id int, owner string, until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.claims[id] = deliveryClaim{owner: owner, until: until}
+}
+
+func (s *TestWebhookStore) claimable(delivery *WebhookDelivery, owner string, now time.Time) bool {
+	if delivery.Status != DeliveryStatusPending || delivery.NextAttemptAt.After(now) {
+		return false
+	}
+	claim, claimed := s.claims[delivery.ID]
+	return !claimed || !claim.until.After(now) || claim.owner == owner
+}
+
+func (s *TestWebhookStore) filterDeliveries(match func(*WebhookDelivery) bool) []WebhookDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries := []WebhookDelivery{}
+	for _, delivery := range s.deliveries {
+		if match(delivery) {
+			deliveries = append(deliveries, *delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+	return deliveries
+}
+
+func (s *TestWebhookStore) UpdateDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.deliveries[delivery.ID]; !exists {
+		return fmt.Errorf("webhook delivery %d: %w", delivery.ID, ErrNotFound)
+	}
+	delivery.UpdatedAt = time.Now()
+
+	stored := *delivery
+	s.deliveries[stored.ID] = &stored
+	delete(s.claims, stored.ID)
+	return nil
+}
+
+func (s *TestWebhookStore) RequeueDelivery(ctx context.Context, id int) (*WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delivery, exists := s.deliveries[id]
+	if !exists {
+		return nil, fmt.Errorf("webhook delivery %d: %w", id, ErrNotFound)
+	}
+	if delivery.Status == DeliveryStatusPending {
+		return nil, fmt.Errorf("webhook delivery %d: %w", id, ErrDeliveryPending)
+	}
+	delivery.Status = DeliveryStatusPending
+	delivery.UpdatedAt = time.Now()
+	delivery.NextAttemptAt = delivery.UpdatedAt
+	delivery.RoundAttempts = 0
+	result := *delivery
+	return &result, nil
+}
+
+func (s *TestWebhookStore) RecordAttempt(ctx context.Context, attempt *WebhookAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt.ID = s.newID()
+	attempt.CreatedAt = time.Now()
+	s.attempts[attempt.DeliveryID] = append(s.attempts[attempt.DeliveryID], *attempt)
+	return nil
+}
+
+func (s *TestWebhookStore) ListAttempts(ctx context.Context, deliveryID int) ([]WebhookAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts := append([]WebhookAttempt{}, s.attempts[deliveryID]...)
+	return attempts, nil
+}
@@ -0,0 +1,340 @@
+package data
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bulk transfer formats
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// maxJSONLLine bounds a single JSON Lines record
+const maxJSONLLine = 1 << 20
+
+var ErrInvalidTransfer = errors.New("invalid import or export")
+
+// BulkStore imports and exports whole entities outside the per-command path
+type BulkStore interface {
+	// ImportRecords inserts the records returned by next until it returns io.EOF, all or
+	// none of them, and returns the inserted records
+	ImportRecords(ctx context.Context, entity string, next func() (map[string]interface{}, error)) ([]map[string]interface{}, error)
+	// ExportRecords passes the records matching conditions to emit in id order
+	ExportRecords(ctx context.Context, entity string, conditions []Condition, emit func(map[string]interface{}) error) error
+}
+
+// importFields lists the fields an import fills for each entity, with how CSV cells convert
+var importFields = map[string]map[string]fieldKind{
+	"user":    {"name": textField, "email": textField},
+	"product": {"name": textField, "price": numberField, "description": textField},
+	"order":   {"user_id": textField, "items": jsonField, "total_amount": numberField, "status": textField},
+}
+
+// exportColumns lists the exported fields of each entity in column order
+var exportColumns = map[string][]string{
+	"user":    {"id", "name", "email", "created_at", "updated_at"},
+	"product": {"id", "name", "price", "description", "created_at", "updated_at"},
+	"order":   {"id", "user_id", "items", "total_amount", "status", "created_at", "updated_at"},
+}
+
+// RowError reports a row an import skipped. Rows are numbered from 1, not counting the CSV header.
+type RowError struct {
+	Row     int    `json:"row"`
+	Message string `json:"error"`
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("row %d: %s", e.Row, e.Message)
+}
+
+// RecordReader streams the records of an import file. Read returns a *RowError for a row
+// that cannot be parsed, after which reading can continue, and io.EOF at the end.
+type RecordReader struct {
+	entity string
+	row    int
+	next   func() (map[string]interface{}, error)
+}
+
+// NewRecordReader reads entity records in format from r. CSV files start with a header row
+// naming the columns; the exported id and timestamp columns are accepted and ignored.
+func NewRecordReader(format, entity string, r io.Reader) (*RecordReader, error) {
+	if importFields[entity] == nil {
+		return nil, fmt.Errorf("%w: %s cannot be imported", ErrInvalidTransfer, entity)
+	}
+	reader := &RecordReader{entity: entity}
+
+	switch format {
+	case FormatCSV:
+		cells := csv.NewReader(r)
+		cells.ReuseRecord = true
+		header, err := cells.Read()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: csv header row is missing", ErrInvalidTransfer)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: csv header: %w", ErrInvalidTransfer, err)
+		}
+		columns, err := importHeader(entity, header)
+		if err != nil {
+			return nil, err
+		}
+		cells.FieldsPerRecord = len(columns)
+		reader.next = func() (map[string]interface{}, error) { return reader.readCSV(cells, columns) }
+	case FormatJSONL:
+		lines := bufio.NewScanner(r)
+		lines.Buffer(make([]byte, 64*1024), maxJSONLLine)
+		reader.next = func() (map[string]interface{}, error) { return reader.readJSONL(lines) }
+	default:
+		return nil, fmt.Errorf("%w: unsupported format %q, use csv or jsonl", ErrInvalidTransfer, format)
+	}
+
+	return reader, nil
+}
+
+// Read returns the next record
+func (r *RecordReader) Read() (map[string]interface{}, error) {
+	return r.next()
+}
+
+// Row is the number of the row last read
+func (r *RecordReader) Row() int {
+	return r.row
+}
+
+func importHeader(entity string, header []string) ([]string, error) {
+	columns := make([]string, len(header))
+	seen := map[string]bool{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, exists := importFields[entity][name]; !exists && !entityFields[entity][name] {
+			return nil, fmt.Errorf("%w: unknown column %q for %s", ErrInvalidTransfer, name, entity)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%w: duplicate column %q", ErrInvalidTransfer, name)
+		}
+		seen[name] = true
+		columns[i] = name
+	}
+	return columns, nil
+}
+
+func (r *RecordReader) readCSV(cells *csv.Reader, columns []string) (map[string]interface{}, error) {
+	values, err := cells.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	r.row++
+
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return nil, &RowError{Row: r.row, Message: parseErr.Err.Error()}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv: %w", err)
+	}
+
+	record := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		kind, imported := importFields[r.entity][column]
+		if !imported || values[i] == "" {
+			continue
+		}
+
+		switch kind {
+		case numberField:
+			number, err := strconv.ParseFloat(strings.TrimSpace(values[i]), 64)
+			if err != nil {
+				return nil, &RowError{Row: r.row, Message: fmt.Sprintf("%s must be a number", column)}
+			}
+			record[column] = number
+		case jsonField:
+			var value interface{}
+			if err := json.Unmarshal([]byte(values[i]), &value); err != nil {
+				return nil, &RowError{Row: r.row, Message: fmt.Sprintf("%s must be JSON", column)}
+			}
+			record[column] = value
+		default:
+			record[column] = values[i]
+		}
+	}
+	return record, nil
+}
+
+func (r *RecordReader) readJSONL(lines *bufio.Scanner) (map[string]interface{}, error) {
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" {
+			continue
+		}
+		r.row++
+
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return nil, &RowError{Row: r.row, Message: "invalid JSON object"}
+		}
+		for field := range record {
+			if _, imported := importFields[r.entity][field]; !imported {
+				if !entityFields[r.entity][field] {
+					return nil, &RowError{Row: r.row, Message: fmt.Sprintf("unknown field %q", field)}
+				}
+				delete(record, field)
+			}
+		}
+		if userID, ok := record["user_id"].(float64); ok {
+			// Orders are created with user_id as a string, as in "create order" commands
+			record["user_id"] = strconv.FormatFloat(userID, 'f', -1, 64)
+		}
+		return record, nil
+	}
+
+	if err := lines.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read jsonl: %w", err)
+	}
+	return nil, io.EOF
+}
+
+// RecordWriter streams exported records in one of the transfer formats
+type RecordWriter struct {
+	entity string
+	csv    *csv.Writer
+	json   *json.Encoder
+	flush  func() error
+}
+
+// NewRecordWriter writes entity records in format to w; CSV output starts with a header row
+func NewRecordWriter(format, entity string, w io.Writer) (*RecordWriter, error) {
+	columns, exists := exportColumns[entity]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s cannot be exported", ErrInvalidTransfer, entity)
+	}
+
+	writer := &RecordWriter{entity: entity}
+	switch format {
+	case FormatCSV:
+		writer.csv = csv.NewWriter(w)
+		if err := writer.csv.Write(columns); err != nil {
+			return nil, err
+		}
+		writer.flush = func() error {
+			writer.csv.Flush()
+			return writer.csv.Error()
+		}
+	case FormatJSONL:
+		buffered := bufio.NewWriter(w)
+		writer.json = json.NewEncoder(buffered)
+		writer.flush = buffered.Flush
+	default:
+		return nil, fmt.Errorf("%w: unsupported format %q, use csv or jsonl", ErrInvalidTransfer, format)
+	}
+	return writer, nil
+}
+
+// ContentType is the media type of the format
+func ContentType(format string) string {
+	if format == FormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// Write writes one record, keeping the exported columns only
+func (w *RecordWriter) Write(record map[string]interface{}) error {
+	columns := exportColumns[w.entity]
+	if w.entity == "order" {
+		// PostgresDataAgent returns items as a JSON string
+		if encoded, ok := record["items"].(string); ok {
+			record["items"] = json.RawMessage(encoded)
+		}
+	}
+
+	if w.json != nil {
+		row := make(map[string]interface{}, len(columns))
+		for _, column := range columns {
+			row[column] = record[column]
+		}
+		return w.json.Encode(row)
+	}
+
+	cells := make([]string, len(columns))
+	for i, column := range columns {
+		cells[i] = csvCell(record[column])
+	}
+	return w.csv.Write(cells)
+}
+
+// Flush writes out buffered records
+func (w *RecordWriter) Flush() error {
+	return w.flush()
+}
+
+func csvCell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case json.RawMessage:
+		return string(v)
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(encoded)
+	}
+}
+
+// copyRow converts an imported record into the values COPY writes to importColumns(entity)
+func copyRow(entity string, record map[string]interface{}) ([]interface{}, error) {
+	switch entity {
+	case "user":
+		name, _ := record["name"].(string)
+		email, _ := record["email"].(string)
+		return []interface{}{name, email}, nil
+	case "product":
+		name, _ := record["name"].(string)
+		price, _ := record["price"].(float64)
+		description, _ := record["description"].(string)
+		return []interface{}{name, price, description}, nil
+	case "order":
+		items, err := json.Marshal(record["items"])
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal items: %w", err)
+		}
+		var userID *int
+		if id, ok := referenceID(record["user_id"]); ok {
+			userID = &id
+		}
+		var totalAmount *float64
+		if amount, ok := record["total_amount"].(float64); ok {
+			totalAmount = &amount
+		}
+		status, _ := record["status"].(string)
+		if status == "" {
+			status = "pending"
+		}
+		return []interface{}{userID, json.RawMessage(items), totalAmount, status}, nil
+	}
+	return nil, fmt.Errorf("unsupported entity: %s", entity)
+}
+
+// importColumns lists the columns COPY fills, matching copyRow
+var importColumns = map[string][]string{
+	"user":    {"name", "email"},
+	"product": {"name", "price", "description"},
+	"order":   {"user_id", "items", "total_amount", "status"},
+}
@@ -0,0 +1,102 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	DeliveryStatusPending   = "pending"
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusFailed    = "failed"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// ErrDeliveryPending is returned when redelivering a delivery that has not finished its attempts
+var ErrDeliveryPending = errors.New("webhook delivery is still pending")
+
+type WebhookSubscription struct {
+	ID        int       `json:"id"`
+	Tenant    string    `json:"tenant"`
+	URL       string    `json:"url"`
+	Entity    string    `json:"entity"`
+	Actions   []string  `json:"actions"`
+	Secret    string    `json:"-"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Matches reports whether the subscription filter accepts the given entity and action of its
+// tenant. An entity of "*" and an empty action list match everything.
+func (s *WebhookSubscription) Matches(tenant, entity, action string) bool {
+	if !s.Active || s.Tenant != tenant {
+		return false
+	}
+	if s.Entity != "*" && s.Entity != entity {
+		return false
+	}
+	if len(s.Actions) == 0 {
+		return true
+	}
+	for _, a := range s.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+type WebhookDelivery struct {
+	ID             int       `json:"id"`
+	SubscriptionID int       `json:"subscription_id"`
+	EventID        string    `json:"event_id"`
+	EventType      string    `json:"event_type"`
+	Payload        string    `json:"payload"`
+	Status         string    `json:"status"`
+	Attempts       int       `json:"attempts"`
+	LastStatusCode *int      `json:"last_status_code"`
+	LastError      string    `json:"last_error"`
+	NextAttemptAt  time.Time `json:"next_attempt_at"` // when a pending delivery is due to be attempted
+	RoundAttempts  int       `json:"-"`               // attempts since the delivery was last queued
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type WebhookAttempt struct {
+	ID         int       `json:"id"`
+	DeliveryID int       `json:"delivery_id"`
+	Attempt    int       `json:"attempt"`
+	StatusCode *int      `json:"status_code"`
+	Error      string    `json:"error"`
+	DurationMs int       `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type WebhookStore interface {
+	CreateSubscription(ctx context.Context, subscription *WebhookSubscription) error
+	ListSubscriptions(ctx context.Context) ([]WebhookSubscription, error)
+	GetSubscription(ctx context.Context, id int) (*WebhookSubscription, error)
+	DeleteSubscription(ctx context.Context, id int) error
+	MatchingSubscriptions(ctx context.Context, tenant, entity, action string) ([]WebhookSubscription, error)
+
+	CreateDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	GetDelivery(ctx context.Context, id int) (*WebhookDelivery, error)
+	ListDeliveries(ctx context.Context, subscriptionID int) ([]WebhookDelivery, error)
+	// ClaimDueDeliveries claims up to limit pending deliveries due at now for owner until
+	// until, the longest overdue first, skipping those another owner's claim still holds
+	ClaimDueDeliveries(ctx context.Context, owner string, now, until time.Time, limit int) ([]WebhookDelivery, error)
+	// ClaimDelivery claims or renews the claim of owner on a pending delivery due at now. It
+	// returns nil when the delivery is finished, not due or claimed by another owner.
+	ClaimDelivery(ctx context.Context, id int, owner string, now, until time.Time) (*WebhookDelivery, error)
+	// UpdateDelivery stores the outcome of an attempt and releases the delivery's claim
+	UpdateDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	// RequeueDelivery resets a delivered or failed delivery to pending and due now in one step,
+	// so a delivery is never sent by two rounds of attempts at once
+	RequeueDelivery(ctx context.Context, id int) (*WebhookDelivery, error)
+
+	RecordAttempt(ctx context.Context, attempt *WebhookAttempt) error
+	ListAttempts(ctx context.Context, deliveryID int) ([]WebhookAttempt, error)
+}
@@ -3,12 +3,13 @@ package db
 import (
 	"context"
 	"fmt"
-	"os"
-	"strconv"
 	"time"
 
+	"drm-app/app/config"
+	"drm-app/app/tracing"
+	"github.com/jackc/pgx/v5/multitracer"
 	"github.com/jackc/pgx/v5/pgxpool"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -17,54 +18,26 @@ type Database struct {
 	DB   *sqlx.DB
 }
 
-type Config struct {
-	Host     string
-	Port     int
-	User     string
-	Password string
-	DBName   string
-}
-
-func LoadConfig() (*Config, error) {
-	portStr := getEnv("DB_PORT", "5432")
-	port, err := strconv.Atoi(portStr)
+func NewDatabase(cfg config.DatabaseConfig) (*Database, error) {
+	poolConfig, err := pgxpool.ParseConfig(buildDSN(cfg))
 	if err != nil {
-		return nil, fmt.Errorf("invalid DB_PORT value: %s", portStr)
-	}
-
-	config := &Config{
-		Host:     getEnv("DB_HOST", "localhost"),
-		Port:     port,
-		User:     getEnv("DB_USER", "postgres"),
-		Password: getEnv("DB_PASSWORD", ""),
-		DBName:   getEnv("DB_NAME", "postgres"),
+		return nil, fmt.Errorf("invalid database config: %w", err)
 	}
-
-	// Validate required fields
-	if config.Password == "" {
-		return nil, fmt.Errorf("DB_PASSWORD environment variable is required")
-	}
-
-	return config, nil
-}
-
-func NewDatabase() (*Database, error) {
-	config, err := LoadConfig()
-	if err != nil {
-		return nil, fmt.Errorf("failed to load database config: %w", err)
-	}
-
-	dsn := buildDSN(config)
+	poolConfig.ConnConfig.Tracer = multitracer.New(StatementCapture{}, tracing.QueryTracer{}, QueryLogger{})
+	poolConfig.MaxConns = cfg.MaxConns
+	poolConfig.MinConns = cfg.MinConns
+	poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
+	poolConfig.MaxConnIdleTime = cfg.MaxConnIdleTime
 
 	// Try to connect with retries
-	maxRetries := 5
-	retryDelay := 2 * time.Second
+	maxRetries := cfg.ConnectRetries
+	retryDelay := cfg.RetryDelay
 
 	for attempt := 1; attempt <= maxRetries; attempt++ {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
 
 		// Create connection pool
-		pool, err := pgxpool.New(ctx, dsn)
+		pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 		if err != nil {
 			cancel()
 			if attempt == maxRetries {
@@ -85,17 +58,12 @@ func NewDatabase() (*Database, error) {
 			continue
 		}
 
-		// Create sqlx connection for easier queries
-		db, err := sqlx.Open("pgx", dsn)
-		if err != nil {
-			pool.Close()
-			cancel()
-			if attempt == maxRetries {
-				return nil, fmt.Errorf("failed to open sqlx connection after %d attempts: %w", maxRetries, err)
-			}
-			time.Sleep(retryDelay)
-			continue
-		}
+		// Create sqlx connection for easier queries, sharing the traced connection config
+		db := sqlx.NewDb(stdlib.OpenDB(*poolConfig.ConnConfig), "pgx")
+		db.SetMaxOpenConns(int(cfg.MaxConns))
+		db.SetMaxIdleConns(int(cfg.MinConns))
+		db.SetConnMaxLifetime(cfg.MaxConnLifetime)
+		db.SetConnMaxIdleTime(cfg.MaxConnIdleTime)
 
 		// Test sqlx connection
 		if err := db.PingContext(ctx); err != nil {
@@ -132,14 +100,55 @@ func (d *Database) Health(ctx context.Context) error {
 	return d.Pool.Ping(ctx)
 }
 
-func buildDSN(config *Config) string {
-	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		config.Host, config.Port, config.User, config.Password, config.DBName)
+type PoolStats struct {
+	MaxConns             int32  `json:"max_conns"`
+	TotalConns           int32  `json:"total_conns"`
+	IdleConns            int32  `json:"idle_conns"`
+	AcquiredConns        int32  `json:"acquired_conns"`
+	ConstructingConns    int32  `json:"constructing_conns"`
+	AcquireCount         int64  `json:"acquire_count"`
+	EmptyAcquireCount    int64  `json:"empty_acquire_count"`
+	CanceledAcquireCount int64  `json:"canceled_acquire_count"`
+	AcquireDuration      string `json:"acquire_duration"`
+	SQLOpenConnections   int    `json:"sql_open_connections"`
+	SQLInUse             int    `json:"sql_in_use"`
+	SQLIdle              int    `json:"sql_idle"`
+	SQLWaitCount         int64  `json:"sql_wait_count"`
+	SQLWaitDuration      string `json:"sql_wait_duration"`
+}
+
+// Stats reports usage of both the pgx pool and the sqlx connection pool
+func (d *Database) Stats() PoolStats {
+	stat := d.Pool.Stat()
+	sqlStats := d.DB.Stats()
+
+	return PoolStats{
+		MaxConns:             stat.MaxConns(),
+		TotalConns:           stat.TotalConns(),
+		IdleConns:            stat.IdleConns(),
+		AcquiredConns:        stat.AcquiredConns(),
+		ConstructingConns:    stat.ConstructingConns(),
+		AcquireCount:         stat.AcquireCount(),
+		EmptyAcquireCount:    stat.EmptyAcquireCount(),
+		CanceledAcquireCount: stat.CanceledAcquireCount(),
+		AcquireDuration:      stat.AcquireDuration().String(),
+		SQLOpenConnections:   sqlStats.OpenConnections,
+		SQLInUse:             sqlStats.InUse,
+		SQLIdle:              sqlStats.Idle,
+		SQLWaitCount:         sqlStats.WaitCount,
+		SQLWaitDuration:      sqlStats.WaitDuration.String(),
+	}
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+func (d *Database) ServerVersion(ctx context.Context) (string, error) {
+	var version string
+	if err := d.Pool.QueryRow(ctx, `SHOW server_version`).Scan(&version); err != nil {
+		return "", fmt.Errorf("failed to read server version: %w", err)
 	}
-	return defaultValue
+	return version, nil
+}
+
+func buildDSN(cfg config.DatabaseConfig) string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.Name, cfg.SSLMode)
 }
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type Migration struct {
+	Version string
+	SQL     string
+}
+
+// LoadMigrations returns the embedded migrations ordered by version
+func LoadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+
+		content, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		migrations = append(migrations, Migration{
+			Version: strings.TrimSuffix(entry.Name(), ".sql"),
+			SQL:     string(content),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// migrationLock is the advisory lock key replicas take to apply migrations one at a time
+const migrationLock = 72616374
+
+// Migrate applies all embedded migrations that are not yet recorded in schema_migrations. It
+// holds an advisory lock meanwhile, so replicas starting together wait for each other instead
+// of running the same DDL, and then find the migrations already applied.
+func (d *Database) Migrate(ctx context.Context) error {
+	// Session locks belong to a connection, so take one out of the pool for the whole run
+	conn, err := d.DB.Connx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get migration connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLock); err != nil {
+		return fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLock); err != nil {
+			// Drop the connection rather than return it to the pool still holding the lock
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version VARCHAR(255) PRIMARY KEY,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	pending, err := d.PendingMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range pending {
+		tx, err := conn.BeginTxx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %s: %w", migration.Version, err)
+		}
+
+		if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %s: %w", migration.Version, err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, migration.Version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %s: %w", migration.Version, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %s: %w", migration.Version, err)
+		}
+	}
+
+	return nil
+}
+
+// PendingMigrations returns the embedded migrations that have not been applied yet
+func (d *Database) PendingMigrations(ctx context.Context) ([]Migration, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := d.AppliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	appliedSet := make(map[string]bool, len(applied))
+	for _, version := range applied {
+		appliedSet[version] = true
+	}
+
+	var pending []Migration
+	for _, migration := range migrations {
+		if !appliedSet[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// AppliedMigrations returns the versions recorded in schema_migrations in order
+func (d *Database) AppliedMigrations(ctx context.Context) ([]string, error) {
+	var applied []string
+	if err := d.DB.SelectContext(ctx, &applied, `SELECT version FROM schema_migrations ORDER BY version`); err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	return applied, nil
+}
@@ -0,0 +1,16 @@
+-- Token buckets for per-user request rate limits, shared between replicas
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    key VARCHAR(255) PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+-- Daily usage counters for per-user quotas
+CREATE TABLE IF NOT EXISTS usage_quotas (
+    key VARCHAR(255) NOT NULL,
+    day DATE NOT NULL,
+    used INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (key, day)
+);
+
+CREATE INDEX IF NOT EXISTS idx_usage_quotas_day ON usage_quotas(day);
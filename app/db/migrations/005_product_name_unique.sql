@@ -0,0 +1,3 @@
+-- Product names identify products for upserts. Fails if duplicate names exist; rename or
+-- remove the duplicates first.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_products_name ON products (name);
@@ -0,0 +1,58 @@
+-- Every user, product and order belongs to a tenant. Existing rows join the default tenant;
+-- new rows take the tenant of the transaction that inserts them.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(63) NOT NULL DEFAULT 'default';
+ALTER TABLE products ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(63) NOT NULL DEFAULT 'default';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(63) NOT NULL DEFAULT 'default';
+ALTER TABLE webhook_subscriptions ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(63) NOT NULL DEFAULT 'default';
+
+ALTER TABLE users ALTER COLUMN tenant_id SET DEFAULT current_setting('app.tenant_id');
+ALTER TABLE products ALTER COLUMN tenant_id SET DEFAULT current_setting('app.tenant_id');
+ALTER TABLE orders ALTER COLUMN tenant_id SET DEFAULT current_setting('app.tenant_id');
+
+-- Unique keys and references hold within a tenant
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_tenant_email ON users (tenant_id, email);
+DROP INDEX IF EXISTS idx_products_name;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_products_tenant_name ON products (tenant_id, name);
+
+ALTER TABLE users ADD CONSTRAINT users_tenant_id_id_key UNIQUE (tenant_id, id);
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_user_id_fkey;
+ALTER TABLE orders ADD CONSTRAINT orders_tenant_user_fkey
+    FOREIGN KEY (tenant_id, user_id) REFERENCES users (tenant_id, id);
+
+CREATE INDEX IF NOT EXISTS idx_products_tenant ON products (tenant_id);
+CREATE INDEX IF NOT EXISTS idx_orders_tenant ON orders (tenant_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_subscriptions_tenant ON webhook_subscriptions (tenant_id);
+
+-- The application switches to drm_tenant for data access; row-level security limits it to
+-- the rows of the tenant in app.tenant_id. The table owner is not limited.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'drm_tenant') THEN
+        CREATE ROLE drm_tenant NOLOGIN;
+    END IF;
+END
+$$;
+
+GRANT drm_tenant TO CURRENT_USER;
+GRANT SELECT, INSERT, UPDATE, DELETE ON users, products, orders TO drm_tenant;
+GRANT USAGE ON SEQUENCE users_id_seq, products_id_seq, orders_id_seq TO drm_tenant;
+
+ALTER TABLE users ENABLE ROW LEVEL SECURITY;
+ALTER TABLE products ENABLE ROW LEVEL SECURITY;
+ALTER TABLE orders ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON users;
+CREATE POLICY tenant_isolation ON users
+    USING (tenant_id = current_setting('app.tenant_id', true))
+    WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
+
+DROP POLICY IF EXISTS tenant_isolation ON products;
+CREATE POLICY tenant_isolation ON products
+    USING (tenant_id = current_setting('app.tenant_id', true))
+    WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
+
+DROP POLICY IF EXISTS tenant_isolation ON orders;
+CREATE POLICY tenant_isolation ON orders
+    USING (tenant_id = current_setting('app.tenant_id', true))
+    WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
@@ -0,0 +1,32 @@
+-- Passwords of users that sign in with email and password, and the sessions they sign in to.
+-- Only hashes of passwords and tokens are stored.
+CREATE TABLE IF NOT EXISTS user_credentials (
+    tenant_id VARCHAR(63) NOT NULL,
+    user_id INTEGER NOT NULL,
+    role VARCHAR(100) NOT NULL,
+    password_hash TEXT NOT NULL,
+    failed_logins INTEGER NOT NULL DEFAULT 0,
+    locked_until TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (tenant_id, user_id),
+    FOREIGN KEY (tenant_id, user_id) REFERENCES users (tenant_id, id) ON DELETE CASCADE
+);
+
+-- Emails identify accounts regardless of case. Fails if a tenant has emails differing only in
+-- case; merge or rename those users first.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_tenant_email_lower ON users (tenant_id, lower(email));
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id VARCHAR(32) PRIMARY KEY,
+    tenant_id VARCHAR(63) NOT NULL,
+    user_id INTEGER NOT NULL,
+    access_hash CHAR(64) NOT NULL UNIQUE,
+    access_expires_at TIMESTAMPTZ NOT NULL,
+    refresh_hash CHAR(64) NOT NULL UNIQUE,
+    refresh_expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ,
+    FOREIGN KEY (tenant_id, user_id) REFERENCES user_credentials (tenant_id, user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions (tenant_id, user_id);
@@ -0,0 +1,19 @@
+-- Users that sign in through an OpenID Connect provider, by their subject at the provider
+CREATE TABLE IF NOT EXISTS user_identities (
+    tenant_id VARCHAR(63) NOT NULL,
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (tenant_id, issuer, subject),
+    FOREIGN KEY (tenant_id, user_id) REFERENCES users (tenant_id, id) ON DELETE CASCADE
+);
+
+-- Sessions keep the roles their user signed in with, since users signing in through a provider
+-- have no credential to read them from
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS roles TEXT NOT NULL DEFAULT '';
+UPDATE sessions s SET roles = c.role FROM user_credentials c
+    WHERE c.tenant_id = s.tenant_id AND c.user_id = s.user_id AND s.roles = '';
+ALTER TABLE sessions DROP CONSTRAINT IF EXISTS sessions_tenant_id_user_id_fkey;
+ALTER TABLE sessions ADD CONSTRAINT sessions_tenant_user_fkey
+    FOREIGN KEY (tenant_id, user_id) REFERENCES users (tenant_id, id) ON DELETE CASCADE;
@@ -0,0 +1,5 @@
+-- Idempotency keys are scoped to the tenant, identity source and ID of the user that sent
+-- them rather than to the user ID alone. Keys stored before keep their bare user ID as scope,
+-- which new scopes never equal, and are still replayed until they expire.
+ALTER TABLE idempotency_keys RENAME COLUMN user_id TO scope;
+ALTER TABLE idempotency_keys ALTER COLUMN scope TYPE TEXT;
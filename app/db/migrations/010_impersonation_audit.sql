@@ -0,0 +1,20 @@
+-- Audit trail of requests made while acting as another user, including refused attempts.
+-- Rows are only ever inserted.
+CREATE TABLE IF NOT EXISTS impersonation_audit (
+    id BIGSERIAL PRIMARY KEY,
+    tenant_id VARCHAR(63) NOT NULL,
+    impersonator_id VARCHAR(100) NOT NULL,
+    impersonator_source VARCHAR(20) NOT NULL,
+    user_id VARCHAR(100) NOT NULL,
+    user_source VARCHAR(20) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    stage VARCHAR(50) NOT NULL,
+    outcome VARCHAR(50) NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    request_id VARCHAR(128) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_impersonation_audit_tenant ON impersonation_audit (tenant_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_impersonation_audit_impersonator ON impersonation_audit (tenant_id, impersonator_id, created_at);
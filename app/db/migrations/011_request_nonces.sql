@@ -0,0 +1,10 @@
+-- Nonces of signed service requests, shared between replicas so each request is accepted once.
+-- Rows are deleted once expired.
+CREATE TABLE IF NOT EXISTS request_nonces (
+    key_id TEXT NOT NULL,
+    nonce VARCHAR(128) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (key_id, nonce)
+);
+
+CREATE INDEX IF NOT EXISTS idx_request_nonces_expires_at ON request_nonces(expires_at);
@@ -0,0 +1,6 @@
+-- Failed webhook attempts are retried once next_attempt_at has passed, by whichever worker the
+-- poller hands them to. round_attempts counts the attempts since the delivery was last queued.
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS round_attempts INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
@@ -0,0 +1,4 @@
+-- A webhook agent claims the deliveries it queues and attempts until claimed_until, so replicas
+-- never send the same delivery at once. Claims of agents that went away expire.
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS claimed_by VARCHAR(64);
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMPTZ;
@@ -0,0 +1,5 @@
+-- A bucket is the same as a new one once it has refilled, so it can be deleted after full_at.
+-- Existing buckets are swept right away and start full on their next request.
+ALTER TABLE rate_limit_buckets ADD COLUMN IF NOT EXISTS full_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_buckets_full_at ON rate_limit_buckets(full_at);
@@ -0,0 +1,2 @@
+-- Expired idempotency keys are deleted by a periodic sweep
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
@@ -0,0 +1,10 @@
+-- OpenID Connect sign-ins waiting for the provider to send the user back, by their state
+-- parameter, so the callback may reach any replica. Rows are deleted once used or expired.
+CREATE TABLE IF NOT EXISTS oidc_logins (
+    state VARCHAR(64) PRIMARY KEY,
+    verifier VARCHAR(64) NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_oidc_logins_expires_at ON oidc_logins(expires_at);
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type queryLogKey struct{}
+
+type queryLogEntry struct {
+	sql   string
+	args  int
+	start time.Time
+}
+
+// QueryLogger logs every SQL statement at debug level, and failed statements at warn level,
+// with the request ID and trace carried by the query context. Bound arguments are not logged.
+type QueryLogger struct{}
+
+func (QueryLogger) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryLogKey{}, queryLogEntry{sql: data.SQL, args: len(data.Args), start: time.Now()})
+}
+
+func (QueryLogger) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	entry, ok := ctx.Value(queryLogKey{}).(queryLogEntry)
+	if !ok || recording(ctx) {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("sql", entry.sql),
+		slog.Int("args", entry.args),
+		slog.Float64("duration_ms", float64(time.Since(entry.start).Microseconds())/1000),
+	}
+
+	if data.Err != nil {
+		attrs = append(attrs, slog.String("error", data.Err.Error()))
+		slog.LogAttrs(ctx, slog.LevelWarn, "sql query failed", attrs...)
+		return
+	}
+
+	attrs = append(attrs, slog.Int64("rows", data.CommandTag.RowsAffected()))
+	slog.LogAttrs(ctx, slog.LevelDebug, "sql query", attrs...)
+}
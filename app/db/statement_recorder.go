@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Statement is a SQL statement with its bound parameters
+type Statement struct {
+	SQL  string        `json:"sql"`
+	Args []interface{} `json:"args"`
+}
+
+type statementRecorderKey struct{}
+
+type unrecordedKey struct{}
+
+// StatementRecorder collects the statements issued with a recording context
+type StatementRecorder struct {
+	mu         sync.Mutex
+	statements []Statement
+}
+
+// WithStatementRecorder returns a context whose statements are recorded by StatementCapture.
+// They still run, so statements that depend on the results of earlier ones are issued too, but
+// InTenant rolls back the transactions begun with the context instead of committing them.
+// Transaction control and tenant setup statements are not recorded.
+func WithStatementRecorder(ctx context.Context) (context.Context, *StatementRecorder) {
+	recorder := &StatementRecorder{}
+	return context.WithValue(ctx, statementRecorderKey{}, recorder), recorder
+}
+
+// Statements returns the recorded statements in the order they were issued
+func (r *StatementRecorder) Statements() []Statement {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Statement(nil), r.statements...)
+}
+
+// unrecorded marks statements that run even while recording, such as tenant setup
+func unrecorded(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unrecordedKey{}, true)
+}
+
+func recording(ctx context.Context) bool {
+	_, ok := ctx.Value(statementRecorderKey{}).(*StatementRecorder)
+	return ok
+}
+
+// StatementCapture is the query tracer behind WithStatementRecorder
+type StatementCapture struct{}
+
+func (StatementCapture) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	recorder, ok := ctx.Value(statementRecorderKey{}).(*StatementRecorder)
+	if !ok || transactionControl(data.SQL) || ctx.Value(unrecordedKey{}) != nil {
+		return ctx
+	}
+
+	recorder.mu.Lock()
+	recorder.statements = append(recorder.statements, Statement{SQL: data.SQL, Args: data.Args})
+	recorder.mu.Unlock()
+	return ctx
+}
+
+func (StatementCapture) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+}
+
+func transactionControl(sql string) bool {
+	statement := strings.ToLower(strings.TrimSpace(sql))
+	return statement == "begin" || statement == "commit" || statement == "rollback" || strings.HasPrefix(statement, "begin ")
+}
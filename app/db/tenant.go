@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jmoiron/sqlx"
+)
+
+// TenantRole is the role tenant transactions switch to. Row-level security policies limit it
+// to the rows of the tenant in the app.tenant_id setting; the owner of the tables, which
+// migrations run as, is not limited.
+const TenantRole = "drm_tenant"
+
+// Queryer runs statements either on the database or within a transaction
+type Queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error)
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+}
+
+type tenantTxKey struct{}
+
+type tenantTx struct {
+	tx     *sqlx.Tx
+	tenant string
+}
+
+// InTenant runs fn in a transaction that only sees and writes the rows of tenant. Statements
+// issued through Conn with the context fn receives run in that transaction; InTenant calls
+// made with it join the transaction instead of starting another. Transactions begun with a
+// statement recording context are rolled back once fn returns, so dry runs write nothing.
+func (d *Database) InTenant(ctx context.Context, tenant string, fn func(ctx context.Context) error) error {
+	if current, ok := ctx.Value(tenantTxKey{}).(*tenantTx); ok {
+		if current.tenant != tenant {
+			return fmt.Errorf("tenant %q cannot join the transaction of tenant %q", tenant, current.tenant)
+		}
+		return fn(ctx)
+	}
+
+	tx, err := d.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin tenant transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	setup := unrecorded(ctx)
+	if _, err := tx.ExecContext(setup, `SET LOCAL ROLE `+TenantRole); err != nil {
+		return fmt.Errorf("failed to switch to role %s: %w", TenantRole, err)
+	}
+	if _, err := tx.ExecContext(setup, `SELECT set_config('app.tenant_id', $1, true)`, tenant); err != nil {
+		return fmt.Errorf("failed to set tenant: %w", err)
+	}
+
+	if err := fn(context.WithValue(ctx, tenantTxKey{}, &tenantTx{tx: tx, tenant: tenant})); err != nil {
+		return err
+	}
+	if recording(ctx) {
+		return nil
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit tenant transaction: %w", err)
+	}
+	return nil
+}
+
+// Conn returns the tenant transaction of ctx, or the database outside of one
+func (d *Database) Conn(ctx context.Context) Queryer {
+	if current, ok := ctx.Value(tenantTxKey{}).(*tenantTx); ok {
+		return current.tx
+	}
+	return d.DB
+}
+
+// CopyInTenant copies rows into table for tenant in a transaction of its own and returns the
+// ids of the inserted rows. COPY cannot return rows, so the rows are copied into a staging
+// table and moved into table with INSERT ... RETURNING. PostgreSQL does not COPY into tables
+// with row-level security either, so the copy keeps the owner's role and the rows take the
+// tenant from the tenant_id column default instead.
+func (d *Database) CopyInTenant(ctx context.Context, tenant, table string, columns []string, source pgx.CopyFromSource) ([]int, error) {
+	tx, err := d.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin tenant transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	setup := unrecorded(ctx)
+	if _, err := tx.Exec(setup, `SELECT set_config('app.tenant_id', $1, true)`, tenant); err != nil {
+		return nil, fmt.Errorf("failed to set tenant: %w", err)
+	}
+
+	list := make([]string, len(columns))
+	for i, column := range columns {
+		list[i] = pgx.Identifier{column}.Sanitize()
+	}
+	columnList := strings.Join(list, ", ")
+	target := pgx.Identifier{table}.Sanitize()
+
+	staging := `CREATE TEMPORARY TABLE import_staging ON COMMIT DROP AS SELECT ` + columnList + ` FROM ` + target + ` WITH NO DATA`
+	if _, err := tx.Exec(setup, staging); err != nil {
+		return nil, fmt.Errorf("failed to create staging table: %w", err)
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"import_staging"}, columns, source); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, `INSERT INTO `+target+` (`+columnList+`) SELECT `+columnList+` FROM import_staging RETURNING id`)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit tenant transaction: %w", err)
+	}
+	return ids, nil
+}
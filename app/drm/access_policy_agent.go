@@ -1,47 +1,445 @@
 package drm
 
-import "drm-app/app/data"
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"drm-app/app/config"
+	"drm-app/app/data"
+	"drm-app/app/expr"
+)
+
+// fallbackPageSize applies to roles without configured page sizes
+var fallbackPageSize = config.PageSizeConfig{Default: data.DefaultPageSize, Max: 100}
+
+// fallbackBulkLimit applies to roles without a configured bulk limit
+const fallbackBulkLimit = 100
+
+// adminEntities are the entities of the administrative endpoints, which wildcard rules also cover
+var adminEntities = []string{"webhook", "system", "policy"}
 
 type AccessPolicyAgent struct {
-	policies map[string]map[string][]string
+	policies   map[string]map[string][]string
+	inherits   map[string][]string
+	deny       map[string]map[string][]string
+	conditions map[string]map[string]map[string]*condition
+	limits     map[string]config.RateLimitConfig
+	pageSizes  map[string]config.PageSizeConfig
+	bulkLimits map[string]int
+	// rolesAt and denyAt are where the allow and deny rules are configured, for reporting
+	rolesAt string
+	denyAt  string
+	// tenants hold the policies of tenants with access rules of their own
+	tenants map[string]*AccessPolicyAgent
 }
 
+// condition is a compiled policy condition; err is set when its source does not compile,
+// which fails every evaluation
+type condition struct {
+	source  string
+	program *expr.Program
+	err     error
+}
+
+// RecordLoader fetches the stored record a command targets, or nil when it targets none
+type RecordLoader func() (interface{}, error)
+
 func NewAccessPolicyAgent() *AccessPolicyAgent {
+	return NewAccessPolicyAgentFromConfig(config.Default().Policy)
+}
+
+func NewAccessPolicyAgentFromConfig(cfg config.PolicyConfig) *AccessPolicyAgent {
+	agent := newAccessRules(cfg, "policy", "policy")
+	agent.tenants = make(map[string]*AccessPolicyAgent, len(cfg.Tenants))
+	for tenant, override := range cfg.Tenants {
+		rolesAt, denyAt := "policy", "policy"
+		if override.Roles != nil {
+			rolesAt = "policy.tenants." + tenant
+		}
+		if override.Deny != nil {
+			denyAt = "policy.tenants." + tenant
+		}
+		agent.tenants[tenant] = newAccessRules(cfg.ForTenant(tenant), rolesAt, denyAt)
+	}
+	return agent
+}
+
+func newAccessRules(cfg config.PolicyConfig, rolesAt, denyAt string) *AccessPolicyAgent {
+	conditions := make(map[string]map[string]map[string]*condition, len(cfg.Conditions))
+	for role, entities := range cfg.Conditions {
+		conditions[role] = make(map[string]map[string]*condition, len(entities))
+		for entity, actions := range entities {
+			conditions[role][entity] = make(map[string]*condition, len(actions))
+			for action, source := range actions {
+				program, err := config.CompileCondition(source)
+				conditions[role][entity][action] = &condition{source: source, program: program, err: err}
+			}
+		}
+	}
+
 	return &AccessPolicyAgent{
-		policies: map[string]map[string][]string{
-			"admin": {
-				"user":    {"create", "read", "update", "delete"},
-				"product": {"create", "read", "update", "delete"},
-				"order":   {"create", "read", "update", "delete"},
-			},
-			"user": {
-				"user":    {"read", "update"},
-				"product": {"read"},
-				"order":   {"create", "read"},
-			},
-			"guest": {
-				"product": {"read"},
-			},
-		},
+		policies:   cfg.Roles,
+		inherits:   cfg.Inherits,
+		deny:       cfg.Deny,
+		conditions: conditions,
+		limits:     cfg.Limits,
+		pageSizes:  cfg.PageSizes,
+		bulkLimits: cfg.BulkLimits,
+		rolesAt:    rolesAt,
+		denyAt:     denyAt,
+	}
+}
+
+// ForTenant returns the policy that applies to the users of tenant
+func (a *AccessPolicyAgent) ForTenant(tenant string) *AccessPolicyAgent {
+	if policy, exists := a.tenants[tenant]; exists {
+		return policy
 	}
+	return a
+}
+
+// PolicyDecision is the outcome of checking a command against the access policy
+type PolicyDecision struct {
+	Allowed bool `json:"allowed"`
+	// Rule is the policy entry that allowed the command, the deny rule that refused it, or the
+	// entry whose condition did not hold
+	Rule string `json:"rule,omitempty"`
+	// Condition is the condition attached to Rule
+	Condition string `json:"condition,omitempty"`
+	// Reason says why the command was denied
+	Reason string `json:"reason,omitempty"`
 }
 
 func (a *AccessPolicyAgent) CheckAccess(command *data.Command) bool {
-	rolePermissions, roleExists := a.policies[command.UserRole]
-	if !roleExists {
-		return false
+	return a.Decide(command).Allowed
+}
+
+// Decide checks command against the policy of its tenant and reports the rule that allowed it
+// or why it was denied. The command is checked against all of its user's roles and the roles
+// they inherit:
+// any matching deny rule refuses it, otherwise any matching allow rule whose condition holds
+// permits it. Conditions see no stored record; use DecideWithRecord for commands that have one.
+func (a *AccessPolicyAgent) Decide(command *data.Command) PolicyDecision {
+	return a.DecideWithRecord(command, nil)
+}
+
+// DecideWithRecord is Decide with conditions able to read the stored record the command
+// targets. record is called at most once, and only when a condition reads the record.
+func (a *AccessPolicyAgent) DecideWithRecord(command *data.Command, record RecordLoader) PolicyDecision {
+	var vars map[string]interface{}
+	return a.decide(command, func(c *condition) (bool, error) {
+		if c.err != nil {
+			return false, c.err
+		}
+		if vars == nil {
+			vars = conditionVars(command)
+		}
+		if _, loaded := vars["record"]; !loaded && c.program.Uses("record") {
+			vars["record"] = nil
+			if record != nil {
+				stored, err := record()
+				if err != nil {
+					delete(vars, "record")
+					return false, fmt.Errorf("failed to load record: %w", err)
+				}
+				if vars["record"], err = plainValue(stored); err != nil {
+					return false, err
+				}
+			}
+		}
+		return c.program.EvalBool(vars)
+	})
+}
+
+// Grants checks command against the policy without evaluating conditions: a permission with
+// a condition allows it, and the decision carries the condition that would have to hold
+func (a *AccessPolicyAgent) Grants(command *data.Command) PolicyDecision {
+	return a.decide(command, nil)
+}
+
+// decide implements Decide, evaluating conditions with evaluate, or skipping them when it is nil
+func (a *AccessPolicyAgent) decide(command *data.Command, evaluate func(*condition) (bool, error)) PolicyDecision {
+	a = a.ForTenant(command.Tenant)
+	roles := a.lineage(command.UserRoles)
+	subject, verb := describeRoles(command.UserRoles)
+
+	defined := false
+	for _, role := range roles {
+		if _, exists := a.policies[role]; exists {
+			defined = true
+		}
+		if entity, action, denied := matchRule(a.deny[role], command.Entity, command.Action); denied {
+			rule := fmt.Sprintf("%s.deny.%s.%s: %s", a.denyAt, role, entity, action)
+			return PolicyDecision{Rule: rule, Reason: "denied by " + rule}
+		}
+	}
+	if !defined {
+		return PolicyDecision{Reason: fmt.Sprintf("%s %s no policy", subject, verb)}
 	}
 
-	entityPermissions, entityExists := rolePermissions[command.Entity]
-	if !entityExists {
-		return false
+	covered := false
+	var unmet *PolicyDecision
+	for _, role := range roles {
+		if entity, action, allowed := matchRule(a.policies[role], command.Entity, command.Action); allowed {
+			decision := PolicyDecision{Allowed: true, Rule: fmt.Sprintf("%s.roles.%s.%s: %s", a.rolesAt, role, entity, action)}
+			c := a.conditionFor(role, command.Entity, command.Action)
+			if c == nil {
+				return decision
+			}
+			decision.Condition = c.source
+			if evaluate == nil {
+				return decision
+			}
+			held, err := evaluate(c)
+			if held {
+				return decision
+			}
+			// Another role may still allow the command; otherwise the first unmet condition says why not
+			if unmet == nil {
+				decision.Allowed = false
+				decision.Reason = fmt.Sprintf("condition of %s not met: %s", decision.Rule, c.source)
+				if err != nil {
+					decision.Reason = fmt.Sprintf("condition of %s failed: %v", decision.Rule, err)
+				}
+				unmet = &decision
+			}
+		}
+		_, exact := a.policies[role][command.Entity]
+		_, wildcard := a.policies[role][config.Wildcard]
+		covered = covered || exact || wildcard
 	}
+	if unmet != nil {
+		return *unmet
+	}
+	if !covered {
+		return PolicyDecision{Reason: fmt.Sprintf("%s %s no permissions on %s", subject, verb, command.Entity)}
+	}
+
+	return PolicyDecision{Reason: fmt.Sprintf("%s may not %s %s", subject, command.Action, command.Entity)}
+}
+
+// lineage returns roles followed by every role they inherit, each once, nearest first
+func (a *AccessPolicyAgent) lineage(roles []string) []string {
+	seen := map[string]bool{}
+	var lineage []string
+	queue := append([]string(nil), roles...)
+	for len(queue) > 0 {
+		role := queue[0]
+		queue = queue[1:]
+		if seen[role] {
+			continue
+		}
+		seen[role] = true
+		lineage = append(lineage, role)
+		queue = append(queue, a.inherits[role]...)
+	}
+	return lineage
+}
 
-	for _, permission := range entityPermissions {
-		if permission == command.Action {
-			return true
+// conditionFor finds the condition, exact or wildcard, on the permission role has for action on entity
+func (a *AccessPolicyAgent) conditionFor(role, entity, action string) *condition {
+	for _, entityKey := range []string{entity, config.Wildcard} {
+		for _, actionKey := range []string{action, config.Wildcard} {
+			if c, exists := a.conditions[role][entityKey][actionKey]; exists {
+				return c
+			}
 		}
 	}
+	return nil
+}
+
+// conditionVars are the variables conditions read, before the stored record is loaded
+func conditionVars(command *data.Command) map[string]interface{} {
+	roles := make([]interface{}, len(command.UserRoles))
+	for i, role := range command.UserRoles {
+		roles[i] = role
+	}
+	user := map[string]interface{}{"id": command.UserID, "roles": roles}
+	for name, value := range command.UserAttributes {
+		user[name] = value
+	}
 
-	return false
-}
\ No newline at end of file
+	values, err := plainValue(command.Data)
+	if err != nil || values == nil {
+		values = map[string]interface{}{}
+	}
+	return map[string]interface{}{"user": user, "data": values}
+}
+
+// plainValue converts records and command data to the JSON values conditions work with
+func plainValue(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read record: %w", err)
+	}
+	var plain interface{}
+	if err := json.Unmarshal(encoded, &plain); err != nil {
+		return nil, fmt.Errorf("failed to read record: %w", err)
+	}
+	return plain, nil
+}
+
+// matchRule finds the entry of rules, exact or wildcard, that covers action on entity
+func matchRule(rules map[string][]string, entity, action string) (string, string, bool) {
+	for _, key := range []string{entity, config.Wildcard} {
+		for _, permission := range rules[key] {
+			if permission == action || permission == config.Wildcard {
+				return key, permission, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+func describeRoles(roles []string) (string, string) {
+	if len(roles) == 1 {
+		return fmt.Sprintf("role %q", roles[0]), "has"
+	}
+	quoted := make([]string, len(roles))
+	for i, role := range roles {
+		quoted[i] = strconv.Quote(role)
+	}
+	return "roles " + strings.Join(quoted, ", "), "have"
+}
+
+// roleSetting returns the setting of the first of roles that has one, and that role
+func roleSetting[T any](settings map[string]T, roles []string) (T, string, bool) {
+	for _, role := range roles {
+		if setting, exists := settings[role]; exists {
+			return setting, role, true
+		}
+	}
+	var none T
+	return none, "", false
+}
+
+// PermissionMatrix lists the effective permissions of every role, generated by evaluating each
+// role, entity and action of the active policy
+type PermissionMatrix struct {
+	Roles    []string `json:"roles"`
+	Entities []string `json:"entities"`
+	Actions  []string `json:"actions"`
+	// Allowed maps role -> entity -> the actions the role may perform on it
+	Allowed map[string]map[string][]string `json:"allowed"`
+	// Conditions maps role -> entity -> action -> the condition under which an allowed action is allowed
+	Conditions map[string]map[string]map[string]string `json:"conditions,omitempty"`
+}
+
+// Matrix builds the permission matrix of the policy of tenant, with inherited roles, deny
+// rules and wildcards applied. Wildcard rules are listed against every known entity and
+// action, and actions allowed under a condition are listed with it.
+func (a *AccessPolicyAgent) Matrix(tenant string) *PermissionMatrix {
+	a = a.ForTenant(tenant)
+	roles, entities := map[string]bool{}, map[string]bool{}
+	for entity := range knownEntities {
+		entities[entity] = true
+	}
+	for _, entity := range adminEntities {
+		entities[entity] = true
+	}
+	for _, rules := range []map[string]map[string][]string{a.policies, a.deny} {
+		for role, rolePermissions := range rules {
+			roles[role] = true
+			for entity := range rolePermissions {
+				if entity != config.Wildcard {
+					entities[entity] = true
+				}
+			}
+		}
+	}
+
+	matrix := &PermissionMatrix{
+		Roles:    sortedKeys(roles),
+		Entities: sortedKeys(entities),
+		Actions:  config.Actions(),
+		Allowed:  make(map[string]map[string][]string, len(roles)),
+	}
+	for _, role := range matrix.Roles {
+		allowed := map[string][]string{}
+		for _, entity := range matrix.Entities {
+			for _, action := range matrix.Actions {
+				decision := a.Grants(&data.Command{Action: action, Entity: entity, Tenant: tenant, UserRoles: []string{role}})
+				if !decision.Allowed {
+					continue
+				}
+				allowed[entity] = append(allowed[entity], action)
+				if decision.Condition != "" {
+					if matrix.Conditions == nil {
+						matrix.Conditions = map[string]map[string]map[string]string{}
+					}
+					if matrix.Conditions[role] == nil {
+						matrix.Conditions[role] = map[string]map[string]string{}
+					}
+					if matrix.Conditions[role][entity] == nil {
+						matrix.Conditions[role][entity] = map[string]string{}
+					}
+					matrix.Conditions[role][entity][action] = decision.Condition
+				}
+			}
+		}
+		matrix.Allowed[role] = allowed
+	}
+	return matrix
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// LimitPageSize applies the page sizes of the tenant policy to a list read or search, from the
+// user's first role that has them or else the nearest role they inherit that does: a missing
+// limit becomes the default and larger limits are capped at the maximum. Invalid limits are
+// left for the data agent to reject.
+func (a *AccessPolicyAgent) LimitPageSize(command *data.Command) {
+	if (command.Action != "read" && command.Action != "search") || command.Data == nil || command.Data["id"] != nil {
+		return
+	}
+
+	a = a.ForTenant(command.Tenant)
+	size, _, exists := roleSetting(a.pageSizes, a.lineage(command.UserRoles))
+	if !exists {
+		size = fallbackPageSize
+	}
+
+	value, exists := command.Data[data.LimitKey]
+	if !exists {
+		command.Data[data.LimitKey] = size.Default
+		return
+	}
+	if limit, err := data.ParsePageLimit(value); err == nil && limit > size.Max {
+		command.Data[data.LimitKey] = size.Max
+	}
+}
+
+// RateLimit returns the request limits of a user of tenant with roles from the tenant policy,
+// found along role inheritance as page sizes are, and the role they are configured for
+func (a *AccessPolicyAgent) RateLimit(tenant string, roles []string) (config.RateLimitConfig, string, bool) {
+	a = a.ForTenant(tenant)
+	return roleSetting(a.limits, a.lineage(roles))
+}
+
+// LimitBulk sets the most records a bulk update or delete may change, from the bulk limits of
+// the tenant policy, found for the user's roles as page sizes are
+func (a *AccessPolicyAgent) LimitBulk(command *data.Command) {
+	if command.Bulk == nil {
+		return
+	}
+
+	a = a.ForTenant(command.Tenant)
+	limit, _, exists := roleSetting(a.bulkLimits, a.lineage(command.UserRoles))
+	if !exists {
+		limit = fallbackBulkLimit
+	}
+	command.Bulk.MaxAffected = limit
+}
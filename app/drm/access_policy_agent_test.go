@@ -5,6 +5,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+	"drm-app/app/config"
 	"drm-app/app/data"
 )
 
@@ -21,7 +22,7 @@ func (s *AccessPolicyAgentTestSuite) TestAdminCanCreateUser() {
 	command := &data.Command{
 		Action:   "create",
 		Entity:   "user",
-		UserRole: "admin",
+		UserRoles: []string{"admin"},
 	}
 	
 	hasAccess := s.agent.CheckAccess(command)
@@ -32,7 +33,7 @@ func (s *AccessPolicyAgentTestSuite) TestAdminCanReadUser() {
 	command := &data.Command{
 		Action:   "read",
 		Entity:   "user",
-		UserRole: "admin",
+		UserRoles: []string{"admin"},
 	}
 	
 	hasAccess := s.agent.CheckAccess(command)
@@ -43,7 +44,7 @@ func (s *AccessPolicyAgentTestSuite) TestAdminCanUpdateUser() {
 	command := &data.Command{
 		Action:   "update",
 		Entity:   "user",
-		UserRole: "admin",
+		UserRoles: []string{"admin"},
 	}
 	
 	hasAccess := s.agent.CheckAccess(command)
@@ -54,7 +55,7 @@ func (s *AccessPolicyAgentTestSuite) TestAdminCanDeleteUser() {
 	command := &data.Command{
 		Action:   "delete",
 		Entity:   "user",
-		UserRole: "admin",
+		UserRoles: []string{"admin"},
 	}
 	
 	hasAccess := s.agent.CheckAccess(command)
@@ -65,7 +66,7 @@ func (s *AccessPolicyAgentTestSuite) TestUserCanReadUser() {
 	command := &data.Command{
 		Action:   "read",
 		Entity:   "user",
-		UserRole: "user",
+		UserRoles: []string{"user"},
 	}
 	
 	hasAccess := s.agent.CheckAccess(command)
@@ -76,7 +77,7 @@ func (s *AccessPolicyAgentTestSuite) TestUserCanUpdateUser() {
 	command := &data.Command{
 		Action:   "update",
 		Entity:   "user",
-		UserRole: "user",
+		UserRoles: []string{"user"},
 	}
 	
 	hasAccess := s.agent.CheckAccess(command)
@@ -87,7 +88,7 @@ func (s *AccessPolicyAgentTestSuite) TestUserCannotCreateUser() {
 	command := &data.Command{
 		Action:   "create",
 		Entity:   "user",
-		UserRole: "user",
+		UserRoles: []string{"user"},
 	}
 	
 	hasAccess := s.agent.CheckAccess(command)
@@ -98,7 +99,7 @@ func (s *AccessPolicyAgentTestSuite) TestUserCannotDeleteUser() {
 	command := &data.Command{
 		Action:   "delete",
 		Entity:   "user",
-		UserRole: "user",
+		UserRoles: []string{"user"},
 	}
 	
 	hasAccess := s.agent.CheckAccess(command)
@@ -109,7 +110,7 @@ func (s *AccessPolicyAgentTestSuite) TestUserCanReadProduct() {
 	command := &data.Command{
 		Action:   "read",
 		Entity:   "product",
-		UserRole: "user",
+		UserRoles: []string{"user"},
 	}
 	
 	hasAccess := s.agent.CheckAccess(command)
@@ -120,7 +121,7 @@ func (s *AccessPolicyAgentTestSuite) TestUserCannotCreateProduct() {
 	command := &data.Command{
 		Action:   "create",
 		Entity:   "product",
-		UserRole: "user",
+		UserRoles: []string{"user"},
 	}
 	
 	hasAccess := s.agent.CheckAccess(command)
@@ -131,7 +132,7 @@ func (s *AccessPolicyAgentTestSuite) TestUserCanCreateOrder() {
 	command := &data.Command{
 		Action:   "create",
 		Entity:   "order",
-		UserRole: "user",
+		UserRoles: []string{"user"},
 	}
 	
 	hasAccess := s.agent.CheckAccess(command)
@@ -142,7 +143,7 @@ func (s *AccessPolicyAgentTestSuite) TestUserCanReadOrder() {
 	command := &data.Command{
 		Action:   "read",
 		Entity:   "order",
-		UserRole: "user",
+		UserRoles: []string{"user"},
 	}
 	
 	hasAccess := s.agent.CheckAccess(command)
@@ -153,7 +154,7 @@ func (s *AccessPolicyAgentTestSuite) TestUserCannotUpdateOrder() {
 	command := &data.Command{
 		Action:   "update",
 		Entity:   "order",
-		UserRole: "user",
+		UserRoles: []string{"user"},
 	}
 	
 	hasAccess := s.agent.CheckAccess(command)
@@ -164,7 +165,7 @@ func (s *AccessPolicyAgentTestSuite) TestGuestCanReadProduct() {
 	command := &data.Command{
 		Action:   "read",
 		Entity:   "product",
-		UserRole: "guest",
+		UserRoles: []string{"guest"},
 	}
 	
 	hasAccess := s.agent.CheckAccess(command)
@@ -175,7 +176,7 @@ func (s *AccessPolicyAgentTestSuite) TestGuestCannotReadUser() {
 	command := &data.Command{
 		Action:   "read",
 		Entity:   "user",
-		UserRole: "guest",
+		UserRoles: []string{"guest"},
 	}
 	
 	hasAccess := s.agent.CheckAccess(command)
@@ -186,7 +187,7 @@ func (s *AccessPolicyAgentTestSuite) TestGuestCannotCreateProduct() {
 	command := &data.Command{
 		Action:   "create",
 		Entity:   "product",
-		UserRole: "guest",
+		UserRoles: []string{"guest"},
 	}
 	
 	hasAccess := s.agent.CheckAccess(command)
@@ -197,7 +198,7 @@ func (s *AccessPolicyAgentTestSuite) TestGuestCannotAccessOrder() {
 	command := &data.Command{
 		Action:   "read",
 		Entity:   "order",
-		UserRole: "guest",
+		UserRoles: []string{"guest"},
 	}
 	
 	hasAccess := s.agent.CheckAccess(command)
@@ -208,7 +209,7 @@ func (s *AccessPolicyAgentTestSuite) TestUnknownRole() {
 	command := &data.Command{
 		Action:   "read",
 		Entity:   "user",
-		UserRole: "unknown",
+		UserRoles: []string{"unknown"},
 	}
 	
 	hasAccess := s.agent.CheckAccess(command)
@@ -219,7 +220,7 @@ func (s *AccessPolicyAgentTestSuite) TestUnknownEntity() {
 	command := &data.Command{
 		Action:   "read",
 		Entity:   "unknown",
-		UserRole: "admin",
+		UserRoles: []string{"admin"},
 	}
 	
 	hasAccess := s.agent.CheckAccess(command)
@@ -230,13 +231,287 @@ func (s *AccessPolicyAgentTestSuite) TestUnknownAction() {
 	command := &data.Command{
 		Action:   "unknown",
 		Entity:   "user",
-		UserRole: "admin",
+		UserRoles: []string{"admin"},
 	}
 	
 	hasAccess := s.agent.CheckAccess(command)
 	assert.False(s.T(), hasAccess)
 }
 
+func (s *AccessPolicyAgentTestSuite) TestDecide() {
+	decision := s.agent.Decide(&data.Command{Action: "read", Entity: "product", UserRoles: []string{"guest"}})
+	assert.Equal(s.T(), PolicyDecision{Allowed: true, Rule: "policy.roles.guest.product: read"}, decision)
+
+	decision = s.agent.Decide(&data.Command{Action: "delete", Entity: "product", UserRoles: []string{"guest"}})
+	assert.False(s.T(), decision.Allowed)
+	assert.Equal(s.T(), `role "guest" may not delete product`, decision.Reason)
+
+	decision = s.agent.Decide(&data.Command{Action: "read", Entity: "order", UserRoles: []string{"guest"}})
+	assert.Equal(s.T(), `role "guest" has no permissions on order`, decision.Reason)
+
+	decision = s.agent.Decide(&data.Command{Action: "read", Entity: "product", UserRoles: []string{"auditor"}})
+	assert.Equal(s.T(), `role "auditor" has no policy`, decision.Reason)
+	assert.Empty(s.T(), decision.Rule)
+}
+
+func (s *AccessPolicyAgentTestSuite) TestMatrix() {
+	agent := NewAccessPolicyAgentFromConfig(config.PolicyConfig{
+		Roles: map[string]map[string][]string{
+			"editor": {"product": {"update"}},
+			"viewer": {"product": {"read"}, "order": {"read"}},
+		},
+		Inherits: map[string][]string{"editor": {"viewer"}},
+		Deny:     map[string]map[string][]string{"editor": {"order": {"read"}}},
+	})
+
+	matrix := agent.Matrix(config.DefaultTenant)
+	assert.Equal(s.T(), []string{"editor", "viewer"}, matrix.Roles)
+	assert.Equal(s.T(), []string{"order", "policy", "product", "system", "user", "webhook"}, matrix.Entities)
+	assert.Equal(s.T(), config.Actions(), matrix.Actions)
+	assert.Equal(s.T(), map[string][]string{"product": {"read", "update"}}, matrix.Allowed["editor"])
+	assert.Equal(s.T(), map[string][]string{"order": {"read"}, "product": {"read"}}, matrix.Allowed["viewer"])
+}
+
+func (s *AccessPolicyAgentTestSuite) TestInheritedRoles() {
+	decision := s.agent.Decide(&data.Command{Action: "search", Entity: "product", UserRoles: []string{"admin"}})
+	assert.Equal(s.T(), PolicyDecision{Allowed: true, Rule: "policy.roles.guest.product: search"}, decision)
+
+	decision = s.agent.Decide(&data.Command{Action: "create", Entity: "order", UserRoles: []string{"admin"}})
+	assert.Equal(s.T(), "policy.roles.user.order: create", decision.Rule)
+
+	assert.False(s.T(), s.agent.CheckAccess(&data.Command{Action: "create", Entity: "order", UserRoles: []string{"guest"}}))
+}
+
+func (s *AccessPolicyAgentTestSuite) TestDenyOverridesInheritedAllow() {
+	agent := NewAccessPolicyAgentFromConfig(config.PolicyConfig{
+		Roles: map[string]map[string][]string{
+			"user":    {"order": {"create", "read"}, "product": {"read"}},
+			"support": {"user": {"read"}},
+		},
+		Inherits: map[string][]string{"support": {"user"}},
+		Deny:     map[string]map[string][]string{"support": {"order": {"create"}}},
+	})
+
+	decision := agent.Decide(&data.Command{Action: "create", Entity: "order", UserRoles: []string{"support"}})
+	assert.False(s.T(), decision.Allowed)
+	assert.Equal(s.T(), "policy.deny.support.order: create", decision.Rule)
+	assert.Equal(s.T(), "denied by policy.deny.support.order: create", decision.Reason)
+
+	assert.True(s.T(), agent.CheckAccess(&data.Command{Action: "read", Entity: "order", UserRoles: []string{"support"}}))
+	assert.True(s.T(), agent.CheckAccess(&data.Command{Action: "create", Entity: "order", UserRoles: []string{"user"}}))
+	// A deny on any role of a user wins over the allows of its other roles
+	assert.False(s.T(), agent.CheckAccess(&data.Command{Action: "create", Entity: "order", UserRoles: []string{"user", "support"}}))
+}
+
+func (s *AccessPolicyAgentTestSuite) TestWildcards() {
+	agent := NewAccessPolicyAgentFromConfig(config.PolicyConfig{
+		Roles: map[string]map[string][]string{
+			"auditor": {config.Wildcard: {"read", "aggregate"}},
+			"owner":   {config.Wildcard: {config.Wildcard}},
+		},
+		Deny: map[string]map[string][]string{"owner": {"policy": {config.Wildcard}}},
+	})
+
+	decision := agent.Decide(&data.Command{Action: "aggregate", Entity: "order", UserRoles: []string{"auditor"}})
+	assert.Equal(s.T(), PolicyDecision{Allowed: true, Rule: "policy.roles.auditor.*: aggregate"}, decision)
+	assert.False(s.T(), agent.CheckAccess(&data.Command{Action: "delete", Entity: "order", UserRoles: []string{"auditor"}}))
+
+	assert.True(s.T(), agent.CheckAccess(&data.Command{Action: "bulk_delete", Entity: "user", UserRoles: []string{"owner"}}))
+	assert.Equal(s.T(), "policy.deny.owner.policy: *",
+		agent.Decide(&data.Command{Action: "read", Entity: "policy", UserRoles: []string{"owner"}}).Rule)
+}
+
+func (s *AccessPolicyAgentTestSuite) TestMultipleRoles() {
+	command := &data.Command{Action: "create", Entity: "order", UserRoles: []string{"guest", "user"}}
+	assert.Equal(s.T(), "policy.roles.user.order: create", s.agent.Decide(command).Rule)
+
+	decision := s.agent.Decide(&data.Command{Action: "delete", Entity: "order", UserRoles: []string{"guest", "user"}})
+	assert.Equal(s.T(), `roles "guest", "user" may not delete order`, decision.Reason)
+
+	// Page sizes come from the first role that has them
+	command = &data.Command{Action: "read", Entity: "product", UserRoles: []string{"support", "guest", "admin"}, Data: map[string]interface{}{}}
+	s.agent.LimitPageSize(command)
+	assert.Equal(s.T(), 20, command.Data[data.LimitKey])
+}
+
+// conditionalAgent lets users update pending orders, managers read users of their own
+// department, and managers but not admins only update products up to $10k
+func conditionalAgent() *AccessPolicyAgent {
+	return NewAccessPolicyAgentFromConfig(config.PolicyConfig{
+		Roles: map[string]map[string][]string{
+			"admin":   {"product": {"update"}},
+			"manager": {"user": {"read"}, "product": {"update"}},
+			"user":    {"order": {"update"}},
+		},
+		Inherits: map[string][]string{"admin": {"manager"}},
+		Conditions: map[string]map[string]map[string]string{
+			"manager": {
+				"user":    {"read": `record.department == user.department`},
+				"product": {"update": `record.price <= 10000`},
+			},
+			"user": {"order": {config.Wildcard: `record.status == "pending" && !has(data.total_amount)`}},
+		},
+	})
+}
+
+func storedRecord(record map[string]interface{}, loads *int) RecordLoader {
+	return func() (interface{}, error) {
+		*loads++
+		return record, nil
+	}
+}
+
+func (s *AccessPolicyAgentTestSuite) TestConditionOnStoredRecord() {
+	agent := conditionalAgent()
+	loads := 0
+	command := &data.Command{Action: "update", Entity: "order", UserRoles: []string{"user"}, Data: map[string]interface{}{"id": "7", "status": "cancelled"}}
+
+	decision := agent.DecideWithRecord(command, storedRecord(map[string]interface{}{"id": 7, "status": "pending"}, &loads))
+	assert.Equal(s.T(), PolicyDecision{
+		Allowed: true, Rule: "policy.roles.user.order: update", Condition: `record.status == "pending" && !has(data.total_amount)`,
+	}, decision)
+
+	decision = agent.DecideWithRecord(command, storedRecord(map[string]interface{}{"id": 7, "status": "shipped"}, &loads))
+	assert.False(s.T(), decision.Allowed)
+	assert.Equal(s.T(), `condition of policy.roles.user.order: update not met: record.status == "pending" && !has(data.total_amount)`, decision.Reason)
+	assert.Equal(s.T(), 2, loads)
+
+	// Conditions read the command data too
+	command.Data["total_amount"] = 5
+	assert.False(s.T(), agent.DecideWithRecord(command, storedRecord(map[string]interface{}{"status": "pending"}, &loads)).Allowed)
+}
+
+func (s *AccessPolicyAgentTestSuite) TestConditionOnUserAttributes() {
+	agent := conditionalAgent()
+	loads := 0
+	command := &data.Command{Action: "read", Entity: "user", UserRoles: []string{"manager"}, UserAttributes: map[string]string{"department": "sales"}}
+
+	assert.True(s.T(), agent.DecideWithRecord(command, storedRecord(map[string]interface{}{"department": "sales"}, &loads)).Allowed)
+	assert.False(s.T(), agent.DecideWithRecord(command, storedRecord(map[string]interface{}{"department": "finance"}, &loads)).Allowed)
+
+	// Without a stored record, as for list reads, the condition cannot be met
+	decision := agent.DecideWithRecord(command, func() (interface{}, error) { return nil, nil })
+	assert.Equal(s.T(), `condition of policy.roles.manager.user: read failed: evaluation error: cannot read field "department" of null`, decision.Reason)
+	assert.False(s.T(), agent.Decide(command).Allowed)
+}
+
+func (s *AccessPolicyAgentTestSuite) TestUnconditionalRoleOverridesCondition() {
+	agent := conditionalAgent()
+	loads := 0
+	expensive := storedRecord(map[string]interface{}{"price": 12000}, &loads)
+
+	decision := agent.DecideWithRecord(&data.Command{Action: "update", Entity: "product", UserRoles: []string{"manager"}}, expensive)
+	assert.Equal(s.T(), "condition of policy.roles.manager.product: update not met: record.price <= 10000", decision.Reason)
+
+	decision = agent.DecideWithRecord(&data.Command{Action: "update", Entity: "product", UserRoles: []string{"admin"}}, expensive)
+	assert.Equal(s.T(), PolicyDecision{Allowed: true, Rule: "policy.roles.admin.product: update"}, decision)
+	assert.Equal(s.T(), 1, loads)
+
+	decision = agent.DecideWithRecord(&data.Command{Action: "update", Entity: "product", UserRoles: []string{"manager"}},
+		func() (interface{}, error) { return nil, data.ErrNotFound })
+	assert.Equal(s.T(), "condition of policy.roles.manager.product: update failed: failed to load record: not found", decision.Reason)
+}
+
+func (s *AccessPolicyAgentTestSuite) TestGrantsLeavesConditionsUnevaluated() {
+	agent := conditionalAgent()
+
+	decision := agent.Grants(&data.Command{Action: "read", Entity: "user", UserRoles: []string{"manager"}})
+	assert.True(s.T(), decision.Allowed)
+	assert.Equal(s.T(), "record.department == user.department", decision.Condition)
+
+	matrix := agent.Matrix(config.DefaultTenant)
+	assert.Equal(s.T(), []string{"read"}, matrix.Allowed["manager"]["user"])
+	assert.Equal(s.T(), map[string]string{"update": "record.price <= 10000"}, matrix.Conditions["manager"]["product"])
+	assert.NotContains(s.T(), matrix.Conditions["admin"], "product")
+	assert.Len(s.T(), matrix.Conditions["user"]["order"], 1)
+}
+
+func (s *AccessPolicyAgentTestSuite) TestTenantPolicies() {
+	cfg := config.Default().Policy
+	cfg.Tenants = map[string]config.TenantPolicyConfig{
+		"acme":   {Roles: map[string]map[string][]string{"user": {"product": {"read", "create"}}}},
+		"globex": {Deny: map[string]map[string][]string{"user": {"order": {"create"}}}},
+	}
+	agent := NewAccessPolicyAgentFromConfig(cfg)
+	createProduct := func(tenant string) PolicyDecision {
+		return agent.Decide(&data.Command{Action: "create", Entity: "product", Tenant: tenant, UserRoles: []string{"user"}})
+	}
+
+	assert.False(s.T(), createProduct(config.DefaultTenant).Allowed)
+	assert.Equal(s.T(), PolicyDecision{Allowed: true, Rule: "policy.tenants.acme.roles.user.product: create"}, createProduct("acme"))
+	assert.False(s.T(), agent.Decide(&data.Command{Action: "read", Entity: "order", Tenant: "acme", UserRoles: []string{"user"}}).Allowed)
+
+	decision := agent.Decide(&data.Command{Action: "create", Entity: "order", Tenant: "globex", UserRoles: []string{"user"}})
+	assert.Equal(s.T(), "denied by policy.tenants.globex.deny.user.order: create", decision.Reason)
+	assert.True(s.T(), agent.Decide(&data.Command{Action: "create", Entity: "order", Tenant: "initech", UserRoles: []string{"user"}}).Allowed)
+
+	assert.Equal(s.T(), []string{"create", "read"}, agent.Matrix("acme").Allowed["user"]["product"])
+	assert.NotContains(s.T(), agent.Matrix("acme").Allowed["user"], "order")
+}
+
+func (s *AccessPolicyAgentTestSuite) TestLimitPageSize() {
+	command := &data.Command{Action: "read", Entity: "product", UserRoles: []string{"guest"}, Data: map[string]interface{}{}}
+	s.agent.LimitPageSize(command)
+	assert.Equal(s.T(), 20, command.Data["limit"])
+
+	command.Data["limit"] = float64(500)
+	s.agent.LimitPageSize(command)
+	assert.Equal(s.T(), 50, command.Data["limit"])
+
+	command.Data["limit"] = "10"
+	s.agent.LimitPageSize(command)
+	assert.Equal(s.T(), "10", command.Data["limit"])
+
+	command = &data.Command{Action: "read", Entity: "product", UserRoles: []string{"guest"}, Data: map[string]interface{}{"id": "1"}}
+	s.agent.LimitPageSize(command)
+	assert.NotContains(s.T(), command.Data, "limit")
+}
+
+func (s *AccessPolicyAgentTestSuite) TestLimitBulk() {
+	command := &data.Command{Action: "bulk_update", Entity: "order", UserRoles: []string{"admin"}, Bulk: &data.Bulk{}}
+	s.agent.LimitBulk(command)
+	assert.Equal(s.T(), 1000, command.Bulk.MaxAffected)
+
+	command = &data.Command{Action: "bulk_delete", Entity: "order", UserRoles: []string{"user"}, Bulk: &data.Bulk{}}
+	s.agent.LimitBulk(command)
+	assert.Equal(s.T(), 100, command.Bulk.MaxAffected)
+}
+
+func (s *AccessPolicyAgentTestSuite) TestTenantLimitsFollowInheritance() {
+	cfg := config.Default().Policy
+	cfg.Tenants = map[string]config.TenantPolicyConfig{
+		"acme": {
+			Roles: map[string]map[string][]string{
+				"lead":  {"order": {"read", "bulk_update"}},
+				"clerk": {"order": {"read"}},
+			},
+			Inherits:   map[string][]string{"lead": {"clerk"}},
+			PageSizes:  map[string]config.PageSizeConfig{"clerk": {Default: 5, Max: 10}},
+			BulkLimits: map[string]int{"clerk": 25},
+		},
+	}
+	agent := NewAccessPolicyAgentFromConfig(cfg)
+
+	command := &data.Command{Action: "read", Entity: "order", Tenant: "acme", UserRoles: []string{"lead"}, Data: map[string]interface{}{}}
+	agent.LimitPageSize(command)
+	assert.Equal(s.T(), 5, command.Data[data.LimitKey])
+	command.Data[data.LimitKey] = float64(500)
+	agent.LimitPageSize(command)
+	assert.Equal(s.T(), 10, command.Data[data.LimitKey])
+
+	command = &data.Command{Action: "bulk_update", Entity: "order", Tenant: "acme", UserRoles: []string{"lead"}, Bulk: &data.Bulk{}}
+	agent.LimitBulk(command)
+	assert.Equal(s.T(), 25, command.Bulk.MaxAffected)
+
+	// Other tenants keep the deployment-wide sizes and limits
+	command = &data.Command{Action: "read", Entity: "order", Tenant: config.DefaultTenant, UserRoles: []string{"user"}, Data: map[string]interface{}{}}
+	agent.LimitPageSize(command)
+	assert.Equal(s.T(), 50, command.Data[data.LimitKey])
+	command = &data.Command{Action: "bulk_delete", Entity: "order", Tenant: "globex", UserRoles: []string{"admin"}, Bulk: &data.Bulk{}}
+	agent.LimitBulk(command)
+	assert.Equal(s.T(), 1000, command.Bulk.MaxAffected)
+}
+
 func TestAccessPolicyAgentTestSuite(t *testing.T) {
 	suite.Run(t, new(AccessPolicyAgentTestSuite))
 }
\ No newline at end of file
@@ -0,0 +1,312 @@
+package drm
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"strings"
+	"time"
+
+	"drm-app/app/config"
+	"drm-app/app/data"
+)
+
+// Session tokens carry a prefix so they are told apart from configured tokens without a lookup
+const (
+	accessTokenPrefix  = "drm_at_"
+	refreshTokenPrefix = "drm_rt_"
+)
+
+var (
+	ErrRegistrationClosed = errors.New("registration is closed")
+	ErrInvalidAccount     = errors.New("invalid account")
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrAccountLocked      = errors.New("account is locked")
+	ErrInvalidSession     = errors.New("invalid or expired session")
+)
+
+// AccountLockedError reports a sign-in refused after too many wrong passwords and when the
+// account unlocks
+type AccountLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *AccountLockedError) Error() string {
+	return fmt.Sprintf("%s after too many failed sign-ins", ErrAccountLocked)
+}
+
+func (e *AccountLockedError) Is(target error) bool {
+	return target == ErrAccountLocked
+}
+
+// SessionTokens are the tokens of a session. The access token authenticates requests like a
+// configured token until it expires; the refresh token exchanges the pair for a new one.
+type SessionTokens struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int    `json:"refresh_expires_in"`
+}
+
+// AccountAgent registers users that sign in with email and password and manages their
+// sessions. Sessions are kept server-side, so signing out revokes both tokens at once.
+type AccountAgent struct {
+	store data.CredentialStore
+	cfg   config.AccountConfig
+	now   func() time.Time
+
+	// unknownHash is checked for emails without an account, so they take as long to refuse as
+	// wrong passwords
+	unknownHash string
+}
+
+func NewAccountAgent(store data.CredentialStore, cfg config.AccountConfig) *AccountAgent {
+	unknownHash, err := HashPassword(data.NewEventID())
+	if err != nil {
+		slog.Warn("failed to hash the password of unknown accounts", "error", err)
+	}
+	return &AccountAgent{
+		store:       store,
+		cfg:         cfg,
+		now:         time.Now,
+		unknownHash: unknownHash,
+	}
+}
+
+// IsSessionToken reports whether token looks like a session's access token
+func IsSessionToken(token string) bool {
+	return strings.HasPrefix(token, accessTokenPrefix)
+}
+
+// Register creates a user with a password in the tenant and role registrations are configured with
+func (a *AccountAgent) Register(ctx context.Context, name, email, password string) (*User, error) {
+	if !a.cfg.Registration {
+		return nil, ErrRegistrationClosed
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidAccount)
+	}
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != strings.TrimSpace(email) {
+		return nil, fmt.Errorf("%w: email %q is not a valid address", ErrInvalidAccount, email)
+	}
+	if len([]rune(password)) < a.cfg.MinPasswordLength {
+		return nil, fmt.Errorf("%w: password must be at least %d characters", ErrInvalidAccount, a.cfg.MinPasswordLength)
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	credential := &data.Credential{
+		Tenant:       a.cfg.Tenant,
+		Name:         name,
+		Email:        strings.ToLower(address.Address),
+		Role:         a.cfg.Role,
+		PasswordHash: hash,
+	}
+	if err := a.store.Register(ctx, credential); err != nil {
+		return nil, err
+	}
+
+	return credentialUser(credential.UserID, credential.Name, credential.Tenant, credential.Role), nil
+}
+
+// Login signs the user of tenant with email in and opens a session. Tenant defaults to the one
+// registrations join.
+func (a *AccountAgent) Login(ctx context.Context, tenant, email, password string) (*SessionTokens, error) {
+	if tenant == "" {
+		tenant = a.cfg.Tenant
+	}
+
+	credential, err := a.store.Credential(ctx, tenant, strings.TrimSpace(email))
+	if errors.Is(err, data.ErrNotFound) {
+		VerifyPassword(a.unknownHash, password)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := a.now()
+	if credential.LockedUntil != nil && now.Before(*credential.LockedUntil) {
+		return nil, &AccountLockedError{RetryAfter: credential.LockedUntil.Sub(now)}
+	}
+
+	matches, err := VerifyPassword(credential.PasswordHash, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify password of user %s: %w", credential.UserID, err)
+	}
+	if !matches {
+		locked, err := a.store.RecordFailedLogin(ctx, tenant, credential.UserID, a.cfg.MaxFailedLogins, now.Add(a.cfg.LockoutDuration))
+		if err != nil {
+			return nil, err
+		}
+		if locked {
+			slog.WarnContext(ctx, "account locked after failed sign-ins", "tenant", tenant, "user_id", credential.UserID)
+			return nil, &AccountLockedError{RetryAfter: a.cfg.LockoutDuration}
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	if credential.FailedLogins > 0 {
+		if err := a.store.ResetFailedLogins(ctx, tenant, credential.UserID); err != nil {
+			return nil, err
+		}
+	}
+
+	return a.OpenSession(ctx, credentialUser(credential.UserID, credential.Name, tenant, credential.Role))
+}
+
+// OpenSession signs user in with the roles it holds now, which the session keeps until it ends
+func (a *AccountAgent) OpenSession(ctx context.Context, user *User) (*SessionTokens, error) {
+	now := a.now()
+	session := &data.Session{
+		ID:        data.NewEventID(),
+		Tenant:    user.Tenant,
+		UserID:    user.ID,
+		Name:      user.Name,
+		Roles:     user.Roles,
+		CreatedAt: now,
+	}
+	tokens, err := a.issue(session, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.store.CreateSession(ctx, session); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Refresh exchanges a refresh token for a new pair of tokens. Each refresh token works once.
+func (a *AccountAgent) Refresh(ctx context.Context, refreshToken string) (*SessionTokens, error) {
+	if !strings.HasPrefix(refreshToken, refreshTokenPrefix) {
+		return nil, ErrInvalidSession
+	}
+	refreshHash := hashToken(refreshToken)
+	session, err := a.store.SessionByRefresh(ctx, refreshHash)
+	if errors.Is(err, data.ErrNotFound) {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := a.now()
+	if !now.Before(session.RefreshExpiresAt) {
+		return nil, ErrInvalidSession
+	}
+
+	tokens, err := a.issue(session, now)
+	if err != nil {
+		return nil, err
+	}
+	err = a.store.RotateSession(ctx, session, refreshHash)
+	if errors.Is(err, data.ErrNotFound) {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Logout revokes the session of an access or refresh token
+func (a *AccountAgent) Logout(ctx context.Context, token string) error {
+	var session *data.Session
+	var err error
+	switch {
+	case strings.HasPrefix(token, accessTokenPrefix):
+		session, err = a.store.SessionByAccess(ctx, hashToken(token))
+	case strings.HasPrefix(token, refreshTokenPrefix):
+		session, err = a.store.SessionByRefresh(ctx, hashToken(token))
+	default:
+		return ErrInvalidSession
+	}
+	if errors.Is(err, data.ErrNotFound) {
+		return ErrInvalidSession
+	}
+	if err != nil {
+		return err
+	}
+
+	return a.store.RevokeSession(ctx, session.ID)
+}
+
+// Authenticate returns the user of the session of an unexpired access token
+func (a *AccountAgent) Authenticate(ctx context.Context, accessToken string) (*User, error) {
+	session, err := a.store.SessionByAccess(ctx, hashToken(accessToken))
+	if errors.Is(err, data.ErrNotFound) {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !a.now().Before(session.AccessExpiresAt) {
+		return nil, ErrInvalidSession
+	}
+
+	return &User{ID: session.UserID, Name: session.Name, Tenant: session.Tenant, Roles: session.Roles, Source: SourceAccount}, nil
+}
+
+// LookupUser finds the user of tenant with the given ID among the users that sign in with a
+// password or through a provider
+func (a *AccountAgent) LookupUser(ctx context.Context, tenant, id string) (*User, error) {
+	account, err := a.store.Account(ctx, tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	return &User{ID: account.UserID, Name: account.Name, Tenant: account.Tenant, Roles: account.Roles, Source: SourceAccount}, nil
+}
+
+// issue gives session a new pair of tokens, of which only the hashes are kept
+func (a *AccountAgent) issue(session *data.Session, now time.Time) (*SessionTokens, error) {
+	accessToken, err := newToken(accessTokenPrefix)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := newToken(refreshTokenPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	session.AccessHash = hashToken(accessToken)
+	session.AccessExpiresAt = now.Add(a.cfg.AccessTTL)
+	session.RefreshHash = hashToken(refreshToken)
+	session.RefreshExpiresAt = now.Add(a.cfg.RefreshTTL)
+
+	return &SessionTokens{
+		AccessToken:      accessToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        int(a.cfg.AccessTTL.Seconds()),
+		RefreshToken:     refreshToken,
+		RefreshExpiresIn: int(a.cfg.RefreshTTL.Seconds()),
+	}, nil
+}
+
+func credentialUser(id, name, tenant, role string) *User {
+	return &User{ID: id, Name: name, Tenant: tenant, Roles: []string{role}, Source: SourceAccount}
+}
+
+func newToken(prefix string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,191 @@
+package drm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"drm-app/app/config"
+	"drm-app/app/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type AccountAgentTestSuite struct {
+	suite.Suite
+	agent *AccountAgent
+	now   time.Time
+	ctx   context.Context
+}
+
+func (s *AccountAgentTestSuite) SetupTest() {
+	s.ctx = context.Background()
+	s.now = time.Date(2025, 3, 14, 12, 0, 0, 0, time.UTC)
+
+	cfg := config.Default().Auth.Accounts
+	cfg.Registration = true
+	cfg.MaxFailedLogins = 3
+	s.agent = NewAccountAgent(data.NewTestCredentialStore(data.NewTestDataAgent()), cfg)
+	s.agent.now = func() time.Time { return s.now }
+}
+
+func (s *AccountAgentTestSuite) register() *User {
+	user, err := s.agent.Register(s.ctx, "Ada", "Ada@Example.com", "correct horse battery")
+	require.NoError(s.T(), err)
+	return user
+}
+
+func (s *AccountAgentTestSuite) TestRegisterCreatesUserRecord() {
+	user := s.register()
+
+	assert.Equal(s.T(), "3", user.ID)
+	assert.Equal(s.T(), config.DefaultTenant, user.Tenant)
+	assert.Equal(s.T(), []string{"user"}, user.Roles)
+
+	_, err := s.agent.Register(s.ctx, "Ada", "ada@example.com", "another password")
+	assert.ErrorIs(s.T(), err, data.ErrEmailTaken)
+	_, err = s.agent.Register(s.ctx, "John", "john@example.com", "another password")
+	assert.ErrorIs(s.T(), err, data.ErrEmailTaken)
+}
+
+func (s *AccountAgentTestSuite) TestRegisterValidates() {
+	_, err := s.agent.Register(s.ctx, "Ada", "ada@example.com", "short")
+	assert.ErrorIs(s.T(), err, ErrInvalidAccount)
+	assert.Contains(s.T(), err.Error(), "at least 10 characters")
+
+	_, err = s.agent.Register(s.ctx, "Ada", "not an email", "correct horse battery")
+	assert.ErrorIs(s.T(), err, ErrInvalidAccount)
+
+	_, err = s.agent.Register(s.ctx, " ", "ada@example.com", "correct horse battery")
+	assert.ErrorIs(s.T(), err, ErrInvalidAccount)
+
+	s.agent.cfg.Registration = false
+	_, err = s.agent.Register(s.ctx, "Ada", "ada@example.com", "correct horse battery")
+	assert.ErrorIs(s.T(), err, ErrRegistrationClosed)
+}
+
+func (s *AccountAgentTestSuite) TestLoginAuthenticatesRequests() {
+	registered := s.register()
+
+	tokens, err := s.agent.Login(s.ctx, "", "ada@example.com", "correct horse battery")
+	require.NoError(s.T(), err)
+	assert.True(s.T(), IsSessionToken(tokens.AccessToken))
+	assert.Equal(s.T(), 900, tokens.ExpiresIn)
+
+	user, err := s.agent.Authenticate(s.ctx, tokens.AccessToken)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), registered, user)
+
+	s.now = s.now.Add(16 * time.Minute)
+	_, err = s.agent.Authenticate(s.ctx, tokens.AccessToken)
+	assert.ErrorIs(s.T(), err, ErrInvalidSession)
+}
+
+func (s *AccountAgentTestSuite) TestWrongPasswordAndUnknownEmail() {
+	s.register()
+
+	_, err := s.agent.Login(s.ctx, "", "ada@example.com", "wrong password")
+	assert.ErrorIs(s.T(), err, ErrInvalidCredentials)
+	_, err = s.agent.Login(s.ctx, "", "nobody@example.com", "correct horse battery")
+	assert.ErrorIs(s.T(), err, ErrInvalidCredentials)
+	_, err = s.agent.Login(s.ctx, "acme", "ada@example.com", "correct horse battery")
+	assert.ErrorIs(s.T(), err, ErrInvalidCredentials)
+}
+
+func (s *AccountAgentTestSuite) TestLocksAfterRepeatedFailures() {
+	s.register()
+
+	for i := 0; i < 2; i++ {
+		_, err := s.agent.Login(s.ctx, "", "ada@example.com", "wrong password")
+		require.ErrorIs(s.T(), err, ErrInvalidCredentials)
+	}
+	_, err := s.agent.Login(s.ctx, "", "ada@example.com", "wrong password")
+	var locked *AccountLockedError
+	require.ErrorAs(s.T(), err, &locked)
+	assert.Equal(s.T(), 15*time.Minute, locked.RetryAfter)
+
+	// The right password does not help while the account is locked
+	s.now = s.now.Add(5 * time.Minute)
+	_, err = s.agent.Login(s.ctx, "", "ada@example.com", "correct horse battery")
+	require.ErrorAs(s.T(), err, &locked)
+	assert.Equal(s.T(), 10*time.Minute, locked.RetryAfter)
+
+	s.now = s.now.Add(10 * time.Minute)
+	_, err = s.agent.Login(s.ctx, "", "ada@example.com", "correct horse battery")
+	assert.NoError(s.T(), err)
+}
+
+func (s *AccountAgentTestSuite) TestSuccessResetsFailureCount() {
+	s.register()
+
+	for i := 0; i < 2; i++ {
+		_, err := s.agent.Login(s.ctx, "", "ada@example.com", "wrong password")
+		require.ErrorIs(s.T(), err, ErrInvalidCredentials)
+	}
+	_, err := s.agent.Login(s.ctx, "", "ada@example.com", "correct horse battery")
+	require.NoError(s.T(), err)
+
+	_, err = s.agent.Login(s.ctx, "", "ada@example.com", "wrong password")
+	assert.ErrorIs(s.T(), err, ErrInvalidCredentials)
+}
+
+func (s *AccountAgentTestSuite) TestRefreshRotatesTokens() {
+	s.register()
+	tokens, err := s.agent.Login(s.ctx, "", "ada@example.com", "correct horse battery")
+	require.NoError(s.T(), err)
+
+	refreshed, err := s.agent.Refresh(s.ctx, tokens.RefreshToken)
+	require.NoError(s.T(), err)
+	assert.NotEqual(s.T(), tokens.AccessToken, refreshed.AccessToken)
+
+	_, err = s.agent.Authenticate(s.ctx, tokens.AccessToken)
+	assert.ErrorIs(s.T(), err, ErrInvalidSession)
+	_, err = s.agent.Authenticate(s.ctx, refreshed.AccessToken)
+	assert.NoError(s.T(), err)
+
+	_, err = s.agent.Refresh(s.ctx, tokens.RefreshToken)
+	assert.ErrorIs(s.T(), err, ErrInvalidSession)
+
+	s.now = s.now.Add(31 * 24 * time.Hour)
+	_, err = s.agent.Refresh(s.ctx, refreshed.RefreshToken)
+	assert.ErrorIs(s.T(), err, ErrInvalidSession)
+}
+
+func (s *AccountAgentTestSuite) TestLogoutRevokesSession() {
+	s.register()
+	tokens, err := s.agent.Login(s.ctx, "", "ada@example.com", "correct horse battery")
+	require.NoError(s.T(), err)
+
+	require.NoError(s.T(), s.agent.Logout(s.ctx, tokens.AccessToken))
+
+	_, err = s.agent.Authenticate(s.ctx, tokens.AccessToken)
+	assert.ErrorIs(s.T(), err, ErrInvalidSession)
+	_, err = s.agent.Refresh(s.ctx, tokens.RefreshToken)
+	assert.ErrorIs(s.T(), err, ErrInvalidSession)
+	assert.ErrorIs(s.T(), s.agent.Logout(s.ctx, tokens.RefreshToken), ErrInvalidSession)
+}
+
+func (s *AccountAgentTestSuite) TestPasswordHashes() {
+	hash, err := HashPassword("correct horse battery")
+	require.NoError(s.T(), err)
+	assert.Contains(s.T(), hash, "$argon2id$v=19$")
+
+	other, err := HashPassword("correct horse battery")
+	require.NoError(s.T(), err)
+	assert.NotEqual(s.T(), hash, other)
+
+	matches, err := VerifyPassword(hash, "correct horse battery")
+	require.NoError(s.T(), err)
+	assert.True(s.T(), matches)
+	matches, err = VerifyPassword(hash, "Correct horse battery")
+	require.NoError(s.T(), err)
+	assert.False(s.T(), matches)
+
+	_, err = VerifyPassword("plaintext", "plaintext")
+	assert.Error(s.T(), err)
+}
+
+func TestAccountAgentTestSuite(t *testing.T) {
+	suite.Run(t, new(AccountAgentTestSuite))
+}
@@ -3,25 +3,109 @@ package drm
 import (
 	"fmt"
 	"strings"
+	"time"
+
+	"drm-app/app/config"
+	"drm-app/app/data"
+)
+
+// Users authenticate through one of these identity sources. IDs are only unique within a
+// tenant and source: accounts take the IDs of their user records, which configured users may
+// also use.
+const (
+	SourceToken   = "token"   // static bearer tokens
+	SourceAccount = "account" // password and OpenID Connect sign-ins
+	SourceService = "service" // signed service requests
 )
 
 type User struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
-	Role string `json:"role"`
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Tenant string   `json:"tenant"`
+	Roles  []string `json:"roles"`
+	// Source is the identity source the user authenticated through
+	Source string `json:"-"`
+	// Attributes describe the user to policy conditions
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Impersonator is the user acting as this one, if any
+	Impersonator *User `json:"impersonator,omitempty"`
+}
+
+// caller returns the user making the request: the impersonator when u is being impersonated
+func (u *User) caller() *User {
+	if u.Impersonator != nil {
+		return u.Impersonator
+	}
+	return u
+}
+
+// key identifies the user across tenants and identity sources; rate limits and idempotency
+// keys are kept under it
+func (u *User) key() string {
+	return u.Tenant + "/" + u.Source + "/" + u.ID
+}
+
+// idempotencyScope keeps the idempotency keys sent while acting as u apart from those of u
+// and of other impersonators
+func (u *User) idempotencyScope() string {
+	if u.Impersonator != nil {
+		return u.key() + " by " + u.Impersonator.key()
+	}
+	return u.key()
+}
+
+func (u *User) impersonatorID() string {
+	if u.Impersonator != nil {
+		return u.Impersonator.ID
+	}
+	return ""
 }
 
 type AuthAgent struct {
-	users map[string]*User
+	users       map[string]*User
+	serviceKeys map[string]*serviceKey
+	clockSkew   time.Duration
+	nonces      data.NonceStore
+	now         func() time.Time
 }
 
 func NewAuthAgent() *AuthAgent {
+	return NewAuthAgentFromConfig(config.Default().Auth)
+}
+
+// NewAuthAgentFromConfig remembers the nonces of signed requests in memory, which only
+// protects a single instance against replays
+func NewAuthAgentFromConfig(cfg config.AuthConfig) *AuthAgent {
+	return NewAuthAgentWithNonceStore(cfg, data.NewMemoryNonceStore(cfg.Signing.NonceCacheSize))
+}
+
+func NewAuthAgentWithNonceStore(cfg config.AuthConfig, nonces data.NonceStore) *AuthAgent {
+	users := make(map[string]*User, len(cfg.Tokens))
+	for _, token := range cfg.Tokens {
+		users[token.Token] = &User{
+			ID: token.UserID, Name: token.Name, Tenant: token.TenantName(), Roles: token.RoleList(), Attributes: token.Attributes,
+			Source: SourceToken,
+		}
+	}
+
+	serviceKeys := make(map[string]*serviceKey, len(cfg.Signing.Keys))
+	for _, key := range cfg.Signing.Keys {
+		identity := key.Identity()
+		serviceKeys[key.KeyID] = &serviceKey{
+			secret: key.Secret,
+			user: &User{
+				ID: identity.UserID, Name: identity.Name, Tenant: identity.TenantName(), Roles: identity.RoleList(), Attributes: identity.Attributes,
+				Source: SourceService,
+			},
+		}
+	}
+
 	return &AuthAgent{
-		users: map[string]*User{
-			"admin-token": {ID: "1", Name: "Admin", Role: "admin"},
-			"user-token":  {ID: "2", Name: "User", Role: "user"},
-			"guest-token": {ID: "3", Name: "Guest", Role: "guest"},
-		},
+		users:       users,
+		serviceKeys: serviceKeys,
+		clockSkew:   cfg.Signing.ClockSkew,
+		nonces:      nonces,
+		now:         time.Now,
 	}
 }
 
@@ -37,4 +121,14 @@ func (a *AuthAgent) ValidateToken(token string) (*User, error) {
 	}
 
 	return user, nil
-}
\ No newline at end of file
+}
+
+// LookupUser finds the user of tenant with the given ID among the configured tokens
+func (a *AuthAgent) LookupUser(tenant, id string) (*User, bool) {
+	for _, user := range a.users {
+		if user.Tenant == tenant && user.ID == id {
+			return user, true
+		}
+	}
+	return nil, false
+}
@@ -23,7 +23,7 @@ func (s *AuthAgentTestSuite) TestValidateValidAdminToken() {
 	assert.NotNil(s.T(), user)
 	assert.Equal(s.T(), "1", user.ID)
 	assert.Equal(s.T(), "Admin", user.Name)
-	assert.Equal(s.T(), "admin", user.Role)
+	assert.Equal(s.T(), []string{"admin"}, user.Roles)
 }
 
 func (s *AuthAgentTestSuite) TestValidateValidUserToken() {
@@ -33,7 +33,7 @@ func (s *AuthAgentTestSuite) TestValidateValidUserToken() {
 	assert.NotNil(s.T(), user)
 	assert.Equal(s.T(), "2", user.ID)
 	assert.Equal(s.T(), "User", user.Name)
-	assert.Equal(s.T(), "user", user.Role)
+	assert.Equal(s.T(), []string{"user"}, user.Roles)
 }
 
 func (s *AuthAgentTestSuite) TestValidateValidGuestToken() {
@@ -43,7 +43,7 @@ func (s *AuthAgentTestSuite) TestValidateValidGuestToken() {
 	assert.NotNil(s.T(), user)
 	assert.Equal(s.T(), "3", user.ID)
 	assert.Equal(s.T(), "Guest", user.Name)
-	assert.Equal(s.T(), "guest", user.Role)
+	assert.Equal(s.T(), []string{"guest"}, user.Roles)
 }
 
 func (s *AuthAgentTestSuite) TestValidateInvalidToken() {
@@ -75,7 +75,7 @@ func (s *AuthAgentTestSuite) TestValidateTokenWithWhitespace() {
 	
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), user)
-	assert.Equal(s.T(), "admin", user.Role)
+	assert.Equal(s.T(), []string{"admin"}, user.Roles)
 }
 
 func (s *AuthAgentTestSuite) TestValidateNonExistentToken() {
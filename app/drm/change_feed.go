@@ -0,0 +1,232 @@
+package drm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"drm-app/app/data"
+)
+
+const changeSubscriptionBuffer = 64
+
+type ChangeFilter struct {
+	Entity  string                 `json:"entity"`
+	Actions []string               `json:"actions"`
+	Fields  map[string]interface{} `json:"fields"`
+}
+
+type ChangeSubscription struct {
+	Events <-chan data.ChangeEvent
+
+	id        int
+	events    chan data.ChangeEvent
+	user      *User
+	filter    ChangeFilter
+	feed      *ChangeFeed
+	closeOnce sync.Once
+}
+
+func (s *ChangeSubscription) Close() {
+	s.feed.unsubscribe(s)
+}
+
+// ChangeFeed routes change events from the notifier to live subscribers of the event's tenant,
+// applying the subscriber's filter and the access policy to every event.
+type ChangeFeed struct {
+	notifier data.ChangeNotifier
+	policy   *AccessPolicyAgent
+
+	mu          sync.RWMutex
+	subscribers map[int]*ChangeSubscription
+	nextID      int
+	closed      bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewChangeFeed(notifier data.ChangeNotifier, policy *AccessPolicyAgent) *ChangeFeed {
+	return &ChangeFeed{
+		notifier:    notifier,
+		policy:      policy,
+		subscribers: make(map[int]*ChangeSubscription),
+	}
+}
+
+func (f *ChangeFeed) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel = cancel
+	f.done = make(chan struct{})
+
+	go func() {
+		defer close(f.done)
+		if err := f.notifier.Listen(ctx, f.broadcast); err != nil {
+			slog.Error("change feed listener stopped", "error", err)
+		}
+	}()
+}
+
+// Close stops listening and ends every open subscription; later subscriptions are refused
+func (f *ChangeFeed) Close() {
+	if f.cancel != nil {
+		f.cancel()
+		<-f.done
+	}
+
+	f.mu.Lock()
+	subscribers := f.subscribers
+	f.subscribers = make(map[int]*ChangeSubscription)
+	f.closed = true
+	f.mu.Unlock()
+
+	for _, subscription := range subscribers {
+		subscription.closeOnce.Do(func() { close(subscription.events) })
+	}
+}
+
+func (f *ChangeFeed) Publish(ctx context.Context, event data.ChangeEvent) error {
+	return f.notifier.Notify(ctx, event)
+}
+
+// Check returns the error Subscribe would refuse filter with for user, without subscribing
+func (f *ChangeFeed) Check(user *User, filter ChangeFilter) error {
+	if filter.Entity != "" && filter.Entity != "*" {
+		if !knownEntities[filter.Entity] {
+			return fmt.Errorf("unknown entity: %s", filter.Entity)
+		}
+		// Read conditions are checked against each event's record as it arrives
+		if !f.policy.Grants(readCommand(user, filter.Entity)).Allowed {
+			return fmt.Errorf("%w for action read on entity %s", ErrAccessDenied, filter.Entity)
+		}
+	}
+
+	for _, action := range filter.Actions {
+		if !changeActions[action] {
+			return fmt.Errorf("unsupported change action: %s", action)
+		}
+	}
+	return nil
+}
+
+func (f *ChangeFeed) Subscribe(user *User, filter ChangeFilter) (*ChangeSubscription, error) {
+	if filter.Entity == "" {
+		filter.Entity = "*"
+	}
+	if err := f.Check(user, filter); err != nil {
+		return nil, err
+	}
+
+	events := make(chan data.ChangeEvent, changeSubscriptionBuffer)
+	subscription := &ChangeSubscription{
+		Events: events,
+		events: events,
+		user:   user,
+		filter: filter,
+		feed:   f,
+	}
+
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil, ErrShuttingDown
+	}
+	f.nextID++
+	subscription.id = f.nextID
+	f.subscribers[subscription.id] = subscription
+	f.mu.Unlock()
+
+	return subscription, nil
+}
+
+// Subscribers returns how many subscriptions are open
+func (f *ChangeFeed) Subscribers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subscribers)
+}
+
+func (f *ChangeFeed) unsubscribe(subscription *ChangeSubscription) {
+	f.mu.Lock()
+	delete(f.subscribers, subscription.id)
+	f.mu.Unlock()
+
+	subscription.closeOnce.Do(func() { close(subscription.events) })
+}
+
+// broadcast hands the event to every subscriber that accepts it. Slow subscribers whose
+// buffer is full miss the event rather than stalling the listener.
+func (f *ChangeFeed) broadcast(event data.ChangeEvent) {
+	fields := eventFields(event)
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, subscription := range f.subscribers {
+		if !f.accepts(subscription, event, fields) {
+			continue
+		}
+
+		select {
+		case subscription.events <- event:
+		default:
+			slog.Warn("dropping event for slow change subscriber", "event_type", event.Type, "event_id", event.ID, "subscriber", subscription.id, "user_id", subscription.user.ID)
+		}
+	}
+}
+
+func (f *ChangeFeed) accepts(subscription *ChangeSubscription, event data.ChangeEvent, fields map[string]interface{}) bool {
+	filter := subscription.filter
+
+	if event.Tenant != subscription.user.Tenant {
+		return false
+	}
+
+	if filter.Entity != "*" && filter.Entity != event.Entity {
+		return false
+	}
+
+	if len(filter.Actions) > 0 {
+		matched := false
+		for _, action := range filter.Actions {
+			if action == event.Action {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	for key, expected := range filter.Fields {
+		actual, ok := fields[key]
+		if !ok || fmt.Sprint(actual) != fmt.Sprint(expected) {
+			return false
+		}
+	}
+
+	record := func() (interface{}, error) { return fields, nil }
+	return f.policy.DecideWithRecord(readCommand(subscription.user, event.Entity), record).Allowed
+}
+
+func readCommand(user *User, entity string) *data.Command {
+	return &data.Command{
+		Action:         "read",
+		Entity:         entity,
+		UserID:         user.ID,
+		Tenant:         user.Tenant,
+		UserRoles:      user.Roles,
+		UserAttributes: user.Attributes,
+	}
+}
+
+func eventFields(event data.ChangeEvent) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if raw, err := json.Marshal(event.Data); err == nil {
+		json.Unmarshal(raw, &fields)
+	}
+	return fields
+}
@@ -0,0 +1,160 @@
+package drm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"drm-app/app/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type ChangeFeedTestSuite struct {
+	suite.Suite
+	feed *ChangeFeed
+	ctx  context.Context
+}
+
+func (s *ChangeFeedTestSuite) SetupTest() {
+	s.ctx = context.Background()
+	s.feed = NewChangeFeed(data.NewLocalChangeNotifier(), NewAccessPolicyAgent())
+	s.feed.Start()
+
+	// Listen registers asynchronously; wait until published events reach the feed
+	probe, err := s.feed.Subscribe(&User{ID: "1", Roles: []string{"admin"}}, ChangeFilter{})
+	require.NoError(s.T(), err)
+	defer probe.Close()
+	require.Eventually(s.T(), func() bool {
+		s.publish("product", "create", nil)
+		select {
+		case <-probe.Events:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, 5*time.Millisecond)
+}
+
+func (s *ChangeFeedTestSuite) TearDownTest() {
+	s.feed.Close()
+}
+
+func (s *ChangeFeedTestSuite) publish(entity, action string, record map[string]interface{}) {
+	command := &data.Command{Action: action, Entity: entity, Data: map[string]interface{}{}}
+	require.NoError(s.T(), s.feed.Publish(s.ctx, data.NewChangeEvent(command, record)))
+}
+
+func (s *ChangeFeedTestSuite) expectEvent(subscription *ChangeSubscription) data.ChangeEvent {
+	select {
+	case event := <-subscription.Events:
+		return event
+	case <-time.After(time.Second):
+		s.T().Fatal("expected a change event")
+		return data.ChangeEvent{}
+	}
+}
+
+func (s *ChangeFeedTestSuite) expectNoEvent(subscription *ChangeSubscription) {
+	select {
+	case event := <-subscription.Events:
+		s.T().Fatalf("unexpected change event %s", event.Type)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func (s *ChangeFeedTestSuite) TestDeliversMatchingEvents() {
+	subscription, err := s.feed.Subscribe(&User{ID: "1", Roles: []string{"admin"}}, ChangeFilter{Entity: "order"})
+	require.NoError(s.T(), err)
+	defer subscription.Close()
+
+	s.publish("product", "create", map[string]interface{}{"id": "1"})
+	s.publish("order", "create", map[string]interface{}{"id": "5"})
+
+	event := s.expectEvent(subscription)
+	assert.Equal(s.T(), "order.create", event.Type)
+	assert.Equal(s.T(), "5", event.RecordID)
+	s.expectNoEvent(subscription)
+}
+
+func (s *ChangeFeedTestSuite) TestFiltersByActionAndFields() {
+	filter := ChangeFilter{Entity: "order", Actions: []string{"update"}, Fields: map[string]interface{}{"status": "shipped"}}
+	subscription, err := s.feed.Subscribe(&User{ID: "1", Roles: []string{"admin"}}, filter)
+	require.NoError(s.T(), err)
+	defer subscription.Close()
+
+	s.publish("order", "create", map[string]interface{}{"id": "1", "status": "shipped"})
+	s.publish("order", "update", map[string]interface{}{"id": "1", "status": "pending"})
+	s.publish("order", "update", map[string]interface{}{"id": "2", "status": "shipped"})
+
+	assert.Equal(s.T(), "2", s.expectEvent(subscription).RecordID)
+	s.expectNoEvent(subscription)
+}
+
+func (s *ChangeFeedTestSuite) TestWildcardAppliesAccessPolicyPerEvent() {
+	subscription, err := s.feed.Subscribe(&User{ID: "3", Roles: []string{"guest"}}, ChangeFilter{})
+	require.NoError(s.T(), err)
+	defer subscription.Close()
+
+	s.publish("user", "update", map[string]interface{}{"id": "1"})
+	s.publish("product", "update", map[string]interface{}{"id": "1"})
+
+	assert.Equal(s.T(), "product", s.expectEvent(subscription).Entity)
+	s.expectNoEvent(subscription)
+}
+
+func (s *ChangeFeedTestSuite) TestReadConditionsApplyToEachEventRecord() {
+	s.feed.policy = conditionalAgent()
+	manager := &User{ID: "5", Roles: []string{"manager"}, Attributes: map[string]string{"department": "sales"}}
+	subscription, err := s.feed.Subscribe(manager, ChangeFilter{Entity: "user"})
+	require.NoError(s.T(), err)
+	defer subscription.Close()
+
+	s.publish("user", "update", map[string]interface{}{"id": "8", "department": "finance"})
+	s.publish("user", "update", map[string]interface{}{"id": "9", "department": "sales"})
+
+	assert.Equal(s.T(), "9", s.expectEvent(subscription).RecordID)
+	s.expectNoEvent(subscription)
+}
+
+func (s *ChangeFeedTestSuite) TestDeliversOnlyEventsOfSubscriberTenant() {
+	subscription, err := s.feed.Subscribe(&User{ID: "10", Tenant: "acme", Roles: []string{"admin"}}, ChangeFilter{Entity: "product"})
+	require.NoError(s.T(), err)
+	defer subscription.Close()
+
+	s.publish("product", "create", map[string]interface{}{"id": "1"})
+	command := &data.Command{Action: "create", Entity: "product", Tenant: "acme", Data: map[string]interface{}{}}
+	require.NoError(s.T(), s.feed.Publish(s.ctx, data.NewChangeEvent(command, map[string]interface{}{"id": "2"})))
+
+	assert.Equal(s.T(), "2", s.expectEvent(subscription).RecordID)
+	s.expectNoEvent(subscription)
+}
+
+func (s *ChangeFeedTestSuite) TestRejectsUnreadableEntity() {
+	_, err := s.feed.Subscribe(&User{ID: "3", Roles: []string{"guest"}}, ChangeFilter{Entity: "order"})
+	assert.ErrorIs(s.T(), err, ErrAccessDenied)
+}
+
+func (s *ChangeFeedTestSuite) TestRejectsUnknownEntityAndAction() {
+	_, err := s.feed.Subscribe(&User{ID: "1", Roles: []string{"admin"}}, ChangeFilter{Entity: "invoice"})
+	assert.Error(s.T(), err)
+
+	_, err = s.feed.Subscribe(&User{ID: "1", Roles: []string{"admin"}}, ChangeFilter{Actions: []string{"read"}})
+	assert.Error(s.T(), err)
+}
+
+func (s *ChangeFeedTestSuite) TestCloseEndsSubscriptions() {
+	subscription, err := s.feed.Subscribe(&User{ID: "1", Roles: []string{"admin"}}, ChangeFilter{})
+	require.NoError(s.T(), err)
+
+	s.feed.Close()
+
+	_, open := <-subscription.Events
+	assert.False(s.T(), open)
+	subscription.Close()
+}
+
+func TestChangeFeedTestSuite(t *testing.T) {
+	suite.Run(t, new(ChangeFeedTestSuite))
+}
@@ -2,80 +2,467 @@ package drm
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"strings"
 
+	"drm-app/app/config"
 	"drm-app/app/data"
 	"drm-app/app/db"
+	"drm-app/app/metrics"
+)
+
+var (
+	ErrAuthenticationFailed = errors.New("authentication failed")
+	ErrAccessDenied         = errors.New("access denied")
 )
 
 type Engine struct {
 	AuthAgent         *AuthAgent
+	AccountAgent      *AccountAgent
+	OIDCAgent         *OIDCAgent // nil unless OIDC sign-in is enabled
 	AccessPolicyAgent *AccessPolicyAgent
 	IntentParser      *IntentParser
 	LogicAgent        *LogicAgent
 	DataAgent         data.DataExecutor
+	BulkStore         data.BulkStore
+	WebhookAgent      *WebhookAgent
+	ChangeFeed        *ChangeFeed
+	RateLimiter       *RateLimiter
+	IdempotencyAgent  *IdempotencyAgent
+	Impersonations    data.ImpersonationAuditStore // audit of requests made while acting as another user
 	Database          *db.Database
+
+	lifecycle lifecycle
 }
 
-func NewEngine() (*Engine, error) {
-	database, err := db.NewDatabase()
+func NewEngine(cfg *config.Config) (*Engine, error) {
+	database, err := db.NewDatabase(cfg.Database)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
 
+	if err := database.Migrate(context.Background()); err != nil {
+		database.Close()
+		return nil, fmt.Errorf("failed to apply migrations: %w", err)
+	}
+
+	webhookAgent := NewWebhookAgent(data.NewPostgresWebhookStore(database))
+	webhookAgent.Start(context.Background(), 4)
+
+	metrics.Registry.MustRegister(metrics.NewPoolCollector(database.Pool))
+
+	accessPolicyAgent := NewAccessPolicyAgentFromConfig(cfg.Policy)
+	changeFeed := NewChangeFeed(data.NewPostgresChangeNotifier(database), accessPolicyAgent)
+	changeFeed.Start()
+
+	var limitStore data.RateLimitStore = data.NewMemoryRateLimitStore()
+	var nonceStore data.NonceStore = data.NewMemoryNonceStore(cfg.Auth.Signing.NonceCacheSize)
+	if cfg.Policy.LimitStore == "postgres" {
+		limitStore = data.NewPostgresRateLimitStore(database)
+		nonceStore = data.NewPostgresNonceStore(database)
+	}
+
+	credentialStore := data.NewPostgresCredentialStore(database)
+	accountAgent := NewAccountAgent(credentialStore, cfg.Auth.Accounts)
+	var oidcAgent *OIDCAgent
+	if cfg.Auth.OIDC.Enabled {
+		oidcAgent = NewOIDCAgent(cfg.Auth.OIDC, credentialStore, accountAgent)
+	}
+
 	return &Engine{
-		AuthAgent:         NewAuthAgent(),
-		AccessPolicyAgent: NewAccessPolicyAgent(),
+		AuthAgent:         NewAuthAgentWithNonceStore(cfg.Auth, nonceStore),
+		AccountAgent:      accountAgent,
+		OIDCAgent:         oidcAgent,
+		AccessPolicyAgent: accessPolicyAgent,
 		IntentParser:      NewIntentParser(),
 		LogicAgent:        NewLogicAgent(),
-		DataAgent:         data.NewPostgresLLMDataAgent(database),
+		DataAgent:         data.NewPostgresLLMDataAgent(database, cfg.LLM),
+		BulkStore:         data.NewPostgresDataAgent(database),
+		WebhookAgent:      webhookAgent,
+		ChangeFeed:        changeFeed,
+		RateLimiter:       NewRateLimiter(limitStore, accessPolicyAgent),
+		IdempotencyAgent:  NewIdempotencyAgent(data.NewPostgresIdempotencyStore(database), cfg.Idempotency.Window),
+		Impersonations:    data.NewPostgresImpersonationAuditStore(database),
 		Database:          database,
 	}, nil
 }
 
 func (e *Engine) Close() {
+	if e.ChangeFeed != nil {
+		e.ChangeFeed.Close()
+	}
+	if e.WebhookAgent != nil {
+		e.WebhookAgent.Close()
+	}
 	if e.Database != nil {
 		e.Database.Close()
 	}
 }
 
 func NewTestEngine() *Engine {
+	webhookAgent := NewWebhookAgent(data.NewTestWebhookStore())
+	webhookAgent.Start(context.Background(), 1)
+
+	accessPolicyAgent := NewAccessPolicyAgent()
+	changeFeed := NewChangeFeed(data.NewLocalChangeNotifier(), accessPolicyAgent)
+	changeFeed.Start()
+
+	dataAgent := data.NewTestDataAgent()
+
 	return &Engine{
 		AuthAgent:         NewAuthAgent(),
-		AccessPolicyAgent: NewAccessPolicyAgent(),
+		AccountAgent:      NewAccountAgent(data.NewTestCredentialStore(dataAgent), config.Default().Auth.Accounts),
+		AccessPolicyAgent: accessPolicyAgent,
 		IntentParser:      NewIntentParser(),
 		LogicAgent:        NewLogicAgent(),
-		DataAgent:         data.NewTestDataAgent(),
+		DataAgent:         dataAgent,
+		BulkStore:         dataAgent,
+		WebhookAgent:      webhookAgent,
+		ChangeFeed:        changeFeed,
+		RateLimiter:       NewRateLimiter(data.NewMemoryRateLimitStore(), accessPolicyAgent),
+		IdempotencyAgent:  NewIdempotencyAgent(data.NewTestIdempotencyStore(), config.Default().Idempotency.Window),
+		Impersonations:    data.NewTestImpersonationAuditStore(),
 		Database:          nil,
 	}
 }
 
+// RequestOptions carries optional settings for a single request
+type RequestOptions struct {
+	// IdempotencyKey makes a create safe to retry; it is ignored for other actions
+	IdempotencyKey string
+	// DryRun explains how the request would be handled instead of executing it
+	DryRun bool
+	// ActAs names a user of the caller's tenant to run the request as, by identity source and ID
+	// as token:<id> or account:<id>, which takes the impersonate permission
+	ActAs string
+}
+
+type RequestResult struct {
+	Result interface{}
+	// Page is set for list reads and locates Result within the full list
+	Page *data.PageInfo
+	// Replayed is set when Result is the stored response of an earlier request with the same idempotency key
+	Replayed bool
+	// DryRun is set when Result is an *Explanation and nothing was executed
+	DryRun bool
+}
+
 func (e *Engine) ProcessRequest(ctx context.Context, query string, token string) (interface{}, error) {
-	user, err := e.AuthAgent.ValidateToken(token)
+	response, err := e.ProcessRequestWithOptions(ctx, query, token, RequestOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return response.Result, nil
+}
+
+func (e *Engine) ProcessRequestWithOptions(ctx context.Context, query string, token string, opts RequestOptions) (*RequestResult, error) {
+	if err := e.lifecycle.admit(); err != nil {
+		return nil, err
+	}
+	defer e.lifecycle.release()
+
+	ctx, observer := observeRequest(ctx)
+
+	user, err := e.authenticate(observer, token)
 	if err != nil {
-		return nil, fmt.Errorf("authentication failed: %w", err)
+		return nil, err
+	}
+	if user, err = e.actAs(observer, user, opts.ActAs); err != nil {
+		return nil, err
 	}
 
+	observer.begin("parse")
 	command, err := e.IntentParser.Parse(query)
 	if err != nil {
-		return nil, fmt.Errorf("parsing failed: %w", err)
+		return nil, observer.fail(OutcomeInvalidQuery, fmt.Errorf("parsing failed: %w", err))
+	}
+
+	command.UserID = user.ID
+	command.ImpersonatorID = user.impersonatorID()
+	command.Tenant = user.Tenant
+	command.UserRoles = user.Roles
+	command.UserAttributes = user.Attributes
+	observer.describe(command)
+	observer.end(OutcomeSuccess, nil)
+
+	return e.process(ctx, observer, user, command, opts)
+}
+
+// ProcessCommand runs an already structured command, as built by the REST routes, through
+// the same stages as ProcessRequest except parsing
+func (e *Engine) ProcessCommand(ctx context.Context, command *data.Command, token string, opts RequestOptions) (*RequestResult, error) {
+	if err := e.lifecycle.admit(); err != nil {
+		return nil, err
+	}
+	defer e.lifecycle.release()
+
+	ctx, observer := observeRequest(ctx)
+
+	user, err := e.authenticate(observer, token)
+	if err != nil {
+		return nil, err
+	}
+	if user, err = e.actAs(observer, user, opts.ActAs); err != nil {
+		return nil, err
 	}
 
 	command.UserID = user.ID
-	command.UserRole = user.Role
+	command.ImpersonatorID = user.impersonatorID()
+	command.Tenant = user.Tenant
+	command.UserRoles = user.Roles
+	command.UserAttributes = user.Attributes
+	if command.Data == nil {
+		command.Data = make(map[string]interface{})
+	}
+	observer.describe(command)
+
+	return e.process(ctx, observer, user, command, opts)
+}
+
+func (e *Engine) authenticate(observer *requestObserver, token string) (*User, error) {
+	authCtx := observer.begin("auth")
+	user, err := e.identify(authCtx, token)
+	if err != nil {
+		return nil, observer.fail(OutcomeUnauthenticated, fmt.Errorf("%w: %w", ErrAuthenticationFailed, err))
+	}
+	observer.end(OutcomeSuccess, nil)
+
+	if e.RateLimiter != nil {
+		limitCtx := observer.begin("rate_limit")
+		if err := e.RateLimiter.Allow(limitCtx, user); err != nil {
+			return nil, observer.fail(OutcomeRateLimited, err)
+		}
+		observer.end(OutcomeSuccess, nil)
+	}
+
+	return user, nil
+}
+
+// process runs the stages after parsing: policy, validation, idempotency, quota and execution
+func (e *Engine) process(ctx context.Context, observer *requestObserver, user *User, command *data.Command, opts RequestOptions) (*RequestResult, error) {
+	if opts.DryRun {
+		return e.explain(observer, user, command), nil
+	}
 
-	if !e.AccessPolicyAgent.CheckAccess(command) {
-		return nil, fmt.Errorf("access denied for action %s on entity %s", command.Action, command.Entity)
+	policyCtx := observer.begin("policy")
+	if _, err := e.decide(policyCtx, command); err != nil {
+		return nil, observer.fail(OutcomeDenied, err)
 	}
+	e.AccessPolicyAgent.LimitPageSize(command)
+	e.AccessPolicyAgent.LimitBulk(command)
+	observer.end(OutcomeSuccess, nil)
 
+	observer.begin("validation")
 	if err := e.LogicAgent.ValidateCommand(command); err != nil {
-		return nil, fmt.Errorf("validation failed: %w", err)
+		return nil, observer.fail(OutcomeInvalid, fmt.Errorf("validation failed: %w", err))
+	}
+	observer.end(OutcomeSuccess, nil)
+
+	idempotencyKey := ""
+	if e.IdempotencyAgent != nil && command.Action == "create" {
+		idempotencyKey = opts.IdempotencyKey
+	}
+	if idempotencyKey != "" {
+		idempotencyCtx := observer.begin("idempotency")
+		stored, err := e.IdempotencyAgent.Begin(idempotencyCtx, user.idempotencyScope(), idempotencyKey, command)
+		if err != nil {
+			return nil, observer.fail(OutcomeConflict, err)
+		}
+		if stored != nil {
+			var result interface{}
+			if err := json.Unmarshal(stored, &result); err != nil {
+				return nil, observer.fail(OutcomeFailed, fmt.Errorf("failed to replay stored response: %w", err))
+			}
+			observer.end(OutcomeReplayed, nil)
+			observer.finish(OutcomeReplayed, nil)
+			return &RequestResult{Result: result, Replayed: true}, nil
+		}
+		observer.end(OutcomeSuccess, nil)
+	}
+	// release frees the idempotency key when the request fails after reserving it
+	release := func() {
+		if idempotencyKey != "" {
+			e.IdempotencyAgent.Release(ctx, user.idempotencyScope(), idempotencyKey)
+		}
+	}
+
+	// Upserts may create records, so they count against the create quota too. Impersonators
+	// spend their own quota, like their rate limit.
+	if e.RateLimiter != nil && (command.Action == "create" || command.Action == "upsert") {
+		quotaCtx := observer.begin("quota")
+		if err := e.RateLimiter.ConsumeCreateQuota(quotaCtx, user.caller()); err != nil {
+			release()
+			return nil, observer.fail(OutcomeRateLimited, err)
+		}
+		observer.end(OutcomeSuccess, nil)
+	}
+
+	executionCtx := observer.begin("execution")
+	result, err := e.DataAgent.ExecuteCommand(executionCtx, command)
+	if err != nil {
+		release()
+		return nil, observer.fail(executionOutcome(err), fmt.Errorf("execution failed: %w", err))
+	}
+	observer.end(OutcomeSuccess, nil)
+
+	if idempotencyKey != "" {
+		e.IdempotencyAgent.Complete(ctx, user.idempotencyScope(), idempotencyKey, result)
+	}
+
+	if changeActions[command.Action] {
+		e.publishChange(ctx, command, result)
+	} else if upserted, ok := result.(*data.UpsertResult); ok {
+		// Subscribers see an upsert as the create or update it turned out to be
+		change := *command
+		change.Action = upserted.Operation
+		e.publishChange(ctx, &change, upserted.Record)
+	} else if changed, ok := result.(*data.BulkResult); ok && !changed.Preview {
+		// and a bulk change as an update or delete of each record it changed
+		change := *command
+		change.Action = strings.TrimPrefix(command.Action, "bulk_")
+		for _, record := range changed.Records {
+			e.publishChange(ctx, &change, record)
+		}
+	}
+
+	observer.finish(OutcomeSuccess, nil)
+	if page, ok := result.(*data.Page); ok {
+		return &RequestResult{Result: page.Items, Page: &page.PageInfo}, nil
+	}
+	return &RequestResult{Result: result}, nil
+}
+
+// decide checks command against the access policy and returns the deciding rule, or an
+// ErrAccessDenied error when it is not allowed
+func (e *Engine) decide(ctx context.Context, command *data.Command) (PolicyDecision, error) {
+	decision := e.AccessPolicyAgent.DecideWithRecord(command, e.recordLoader(ctx, command))
+	if !decision.Allowed {
+		return decision, accessDenied(command.Action, command.Entity, decision)
+	}
+	if command.Action == "read" {
+		// Expanded records are reads of their own entity and need their own permission
+		for _, entity := range data.ExpandedEntities(command) {
+			expanded := &data.Command{Action: "read", Entity: entity, UserID: command.UserID, Tenant: command.Tenant, UserRoles: command.UserRoles, UserAttributes: command.UserAttributes}
+			if denied := e.AccessPolicyAgent.Decide(expanded); !denied.Allowed {
+				denied.Reason += fmt.Sprintf(", expanded from %s", command.Entity)
+				return denied, fmt.Errorf("%w for action read on entity %s expanded from %s: %s", ErrAccessDenied, entity, command.Entity, denied.Reason)
+			}
+		}
+	}
+	return decision, nil
+}
+
+// recordLoader reads the stored record command targets straight from the store, within the
+// command's tenant, for policy conditions that refer to it. Commands without an id, or whose
+// record does not exist, target no record.
+func (e *Engine) recordLoader(ctx context.Context, command *data.Command) RecordLoader {
+	return func() (interface{}, error) {
+		id, exists := command.Data["id"]
+		if !exists || id == nil {
+			return nil, nil
+		}
+		var stored map[string]interface{}
+		conditions := []data.Condition{{Field: "id", Op: "=", Value: id}}
+		err := e.BulkStore.ExportRecords(data.WithTenant(ctx, command.Tenant), command.Entity, conditions, func(record map[string]interface{}) error {
+			stored = record
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if stored == nil {
+			return nil, nil
+		}
+		return stored, nil
+	}
+}
+
+// identify returns the user of a verified signed request, a configured token or a session's
+// access token
+func (e *Engine) identify(ctx context.Context, token string) (*User, error) {
+	if user, signed := SignedUser(ctx); signed {
+		return user, nil
 	}
+	token = strings.TrimSpace(token)
+	if e.AccountAgent != nil && IsSessionToken(token) {
+		return e.AccountAgent.Authenticate(ctx, token)
+	}
+	return e.AuthAgent.ValidateToken(token)
+}
+
+// Authorize authenticates the token and checks that its user may perform action on entity.
+// It backs the administrative endpoints that do not go through the natural-language parser.
+func (e *Engine) Authorize(ctx context.Context, token, entity, action string) (*User, error) {
+	user, err := e.identify(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAuthenticationFailed, err)
+	}
+
+	command := &data.Command{Action: action, Entity: entity, UserID: user.ID, Tenant: user.Tenant, UserRoles: user.Roles, UserAttributes: user.Attributes}
+	if decision := e.AccessPolicyAgent.Decide(command); !decision.Allowed {
+		return nil, accessDenied(action, entity, decision)
+	}
+
+	return user, nil
+}
+
+// accessDenied reports a denied decision as an ErrAccessDenied error carrying its reason
+func accessDenied(action, entity string, decision PolicyDecision) error {
+	return fmt.Errorf("%w for action %s on entity %s: %s", ErrAccessDenied, action, entity, decision.Reason)
+}
+
+// SubscribeChanges authenticates the token and opens a live change subscription for its user
+func (e *Engine) SubscribeChanges(ctx context.Context, token string, filter ChangeFilter) (*ChangeSubscription, error) {
+	user, err := e.identify(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAuthenticationFailed, err)
+	}
+
+	return e.ChangeFeed.Subscribe(user, filter)
+}
 
-	result, err := e.DataAgent.ExecuteCommand(ctx, command)
+// AuthorizeChanges authenticates the token and checks that its user may subscribe with filter,
+// for transports that can only subscribe once the connection is set up
+func (e *Engine) AuthorizeChanges(ctx context.Context, token string, filter ChangeFilter) (*User, error) {
+	user, err := e.identify(ctx, token)
 	if err != nil {
-		return nil, fmt.Errorf("execution failed: %w", err)
+		return nil, fmt.Errorf("%w: %w", ErrAuthenticationFailed, err)
 	}
 
-	return result, nil
+	if err := e.ChangeFeed.Check(user, filter); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// executionOutcome classifies execution failures that are the caller's doing rather than the store's
+func executionOutcome(err error) string {
+	switch {
+	case errors.Is(err, data.ErrPreviewMismatch):
+		return OutcomeConflict
+	case errors.Is(err, data.ErrBulkLimitExceeded):
+		return OutcomeDenied
+	}
+	return OutcomeFailed
+}
+
+// publishChange fans a committed write out to subscribers. Failures are logged rather
+// than returned because the write itself has already succeeded.
+func (e *Engine) publishChange(ctx context.Context, command *data.Command, result interface{}) {
+	event := data.NewChangeEvent(command, result)
+
+	if e.WebhookAgent != nil {
+		if err := e.WebhookAgent.Publish(ctx, event); err != nil {
+			slog.ErrorContext(ctx, "failed to publish webhook event", "event_type", event.Type, "event_id", event.ID, "error", err)
+		}
+	}
+
+	if e.ChangeFeed != nil {
+		if err := e.ChangeFeed.Publish(ctx, event); err != nil {
+			slog.ErrorContext(ctx, "failed to publish change event", "event_type", event.Type, "event_id", event.ID, "error", err)
+		}
+	}
 }
@@ -2,9 +2,14 @@ package drm
 
 import (
 	"context"
+	"errors"
 	"testing"
 
+	"drm-app/app/config"
+	"drm-app/app/data"
+	"drm-app/app/db"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -78,6 +83,119 @@ func (s *EngineTestSuite) TestProcessRequestSuccess() {
 	assert.Contains(s.T(), resultMap, "created_at")
 }
 
+func (s *EngineTestSuite) TestExpandedEntitiesNeedReadAccess() {
+	engine := NewTestEngine()
+	defer engine.Close()
+	engine.AccessPolicyAgent = NewAccessPolicyAgentFromConfig(config.PolicyConfig{
+		Roles: map[string]map[string][]string{
+			"user": {"order": {"read"}, "product": {"read"}},
+		},
+	})
+
+	_, err := engine.ProcessRequest(s.ctx, "list orders expand items.product", "user-token")
+	assert.NoError(s.T(), err)
+
+	_, err = engine.ProcessRequest(s.ctx, "list orders expand user", "user-token")
+	assert.ErrorIs(s.T(), err, ErrAccessDenied)
+	assert.Contains(s.T(), err.Error(), "entity user expanded from order")
+}
+
+func (s *EngineTestSuite) TestConditionsReadStoredRecord() {
+	engine := NewTestEngine()
+	defer engine.Close()
+	engine.AccessPolicyAgent = NewAccessPolicyAgentFromConfig(config.PolicyConfig{
+		Roles: map[string]map[string][]string{
+			"user": {"product": {"read", "update"}},
+		},
+		Conditions: map[string]map[string]map[string]string{
+			"user": {"product": {"update": "record.price < 100"}},
+		},
+	})
+
+	result, err := engine.ProcessRequest(s.ctx, `update product json:{"id":"2","price":39.99}`, "user-token")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 39.99, result.(map[string]interface{})["price"])
+
+	_, err = engine.ProcessRequest(s.ctx, `update product json:{"id":"1","price":10}`, "user-token")
+	assert.ErrorIs(s.T(), err, ErrAccessDenied)
+	assert.Contains(s.T(), err.Error(), "condition of policy.roles.user.product: update not met: record.price < 100")
+}
+
+// countingDataAgent counts the commands it passes on, by action
+type countingDataAgent struct {
+	data.DataExecutor
+	actions []string
+}
+
+func (a *countingDataAgent) ExecuteCommand(ctx context.Context, command *data.Command) (interface{}, error) {
+	a.actions = append(a.actions, command.Action)
+	return a.DataExecutor.ExecuteCommand(ctx, command)
+}
+
+func (s *EngineTestSuite) TestRecordConditionsReadTheStoreDirectly() {
+	engine := NewTestEngine()
+	defer engine.Close()
+	engine.AccessPolicyAgent = NewAccessPolicyAgentFromConfig(config.PolicyConfig{
+		Roles: map[string]map[string][]string{
+			"user": {"product": {"read", "update"}},
+		},
+		Conditions: map[string]map[string]map[string]string{
+			"user": {"product": {"update": "record.price < 100"}},
+		},
+	})
+	// The data agent may be a language model, which must not take part in access decisions
+	agent := &countingDataAgent{DataExecutor: engine.DataAgent}
+	engine.DataAgent = agent
+
+	_, err := engine.ProcessRequest(s.ctx, `update product json:{"id":"2","price":39.99}`, "user-token")
+	require.NoError(s.T(), err)
+	_, err = engine.ProcessRequest(s.ctx, `update product json:{"id":"1","price":10}`, "user-token")
+	assert.ErrorIs(s.T(), err, ErrAccessDenied)
+	assert.Equal(s.T(), []string{"update"}, agent.actions)
+}
+
+// explainingDataAgent reports fixed statements and fails any command actually executed
+type explainingDataAgent struct {
+	executed bool
+}
+
+func (a *explainingDataAgent) ExecuteCommand(ctx context.Context, command *data.Command) (interface{}, error) {
+	a.executed = true
+	return nil, errors.New("executed during a dry run")
+}
+
+func (a *explainingDataAgent) ExplainCommand(ctx context.Context, command *data.Command) ([]db.Statement, error) {
+	return []db.Statement{{SQL: "DELETE FROM users WHERE id = $1", Args: []interface{}{1}}}, nil
+}
+
+func (s *EngineTestSuite) TestDryRunExplainsWithoutExecuting() {
+	engine := NewTestEngine()
+	defer engine.Close()
+	agent := &explainingDataAgent{}
+	engine.DataAgent = agent
+
+	response, err := engine.ProcessRequestWithOptions(s.ctx, `delete user json:{"id":"1"}`, "admin-token", RequestOptions{DryRun: true})
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), response.DryRun)
+	assert.False(s.T(), agent.executed)
+
+	explanation := response.Result.(*Explanation)
+	assert.Equal(s.T(), "delete", explanation.Command.Action)
+	assert.Equal(s.T(), []string{"admin"}, explanation.User.Roles)
+	assert.Equal(s.T(), "policy.roles.admin.user: delete", explanation.Policy.Rule)
+	assert.True(s.T(), explanation.Validation.Valid)
+	require.Len(s.T(), explanation.Statements, 1)
+	assert.Equal(s.T(), "DELETE FROM users WHERE id = $1", explanation.Statements[0].SQL)
+
+	response, err = engine.ProcessRequestWithOptions(s.ctx, `delete user json:{"id":"1"}`, "guest-token", RequestOptions{DryRun: true})
+	assert.NoError(s.T(), err)
+	explanation = response.Result.(*Explanation)
+	assert.False(s.T(), explanation.Policy.Allowed)
+	assert.Empty(s.T(), explanation.Statements)
+	assert.Equal(s.T(), "not executed: access denied", explanation.Note)
+	assert.False(s.T(), agent.executed)
+}
+
 func TestEngineTestSuite(t *testing.T) {
 	suite.Run(t, new(EngineTestSuite))
 }
@@ -0,0 +1,65 @@
+package drm
+
+import (
+	"drm-app/app/data"
+	"drm-app/app/db"
+)
+
+// ValidationResult reports whether a command passed LogicAgent validation
+type ValidationResult struct {
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+}
+
+// Explanation describes how a request would be handled: the command it parsed to, who made
+// it, the policy decision and validation result, and the SQL statements its execution issues
+type Explanation struct {
+	Command    *data.Command    `json:"command"`
+	User       *User            `json:"user"`
+	Policy     PolicyDecision   `json:"policy"`
+	Validation ValidationResult `json:"validation"`
+	Statements []db.Statement   `json:"statements,omitempty"`
+	// Note says why Statements is missing or why execution would fail
+	Note string `json:"note,omitempty"`
+}
+
+// explain runs the policy and validation stages of a dry run, recording their outcome instead
+// of stopping at the first failure. Idempotency keys, quotas and change events do not apply
+// and nothing is written.
+func (e *Engine) explain(observer *requestObserver, user *User, command *data.Command) *RequestResult {
+	explanation := &Explanation{Command: command, User: user}
+
+	policyCtx := observer.begin("policy")
+	explanation.Policy, _ = e.decide(policyCtx, command)
+	e.AccessPolicyAgent.LimitPageSize(command)
+	e.AccessPolicyAgent.LimitBulk(command)
+	observer.end(OutcomeSuccess, nil)
+
+	observer.begin("validation")
+	explanation.Validation.Valid = true
+	if err := e.LogicAgent.ValidateCommand(command); err != nil {
+		explanation.Validation = ValidationResult{Error: err.Error()}
+	}
+	observer.end(OutcomeSuccess, nil)
+
+	explainer, canExplain := e.DataAgent.(data.StatementExplainer)
+	switch {
+	case !explanation.Policy.Allowed:
+		explanation.Note = "not executed: access denied"
+	case !explanation.Validation.Valid:
+		explanation.Note = "not executed: validation failed"
+	case !canExplain:
+		explanation.Note = "the data agent does not report SQL"
+	default:
+		executionCtx := observer.begin("explain")
+		statements, err := explainer.ExplainCommand(executionCtx, command)
+		if err != nil {
+			explanation.Note = "execution would fail: " + err.Error()
+		}
+		explanation.Statements = statements
+		observer.end(OutcomeSuccess, nil)
+	}
+
+	observer.finish(OutcomeExplained, nil)
+	return &RequestResult{Result: explanation, DryRun: true}
+}
@@ -0,0 +1,176 @@
+package drm
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"drm-app/app/db"
+)
+
+const Version = "1.0.0"
+
+const (
+	HealthOK          = "ok"
+	HealthDegraded    = "degraded"
+	HealthUnavailable = "unavailable"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+var startedAt = time.Now()
+
+type ComponentHealth struct {
+	Status    string `json:"status"`
+	Optional  bool   `json:"optional,omitempty"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+type HealthReport struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentHealth `json:"components"`
+}
+
+type SystemStatus struct {
+	HealthReport
+	Version    string            `json:"version"`
+	GoVersion  string            `json:"go_version"`
+	Uptime     string            `json:"uptime"`
+	Versions   map[string]string `json:"versions"`
+	Migrations []string          `json:"migrations,omitempty"`
+	Pool       *db.PoolStats     `json:"pool,omitempty"`
+}
+
+// healthChecker is implemented by data agents that depend on an external service, which is
+// only checked while it is enabled
+type healthChecker interface {
+	Health(ctx context.Context) error
+	Enabled() bool
+}
+
+type versionReporter interface {
+	Version(ctx context.Context) (string, error)
+	Model() string
+}
+
+// Readiness checks every dependency concurrently. Postgres and its migrations are required;
+// the LLM is optional because the data agent falls back to direct execution without it.
+func (e *Engine) Readiness(ctx context.Context) HealthReport {
+	if e.ShuttingDown() {
+		return HealthReport{
+			Status: HealthUnavailable,
+			Components: map[string]ComponentHealth{
+				"server": {Status: HealthUnavailable, Error: ErrShuttingDown.Error()},
+			},
+		}
+	}
+
+	checks := map[string]func(context.Context) error{}
+	optional := map[string]bool{}
+
+	if e.Database != nil {
+		checks["database"] = e.Database.Health
+		checks["migrations"] = e.checkMigrations
+	}
+
+	if checker, ok := e.DataAgent.(healthChecker); ok && checker.Enabled() {
+		checks["llm"] = checker.Health
+		optional["llm"] = true
+	}
+
+	report := HealthReport{
+		Status:     HealthOK,
+		Components: make(map[string]ComponentHealth, len(checks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) error) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check(checkCtx)
+			component := ComponentHealth{
+				Status:    HealthOK,
+				Optional:  optional[name],
+				LatencyMs: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				component.Status = HealthUnavailable
+				component.Error = err.Error()
+			}
+
+			mu.Lock()
+			report.Components[name] = component
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	for _, component := range report.Components {
+		if component.Status == HealthOK {
+			continue
+		}
+		if !component.Optional {
+			report.Status = HealthUnavailable
+			break
+		}
+		report.Status = HealthDegraded
+	}
+
+	return report
+}
+
+func (e *Engine) checkMigrations(ctx context.Context) error {
+	pending, err := e.Database.PendingMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d pending migrations, next is %s", len(pending), pending[0].Version)
+	}
+	return nil
+}
+
+// Status extends the readiness report with versions, migrations and pool usage for operators
+func (e *Engine) Status(ctx context.Context) SystemStatus {
+	status := SystemStatus{
+		HealthReport: e.Readiness(ctx),
+		Version:      Version,
+		GoVersion:    runtime.Version(),
+		Uptime:       time.Since(startedAt).Round(time.Second).String(),
+		Versions:     map[string]string{"drm": Version},
+	}
+
+	versionCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	if e.Database != nil {
+		stats := e.Database.Stats()
+		status.Pool = &stats
+
+		if version, err := e.Database.ServerVersion(versionCtx); err == nil {
+			status.Versions["postgres"] = version
+		}
+		if migrations, err := e.Database.AppliedMigrations(versionCtx); err == nil {
+			status.Migrations = migrations
+		}
+	}
+
+	if reporter, ok := e.DataAgent.(versionReporter); ok {
+		status.Versions["llm_model"] = reporter.Model()
+		if version, err := reporter.Version(versionCtx); err == nil {
+			status.Versions["ollama"] = version
+		}
+	}
+
+	return status
+}
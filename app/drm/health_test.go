@@ -0,0 +1,100 @@
+package drm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"drm-app/app/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type fakeLLMDataAgent struct {
+	*data.TestDataAgent
+	healthErr error
+	disabled  bool
+}
+
+func (f *fakeLLMDataAgent) Enabled() bool {
+	return !f.disabled
+}
+
+func (f *fakeLLMDataAgent) Health(ctx context.Context) error {
+	return f.healthErr
+}
+
+func (f *fakeLLMDataAgent) Version(ctx context.Context) (string, error) {
+	return "0.9.5", nil
+}
+
+func (f *fakeLLMDataAgent) Model() string {
+	return "test-model"
+}
+
+type HealthTestSuite struct {
+	suite.Suite
+	engine *Engine
+	ctx    context.Context
+}
+
+func (s *HealthTestSuite) SetupTest() {
+	s.engine = NewTestEngine()
+	s.ctx = context.Background()
+}
+
+func (s *HealthTestSuite) TearDownTest() {
+	s.engine.Close()
+}
+
+func (s *HealthTestSuite) TestReadyWithoutExternalDependencies() {
+	report := s.engine.Readiness(s.ctx)
+
+	assert.Equal(s.T(), HealthOK, report.Status)
+	assert.Empty(s.T(), report.Components)
+}
+
+func (s *HealthTestSuite) TestHealthyLLMIsReported() {
+	s.engine.DataAgent = &fakeLLMDataAgent{TestDataAgent: data.NewTestDataAgent()}
+
+	report := s.engine.Readiness(s.ctx)
+
+	assert.Equal(s.T(), HealthOK, report.Status)
+	assert.Equal(s.T(), HealthOK, report.Components["llm"].Status)
+}
+
+func (s *HealthTestSuite) TestUnreachableLLMIsDegraded() {
+	s.engine.DataAgent = &fakeLLMDataAgent{TestDataAgent: data.NewTestDataAgent(), healthErr: errors.New("connection refused")}
+
+	report := s.engine.Readiness(s.ctx)
+
+	assert.Equal(s.T(), HealthDegraded, report.Status)
+	assert.Equal(s.T(), HealthUnavailable, report.Components["llm"].Status)
+	assert.True(s.T(), report.Components["llm"].Optional)
+	assert.Equal(s.T(), "connection refused", report.Components["llm"].Error)
+}
+
+func (s *HealthTestSuite) TestDisabledLLMIsNotChecked() {
+	s.engine.DataAgent = &fakeLLMDataAgent{TestDataAgent: data.NewTestDataAgent(), healthErr: errors.New("connection refused"), disabled: true}
+
+	report := s.engine.Readiness(s.ctx)
+
+	assert.Equal(s.T(), HealthOK, report.Status)
+	assert.NotContains(s.T(), report.Components, "llm")
+}
+
+func (s *HealthTestSuite) TestStatusIncludesVersions() {
+	s.engine.DataAgent = &fakeLLMDataAgent{TestDataAgent: data.NewTestDataAgent()}
+
+	status := s.engine.Status(s.ctx)
+
+	assert.Equal(s.T(), Version, status.Version)
+	assert.Equal(s.T(), Version, status.Versions["drm"])
+	assert.Equal(s.T(), "test-model", status.Versions["llm_model"])
+	assert.Equal(s.T(), "0.9.5", status.Versions["ollama"])
+	assert.Nil(s.T(), status.Pool)
+}
+
+func TestHealthTestSuite(t *testing.T) {
+	suite.Run(t, new(HealthTestSuite))
+}
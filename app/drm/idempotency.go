@@ -0,0 +1,123 @@
+package drm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"drm-app/app/data"
+)
+
+const (
+	maxIdempotencyKeyLength = 255
+
+	// idempotencyLockTimeout is how long a pending key blocks retries before it is presumed
+	// abandoned by a crashed request
+	idempotencyLockTimeout = time.Minute
+)
+
+var ErrIdempotencyConflict = errors.New("idempotency key conflict")
+
+// IdempotencyAgent makes create commands sent with an idempotency key safe to retry: the first
+// request's response is stored and replayed to later requests with the same key and payload.
+type IdempotencyAgent struct {
+	store  data.IdempotencyStore
+	window time.Duration
+	now    func() time.Time
+}
+
+func NewIdempotencyAgent(store data.IdempotencyStore, window time.Duration) *IdempotencyAgent {
+	return &IdempotencyAgent{
+		store:  store,
+		window: window,
+		now:    time.Now,
+	}
+}
+
+func ValidateIdempotencyKey(key string) error {
+	if len(key) > maxIdempotencyKeyLength {
+		return fmt.Errorf("idempotency key must be at most %d characters", maxIdempotencyKeyLength)
+	}
+	for _, r := range key {
+		if r < 0x21 || r > 0x7e {
+			return fmt.Errorf("idempotency key must be printable ASCII without spaces")
+		}
+	}
+	return nil
+}
+
+// Begin reserves the key in scope, which names the user sending it, for command. It returns
+// the stored response when the key was already used for the same command, and
+// ErrIdempotencyConflict when it was used for a different one or the first request is still
+// running.
+func (a *IdempotencyAgent) Begin(ctx context.Context, scope, key string, command *data.Command) (json.RawMessage, error) {
+	fingerprint, err := commandFingerprint(command)
+	if err != nil {
+		return nil, err
+	}
+
+	now := a.now()
+	record := &data.IdempotencyRecord{
+		Scope:       scope,
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(a.window),
+		LegacyScope: command.UserID,
+	}
+
+	existing, err := a.store.Reserve(ctx, record, now.Add(-idempotencyLockTimeout))
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, nil
+	}
+
+	if existing.Fingerprint != fingerprint {
+		return nil, fmt.Errorf("%w: key %q was already used with a different request", ErrIdempotencyConflict, key)
+	}
+	if existing.Status != data.IdempotencyStatusCompleted {
+		return nil, fmt.Errorf("%w: a request with key %q is still in progress", ErrIdempotencyConflict, key)
+	}
+	return existing.Response, nil
+}
+
+// Complete stores the result for replay. A failure only costs the replay, so it is logged.
+func (a *IdempotencyAgent) Complete(ctx context.Context, scope, key string, result interface{}) {
+	response, err := json.Marshal(result)
+	if err == nil {
+		err = a.store.Complete(ctx, scope, key, response)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to store idempotent response", "scope", scope, "error", err)
+	}
+}
+
+// Release frees the key after a failed request so the client can retry it
+func (a *IdempotencyAgent) Release(ctx context.Context, scope, key string) {
+	if err := a.store.Release(ctx, scope, key); err != nil {
+		slog.ErrorContext(ctx, "failed to release idempotency key", "scope", scope, "error", err)
+	}
+}
+
+// commandFingerprint hashes what the command does; JSON encoding sorts map keys, so
+// equivalent payloads produce the same fingerprint
+func commandFingerprint(command *data.Command) (string, error) {
+	payload, err := json.Marshal(struct {
+		Action string                 `json:"action"`
+		Entity string                 `json:"entity"`
+		Data   map[string]interface{} `json:"data"`
+	}{command.Action, command.Entity, command.Data})
+	if err != nil {
+		return "", fmt.Errorf("failed to fingerprint command: %w", err)
+	}
+
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,169 @@
+package drm
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"drm-app/app/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type IdempotencyAgentTestSuite struct {
+	suite.Suite
+	agent *IdempotencyAgent
+	now   time.Time
+	ctx   context.Context
+	scope string
+}
+
+func (s *IdempotencyAgentTestSuite) SetupTest() {
+	s.ctx = context.Background()
+	s.now = time.Date(2025, 3, 14, 12, 0, 0, 0, time.UTC)
+	s.agent = NewIdempotencyAgent(data.NewTestIdempotencyStore(), time.Hour)
+	s.agent.now = func() time.Time { return s.now }
+	s.scope = (&User{ID: "2", Tenant: "default", Source: SourceToken}).key()
+}
+
+func (s *IdempotencyAgentTestSuite) command(name string) *data.Command {
+	return &data.Command{
+		Action: "create",
+		Entity: "product",
+		Data:   map[string]interface{}{"name": name, "price": 10.0},
+		UserID: "2",
+	}
+}
+
+func (s *IdempotencyAgentTestSuite) TestReplaysCompletedResponse() {
+	stored, err := s.agent.Begin(s.ctx, s.scope, "key-1", s.command("Widget"))
+	require.NoError(s.T(), err)
+	assert.Nil(s.T(), stored)
+
+	s.agent.Complete(s.ctx, s.scope, "key-1", map[string]interface{}{"id": "7"})
+
+	stored, err = s.agent.Begin(s.ctx, s.scope, "key-1", s.command("Widget"))
+	require.NoError(s.T(), err)
+	assert.JSONEq(s.T(), `{"id":"7"}`, string(stored))
+}
+
+func (s *IdempotencyAgentTestSuite) TestDifferentPayloadConflicts() {
+	_, err := s.agent.Begin(s.ctx, s.scope, "key-1", s.command("Widget"))
+	require.NoError(s.T(), err)
+	s.agent.Complete(s.ctx, s.scope, "key-1", map[string]interface{}{"id": "7"})
+
+	_, err = s.agent.Begin(s.ctx, s.scope, "key-1", s.command("Gadget"))
+
+	assert.ErrorIs(s.T(), err, ErrIdempotencyConflict)
+	assert.Contains(s.T(), err.Error(), "different request")
+}
+
+func (s *IdempotencyAgentTestSuite) TestPendingRequestConflicts() {
+	_, err := s.agent.Begin(s.ctx, s.scope, "key-1", s.command("Widget"))
+	require.NoError(s.T(), err)
+
+	_, err = s.agent.Begin(s.ctx, s.scope, "key-1", s.command("Widget"))
+	assert.ErrorIs(s.T(), err, ErrIdempotencyConflict)
+	assert.Contains(s.T(), err.Error(), "in progress")
+
+	s.now = s.now.Add(idempotencyLockTimeout + time.Second)
+	stored, err := s.agent.Begin(s.ctx, s.scope, "key-1", s.command("Widget"))
+	assert.NoError(s.T(), err)
+	assert.Nil(s.T(), stored)
+}
+
+func (s *IdempotencyAgentTestSuite) TestReleaseAllowsRetry() {
+	_, err := s.agent.Begin(s.ctx, s.scope, "key-1", s.command("Widget"))
+	require.NoError(s.T(), err)
+
+	s.agent.Release(s.ctx, s.scope, "key-1")
+
+	stored, err := s.agent.Begin(s.ctx, s.scope, "key-1", s.command("Gadget"))
+	assert.NoError(s.T(), err)
+	assert.Nil(s.T(), stored)
+}
+
+func (s *IdempotencyAgentTestSuite) TestKeysExpireAfterWindow() {
+	_, err := s.agent.Begin(s.ctx, s.scope, "key-1", s.command("Widget"))
+	require.NoError(s.T(), err)
+	s.agent.Complete(s.ctx, s.scope, "key-1", map[string]interface{}{"id": "7"})
+
+	s.now = s.now.Add(time.Hour)
+
+	stored, err := s.agent.Begin(s.ctx, s.scope, "key-1", s.command("Gadget"))
+	assert.NoError(s.T(), err)
+	assert.Nil(s.T(), stored)
+}
+
+func (s *IdempotencyAgentTestSuite) TestKeysAreScopedToUser() {
+	_, err := s.agent.Begin(s.ctx, s.scope, "key-1", s.command("Widget"))
+	require.NoError(s.T(), err)
+
+	others := []*User{
+		{ID: "3", Tenant: "default", Source: SourceToken},
+		{ID: "2", Tenant: "acme", Source: SourceToken},
+		{ID: "2", Tenant: "default", Source: SourceAccount},
+	}
+	for _, other := range others {
+		stored, err := s.agent.Begin(s.ctx, other.key(), "key-1", s.command("Gadget"))
+		assert.NoError(s.T(), err)
+		assert.Nil(s.T(), stored)
+	}
+}
+
+func (s *IdempotencyAgentTestSuite) TestReplaysKeysStoredUnderBareUserIDs() {
+	// Keys stored before scopes named the tenant and identity source are scoped to the user ID
+	store := data.NewTestIdempotencyStore()
+	s.agent.store = store
+	fingerprint, err := commandFingerprint(s.command("Widget"))
+	require.NoError(s.T(), err)
+	legacy := &data.IdempotencyRecord{Scope: "2", Key: "key-1", Fingerprint: fingerprint, CreatedAt: s.now, ExpiresAt: s.now.Add(time.Hour)}
+	_, err = store.Reserve(s.ctx, legacy, s.now)
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), store.Complete(s.ctx, "2", "key-1", []byte(`{"id":"7"}`)))
+
+	stored, err := s.agent.Begin(s.ctx, s.scope, "key-1", s.command("Widget"))
+	require.NoError(s.T(), err)
+	assert.JSONEq(s.T(), `{"id":"7"}`, string(stored))
+	_, err = s.agent.Begin(s.ctx, s.scope, "key-1", s.command("Gadget"))
+	assert.ErrorIs(s.T(), err, ErrIdempotencyConflict)
+
+	s.now = s.now.Add(time.Hour)
+	stored, err = s.agent.Begin(s.ctx, s.scope, "key-1", s.command("Gadget"))
+	assert.NoError(s.T(), err)
+	assert.Nil(s.T(), stored)
+}
+
+func (s *IdempotencyAgentTestSuite) TestValidateIdempotencyKey() {
+	assert.NoError(s.T(), ValidateIdempotencyKey(""))
+	assert.NoError(s.T(), ValidateIdempotencyKey("8e03978e-40d5-43e8-bc93-6894a57f9324"))
+	assert.Error(s.T(), ValidateIdempotencyKey("has space"))
+	assert.Error(s.T(), ValidateIdempotencyKey("ключ"))
+	assert.Error(s.T(), ValidateIdempotencyKey(strings.Repeat("k", maxIdempotencyKeyLength+1)))
+}
+
+func (s *IdempotencyAgentTestSuite) TestEngineReplaysCreate() {
+	engine := NewTestEngine()
+	defer engine.Close()
+
+	query := `create product json:{"name":"Widget","price":10}`
+	opts := RequestOptions{IdempotencyKey: "create-widget"}
+
+	first, err := engine.ProcessRequestWithOptions(s.ctx, query, "admin-token", opts)
+	require.NoError(s.T(), err)
+	assert.False(s.T(), first.Replayed)
+
+	second, err := engine.ProcessRequestWithOptions(s.ctx, query, "admin-token", opts)
+	require.NoError(s.T(), err)
+	assert.True(s.T(), second.Replayed)
+	assert.Equal(s.T(), first.Result.(map[string]interface{})["id"], second.Result.(map[string]interface{})["id"])
+
+	_, err = engine.ProcessRequestWithOptions(s.ctx, `create product json:{"name":"Gadget","price":10}`, "admin-token", opts)
+	assert.ErrorIs(s.T(), err, ErrIdempotencyConflict)
+}
+
+func TestIdempotencyAgentTestSuite(t *testing.T) {
+	suite.Run(t, new(IdempotencyAgentTestSuite))
+}
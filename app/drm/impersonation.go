@@ -0,0 +1,108 @@
+package drm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"drm-app/app/data"
+)
+
+// ImpersonateAction is the permission on user records that lets a user act as the user
+const ImpersonateAction = "impersonate"
+
+var ErrInvalidActAs = errors.New("act_as must name the identity source and user ID, as token:<id> or account:<id>")
+
+// actAs returns the user caller acts as for the request, or caller when actAs is empty. actAs
+// names the user as source:id, since token users and accounts may share IDs. The attempt is
+// logged whether or not it is allowed, and the request is recorded in the impersonation audit
+// once it finishes.
+func (e *Engine) actAs(observer *requestObserver, caller *User, actAs string) (*User, error) {
+	if actAs == "" {
+		return caller, nil
+	}
+	source, id, _ := strings.Cut(actAs, ":")
+
+	observer.userID = id
+	observer.tenant = caller.Tenant
+	observer.impersonatorID = caller.ID
+	observer.impersonation = &data.ImpersonationEntry{
+		Tenant: caller.Tenant, ImpersonatorID: caller.ID, ImpersonatorSource: caller.Source, UserID: id, UserSource: source,
+	}
+	observer.audit = e.Impersonations
+
+	ctx := observer.begin("impersonation")
+	user, err := e.impersonate(data.WithTenant(ctx, caller.Tenant), caller, source, id)
+	if err != nil {
+		slog.WarnContext(ctx, "impersonation denied", "impersonator_id", caller.ID, "user_id", id, "user_source", source, "tenant", caller.Tenant, "error", err)
+		return nil, observer.fail(OutcomeDenied, err)
+	}
+	observer.end(OutcomeSuccess, nil)
+
+	slog.InfoContext(ctx, "impersonation allowed", "impersonator_id", caller.ID, "user_id", user.ID, "tenant", caller.Tenant, "roles", user.Roles)
+	return user, nil
+}
+
+// impersonate checks that caller may act as the user of their tenant with source and id and
+// returns that user. It takes the impersonate permission on the user's record, and users whose
+// roles grant the permission themselves cannot be acted as, so admins cannot act as each other.
+func (e *Engine) impersonate(ctx context.Context, caller *User, source, id string) (*User, error) {
+	if (source != SourceToken && source != SourceAccount) || id == "" {
+		return nil, ErrInvalidActAs
+	}
+
+	command := &data.Command{
+		Action:         ImpersonateAction,
+		Entity:         "user",
+		Data:           map[string]interface{}{"id": id},
+		UserID:         caller.ID,
+		Tenant:         caller.Tenant,
+		UserRoles:      caller.Roles,
+		UserAttributes: caller.Attributes,
+	}
+	if _, err := e.decide(ctx, command); err != nil {
+		return nil, err
+	}
+
+	target, err := e.lookupUser(ctx, caller.Tenant, source, id)
+	if errors.Is(err, data.ErrNotFound) {
+		return nil, fmt.Errorf("%w to act as user %s: no such %s user", ErrAccessDenied, id, source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Any grant counts, inherited or conditional, under the policy of the target's tenant, since
+	// the target would exercise it with records of their own choosing
+	targetCommand := &data.Command{
+		Action:         ImpersonateAction,
+		Entity:         "user",
+		UserID:         target.ID,
+		Tenant:         target.Tenant,
+		UserRoles:      target.Roles,
+		UserAttributes: target.Attributes,
+	}
+	if e.AccessPolicyAgent.Grants(targetCommand).Allowed {
+		return nil, fmt.Errorf("%w to act as user %s: users who may impersonate cannot be impersonated", ErrAccessDenied, id)
+	}
+
+	user := *target
+	user.Impersonator = caller
+	return &user, nil
+}
+
+// lookupUser finds the user of tenant with id among the configured tokens or the accounts
+func (e *Engine) lookupUser(ctx context.Context, tenant, source, id string) (*User, error) {
+	if source == SourceAccount {
+		if e.AccountAgent == nil {
+			return nil, data.ErrNotFound
+		}
+		return e.AccountAgent.LookupUser(ctx, tenant, id)
+	}
+	if user, exists := e.AuthAgent.LookupUser(tenant, id); exists {
+		return user, nil
+	}
+	return nil, data.ErrNotFound
+}
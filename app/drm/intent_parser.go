@@ -3,11 +3,15 @@ package drm
 import (
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"drm-app/app/data"
 )
 
+var knownEntities = map[string]bool{"user": true, "product": true, "order": true}
+
 type IntentParser struct{}
 
 func NewIntentParser() *IntentParser {
@@ -21,28 +25,44 @@ func (p *IntentParser) Parse(query string) (*data.Command, error) {
 		return nil, fmt.Errorf("empty query")
 	}
 
+	if match := aggregatePattern.FindStringSubmatch(query); match != nil {
+		return parseAggregate(match)
+	}
+
+	if match := searchPattern.FindStringSubmatch(query); match != nil {
+		return parseSearch(match)
+	}
+
+	if match := bulkPattern.FindStringSubmatch(query); match != nil {
+		return parseBulk(match)
+	}
+
 	var command data.Command
 
-	if strings.Contains(query, "create") || strings.Contains(query, "add") {
+	// Extract the command part (before json:) and take out the read phrases, whose cursors
+	// and field names could otherwise be mistaken for action or entity keywords
+	commandPart := strings.TrimSpace(query)
+	if jsonIndex := strings.Index(query, "json:"); jsonIndex != -1 {
+		commandPart = strings.TrimSpace(query[:jsonIndex])
+	}
+	commandWords, readOptions := extractReadOptions(strings.Fields(commandPart))
+	commandPart = strings.Join(commandWords, " ")
+
+	if strings.Contains(commandPart, "upsert") {
+		command.Action = "upsert"
+	} else if strings.Contains(commandPart, "create") || strings.Contains(commandPart, "add") {
 		command.Action = "create"
-	} else if strings.Contains(query, "read") || strings.Contains(query, "get") || strings.Contains(query, "list") || strings.Contains(query, "show") {
+	} else if strings.Contains(commandPart, "read") || strings.Contains(commandPart, "get") || strings.Contains(commandPart, "list") || strings.Contains(commandPart, "show") {
 		command.Action = "read"
-	} else if strings.Contains(query, "update") || strings.Contains(query, "modify") || strings.Contains(query, "change") {
+	} else if strings.Contains(commandPart, "update") || strings.Contains(commandPart, "modify") || strings.Contains(commandPart, "change") {
 		command.Action = "update"
-	} else if strings.Contains(query, "delete") || strings.Contains(query, "remove") {
+	} else if strings.Contains(commandPart, "delete") || strings.Contains(commandPart, "remove") {
 		command.Action = "delete"
 	} else {
 		command.Action = "read"
 	}
 
-	// Extract the command part (before json:)
-	commandPart := strings.TrimSpace(query)
-	if jsonIndex := strings.Index(query, "json:"); jsonIndex != -1 {
-		commandPart = strings.TrimSpace(query[:jsonIndex])
-	}
-
 	// Use word boundary detection for more precise matching
-	commandWords := strings.Fields(commandPart)
 	entityFound := false
 
 	for _, word := range commandWords {
@@ -64,6 +84,11 @@ func (p *IntentParser) Parse(query string) (*data.Command, error) {
 	}
 
 	command.Data = make(map[string]interface{})
+	if command.Action == "read" {
+		for key, value := range readOptions {
+			command.Data[key] = value
+		}
+	}
 
 	if strings.Contains(query, "json:") {
 		jsonStart := strings.Index(query, "json:")
@@ -77,3 +102,204 @@ func (p *IntentParser) Parse(query string) (*data.Command, error) {
 
 	return &command, nil
 }
+
+// extractReadOptions removes the read phrases "first|top|limit N", "N <entity>", "cursor <cursor>",
+// "with total", "fields <a,b>" and "expand <a,b>" from the words of a query and returns them as
+// Command.Data keys
+func extractReadOptions(words []string) ([]string, map[string]interface{}) {
+	options := map[string]interface{}{}
+	rest := make([]string, 0, len(words))
+
+	for i := 0; i < len(words); i++ {
+		next := ""
+		if i+1 < len(words) {
+			next = words[i+1]
+		}
+
+		switch word := words[i]; {
+		case (word == "first" || word == "top" || word == "limit") && isCount(next):
+			options[data.LimitKey], _ = strconv.Atoi(next)
+			i++
+		case isCount(word) && entityNames[next] != "":
+			options[data.LimitKey], _ = strconv.Atoi(word)
+		case word == "cursor" && next != "":
+			options[data.CursorKey] = next
+			i++
+		case word == "with" && next == "total":
+			options[data.IncludeTotalKey] = true
+			i++
+		case word == "fields" && next != "":
+			options[data.FieldsKey] = next
+			i++
+		case word == "expand" && next != "":
+			options[data.ExpandKey] = next
+			i++
+		default:
+			rest = append(rest, word)
+		}
+	}
+
+	return rest, options
+}
+
+var entityNames = map[string]string{
+	"user": "user", "users": "user", "product": "product", "products": "product", "order": "order", "orders": "order",
+}
+
+// aggregatePattern matches "<function> [<field> of|in] <entity> [where <conditions>] [group by <field>]"
+var aggregatePattern = regexp.MustCompile(`^(count|sum|avg|average|min|max)\s+(?:(\w+)\s+(?:of|in)\s+)?(\w+)(?:\s+where\s+(.+?))?(?:\s+group\s+by\s+(\w+))?$`)
+
+var conjunctionPattern = regexp.MustCompile(`(?i)\s+and\s+`)
+
+var conditionPattern = regexp.MustCompile(`^(\w+)\s*(!=|>=|<=|=|>|<)\s*(.+)$`)
+
+func parseAggregate(match []string) (*data.Command, error) {
+	entity := entityNames[match[3]]
+	if entity == "" {
+		return nil, fmt.Errorf("unknown entity in query: %s", match[0])
+	}
+
+	function := match[1]
+	if function == "average" {
+		function = "avg"
+	}
+
+	conditions, err := ParseConditions(match[4])
+	if err != nil {
+		return nil, err
+	}
+	aggregation := &data.Aggregation{Function: function, Field: match[2], GroupBy: match[5], Where: conditions}
+
+	return &data.Command{
+		Action:      "aggregate",
+		Entity:      entity,
+		Data:        make(map[string]interface{}),
+		Aggregation: aggregation,
+	}, nil
+}
+
+// ParseConditions reads "<field> <op> <value> [and ...]" as used after "where" in queries
+func ParseConditions(text string) ([]data.Condition, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+
+	var conditions []data.Condition
+	for _, part := range conjunctionPattern.Split(text, -1) {
+		condition := conditionPattern.FindStringSubmatch(strings.TrimSpace(part))
+		if condition == nil {
+			return nil, fmt.Errorf("invalid condition: %s", part)
+		}
+		conditions = append(conditions, data.Condition{
+			Field: condition[1],
+			Op:    condition[2],
+			Value: conditionValue(condition[3]),
+		})
+	}
+	return conditions, nil
+}
+
+// conditionValue unquotes a condition value and reads numbers as numbers
+func conditionValue(value string) interface{} {
+	value = strings.Trim(strings.TrimSpace(value), `"'`)
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		return number
+	}
+	return value
+}
+
+// searchPattern matches "search <entity> [for] <text> [paging] [json:{...}]"
+var searchPattern = regexp.MustCompile(`^search\s+(\w+)(?:\s+for)?(?:\s+(.*?))?\s*(json:.*)?$`)
+
+var quotedPattern = regexp.MustCompile(`^(?:"([^"]*)"|'([^']*)')`)
+
+// parseSearch reads the search text, either quoted or the words left after the paging phrases
+func parseSearch(match []string) (*data.Command, error) {
+	entity := entityNames[match[1]]
+	if entity == "" {
+		return nil, fmt.Errorf("unknown entity in query: %s", match[0])
+	}
+
+	rest := match[2]
+	text := ""
+	if quoted := quotedPattern.FindStringSubmatch(rest); quoted != nil {
+		text = quoted[1] + quoted[2]
+		rest = rest[len(quoted[0]):]
+	}
+	words, options := extractReadOptions(strings.Fields(rest))
+	if text == "" {
+		text = strings.Join(words, " ")
+	}
+
+	command := &data.Command{Action: "search", Entity: entity, Data: options}
+	if text != "" {
+		command.Data[data.SearchKey] = text
+	}
+
+	if match[3] != "" {
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(match[3], "json:")), &command.Data); err != nil {
+			return nil, fmt.Errorf("invalid JSON data: %w", err)
+		}
+	}
+
+	return command, nil
+}
+
+// bulkPattern matches "update|delete <entity> where <conditions> [set <field = value, ...>]
+// [confirm N] [json:{...}]"
+var bulkPattern = regexp.MustCompile(`^(update|modify|change|delete|remove)\s+(\w+)\s+where\s+(.+?)(?:\s+set\s+(.+?))?(?:\s+confirm\s+(\d+))?\s*(json:.*)?$`)
+
+var assignmentPattern = regexp.MustCompile(`^\s*(\w+)\s*=\s*("[^"]*"|'[^']*'|[^,]*?)\s*(?:,|$)`)
+
+func parseBulk(match []string) (*data.Command, error) {
+	entity := entityNames[match[2]]
+	if entity == "" {
+		return nil, fmt.Errorf("unknown entity in query: %s", match[0])
+	}
+
+	conditions, err := ParseConditions(match[3])
+	if err != nil {
+		return nil, err
+	}
+
+	command := &data.Command{
+		Action: "bulk_update",
+		Entity: entity,
+		Data:   make(map[string]interface{}),
+		Bulk:   &data.Bulk{Where: conditions},
+	}
+	if match[1] == "delete" || match[1] == "remove" {
+		command.Action = "bulk_delete"
+		if match[4] != "" || match[6] != "" {
+			return nil, fmt.Errorf("bulk delete takes no values to set: %s", match[0])
+		}
+	}
+
+	for rest := match[4]; rest != ""; {
+		assignment := assignmentPattern.FindStringSubmatch(rest)
+		if assignment == nil {
+			return nil, fmt.Errorf("invalid assignment: %s", rest)
+		}
+		command.Data[assignment[1]] = conditionValue(assignment[2])
+		rest = rest[len(assignment[0]):]
+	}
+
+	if match[5] != "" {
+		confirm, _ := strconv.Atoi(match[5])
+		command.Bulk.Confirm = &confirm
+	}
+
+	if match[6] != "" {
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(match[6], "json:")), &command.Data); err != nil {
+			return nil, fmt.Errorf("invalid JSON data: %w", err)
+		}
+	}
+
+	return command, nil
+}
+
+func isCount(word string) bool {
+	n, err := strconv.Atoi(word)
+	return err == nil && n > 0
+}
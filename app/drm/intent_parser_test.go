@@ -3,6 +3,7 @@ package drm
 import (
 	"testing"
 
+	"drm-app/app/data"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
@@ -144,6 +145,152 @@ func (s *IntentParserTestSuite) TestParseWhitespaceQuery() {
 	assert.Contains(s.T(), err.Error(), "empty query")
 }
 
+func (s *IntentParserTestSuite) TestParsePaging() {
+	command, err := s.parser.Parse("list 10 products with total")
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "read", command.Action)
+	assert.Equal(s.T(), "product", command.Entity)
+	assert.Equal(s.T(), 10, command.Data["limit"])
+	assert.Equal(s.T(), true, command.Data["include_total"])
+
+	command, err = s.parser.Parse("show first 5 orders")
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "order", command.Entity)
+	assert.Equal(s.T(), 5, command.Data["limit"])
+}
+
+func (s *IntentParserTestSuite) TestParseCursorIsNotReadAsAction() {
+	command, err := s.parser.Parse("list users cursor K5UPDATEADDQ")
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "read", command.Action)
+	assert.Equal(s.T(), "user", command.Entity)
+	assert.Equal(s.T(), "k5updateaddq", command.Data["cursor"])
+}
+
+func (s *IntentParserTestSuite) TestParsePagingIgnoredForWrites() {
+	command, err := s.parser.Parse("create 2 products json:{\"name\":\"pen\",\"price\":1}")
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "create", command.Action)
+	assert.NotContains(s.T(), command.Data, "limit")
+}
+
+func (s *IntentParserTestSuite) TestParseFieldsAndExpand() {
+	command, err := s.parser.Parse("list orders fields id,status expand user,items.product")
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "read", command.Action)
+	assert.Equal(s.T(), "order", command.Entity)
+	assert.Equal(s.T(), "id,status", command.Data["fields"])
+	assert.Equal(s.T(), "user,items.product", command.Data["expand"])
+}
+
+func (s *IntentParserTestSuite) TestParseCount() {
+	command, err := s.parser.Parse("count orders where status = pending and total_amount > 100")
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "aggregate", command.Action)
+	assert.Equal(s.T(), "order", command.Entity)
+	assert.Equal(s.T(), &data.Aggregation{
+		Function: "count",
+		Where: []data.Condition{
+			{Field: "status", Op: "=", Value: "pending"},
+			{Field: "total_amount", Op: ">", Value: float64(100)},
+		},
+	}, command.Aggregation)
+}
+
+func (s *IntentParserTestSuite) TestParseGroupedAggregate() {
+	command, err := s.parser.Parse("Sum total_amount of orders where created_at >= '2025-03-10' group by status")
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "aggregate", command.Action)
+	assert.Equal(s.T(), "order", command.Entity)
+	assert.Equal(s.T(), &data.Aggregation{
+		Function: "sum",
+		Field:    "total_amount",
+		GroupBy:  "status",
+		Where:    []data.Condition{{Field: "created_at", Op: ">=", Value: "2025-03-10"}},
+	}, command.Aggregation)
+
+	command, err = s.parser.Parse("average price in products")
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), &data.Aggregation{Function: "avg", Field: "price"}, command.Aggregation)
+}
+
+func (s *IntentParserTestSuite) TestParseSearch() {
+	command, err := s.parser.Parse(`Search products "Wireless Mouse" first 5 with total`)
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "search", command.Action)
+	assert.Equal(s.T(), "product", command.Entity)
+	assert.Equal(s.T(), map[string]interface{}{
+		data.SearchKey:       "wireless mouse",
+		data.LimitKey:        5,
+		data.IncludeTotalKey: true,
+	}, command.Data)
+
+	command, err = s.parser.Parse("search products for laptop cursor K5UPDATEADDQ")
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), map[string]interface{}{
+		data.SearchKey: "laptop",
+		data.CursorKey: "k5updateaddq",
+	}, command.Data)
+
+	command, err = s.parser.Parse(`search products json:{"q":"gaming keyboard"}`)
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "gaming keyboard", command.Data[data.SearchKey])
+}
+
+func (s *IntentParserTestSuite) TestParseUpsert() {
+	command, err := s.parser.Parse(`upsert product json:{"name":"mouse","price":25}`)
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "upsert", command.Action)
+	assert.Equal(s.T(), "product", command.Entity)
+	assert.Equal(s.T(), map[string]interface{}{"name": "mouse", "price": float64(25)}, command.Data)
+}
+
+func (s *IntentParserTestSuite) TestParseBulkUpdate() {
+	command, err := s.parser.Parse("update orders where status = pending and total_amount < 50 set status = 'on hold', total_amount = 0 confirm 3")
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "bulk_update", command.Action)
+	assert.Equal(s.T(), "order", command.Entity)
+	assert.Equal(s.T(), map[string]interface{}{"status": "on hold", "total_amount": float64(0)}, command.Data)
+	confirm := 3
+	assert.Equal(s.T(), &data.Bulk{
+		Where: []data.Condition{
+			{Field: "status", Op: "=", Value: "pending"},
+			{Field: "total_amount", Op: "<", Value: float64(50)},
+		},
+		Confirm: &confirm,
+	}, command.Bulk)
+
+	command, err = s.parser.Parse(`update products where price > 100 json:{"description":"premium"}`)
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), map[string]interface{}{"description": "premium"}, command.Data)
+	assert.Nil(s.T(), command.Bulk.Confirm)
+}
+
+func (s *IntentParserTestSuite) TestParseBulkDelete() {
+	command, err := s.parser.Parse("delete orders where status = cancelled")
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "bulk_delete", command.Action)
+	assert.Equal(s.T(), []data.Condition{{Field: "status", Op: "=", Value: "cancelled"}}, command.Bulk.Where)
+
+	_, err = s.parser.Parse("delete orders where status = cancelled set status = gone")
+	assert.ErrorContains(s.T(), err, "bulk delete takes no values to set")
+}
+
 func TestIntentParserTestSuite(t *testing.T) {
 	suite.Run(t, new(IntentParserTestSuite))
 }
\ No newline at end of file
@@ -36,12 +36,26 @@ func (l *LogicAgent) loadRules() {
 }
 
 func (l *LogicAgent) ValidateCommand(command *data.Command) error {
+	action := command.Action
+	switch action {
+	case "aggregate":
+		return data.ValidateAggregation(command.Entity, command.Aggregation)
+	case "bulk_update", "bulk_delete":
+		return data.ValidateBulk(command.Entity, action, command.Bulk, command.Data)
+	case "upsert":
+		if err := data.ValidateUpsert(command.Entity, command.Data); err != nil {
+			return err
+		}
+		// An upsert carries a full record, as it may create one
+		action = "create"
+	}
+
 	entityRules, exists := l.rules[command.Entity]
 	if !exists {
 		return nil
 	}
 	
-	validator, exists := entityRules[command.Action]
+	validator, exists := entityRules[action]
 	if !exists {
 		return nil
 	}
@@ -235,6 +235,29 @@ func (s *LogicAgentTestSuite) TestValidateReadAction() {
 	assert.NoError(s.T(), err)
 }
 
+func (s *LogicAgentTestSuite) TestValidateAggregate() {
+	command := &data.Command{
+		Action: "aggregate",
+		Entity: "order",
+		Aggregation: &data.Aggregation{
+			Function: "sum",
+			Field:    "total_amount",
+			GroupBy:  "status",
+			Where:    []data.Condition{{Field: "created_at", Op: ">=", Value: "2025-03-10"}},
+		},
+	}
+	assert.NoError(s.T(), s.agent.ValidateCommand(command))
+
+	command.Aggregation.Field = "status"
+	assert.ErrorContains(s.T(), s.agent.ValidateCommand(command), "sum needs a numeric field of order")
+
+	command.Aggregation = &data.Aggregation{Function: "count", Where: []data.Condition{{Field: "items", Op: "=", Value: "x"}}}
+	assert.ErrorContains(s.T(), s.agent.ValidateCommand(command), `order cannot be filtered by "items"`)
+
+	command.Aggregation = &data.Aggregation{Function: "count", Where: []data.Condition{{Field: "total_amount", Op: ">", Value: "lots"}}}
+	assert.ErrorIs(s.T(), s.agent.ValidateCommand(command), data.ErrInvalidFilter)
+}
+
 func TestLogicAgentTestSuite(t *testing.T) {
 	suite.Run(t, new(LogicAgentTestSuite))
 }
\ No newline at end of file
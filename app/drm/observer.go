@@ -0,0 +1,186 @@
+package drm
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"drm-app/app/data"
+	"drm-app/app/logging"
+	"drm-app/app/metrics"
+	"drm-app/app/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	OutcomeSuccess         = "success"
+	OutcomeUnauthenticated = "unauthenticated"
+	OutcomeInvalidQuery    = "invalid_query"
+	OutcomeRateLimited     = "rate_limited"
+	OutcomeConflict        = "conflict"
+	OutcomeReplayed        = "replayed"
+	OutcomeDenied          = "denied"
+	OutcomeInvalid         = "invalid"
+	OutcomeFailed          = "failed"
+	OutcomeExplained       = "explained"
+)
+
+const unknownLabel = "unknown"
+
+// requestObserver records metrics, trace spans and logs for one ProcessRequest call: a root
+// span for the request, a child span plus stage histogram sample per stage and a log line
+// once the request completes. Entity and action stay "unknown" until the query has been parsed.
+type requestObserver struct {
+	ctx        context.Context
+	span       trace.Span
+	start      time.Time
+	stageName  string
+	stageStart time.Time
+	stageSpan  trace.Span
+	entity     string
+	action     string
+	userID     string
+	userRole   string
+	tenant     string
+	// impersonatorID is the user acting as userID, if any
+	impersonatorID string
+	// impersonation is the audit entry of a request made while acting as another user, written
+	// to audit once the request finishes
+	impersonation *data.ImpersonationEntry
+	audit         data.ImpersonationAuditStore
+}
+
+func observeRequest(ctx context.Context) (context.Context, *requestObserver) {
+	ctx, span := tracing.Tracer().Start(ctx, "Engine.ProcessRequest")
+	return ctx, &requestObserver{
+		ctx:    ctx,
+		span:   span,
+		start:  time.Now(),
+		entity: unknownLabel,
+		action: unknownLabel,
+	}
+}
+
+// describe labels the request with its command and limits the data access of its stages to
+// the command's tenant
+func (o *requestObserver) describe(command *data.Command) {
+	if knownEntities[command.Entity] {
+		o.entity = command.Entity
+	}
+	if command.Action != "" {
+		o.action = command.Action
+	}
+	o.userID = command.UserID
+	o.userRole = strings.Join(command.UserRoles, ",")
+	o.tenant = command.Tenant
+	o.impersonatorID = command.ImpersonatorID
+	o.ctx = data.WithTenant(o.ctx, command.Tenant)
+
+	slog.DebugContext(o.ctx, "command parsed",
+		"entity", command.Entity,
+		"action", command.Action,
+		"user_id", command.UserID,
+		"tenant", command.Tenant,
+		"role", o.userRole,
+		"impersonator_id", command.ImpersonatorID,
+		"data", logging.Redact(command.Data),
+	)
+	o.span.SetAttributes(
+		attribute.String("drm.entity", o.entity),
+		attribute.String("drm.action", o.action),
+		attribute.String("drm.user.id", command.UserID),
+		attribute.String("drm.tenant", command.Tenant),
+		attribute.String("drm.user.role", o.userRole),
+	)
+	if command.ImpersonatorID != "" {
+		o.span.SetAttributes(attribute.String("drm.impersonator.id", command.ImpersonatorID))
+	}
+}
+
+// begin starts the named stage and returns the context its work should run with
+func (o *requestObserver) begin(name string) context.Context {
+	ctx, span := tracing.Tracer().Start(o.ctx, "Engine."+name)
+	o.stageName = name
+	o.stageStart = time.Now()
+	o.stageSpan = span
+	return ctx
+}
+
+// end closes the current stage
+func (o *requestObserver) end(outcome string, err error) {
+	metrics.EngineStageDuration.WithLabelValues(o.stageName, o.entity, o.action, outcome).Observe(time.Since(o.stageStart).Seconds())
+
+	o.stageSpan.SetAttributes(attribute.String("drm.outcome", outcome))
+	tracing.RecordError(o.stageSpan, err)
+	o.stageSpan.End()
+}
+
+// fail closes the current stage and the request with the given outcome and returns err
+func (o *requestObserver) fail(outcome string, err error) error {
+	o.end(outcome, err)
+	o.finish(outcome, err)
+	return err
+}
+
+func (o *requestObserver) finish(outcome string, err error) {
+	metrics.EngineRequests.WithLabelValues(o.entity, o.action, outcome).Inc()
+	metrics.EngineRequestDuration.WithLabelValues(o.entity, o.action, outcome).Observe(time.Since(o.start).Seconds())
+
+	o.log(outcome, err)
+	if o.impersonation != nil && o.audit != nil {
+		o.recordImpersonation(outcome, err)
+	}
+
+	o.span.SetAttributes(attribute.String("drm.outcome", outcome))
+	tracing.RecordError(o.span, err)
+	o.span.End()
+}
+
+func (o *requestObserver) log(outcome string, err error) {
+	level := slog.LevelWarn
+	switch outcome {
+	case OutcomeSuccess, OutcomeReplayed, OutcomeExplained:
+		level = slog.LevelInfo
+	case OutcomeFailed:
+		level = slog.LevelError
+	}
+
+	attrs := []slog.Attr{
+		slog.String("entity", o.entity),
+		slog.String("action", o.action),
+		slog.String("outcome", outcome),
+		slog.Float64("duration_ms", float64(time.Since(o.start).Microseconds())/1000),
+	}
+	if o.userID != "" {
+		attrs = append(attrs, slog.String("user_id", o.userID), slog.String("tenant", o.tenant), slog.String("role", o.userRole))
+	}
+	if o.impersonatorID != "" {
+		attrs = append(attrs, slog.String("impersonator_id", o.impersonatorID))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	slog.LogAttrs(o.ctx, level, "request processed", attrs...)
+}
+
+// recordImpersonation writes the audit entry of an impersonated request before its response is
+// sent, even when the caller has gone away. Failures are logged and counted.
+func (o *requestObserver) recordImpersonation(outcome string, err error) {
+	entry := o.impersonation
+	entry.Entity = o.entity
+	entry.Action = o.action
+	entry.Stage = o.stageName
+	entry.Outcome = outcome
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	entry.RequestID = logging.RequestID(o.ctx)
+	entry.CreatedAt = time.Now().UTC()
+
+	if err := o.audit.RecordImpersonation(context.WithoutCancel(o.ctx), entry); err != nil {
+		slog.ErrorContext(o.ctx, "failed to record impersonation", "impersonator_id", entry.ImpersonatorID, "user_id", entry.UserID, "error", err)
+		metrics.ImpersonationAuditErrors.Inc()
+	}
+}
@@ -0,0 +1,127 @@
+package drm
+
+import (
+	"context"
+	"testing"
+
+	"drm-app/app/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type MetricsTestSuite struct {
+	suite.Suite
+	engine *Engine
+	ctx    context.Context
+}
+
+func (s *MetricsTestSuite) SetupTest() {
+	s.engine = NewTestEngine()
+	s.ctx = context.Background()
+}
+
+func (s *MetricsTestSuite) TearDownTest() {
+	s.engine.Close()
+}
+
+func (s *MetricsTestSuite) TestSuccessfulRequestIsCounted() {
+	counter := metrics.EngineRequests.WithLabelValues("product", "read", OutcomeSuccess)
+	before := testutil.ToFloat64(counter)
+
+	_, err := s.engine.ProcessRequest(s.ctx, "list products", "guest-token")
+
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), before+1, testutil.ToFloat64(counter))
+}
+
+func (s *MetricsTestSuite) TestDeniedRequestStopsAtPolicyStage() {
+	counter := metrics.EngineRequests.WithLabelValues("user", "delete", OutcomeDenied)
+	before := testutil.ToFloat64(counter)
+
+	_, err := s.engine.ProcessRequest(s.ctx, "delete user json:{\"id\":\"1\"}", "guest-token")
+
+	assert.Error(s.T(), err)
+	assert.Equal(s.T(), before+1, testutil.ToFloat64(counter))
+
+	stages := testutil.CollectAndCount(metrics.EngineStageDuration, "drm_engine_stage_duration_seconds")
+	assert.Greater(s.T(), stages, 0)
+}
+
+func (s *MetricsTestSuite) TestUnauthenticatedRequestHasUnknownLabels() {
+	counter := metrics.EngineRequests.WithLabelValues(unknownLabel, unknownLabel, OutcomeUnauthenticated)
+	before := testutil.ToFloat64(counter)
+
+	_, err := s.engine.ProcessRequest(s.ctx, "list products", "invalid-token")
+
+	assert.Error(s.T(), err)
+	assert.Equal(s.T(), before+1, testutil.ToFloat64(counter))
+}
+
+func TestMetricsTestSuite(t *testing.T) {
+	suite.Run(t, new(MetricsTestSuite))
+}
+
+type TracingTestSuite struct {
+	suite.Suite
+	engine   *Engine
+	recorder *tracetest.SpanRecorder
+	previous trace.TracerProvider
+}
+
+func (s *TracingTestSuite) SetupTest() {
+	s.recorder = tracetest.NewSpanRecorder()
+	s.previous = otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(s.recorder)))
+	s.engine = NewTestEngine()
+}
+
+func (s *TracingTestSuite) TearDownTest() {
+	s.engine.Close()
+	otel.SetTracerProvider(s.previous)
+}
+
+func (s *TracingTestSuite) spansByName() map[string]sdktrace.ReadOnlySpan {
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range s.recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	return spans
+}
+
+func (s *TracingTestSuite) TestEveryStageIsAChildSpan() {
+	_, err := s.engine.ProcessRequest(context.Background(), "list products", "guest-token")
+	assert.NoError(s.T(), err)
+
+	spans := s.spansByName()
+	root, ok := spans["Engine.ProcessRequest"]
+	assert.True(s.T(), ok)
+
+	for _, stage := range []string{"auth", "parse", "policy", "validation", "execution"} {
+		span, ok := spans["Engine."+stage]
+		if assert.True(s.T(), ok, stage) {
+			assert.Equal(s.T(), root.SpanContext().SpanID(), span.Parent().SpanID(), stage)
+		}
+	}
+	assert.Contains(s.T(), root.Attributes(), attribute.String("drm.entity", "product"))
+}
+
+func (s *TracingTestSuite) TestFailedStageIsMarkedAsError() {
+	_, err := s.engine.ProcessRequest(context.Background(), "delete user json:{\"id\":\"1\"}", "guest-token")
+	assert.Error(s.T(), err)
+
+	spans := s.spansByName()
+	assert.Equal(s.T(), codes.Error, spans["Engine.policy"].Status().Code)
+	assert.Equal(s.T(), codes.Error, spans["Engine.ProcessRequest"].Status().Code)
+	assert.NotContains(s.T(), spans, "Engine.validation")
+}
+
+func TestTracingTestSuite(t *testing.T) {
+	suite.Run(t, new(TracingTestSuite))
+}
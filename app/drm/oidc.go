@@ -0,0 +1,315 @@
+package drm
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// idTokenLeeway is the clock skew tolerated between the provider and us
+const idTokenLeeway = time.Minute
+
+// oidcProvider talks to an OpenID Connect provider: it reads the discovery document, exchanges
+// authorization codes and verifies ID tokens with the provider's signing keys. Both the
+// document and the keys are fetched on first use, so the server starts while the provider is down.
+type oidcProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	client       *http.Client
+
+	mu          sync.Mutex
+	metadata    *oidcMetadata
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+}
+
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// idTokenClaims are the claims of an ID token we read. Claims keeps all of them for the groups
+// claim, whose name is configured.
+type idTokenClaims struct {
+	Issuer            string         `json:"iss"`
+	Subject           string         `json:"sub"`
+	Audience          audience       `json:"aud"`
+	AuthorizedParty   string         `json:"azp"`
+	Expiry            float64        `json:"exp"`
+	IssuedAt          float64        `json:"iat"`
+	Nonce             string         `json:"nonce"`
+	Name              string         `json:"name"`
+	PreferredUsername string         `json:"preferred_username"`
+	Email             string         `json:"email"`
+	EmailVerified     claimBool      `json:"email_verified"`
+	Claims            map[string]any `json:"-"`
+}
+
+// audience is the aud claim, which is either a single client ID or a list of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(raw []byte) error {
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	return json.Unmarshal(raw, (*[]string)(a))
+}
+
+// claimBool is a boolean claim, which some providers send as a string
+type claimBool bool
+
+func (b *claimBool) UnmarshalJSON(raw []byte) error {
+	var value bool
+	if err := json.Unmarshal(raw, &value); err == nil {
+		*b = claimBool(value)
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return err
+	}
+	*b = claimBool(text == "true")
+	return nil
+}
+
+func newOIDCProvider(issuer, clientID, clientSecret, redirectURL string) *oidcProvider {
+	return &oidcProvider{
+		issuer:       issuer,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// discover returns the provider's discovery document
+func (p *oidcProvider) discover(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var metadata oidcMetadata
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.issuer, "/")+"/.well-known/openid-configuration", &metadata); err != nil {
+		return nil, fmt.Errorf("failed to discover provider: %w", err)
+	}
+	if metadata.Issuer != p.issuer {
+		return nil, fmt.Errorf("provider reports issuer %q instead of %q", metadata.Issuer, p.issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("provider discovery document lacks an authorization, token or jwks endpoint")
+	}
+
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+// exchange trades an authorization code and its PKCE verifier for the ID token
+func (p *oidcProvider) exchange(ctx context.Context, code, verifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL},
+		"client_id":     {p.clientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to read token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %d: %s %s", resp.StatusCode, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", fmt.Errorf("token response has no id_token")
+	}
+	return body.IDToken, nil
+}
+
+// verify checks the signature and claims of an ID token issued for us in answer to the
+// sign-in with nonce
+func (p *oidcProvider) verify(ctx context.Context, token, nonce string, now time.Time) (*idTokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("ID token is not a JWT")
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid ID token header: %w", err)
+	}
+	if header.Algorithm != "RS256" {
+		return nil, fmt.Errorf("ID token algorithm %q is not supported", header.Algorithm)
+	}
+
+	key, err := p.key(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token signature: %w", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("ID token signature does not verify")
+	}
+
+	var claims idTokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid ID token claims: %w", err)
+	}
+	if err := decodeSegment(parts[1], &claims.Claims); err != nil {
+		return nil, fmt.Errorf("invalid ID token claims: %w", err)
+	}
+
+	switch {
+	case claims.Issuer != p.issuer:
+		return nil, fmt.Errorf("ID token issuer %q is not %q", claims.Issuer, p.issuer)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("ID token has no subject")
+	case !slices.Contains(claims.Audience, p.clientID):
+		return nil, fmt.Errorf("ID token is not issued for this client")
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.clientID:
+		return nil, fmt.Errorf("ID token is authorized for %q", claims.AuthorizedParty)
+	case !now.Before(unixTime(claims.Expiry).Add(idTokenLeeway)):
+		return nil, fmt.Errorf("ID token has expired")
+	case unixTime(claims.IssuedAt).After(now.Add(idTokenLeeway)):
+		return nil, fmt.Errorf("ID token is issued in the future")
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("ID token nonce does not match the sign-in")
+	}
+	return &claims, nil
+}
+
+// key returns the provider's signing key with id, fetching the keys again when it is unknown
+// since providers rotate them. Refetches are at most a minute apart so forged key IDs cannot
+// make us hammer the provider.
+func (p *oidcProvider) key(ctx context.Context, id string) (*rsa.PublicKey, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, exists := p.keys[id]; exists {
+		return key, nil
+	}
+	if p.keys != nil && time.Since(p.keysFetched) < time.Minute {
+		return nil, fmt.Errorf("ID token is signed with unknown key %q", id)
+	}
+
+	var set struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			Use     string `json:"use"`
+			KeyID   string `json:"kid"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, metadata.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch provider keys: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.KeyType != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			continue
+		}
+		keys[jwk.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	p.keys = keys
+	p.keysFetched = time.Now()
+
+	if key, exists := p.keys[id]; exists {
+		return key, nil
+	}
+	return nil, fmt.Errorf("ID token is signed with unknown key %q", id)
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, target string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", target, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func decodeSegment(segment string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(int64(seconds), 0)
+}
@@ -0,0 +1,173 @@
+package drm
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"drm-app/app/config"
+	"drm-app/app/data"
+)
+
+// oidcLoginTimeout is how long a user has to sign in at the provider and come back
+const oidcLoginTimeout = 10 * time.Minute
+
+var (
+	ErrOIDCNotConfigured = errors.New("OIDC sign-in is not configured")
+	ErrOIDCLogin         = errors.New("OIDC sign-in failed")
+	ErrOIDCProvider      = errors.New("OIDC provider is unavailable")
+	ErrNoRole            = errors.New("no role is mapped to the user's groups")
+)
+
+// OIDCAgent signs users in through an OpenID Connect provider with the authorization code flow
+// and PKCE, provisions their user record on first sign-in and opens a session for them with the
+// roles their groups map to. Sign-ins waiting for their callback are kept in the store, so the
+// provider may send the user back to any instance.
+type OIDCAgent struct {
+	provider *oidcProvider
+	store    data.CredentialStore
+	accounts *AccountAgent
+	cfg      config.OIDCConfig
+	now      func() time.Time
+}
+
+func NewOIDCAgent(cfg config.OIDCConfig, store data.CredentialStore, accounts *AccountAgent) *OIDCAgent {
+	return &OIDCAgent{
+		provider: newOIDCProvider(cfg.Issuer, cfg.ClientID, cfg.ClientSecret, cfg.RedirectURL),
+		store:    store,
+		accounts: accounts,
+		cfg:      cfg,
+		now:      time.Now,
+	}
+}
+
+// AuthorizationURL starts a sign-in and returns the provider URL to send the user to
+func (a *OIDCAgent) AuthorizationURL(ctx context.Context) (string, error) {
+	metadata, err := a.provider.discover(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrOIDCProvider, err)
+	}
+	endpoint, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid authorization endpoint: %w", ErrOIDCProvider, err)
+	}
+
+	login := data.PendingLogin{ExpiresAt: a.now().Add(oidcLoginTimeout)}
+	for _, value := range []*string{&login.State, &login.Verifier, &login.Nonce} {
+		if *value, err = newToken(""); err != nil {
+			return "", err
+		}
+	}
+	if err := a.store.SavePendingLogin(ctx, &login); err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	query := endpoint.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", a.cfg.ClientID)
+	query.Set("redirect_uri", a.cfg.RedirectURL)
+	query.Set("scope", strings.Join(a.cfg.Scopes, " "))
+	query.Set("state", login.State)
+	query.Set("nonce", login.Nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	endpoint.RawQuery = query.Encode()
+	return endpoint.String(), nil
+}
+
+// Callback completes the sign-in of state with the authorization code the provider returned and
+// opens a session for the user
+func (a *OIDCAgent) Callback(ctx context.Context, code, state string) (*SessionTokens, error) {
+	now := a.now()
+	login, err := a.store.TakePendingLogin(ctx, state, now)
+	if errors.Is(err, data.ErrNotFound) {
+		return nil, fmt.Errorf("%w: unknown or expired state", ErrOIDCLogin)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if code == "" {
+		return nil, fmt.Errorf("%w: no authorization code", ErrOIDCLogin)
+	}
+
+	idToken, err := a.provider.exchange(ctx, code, login.Verifier)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrOIDCLogin, err)
+	}
+	claims, err := a.provider.verify(ctx, idToken, login.Nonce, now)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrOIDCLogin, err)
+	}
+
+	roles := a.roles(claims)
+	if len(roles) == 0 {
+		slog.WarnContext(ctx, "OIDC sign-in refused without a role", "subject", claims.Subject, "email", claims.Email)
+		return nil, ErrNoRole
+	}
+
+	identity := &data.Identity{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Tenant:        a.cfg.Tenant,
+		Name:          cmp.Or(claims.Name, claims.PreferredUsername, claims.Email, claims.Subject),
+		Email:         strings.ToLower(claims.Email),
+		EmailVerified: bool(claims.EmailVerified),
+	}
+	if err := a.store.ProvisionIdentity(ctx, identity); err != nil {
+		return nil, err
+	}
+
+	return a.accounts.OpenSession(ctx, &User{ID: identity.UserID, Name: identity.Name, Tenant: identity.Tenant, Roles: roles, Source: SourceAccount})
+}
+
+// roles maps the groups of the ID token to roles, or falls back to the default role
+func (a *OIDCAgent) roles(claims *idTokenClaims) []string {
+	var roles []string
+	for _, group := range groups(claims.Claims, a.cfg.GroupsClaim) {
+		for _, role := range a.cfg.Roles[group] {
+			if !slices.Contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	if len(roles) == 0 && a.cfg.DefaultRole != "" {
+		roles = []string{a.cfg.DefaultRole}
+	}
+	return roles
+}
+
+// groups reads the groups claim, which may be nested in objects with a dotted name such as
+// realm_access.roles and holds a list of groups or a single one
+func groups(claims map[string]any, name string) []string {
+	var value any = claims
+	for _, key := range strings.Split(name, ".") {
+		object, isObject := value.(map[string]any)
+		if !isObject {
+			return nil
+		}
+		value = object[key]
+	}
+
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []any:
+		groups := make([]string, 0, len(value))
+		for _, group := range value {
+			if group, isString := group.(string); isString {
+				groups = append(groups, group)
+			}
+		}
+		return groups
+	}
+	return nil
+}
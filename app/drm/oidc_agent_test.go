@@ -0,0 +1,169 @@
+package drm
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	"drm-app/app/config"
+	"drm-app/app/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type OIDCAgentTestSuite struct {
+	suite.Suite
+	agent *OIDCAgent
+	store *data.TestCredentialStore
+	key   *rsa.PrivateKey
+	now   time.Time
+}
+
+func (s *OIDCAgentTestSuite) SetupSuite() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(s.T(), err)
+	s.key = key
+}
+
+func (s *OIDCAgentTestSuite) SetupTest() {
+	s.now = time.Date(2025, 3, 14, 12, 0, 0, 0, time.UTC)
+
+	cfg := config.Default().Auth.OIDC
+	cfg.Enabled = true
+	cfg.Issuer = "https://id.example.com"
+	cfg.ClientID = "drm"
+	cfg.RedirectURL = "https://drm.example.com/auth/oidc/callback"
+	cfg.Roles = map[string][]string{"drm-admins": {"admin", "user"}, "drm-users": {"user"}}
+
+	s.store = data.NewTestCredentialStore(data.NewTestDataAgent())
+	s.agent = NewOIDCAgent(cfg, s.store, NewAccountAgent(s.store, config.Default().Auth.Accounts))
+	s.agent.now = func() time.Time { return s.now }
+
+	// Provide the provider's keys up front so verification needs no network
+	s.agent.provider.metadata = &oidcMetadata{Issuer: cfg.Issuer}
+	s.agent.provider.keys = map[string]*rsa.PublicKey{"k1": &s.key.PublicKey}
+	s.agent.provider.keysFetched = time.Now()
+}
+
+func (s *OIDCAgentTestSuite) sign(kid string, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	require.NoError(s.T(), err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (s *OIDCAgentTestSuite) claims() map[string]any {
+	return map[string]any{
+		"iss":   "https://id.example.com",
+		"sub":   "ada-1815",
+		"aud":   "drm",
+		"iat":   s.now.Unix(),
+		"exp":   s.now.Add(5 * time.Minute).Unix(),
+		"nonce": "n-0S6",
+	}
+}
+
+func (s *OIDCAgentTestSuite) verify(token string) (*idTokenClaims, error) {
+	return s.agent.provider.verify(context.Background(), token, "n-0S6", s.now)
+}
+
+func (s *OIDCAgentTestSuite) TestVerifiesIDToken() {
+	claims := s.claims()
+	claims["email_verified"] = "true"
+	verified, err := s.verify(s.sign("k1", claims))
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "ada-1815", verified.Subject)
+	assert.True(s.T(), bool(verified.EmailVerified))
+
+	// Tokens for several clients must be authorized for us
+	claims["aud"] = []string{"drm", "other"}
+	_, err = s.verify(s.sign("k1", claims))
+	assert.ErrorContains(s.T(), err, "authorized for")
+	claims["azp"] = "drm"
+	_, err = s.verify(s.sign("k1", claims))
+	assert.NoError(s.T(), err)
+}
+
+func (s *OIDCAgentTestSuite) TestRejectsInvalidIDTokens() {
+	invalid := map[string]func(map[string]any){
+		"issuer":                     func(c map[string]any) { c["iss"] = "https://evil.example.com" },
+		"not issued for this client": func(c map[string]any) { c["aud"] = "other" },
+		"has expired":                func(c map[string]any) { c["exp"] = s.now.Add(-2 * time.Minute).Unix() },
+		"issued in the future":       func(c map[string]any) { c["iat"] = s.now.Add(5 * time.Minute).Unix() },
+		"nonce":                      func(c map[string]any) { c["nonce"] = "replayed" },
+		"no subject":                 func(c map[string]any) { delete(c, "sub") },
+	}
+	for reason, tamper := range invalid {
+		claims := s.claims()
+		tamper(claims)
+		_, err := s.verify(s.sign("k1", claims))
+		assert.ErrorContains(s.T(), err, reason)
+	}
+
+	// Clock skew within the leeway is tolerated
+	claims := s.claims()
+	claims["exp"] = s.now.Add(-30 * time.Second).Unix()
+	_, err := s.verify(s.sign("k1", claims))
+	assert.NoError(s.T(), err)
+
+	token := s.sign("k1", s.claims())
+	_, err = s.verify(token[:len(token)-4] + "AAAA")
+	assert.ErrorContains(s.T(), err, "signature")
+	_, err = s.verify(s.sign("k2", s.claims()))
+	assert.ErrorContains(s.T(), err, "unknown key")
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload, _ := json.Marshal(s.claims())
+	_, err = s.verify(header + "." + base64.RawURLEncoding.EncodeToString(payload) + ".")
+	assert.ErrorContains(s.T(), err, "not supported")
+}
+
+func (s *OIDCAgentTestSuite) TestMapsGroupsToRoles() {
+	roles := func(claims map[string]any) []string {
+		return s.agent.roles(&idTokenClaims{Claims: claims})
+	}
+
+	assert.Equal(s.T(), []string{"user", "admin"}, roles(map[string]any{"groups": []any{"drm-users", "drm-admins"}}))
+	assert.Equal(s.T(), []string{"user"}, roles(map[string]any{"groups": "drm-users"}))
+	assert.Empty(s.T(), roles(map[string]any{"groups": []any{"engineering"}}))
+	assert.Empty(s.T(), roles(map[string]any{}))
+
+	s.agent.cfg.DefaultRole = "user"
+	assert.Equal(s.T(), []string{"user"}, roles(map[string]any{"groups": []any{"engineering"}}))
+
+	s.agent.cfg.GroupsClaim = "realm_access.roles"
+	assert.Equal(s.T(), []string{"admin", "user"}, roles(map[string]any{
+		"realm_access": map[string]any{"roles": []any{"drm-admins"}},
+	}))
+}
+
+func (s *OIDCAgentTestSuite) TestCallbackNeedsKnownState() {
+	_, err := s.agent.Callback(context.Background(), "code", "unknown")
+	assert.ErrorIs(s.T(), err, ErrOIDCLogin)
+
+	authorizationURL, err := s.agent.AuthorizationURL(context.Background())
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 1, s.store.PendingLogins())
+	state, err := url.Parse(authorizationURL)
+	require.NoError(s.T(), err)
+
+	s.now = s.now.Add(oidcLoginTimeout)
+	_, err = s.agent.Callback(context.Background(), "code", state.Query().Get("state"))
+	assert.ErrorIs(s.T(), err, ErrOIDCLogin)
+	assert.Zero(s.T(), s.store.PendingLogins())
+}
+
+func TestOIDCAgentTestSuite(t *testing.T) {
+	suite.Run(t, new(OIDCAgentTestSuite))
+}
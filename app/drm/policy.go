@@ -0,0 +1,77 @@
+package drm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"drm-app/app/data"
+)
+
+var ErrInvalidPolicyQuery = errors.New("invalid policy query")
+
+// PolicyQuery asks whether a role, or the user with UserID, may perform Action on Entity,
+// optionally on the record with RecordID
+type PolicyQuery struct {
+	Role     string `json:"role,omitempty"`
+	UserID   string `json:"user_id,omitempty"`
+	Action   string `json:"action"`
+	Entity   string `json:"entity"`
+	RecordID string `json:"record_id,omitempty"`
+	// Roles are the roles the query was checked with: Role, or the roles of the user
+	Roles []string `json:"roles,omitempty"`
+}
+
+// AccessCheck answers a PolicyQuery with Query.Roles filled in
+type AccessCheck struct {
+	Query    PolicyQuery    `json:"query"`
+	Decision PolicyDecision `json:"decision"`
+}
+
+// CheckPolicy evaluates query against the policy of the tenant of a token allowed to read it.
+// Conditions see the attributes of the user with UserID and the stored record with RecordID,
+// both of that tenant.
+func (e *Engine) CheckPolicy(ctx context.Context, token string, query PolicyQuery) (*AccessCheck, error) {
+	caller, err := e.Authorize(ctx, token, "policy", "read")
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Action == "" || query.Entity == "" {
+		return nil, fmt.Errorf("%w: action and entity are required", ErrInvalidPolicyQuery)
+	}
+	var attributes map[string]string
+	switch {
+	case query.UserID != "" && query.Role != "":
+		return nil, fmt.Errorf("%w: give a role or a user_id, not both", ErrInvalidPolicyQuery)
+	case query.UserID != "":
+		user, exists := e.AuthAgent.LookupUser(caller.Tenant, query.UserID)
+		if !exists {
+			return nil, fmt.Errorf("user %s: %w", query.UserID, data.ErrNotFound)
+		}
+		query.Roles = user.Roles
+		attributes = user.Attributes
+	case query.Role == "":
+		return nil, fmt.Errorf("%w: a role or a user_id is required", ErrInvalidPolicyQuery)
+	default:
+		query.Roles = []string{query.Role}
+	}
+
+	command := &data.Command{Action: query.Action, Entity: query.Entity, UserID: query.UserID, Tenant: caller.Tenant, UserRoles: query.Roles, UserAttributes: attributes}
+	if query.RecordID != "" {
+		command.Data = map[string]interface{}{"id": query.RecordID}
+	}
+
+	ctx = data.WithTenant(ctx, caller.Tenant)
+	return &AccessCheck{Query: query, Decision: e.AccessPolicyAgent.DecideWithRecord(command, e.recordLoader(ctx, command))}, nil
+}
+
+// PermissionMatrix returns the effective permissions of every role of the token's tenant for a
+// token allowed to read the policy
+func (e *Engine) PermissionMatrix(ctx context.Context, token string) (*PermissionMatrix, error) {
+	user, err := e.Authorize(ctx, token, "policy", "read")
+	if err != nil {
+		return nil, err
+	}
+	return e.AccessPolicyAgent.Matrix(user.Tenant), nil
+}
@@ -0,0 +1,97 @@
+package drm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"drm-app/app/data"
+	"drm-app/app/metrics"
+)
+
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// RateLimitError reports a rejected request and when the caller may retry
+type RateLimitError struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrRateLimited, e.Reason)
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// RateLimiter applies the request rate and daily create quota of the tenant policy to
+// authenticated users.
+// Store failures let the request through: limits protect capacity and should not take the
+// service down with them. They are logged and counted so a broken store does not go unnoticed.
+type RateLimiter struct {
+	store  data.RateLimitStore
+	policy *AccessPolicyAgent
+	now    func() time.Time
+}
+
+func NewRateLimiter(store data.RateLimitStore, policy *AccessPolicyAgent) *RateLimiter {
+	return &RateLimiter{
+		store:  store,
+		policy: policy,
+		now:    time.Now,
+	}
+}
+
+// Allow spends one request from the user's token bucket
+func (r *RateLimiter) Allow(ctx context.Context, user *User) error {
+	limit, role, exists := r.policy.RateLimit(user.Tenant, user.Roles)
+	if !exists || limit.RequestsPerMinute == 0 {
+		return nil
+	}
+
+	bucket := data.RateLimit{Rate: limit.RequestsPerMinute / 60, Burst: limit.Burst}
+	allowed, retryAfter, err := r.store.TakeToken(ctx, "requests:"+user.key(), bucket, r.now())
+	if err != nil {
+		slog.ErrorContext(ctx, "rate limit check failed, allowing request", "user_id", user.ID, "error", err)
+		metrics.RateLimitStoreErrors.WithLabelValues("requests").Inc()
+		return nil
+	}
+	if allowed {
+		return nil
+	}
+
+	metrics.RateLimited.WithLabelValues(role, "requests").Inc()
+	return &RateLimitError{
+		Reason:     fmt.Sprintf("%s role allows %g requests per minute", role, limit.RequestsPerMinute),
+		RetryAfter: retryAfter,
+	}
+}
+
+// ConsumeCreateQuota counts a create against the user's daily quota, which resets at midnight UTC
+func (r *RateLimiter) ConsumeCreateQuota(ctx context.Context, user *User) error {
+	limit, role, exists := r.policy.RateLimit(user.Tenant, user.Roles)
+	if !exists || limit.DailyCreates == 0 {
+		return nil
+	}
+
+	now := r.now().UTC()
+	allowed, err := r.store.ConsumeQuota(ctx, "creates:"+user.key(), now.Format(time.DateOnly), limit.DailyCreates)
+	if err != nil {
+		slog.ErrorContext(ctx, "quota check failed, allowing request", "user_id", user.ID, "error", err)
+		metrics.RateLimitStoreErrors.WithLabelValues("daily_creates").Inc()
+		return nil
+	}
+	if allowed {
+		return nil
+	}
+
+	metrics.RateLimited.WithLabelValues(role, "daily_creates").Inc()
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return &RateLimitError{
+		Reason:     fmt.Sprintf("daily quota of %d creates used", limit.DailyCreates),
+		RetryAfter: midnight.Sub(now),
+	}
+}
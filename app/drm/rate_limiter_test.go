@@ -0,0 +1,219 @@
+package drm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"drm-app/app/config"
+	"drm-app/app/data"
+	"drm-app/app/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) TakeToken(ctx context.Context, key string, limit data.RateLimit, now time.Time) (bool, time.Duration, error) {
+	return false, 0, errors.New("store unavailable")
+}
+
+func (failingRateLimitStore) ConsumeQuota(ctx context.Context, key string, day string, limit int) (bool, error) {
+	return false, errors.New("store unavailable")
+}
+
+type RateLimiterTestSuite struct {
+	suite.Suite
+	limiter *RateLimiter
+	now     time.Time
+	ctx     context.Context
+	guest   *User
+	user    *User
+	admin   *User
+}
+
+func (s *RateLimiterTestSuite) SetupTest() {
+	s.ctx = context.Background()
+	s.now = time.Date(2025, 3, 14, 23, 59, 0, 0, time.UTC)
+	s.limiter = NewRateLimiter(data.NewMemoryRateLimitStore(), NewAccessPolicyAgentFromConfig(config.PolicyConfig{Limits: map[string]config.RateLimitConfig{
+		"guest": {RequestsPerMinute: 6, Burst: 2},
+		"user":  {RequestsPerMinute: 600, Burst: 100, DailyCreates: 2},
+	}}))
+	s.limiter.now = func() time.Time { return s.now }
+
+	s.guest = &User{ID: "3", Tenant: config.DefaultTenant, Roles: []string{"guest"}, Source: SourceToken}
+	s.user = &User{ID: "2", Tenant: config.DefaultTenant, Roles: []string{"user"}, Source: SourceToken}
+	s.admin = &User{ID: "1", Tenant: config.DefaultTenant, Roles: []string{"admin"}, Source: SourceToken}
+}
+
+func (s *RateLimiterTestSuite) TestBurstThenRetryAfter() {
+	require.NoError(s.T(), s.limiter.Allow(s.ctx, s.guest))
+	require.NoError(s.T(), s.limiter.Allow(s.ctx, s.guest))
+
+	err := s.limiter.Allow(s.ctx, s.guest)
+
+	var limitErr *RateLimitError
+	require.ErrorAs(s.T(), err, &limitErr)
+	assert.ErrorIs(s.T(), err, ErrRateLimited)
+	assert.Equal(s.T(), 10*time.Second, limitErr.RetryAfter)
+}
+
+func (s *RateLimiterTestSuite) TestRefillsOverTime() {
+	require.NoError(s.T(), s.limiter.Allow(s.ctx, s.guest))
+	require.NoError(s.T(), s.limiter.Allow(s.ctx, s.guest))
+	require.Error(s.T(), s.limiter.Allow(s.ctx, s.guest))
+
+	s.now = s.now.Add(10 * time.Second)
+	assert.NoError(s.T(), s.limiter.Allow(s.ctx, s.guest))
+	assert.Error(s.T(), s.limiter.Allow(s.ctx, s.guest))
+
+	s.now = s.now.Add(time.Hour)
+	assert.NoError(s.T(), s.limiter.Allow(s.ctx, s.guest))
+	assert.NoError(s.T(), s.limiter.Allow(s.ctx, s.guest))
+	assert.Error(s.T(), s.limiter.Allow(s.ctx, s.guest))
+}
+
+func (s *RateLimiterTestSuite) TestLimitsAreKeyedByUser() {
+	other := &User{ID: "33", Roles: []string{"guest"}}
+	require.NoError(s.T(), s.limiter.Allow(s.ctx, s.guest))
+	require.NoError(s.T(), s.limiter.Allow(s.ctx, s.guest))
+	require.Error(s.T(), s.limiter.Allow(s.ctx, s.guest))
+
+	assert.NoError(s.T(), s.limiter.Allow(s.ctx, other))
+}
+
+func (s *RateLimiterTestSuite) TestLimitsAreKeyedByTenantAndSource() {
+	require.NoError(s.T(), s.limiter.Allow(s.ctx, s.guest))
+	require.NoError(s.T(), s.limiter.Allow(s.ctx, s.guest))
+	require.Error(s.T(), s.limiter.Allow(s.ctx, s.guest))
+
+	account := *s.guest
+	account.Source = SourceAccount
+	assert.NoError(s.T(), s.limiter.Allow(s.ctx, &account))
+	otherTenant := *s.guest
+	otherTenant.Tenant = "acme"
+	assert.NoError(s.T(), s.limiter.Allow(s.ctx, &otherTenant))
+
+	require.NoError(s.T(), s.limiter.ConsumeCreateQuota(s.ctx, s.user))
+	require.NoError(s.T(), s.limiter.ConsumeCreateQuota(s.ctx, s.user))
+	require.Error(s.T(), s.limiter.ConsumeCreateQuota(s.ctx, s.user))
+	service := *s.user
+	service.Source = SourceService
+	assert.NoError(s.T(), s.limiter.ConsumeCreateQuota(s.ctx, &service))
+}
+
+func (s *RateLimiterTestSuite) TestRoleWithoutLimitIsUnlimited() {
+	for i := 0; i < 1000; i++ {
+		require.NoError(s.T(), s.limiter.Allow(s.ctx, s.admin))
+		require.NoError(s.T(), s.limiter.ConsumeCreateQuota(s.ctx, s.admin))
+	}
+}
+
+func (s *RateLimiterTestSuite) TestDailyCreateQuotaResetsAtMidnight() {
+	require.NoError(s.T(), s.limiter.ConsumeCreateQuota(s.ctx, s.user))
+	require.NoError(s.T(), s.limiter.ConsumeCreateQuota(s.ctx, s.user))
+
+	err := s.limiter.ConsumeCreateQuota(s.ctx, s.user)
+
+	var limitErr *RateLimitError
+	require.ErrorAs(s.T(), err, &limitErr)
+	assert.Contains(s.T(), err.Error(), "daily quota of 2 creates used")
+	assert.Equal(s.T(), time.Minute, limitErr.RetryAfter)
+
+	s.now = s.now.Add(time.Minute)
+	assert.NoError(s.T(), s.limiter.ConsumeCreateQuota(s.ctx, s.user))
+}
+
+func (s *RateLimiterTestSuite) TestLimitsFollowInheritanceAndTenantPolicy() {
+	limiter := NewRateLimiter(data.NewMemoryRateLimitStore(), NewAccessPolicyAgentFromConfig(config.PolicyConfig{
+		Inherits: map[string][]string{"support": {"user"}},
+		Limits:   map[string]config.RateLimitConfig{"user": {DailyCreates: 1}},
+		Tenants: map[string]config.TenantPolicyConfig{
+			"acme": {Limits: map[string]config.RateLimitConfig{"user": {DailyCreates: 2}}},
+		},
+	}))
+
+	// Roles without limits of their own take those of the roles they inherit
+	support := &User{ID: "4", Tenant: config.DefaultTenant, Roles: []string{"support"}, Source: SourceToken}
+	require.NoError(s.T(), limiter.ConsumeCreateQuota(s.ctx, support))
+	assert.ErrorIs(s.T(), limiter.ConsumeCreateQuota(s.ctx, support), ErrRateLimited)
+
+	acme := &User{ID: "2", Tenant: "acme", Roles: []string{"user"}, Source: SourceToken}
+	require.NoError(s.T(), limiter.ConsumeCreateQuota(s.ctx, acme))
+	require.NoError(s.T(), limiter.ConsumeCreateQuota(s.ctx, acme))
+	assert.ErrorIs(s.T(), limiter.ConsumeCreateQuota(s.ctx, acme), ErrRateLimited)
+
+	// By default admin inherits user but not its limits
+	limiter = NewRateLimiter(data.NewMemoryRateLimitStore(), NewAccessPolicyAgent())
+	for range 501 {
+		require.NoError(s.T(), limiter.ConsumeCreateQuota(s.ctx, s.admin))
+	}
+}
+
+func (s *RateLimiterTestSuite) TestStoreFailureAllowsRequest() {
+	limiter := NewRateLimiter(failingRateLimitStore{}, NewAccessPolicyAgentFromConfig(config.PolicyConfig{Limits: map[string]config.RateLimitConfig{
+		"guest": {RequestsPerMinute: 1, Burst: 1, DailyCreates: 1},
+	}}))
+
+	requests := testutil.ToFloat64(metrics.RateLimitStoreErrors.WithLabelValues("requests"))
+	creates := testutil.ToFloat64(metrics.RateLimitStoreErrors.WithLabelValues("daily_creates"))
+
+	assert.NoError(s.T(), limiter.Allow(s.ctx, s.guest))
+	assert.NoError(s.T(), limiter.ConsumeCreateQuota(s.ctx, s.guest))
+
+	assert.Equal(s.T(), requests+1, testutil.ToFloat64(metrics.RateLimitStoreErrors.WithLabelValues("requests")))
+	assert.Equal(s.T(), creates+1, testutil.ToFloat64(metrics.RateLimitStoreErrors.WithLabelValues("daily_creates")))
+}
+
+func (s *RateLimiterTestSuite) TestMemoryStoreDropsRefilledBuckets() {
+	store := data.NewMemoryRateLimitStore()
+	fast := data.RateLimit{Rate: 1, Burst: 1}
+	slow := data.RateLimit{Rate: 1.0 / 3600, Burst: 1}
+
+	take := func(key string, limit data.RateLimit, at time.Time) bool {
+		allowed, _, err := store.TakeToken(s.ctx, key, limit, at)
+		require.NoError(s.T(), err)
+		return allowed
+	}
+
+	assert.True(s.T(), take("requests:1", fast, s.now))
+	assert.True(s.T(), take("requests:2", slow, s.now.Add(time.Second)))
+	assert.Equal(s.T(), 2, store.Buckets())
+
+	// A minute on, the fast bucket has refilled and is dropped; the slow one is still empty
+	assert.True(s.T(), take("requests:3", fast, s.now.Add(time.Minute)))
+	assert.Equal(s.T(), 2, store.Buckets())
+	assert.False(s.T(), take("requests:2", slow, s.now.Add(time.Minute)))
+	assert.True(s.T(), take("requests:1", fast, s.now.Add(time.Minute)))
+}
+
+func (s *RateLimiterTestSuite) TestEngineAppliesLimits() {
+	engine := NewTestEngine()
+	defer engine.Close()
+	engine.RateLimiter = s.limiter
+
+	_, err := engine.ProcessRequest(s.ctx, `create order json:{"items":[{"product_id":"1","quantity":1}]}`, "user-token")
+	require.NoError(s.T(), err)
+	_, err = engine.ProcessRequest(s.ctx, `create order json:{"items":[{"product_id":"1","quantity":1}]}`, "user-token")
+	require.NoError(s.T(), err)
+
+	_, err = engine.ProcessRequest(s.ctx, `create order json:{"items":[{"product_id":"1","quantity":1}]}`, "user-token")
+	assert.ErrorIs(s.T(), err, ErrRateLimited)
+
+	_, err = engine.ProcessRequest(s.ctx, "list orders", "user-token")
+	assert.NoError(s.T(), err, "reads do not count against the create quota")
+
+	_, err = engine.ProcessRequest(s.ctx, "list products", "guest-token")
+	require.NoError(s.T(), err)
+	_, err = engine.ProcessRequest(s.ctx, "list products", "guest-token")
+	require.NoError(s.T(), err)
+	_, err = engine.ProcessRequest(s.ctx, "list products", "guest-token")
+	assert.ErrorIs(s.T(), err, ErrRateLimited)
+}
+
+func TestRateLimiterTestSuite(t *testing.T) {
+	suite.Run(t, new(RateLimiterTestSuite))
+}
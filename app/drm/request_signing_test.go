@@ -0,0 +1,132 @@
+package drm
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"drm-app/app/config"
+	"drm-app/app/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const billingSecret = "billing-secret-0123456789abcdefghij"
+
+type RequestSigningTestSuite struct {
+	suite.Suite
+	agent *AuthAgent
+	now   time.Time
+}
+
+func (s *RequestSigningTestSuite) SetupTest() {
+	s.now = time.Date(2025, 3, 14, 12, 0, 0, 0, time.UTC)
+
+	cfg := config.Default().Auth
+	cfg.Signing.Keys = []config.ServiceKeyConfig{
+		{KeyID: "billing", Secret: billingSecret, UserID: "900", Name: "Billing", Tenant: "acme", Role: "user"},
+	}
+	s.agent = NewAuthAgentFromConfig(cfg)
+	s.agent.now = func() time.Time { return s.now }
+}
+
+// request returns a request signed by the billing service now
+func (s *RequestSigningTestSuite) request(nonce string) *SignedRequest {
+	timestamp := strconv.FormatInt(s.now.Unix(), 10)
+	body := []byte(`{"query":"list products"}`)
+	return &SignedRequest{
+		KeyID:     "billing",
+		Timestamp: timestamp,
+		Nonce:     nonce,
+		Signature: "sha256=" + SignRequest(billingSecret, "POST", "/request", timestamp, nonce, body),
+		Method:    "POST",
+		Path:      "/request",
+		Body:      body,
+	}
+}
+
+func (s *RequestSigningTestSuite) TestMapsKeyToServiceUser() {
+	user, err := s.agent.VerifySignature(context.Background(), s.request("n-1"))
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "900", user.ID)
+	assert.Equal(s.T(), "acme", user.Tenant)
+	assert.Equal(s.T(), []string{"user"}, user.Roles)
+}
+
+func (s *RequestSigningTestSuite) TestRejectsTamperedRequests() {
+	tampered := map[string]func(req *SignedRequest){
+		"unknown key":              func(req *SignedRequest) { req.KeyID = "reports" },
+		"method":                   func(req *SignedRequest) { req.Method = "PUT" },
+		"path":                     func(req *SignedRequest) { req.Path = "/request/batch" },
+		"body":                     func(req *SignedRequest) { req.Body = []byte(`{"query":"delete product 1"}`) },
+		"nonce":                    func(req *SignedRequest) { req.Nonce = "n-other" },
+		"signature without sha256": func(req *SignedRequest) { req.Signature = req.Signature[len("sha256="):] },
+	}
+	for name, tamper := range tampered {
+		req := s.request("n-" + name)
+		tamper(req)
+		_, err := s.agent.VerifySignature(context.Background(), req)
+		assert.ErrorIs(s.T(), err, ErrInvalidSignature, name)
+	}
+
+	req := s.request("")
+	_, err := s.agent.VerifySignature(context.Background(), req)
+	assert.ErrorContains(s.T(), err, "nonce must be")
+}
+
+func (s *RequestSigningTestSuite) TestToleratesClockSkew() {
+	req := s.request("n-skew")
+	s.now = s.now.Add(4 * time.Minute)
+	_, err := s.agent.VerifySignature(context.Background(), req)
+	assert.NoError(s.T(), err)
+
+	req = s.request("n-late")
+	s.now = s.now.Add(5*time.Minute + time.Second)
+	_, err = s.agent.VerifySignature(context.Background(), req)
+	assert.ErrorContains(s.T(), err, "away from the server's clock")
+
+	req = s.request("n-early")
+	s.now = s.now.Add(-6 * time.Minute)
+	_, err = s.agent.VerifySignature(context.Background(), req)
+	assert.ErrorContains(s.T(), err, "away from the server's clock")
+}
+
+func (s *RequestSigningTestSuite) TestRejectsReplays() {
+	req := s.request("n-once")
+	_, err := s.agent.VerifySignature(context.Background(), req)
+	require.NoError(s.T(), err)
+	_, err = s.agent.VerifySignature(context.Background(), req)
+	assert.ErrorContains(s.T(), err, "nonce was already used")
+
+	// Invalid signatures do not use up nonces
+	forged := s.request("n-forged")
+	forged.Signature = "sha256=00"
+	_, err = s.agent.VerifySignature(context.Background(), forged)
+	assert.ErrorContains(s.T(), err, "signature does not match")
+	_, err = s.agent.VerifySignature(context.Background(), s.request("n-forged"))
+	assert.NoError(s.T(), err)
+}
+
+func (s *RequestSigningTestSuite) TestNonceCacheIsBounded() {
+	nonces := data.NewMemoryNonceStore(2)
+	s.agent.nonces = nonces
+	for i := range 2 {
+		_, err := s.agent.VerifySignature(context.Background(), s.request(fmt.Sprintf("n-%d", i)))
+		require.NoError(s.T(), err)
+	}
+	_, err := s.agent.VerifySignature(context.Background(), s.request("n-full"))
+	assert.ErrorIs(s.T(), err, ErrRateLimited)
+
+	// Nonces are forgotten once their timestamps are out of the window
+	s.now = s.now.Add(6 * time.Minute)
+	_, err = s.agent.VerifySignature(context.Background(), s.request("n-full"))
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), 1, nonces.Len())
+}
+
+func TestRequestSigningTestSuite(t *testing.T) {
+	suite.Run(t, new(RequestSigningTestSuite))
+}
@@ -0,0 +1,86 @@
+package drm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// lifecycle tracks shutdown state. Readiness flips as soon as shutdown begins; new
+// requests are only turned away once draining starts, so the server keeps answering
+// while load balancers notice it is no longer ready.
+type lifecycle struct {
+	shuttingDown atomic.Bool
+
+	mu       sync.RWMutex
+	draining bool
+	inFlight sync.WaitGroup
+}
+
+// admit registers an in-flight request; the caller must call release when it is done
+func (l *lifecycle) admit() error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.draining {
+		return ErrShuttingDown
+	}
+	l.inFlight.Add(1)
+	return nil
+}
+
+func (l *lifecycle) release() {
+	l.inFlight.Done()
+}
+
+// BeginShutdown marks the engine as not ready and ends every live change subscription,
+// letting subscribers receive the events already buffered for them. Requests are still served.
+func (e *Engine) BeginShutdown() {
+	if e.lifecycle.shuttingDown.Swap(true) {
+		return
+	}
+	if e.ChangeFeed != nil {
+		e.ChangeFeed.Close()
+	}
+}
+
+func (e *Engine) ShuttingDown() bool {
+	return e.lifecycle.shuttingDown.Load()
+}
+
+// Shutdown stops admitting requests, waits until in-flight ones finish or ctx ends, flushes
+// queued webhook deliveries and closes the database. The engine is closed even when ctx
+// expires first; the returned error then reports what was cut short.
+func (e *Engine) Shutdown(ctx context.Context) error {
+	e.BeginShutdown()
+
+	e.lifecycle.mu.Lock()
+	e.lifecycle.draining = true
+	e.lifecycle.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		e.lifecycle.inFlight.Wait()
+		close(drained)
+	}()
+
+	var errs []error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("in-flight requests did not finish: %w", ctx.Err()))
+	}
+
+	if e.WebhookAgent != nil {
+		if err := e.WebhookAgent.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	e.Close()
+	return errors.Join(errs...)
+}
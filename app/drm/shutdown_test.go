@@ -0,0 +1,135 @@
+package drm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"drm-app/app/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// blockingDataAgent holds every command until released so tests can observe in-flight requests
+type blockingDataAgent struct {
+	*data.TestDataAgent
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingDataAgent) ExecuteCommand(ctx context.Context, command *data.Command) (interface{}, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return b.TestDataAgent.ExecuteCommand(ctx, command)
+}
+
+type ShutdownTestSuite struct {
+	suite.Suite
+	engine *Engine
+	agent  *blockingDataAgent
+	ctx    context.Context
+}
+
+func (s *ShutdownTestSuite) SetupTest() {
+	s.ctx = context.Background()
+	s.agent = &blockingDataAgent{
+		TestDataAgent: data.NewTestDataAgent(),
+		started:       make(chan struct{}, 1),
+		release:       make(chan struct{}),
+	}
+	s.engine = NewTestEngine()
+	s.engine.DataAgent = s.agent
+}
+
+func (s *ShutdownTestSuite) TearDownTest() {
+	s.engine.Close()
+}
+
+// startRequest runs a request until it reaches the data agent and returns its eventual error
+func (s *ShutdownTestSuite) startRequest() <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		_, err := s.engine.ProcessRequest(s.ctx, "list products", "guest-token")
+		result <- err
+	}()
+	<-s.agent.started
+	return result
+}
+
+func (s *ShutdownTestSuite) TestBeginShutdownFlipsReadiness() {
+	require.Equal(s.T(), HealthOK, s.engine.Readiness(s.ctx).Status)
+
+	s.engine.BeginShutdown()
+
+	report := s.engine.Readiness(s.ctx)
+	assert.Equal(s.T(), HealthUnavailable, report.Status)
+	assert.Equal(s.T(), ErrShuttingDown.Error(), report.Components["server"].Error)
+	assert.True(s.T(), s.engine.ShuttingDown())
+}
+
+func (s *ShutdownTestSuite) TestBeginShutdownEndsSubscriptions() {
+	subscription, err := s.engine.SubscribeChanges(context.Background(), "admin-token", ChangeFilter{})
+	require.NoError(s.T(), err)
+
+	s.engine.BeginShutdown()
+
+	_, open := <-subscription.Events
+	assert.False(s.T(), open)
+
+	_, err = s.engine.SubscribeChanges(context.Background(), "admin-token", ChangeFilter{})
+	assert.ErrorIs(s.T(), err, ErrShuttingDown)
+}
+
+func (s *ShutdownTestSuite) TestBeginShutdownKeepsServingRequests() {
+	s.engine.DataAgent = data.NewTestDataAgent()
+	s.engine.BeginShutdown()
+
+	_, err := s.engine.ProcessRequest(s.ctx, "list products", "guest-token")
+	assert.NoError(s.T(), err)
+}
+
+func (s *ShutdownTestSuite) TestShutdownDrainsInFlightRequests() {
+	inFlight := s.startRequest()
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.engine.Shutdown(s.ctx)
+	}()
+
+	require.Eventually(s.T(), func() bool {
+		_, err := s.engine.ProcessRequest(s.ctx, "list products", "guest-token")
+		return errors.Is(err, ErrShuttingDown)
+	}, time.Second, 5*time.Millisecond)
+
+	select {
+	case <-shutdown:
+		s.FailNow("shutdown finished before the in-flight request")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(s.agent.release)
+	assert.NoError(s.T(), <-inFlight)
+	assert.NoError(s.T(), <-shutdown)
+}
+
+func (s *ShutdownTestSuite) TestShutdownGivesUpAtDeadline() {
+	inFlight := s.startRequest()
+	defer func() {
+		close(s.agent.release)
+		<-inFlight
+	}()
+
+	ctx, cancel := context.WithTimeout(s.ctx, 20*time.Millisecond)
+	defer cancel()
+
+	err := s.engine.Shutdown(ctx)
+
+	assert.ErrorIs(s.T(), err, context.DeadlineExceeded)
+	assert.ErrorContains(s.T(), err, "in-flight requests did not finish")
+}
+
+func TestShutdownTestSuite(t *testing.T) {
+	suite.Run(t, new(ShutdownTestSuite))
+}
@@ -0,0 +1,194 @@
+package drm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"drm-app/app/data"
+)
+
+// maxReportedRowErrors bounds the row errors listed in an import report; Failed still counts all
+const maxReportedRowErrors = 100
+
+// ImportOptions controls an import
+type ImportOptions struct {
+	Format string
+	// DryRun parses and validates every row without writing any
+	DryRun bool
+}
+
+// ImportReport summarises an import. Rows that fail to parse or validate are skipped and
+// listed in Errors; the valid rows are imported together or not at all.
+type ImportReport struct {
+	Entity          string          `json:"entity"`
+	Format          string          `json:"format"`
+	DryRun          bool            `json:"dry_run"`
+	Rows            int             `json:"rows"`
+	Valid           int             `json:"valid"`
+	Imported        int             `json:"imported"`
+	Failed          int             `json:"failed"`
+	Errors          []data.RowError `json:"errors"`
+	ErrorsTruncated bool            `json:"errors_truncated,omitempty"`
+}
+
+func (r *ImportReport) fail(row int, err error) {
+	r.Failed++
+	if len(r.Errors) == maxReportedRowErrors {
+		r.ErrorsTruncated = true
+		return
+	}
+	r.Errors = append(r.Errors, data.RowError{Row: row, Message: err.Error()})
+}
+
+// Import streams records of entity from body into the data store. Each row is validated
+// with the same rules as a create command. Each imported record is published as a create
+// once the import has committed.
+func (e *Engine) Import(ctx context.Context, token, entity string, body io.Reader, opts ImportOptions) (*ImportReport, error) {
+	if err := e.lifecycle.admit(); err != nil {
+		return nil, err
+	}
+	defer e.lifecycle.release()
+
+	ctx, observer := observeRequest(ctx)
+
+	user, err := e.authenticate(observer, token)
+	if err != nil {
+		return nil, err
+	}
+
+	command := &data.Command{Action: "import", Entity: entity, UserID: user.ID, Tenant: user.Tenant, UserRoles: user.Roles, UserAttributes: user.Attributes}
+	observer.describe(command)
+
+	policyCtx := observer.begin("policy")
+	if _, err := e.decide(policyCtx, command); err != nil {
+		return nil, observer.fail(OutcomeDenied, err)
+	}
+	observer.end(OutcomeSuccess, nil)
+
+	executionCtx := observer.begin("execution")
+	reader, err := data.NewRecordReader(opts.Format, entity, body)
+	if err != nil {
+		return nil, observer.fail(OutcomeInvalid, err)
+	}
+
+	report := &ImportReport{Entity: entity, Format: opts.Format, DryRun: opts.DryRun, Errors: []data.RowError{}}
+	next := func() (map[string]interface{}, error) {
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+
+			var rowErr *data.RowError
+			if errors.As(err, &rowErr) {
+				report.Rows++
+				report.fail(rowErr.Row, errors.New(rowErr.Message))
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			report.Rows++
+
+			create := &data.Command{Action: "create", Entity: entity, Data: record, UserID: user.ID, Tenant: user.Tenant, UserRoles: user.Roles, UserAttributes: user.Attributes}
+			if err := e.LogicAgent.ValidateCommand(create); err != nil {
+				report.fail(reader.Row(), err)
+				continue
+			}
+			report.Valid++
+			return record, nil
+		}
+	}
+
+	if opts.DryRun {
+		for {
+			if _, err = next(); err != nil {
+				break
+			}
+		}
+		if err == io.EOF {
+			err = nil
+		}
+	} else {
+		var imported []map[string]interface{}
+		imported, err = e.BulkStore.ImportRecords(executionCtx, entity, next)
+		report.Imported = len(imported)
+		if err == nil {
+			created := &data.Command{Action: "create", Entity: entity, UserID: user.ID, Tenant: user.Tenant}
+			for _, record := range imported {
+				e.publishChange(ctx, created, record)
+			}
+		}
+	}
+	if err != nil {
+		return nil, observer.fail(OutcomeFailed, fmt.Errorf("import failed: %w", err))
+	}
+	observer.end(OutcomeSuccess, nil)
+	observer.finish(OutcomeSuccess, nil)
+
+	slog.InfoContext(ctx, "import finished",
+		"entity", entity,
+		"format", opts.Format,
+		"dry_run", opts.DryRun,
+		"rows", report.Rows,
+		"imported", report.Imported,
+		"failed", report.Failed,
+	)
+	return report, nil
+}
+
+// Export is an authorized export of one entity, streamed by WriteTo
+type Export struct {
+	engine     *Engine
+	tenant     string
+	entity     string
+	format     string
+	conditions []data.Condition
+}
+
+// NewExport checks the token may export entity and that format and conditions are valid, so
+// errors are reported before any of the export is streamed
+func (e *Engine) NewExport(ctx context.Context, token, entity, format string, conditions []data.Condition) (*Export, error) {
+	user, err := e.Authorize(ctx, token, entity, "export")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := data.NewRecordWriter(format, entity, io.Discard); err != nil {
+		return nil, err
+	}
+	if err := data.ValidateConditions(entity, conditions); err != nil {
+		return nil, err
+	}
+
+	return &Export{engine: e, tenant: user.Tenant, entity: entity, format: format, conditions: conditions}, nil
+}
+
+// WriteTo streams the matching records of the exporting user's tenant to w and returns how
+// many were written
+func (x *Export) WriteTo(ctx context.Context, w io.Writer) (int, error) {
+	if err := x.engine.lifecycle.admit(); err != nil {
+		return 0, err
+	}
+	defer x.engine.lifecycle.release()
+
+	writer, err := data.NewRecordWriter(x.format, x.entity, w)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = x.engine.BulkStore.ExportRecords(data.WithTenant(ctx, x.tenant), x.entity, x.conditions, func(record map[string]interface{}) error {
+		count++
+		return writer.Write(record)
+	})
+	if flushErr := writer.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		return count, fmt.Errorf("export failed: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,425 @@
+package drm
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"drm-app/app/data"
+)
+
+const (
+	WebhookSignatureHeader = "X-DRM-Signature"
+	WebhookTimestampHeader = "X-DRM-Timestamp"
+	WebhookEventHeader     = "X-DRM-Event"
+	WebhookDeliveryHeader  = "X-DRM-Delivery"
+)
+
+var changeActions = map[string]bool{"create": true, "update": true, "delete": true}
+
+type WebhookAgent struct {
+	store       data.WebhookStore
+	client      *http.Client
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// PollInterval is how often the store is looked for retries that are due and deliveries that
+	// did not fit in the queue
+	PollInterval time.Duration
+	// ClaimTimeout is how long deliveries this agent queued or is attempting are kept from other
+	// replicas. It must exceed the time an attempt takes; claims of a stopped agent expire after it.
+	ClaimTimeout time.Duration
+
+	// owner identifies the claims of this agent
+	owner string
+	queue chan int
+	mu    sync.Mutex
+	// tracked holds the deliveries in the queue or being attempted, so none is queued twice
+	tracked map[int]bool
+	stop    chan struct{}
+	// stopPolling ends the poller alone, so shutdown stops picking up retries first
+	stopPolling chan struct{}
+	wg          sync.WaitGroup
+	pollOnce    sync.Once
+	startOnce   sync.Once
+	closeOnce   sync.Once
+}
+
+func NewWebhookAgent(store data.WebhookStore) *WebhookAgent {
+	return &WebhookAgent{
+		store:        store,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts:  5,
+		BaseBackoff:  time.Second,
+		MaxBackoff:   5 * time.Minute,
+		PollInterval: 5 * time.Second,
+		ClaimTimeout: time.Minute,
+		owner:        data.NewEventID(),
+		queue:        make(chan int, 1024),
+		tracked:      make(map[int]bool),
+		stop:         make(chan struct{}),
+		stopPolling:  make(chan struct{}),
+	}
+}
+
+// Start launches the delivery workers and queues the deliveries that are due, including those
+// left pending by a previous run
+func (w *WebhookAgent) Start(ctx context.Context, workers int) {
+	w.startOnce.Do(func() {
+		for i := 0; i < workers; i++ {
+			w.wg.Add(1)
+			go w.worker()
+		}
+		w.wg.Add(1)
+		go w.poll()
+
+		w.requeueDue(ctx)
+	})
+}
+
+// requeueDue claims and queues the due deliveries of the store that fit in the queue. Those
+// other replicas have claimed are left to them.
+func (w *WebhookAgent) requeueDue(ctx context.Context) {
+	free := cap(w.queue) - len(w.queue)
+	if free == 0 {
+		return
+	}
+	now := time.Now()
+	due, err := w.store.ClaimDueDeliveries(ctx, w.owner, now, now.Add(w.ClaimTimeout), free)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load due webhook deliveries", "error", err)
+		return
+	}
+	for _, delivery := range due {
+		if !w.enqueue(delivery.ID) {
+			return
+		}
+	}
+}
+
+// poll picks up retries once their backoff has passed, and the deliveries left in the store
+// while the queue was full
+func (w *WebhookAgent) poll() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.stopPolling:
+			return
+		case <-ticker.C:
+			w.requeueDue(context.Background())
+		}
+	}
+}
+
+func (w *WebhookAgent) Close() {
+	w.closeOnce.Do(func() {
+		w.pollOnce.Do(func() { close(w.stopPolling) })
+		close(w.stop)
+		w.wg.Wait()
+	})
+}
+
+// Shutdown stops picking up retries, gives the deliveries already queued or being attempted
+// until ctx ends, then closes the agent. Deliveries still waiting for a retry stay pending and
+// resume on the next Start.
+func (w *WebhookAgent) Shutdown(ctx context.Context) error {
+	defer w.Close()
+	w.pollOnce.Do(func() { close(w.stopPolling) })
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for w.queued() > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d webhook deliveries left pending: %w", w.queued(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func (w *WebhookAgent) CreateSubscription(ctx context.Context, subscription *data.WebhookSubscription) error {
+	parsed, err := url.Parse(subscription.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("webhook url must be an absolute http(s) URL")
+	}
+
+	if subscription.Entity == "" {
+		subscription.Entity = "*"
+	}
+	if subscription.Entity != "*" && !knownEntities[subscription.Entity] {
+		return fmt.Errorf("unknown entity: %s", subscription.Entity)
+	}
+
+	for _, action := range subscription.Actions {
+		if !changeActions[action] {
+			return fmt.Errorf("unsupported webhook action: %s", action)
+		}
+	}
+
+	if subscription.Secret == "" {
+		subscription.Secret = data.NewEventID()
+	}
+	subscription.Active = true
+
+	return w.store.CreateSubscription(ctx, subscription)
+}
+
+// Subscriptions lists the subscriptions of tenant
+func (w *WebhookAgent) Subscriptions(ctx context.Context, tenant string) ([]data.WebhookSubscription, error) {
+	all, err := w.store.ListSubscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	subscriptions := []data.WebhookSubscription{}
+	for _, subscription := range all {
+		if subscription.Tenant == tenant {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	return subscriptions, nil
+}
+
+// Subscription returns the subscription with id, which other tenants cannot see
+func (w *WebhookAgent) Subscription(ctx context.Context, tenant string, id int) (*data.WebhookSubscription, error) {
+	subscription, err := w.store.GetSubscription(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if subscription.Tenant != tenant {
+		return nil, fmt.Errorf("webhook subscription %d: %w", id, data.ErrNotFound)
+	}
+	return subscription, nil
+}
+
+func (w *WebhookAgent) DeleteSubscription(ctx context.Context, tenant string, id int) error {
+	if _, err := w.Subscription(ctx, tenant, id); err != nil {
+		return err
+	}
+	return w.store.DeleteSubscription(ctx, id)
+}
+
+func (w *WebhookAgent) Deliveries(ctx context.Context, subscriptionID int) ([]data.WebhookDelivery, error) {
+	return w.store.ListDeliveries(ctx, subscriptionID)
+}
+
+func (w *WebhookAgent) Delivery(ctx context.Context, id int) (*data.WebhookDelivery, error) {
+	return w.store.GetDelivery(ctx, id)
+}
+
+func (w *WebhookAgent) Attempts(ctx context.Context, deliveryID int) ([]data.WebhookAttempt, error) {
+	return w.store.ListAttempts(ctx, deliveryID)
+}
+
+// Publish records a pending delivery for every matching subscription of the event's tenant and
+// queues it for dispatch
+func (w *WebhookAgent) Publish(ctx context.Context, event data.ChangeEvent) error {
+	subscriptions, err := w.store.MatchingSubscriptions(ctx, event.Tenant, event.Entity, event.Action)
+	if err != nil {
+		return err
+	}
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook payload: %w", err)
+	}
+
+	for _, subscription := range subscriptions {
+		delivery := &data.WebhookDelivery{
+			SubscriptionID: subscription.ID,
+			EventID:        event.ID,
+			EventType:      event.Type,
+			Payload:        string(payload),
+			Status:         data.DeliveryStatusPending,
+			NextAttemptAt:  time.Now(),
+		}
+		if err := w.store.CreateDelivery(ctx, delivery); err != nil {
+			return err
+		}
+		w.enqueue(delivery.ID)
+	}
+
+	return nil
+}
+
+// Redeliver resets a delivered or failed delivery to pending and queues it for another round of
+// attempts. Deliveries still pending answer data.ErrDeliveryPending.
+func (w *WebhookAgent) Redeliver(ctx context.Context, deliveryID int) (*data.WebhookDelivery, error) {
+	delivery, err := w.store.RequeueDelivery(ctx, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The worker of its last round may not have let go of it yet; the poller then queues it
+	w.enqueue(delivery.ID)
+	return delivery, nil
+}
+
+// enqueue queues a pending delivery unless it is queued already. When the queue is full the
+// delivery stays pending in the store for the poller, and enqueue reports false.
+func (w *WebhookAgent) enqueue(deliveryID int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.tracked[deliveryID] {
+		return true
+	}
+	select {
+	case w.queue <- deliveryID:
+		w.tracked[deliveryID] = true
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *WebhookAgent) queued() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.tracked)
+}
+
+func (w *WebhookAgent) worker() {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case deliveryID := <-w.queue:
+			w.deliver(deliveryID)
+			w.mu.Lock()
+			delete(w.tracked, deliveryID)
+			w.mu.Unlock()
+		}
+	}
+}
+
+// deliver makes one attempt at a delivery that is due. A failed attempt leaves it pending until
+// its backoff has passed, when the poller queues it again, so workers never wait out a backoff.
+// The MaxAttempts-th failed attempt since it was queued marks it failed. The delivery is claimed
+// for the attempt, so it is skipped while another replica is attempting it.
+func (w *WebhookAgent) deliver(deliveryID int) {
+	ctx := context.Background()
+
+	now := time.Now()
+	delivery, err := w.store.ClaimDelivery(ctx, deliveryID, w.owner, now, now.Add(w.ClaimTimeout))
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to claim webhook delivery", "delivery_id", deliveryID, "error", err)
+		return
+	}
+	if delivery == nil {
+		return
+	}
+
+	subscription, err := w.store.GetSubscription(ctx, delivery.SubscriptionID)
+	if err != nil {
+		delivery.Status = data.DeliveryStatusFailed
+		delivery.LastError = err.Error()
+		if err := w.store.UpdateDelivery(ctx, delivery); err != nil {
+			slog.ErrorContext(ctx, "failed to update webhook delivery", "delivery_id", delivery.ID, "error", err)
+		}
+		return
+	}
+
+	statusCode, duration, sendErr := w.send(subscription, delivery)
+
+	delivery.Attempts++
+	delivery.RoundAttempts++
+	attempt := &data.WebhookAttempt{
+		DeliveryID: delivery.ID,
+		Attempt:    delivery.Attempts,
+		DurationMs: int(duration.Milliseconds()),
+	}
+	if statusCode != 0 {
+		attempt.StatusCode = &statusCode
+		delivery.LastStatusCode = &statusCode
+	}
+	if sendErr != nil {
+		attempt.Error = sendErr.Error()
+	}
+	delivery.LastError = attempt.Error
+
+	if err := w.store.RecordAttempt(ctx, attempt); err != nil {
+		slog.ErrorContext(ctx, "failed to record webhook attempt", "delivery_id", delivery.ID, "error", err)
+	}
+
+	switch {
+	case sendErr == nil:
+		delivery.Status = data.DeliveryStatusDelivered
+	case delivery.RoundAttempts >= w.MaxAttempts:
+		delivery.Status = data.DeliveryStatusFailed
+	default:
+		delivery.NextAttemptAt = time.Now().Add(w.backoff(delivery.RoundAttempts))
+	}
+
+	if err := w.store.UpdateDelivery(ctx, delivery); err != nil {
+		slog.ErrorContext(ctx, "failed to update webhook delivery", "delivery_id", delivery.ID, "error", err)
+	}
+}
+
+func (w *WebhookAgent) send(subscription *data.WebhookSubscription, delivery *data.WebhookDelivery) (int, time.Duration, error) {
+	body := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequest(http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "DRM-Webhooks/1.0")
+	req.Header.Set(WebhookEventHeader, delivery.EventType)
+	req.Header.Set(WebhookDeliveryHeader, strconv.Itoa(delivery.ID))
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhookPayload(subscription.Secret, timestamp, body))
+
+	start := time.Now()
+	resp, err := w.client.Do(req)
+	duration := time.Since(start)
+	if err != nil {
+		return 0, duration, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, duration, fmt.Errorf("receiver responded with status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, duration, nil
+}
+
+func (w *WebhookAgent) backoff(attempt int) time.Duration {
+	delay := w.BaseBackoff << (attempt - 1)
+	if delay <= 0 || delay > w.MaxBackoff {
+		return w.MaxBackoff
+	}
+	return delay
+}
+
+// SignWebhookPayload computes the hex HMAC-SHA256 of "<timestamp>.<body>" so receivers can
+// reject both tampered and replayed payloads.
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,329 @@
+package drm
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"drm-app/app/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type receivedWebhook struct {
+	headers http.Header
+	body    []byte
+}
+
+type WebhookAgentTestSuite struct {
+	suite.Suite
+	agent    *WebhookAgent
+	store    *data.TestWebhookStore
+	server   *httptest.Server
+	ctx      context.Context
+	mu       sync.Mutex
+	received []receivedWebhook
+	failures int
+}
+
+func (s *WebhookAgentTestSuite) SetupTest() {
+	s.ctx = context.Background()
+	s.received = nil
+	s.failures = 0
+
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.received = append(s.received, receivedWebhook{headers: r.Header.Clone(), body: body})
+		if s.failures > 0 {
+			s.failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	s.store = data.NewTestWebhookStore()
+	s.agent = NewWebhookAgent(s.store)
+	s.agent.MaxAttempts = 3
+	s.agent.BaseBackoff = time.Millisecond
+	s.agent.MaxBackoff = 5 * time.Millisecond
+	s.agent.PollInterval = 10 * time.Millisecond
+	s.agent.Start(s.ctx, 1)
+}
+
+func (s *WebhookAgentTestSuite) TearDownTest() {
+	s.agent.Close()
+	s.server.Close()
+}
+
+func (s *WebhookAgentTestSuite) failNext(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = n
+}
+
+func (s *WebhookAgentTestSuite) receivedCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.received)
+}
+
+func (s *WebhookAgentTestSuite) subscribe(entity string, actions ...string) *data.WebhookSubscription {
+	subscription := &data.WebhookSubscription{URL: s.server.URL, Entity: entity, Actions: actions, Secret: "test-secret"}
+	require.NoError(s.T(), s.agent.CreateSubscription(s.ctx, subscription))
+	return subscription
+}
+
+func (s *WebhookAgentTestSuite) orderEvent(action string) data.ChangeEvent {
+	command := &data.Command{Action: action, Entity: "order", UserID: "2", Data: map[string]interface{}{"id": "7"}}
+	return data.NewChangeEvent(command, map[string]interface{}{"id": "7", "status": "pending"})
+}
+
+func (s *WebhookAgentTestSuite) waitForStatus(deliveryID int, status string) *data.WebhookDelivery {
+	var delivery *data.WebhookDelivery
+	require.Eventually(s.T(), func() bool {
+		var err error
+		delivery, err = s.store.GetDelivery(s.ctx, deliveryID)
+		return err == nil && delivery.Status == status
+	}, 2*time.Second, 5*time.Millisecond)
+	return delivery
+}
+
+func (s *WebhookAgentTestSuite) onlyDelivery(subscriptionID int) data.WebhookDelivery {
+	deliveries, err := s.store.ListDeliveries(s.ctx, subscriptionID)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), deliveries, 1)
+	return deliveries[0]
+}
+
+func (s *WebhookAgentTestSuite) TestDeliversSignedPayload() {
+	subscription := s.subscribe("order")
+
+	require.NoError(s.T(), s.agent.Publish(s.ctx, s.orderEvent("create")))
+	delivery := s.waitForStatus(s.onlyDelivery(subscription.ID).ID, data.DeliveryStatusDelivered)
+
+	assert.Equal(s.T(), 1, delivery.Attempts)
+	require.Equal(s.T(), 1, s.receivedCount())
+
+	received := s.received[0]
+	timestamp, err := strconv.ParseInt(received.headers.Get(WebhookTimestampHeader), 10, 64)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "sha256="+SignWebhookPayload("test-secret", timestamp, received.body), received.headers.Get(WebhookSignatureHeader))
+	assert.Equal(s.T(), "order.create", received.headers.Get(WebhookEventHeader))
+	assert.Contains(s.T(), string(received.body), `"record_id":"7"`)
+}
+
+func (s *WebhookAgentTestSuite) TestRetriesWithBackoffUntilSuccess() {
+	s.failNext(2)
+	subscription := s.subscribe("order")
+
+	require.NoError(s.T(), s.agent.Publish(s.ctx, s.orderEvent("update")))
+	delivery := s.waitForStatus(s.onlyDelivery(subscription.ID).ID, data.DeliveryStatusDelivered)
+
+	assert.Equal(s.T(), 3, delivery.Attempts)
+	attempts, err := s.store.ListAttempts(s.ctx, delivery.ID)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), attempts, 3)
+	assert.Equal(s.T(), http.StatusInternalServerError, *attempts[0].StatusCode)
+	assert.NotEmpty(s.T(), attempts[0].Error)
+	assert.Equal(s.T(), http.StatusOK, *attempts[2].StatusCode)
+}
+
+func (s *WebhookAgentTestSuite) TestBackoffDoesNotHoldWorkers() {
+	s.agent.BaseBackoff = time.Minute
+	s.agent.MaxBackoff = time.Minute
+	s.failNext(1)
+	orders := s.subscribe("order")
+	require.NoError(s.T(), s.agent.Publish(s.ctx, s.orderEvent("create")))
+	require.Eventually(s.T(), func() bool { return s.receivedCount() == 1 }, 2*time.Second, 5*time.Millisecond)
+
+	// The only worker is free while the failed delivery waits for its retry in the store
+	products := s.subscribe("product")
+	event := data.NewChangeEvent(&data.Command{Action: "create", Entity: "product", Data: map[string]interface{}{"id": "3"}}, map[string]interface{}{"id": "3"})
+	require.NoError(s.T(), s.agent.Publish(s.ctx, event))
+	s.waitForStatus(s.onlyDelivery(products.ID).ID, data.DeliveryStatusDelivered)
+
+	retrying := s.onlyDelivery(orders.ID)
+	assert.Equal(s.T(), data.DeliveryStatusPending, retrying.Status)
+	assert.Equal(s.T(), 1, retrying.Attempts)
+	assert.WithinDuration(s.T(), time.Now().Add(time.Minute), retrying.NextAttemptAt, 5*time.Second)
+}
+
+func (s *WebhookAgentTestSuite) TestMarksFailedAfterMaxAttemptsAndRedelivers() {
+	s.failNext(3)
+	subscription := s.subscribe("order")
+
+	require.NoError(s.T(), s.agent.Publish(s.ctx, s.orderEvent("delete")))
+	delivery := s.waitForStatus(s.onlyDelivery(subscription.ID).ID, data.DeliveryStatusFailed)
+	assert.Equal(s.T(), 3, delivery.Attempts)
+
+	_, err := s.agent.Redeliver(s.ctx, delivery.ID)
+	require.NoError(s.T(), err)
+	delivery = s.waitForStatus(delivery.ID, data.DeliveryStatusDelivered)
+	assert.Equal(s.T(), 4, delivery.Attempts)
+}
+
+func (s *WebhookAgentTestSuite) TestRedeliversOnlyFinishedDeliveries() {
+	subscription := s.subscribe("order")
+	delivery := &data.WebhookDelivery{SubscriptionID: subscription.ID, EventType: "order.create", Payload: "{}", Status: data.DeliveryStatusPending}
+	require.NoError(s.T(), s.store.CreateDelivery(s.ctx, delivery))
+
+	_, err := s.agent.Redeliver(s.ctx, delivery.ID)
+	assert.ErrorIs(s.T(), err, data.ErrDeliveryPending)
+	_, err = s.agent.Redeliver(s.ctx, delivery.ID+100)
+	assert.ErrorIs(s.T(), err, data.ErrNotFound)
+}
+
+func (s *WebhookAgentTestSuite) TestLeavesOverflowToPoller() {
+	s.agent.Close()
+	s.agent = NewWebhookAgent(s.store)
+	s.agent.queue = make(chan int, 1)
+	s.agent.PollInterval = 10 * time.Millisecond
+	subscription := s.subscribe("order")
+
+	// Deliveries that do not fit in the queue wait in the store rather than in goroutines
+	for range 5 {
+		require.NoError(s.T(), s.agent.Publish(s.ctx, s.orderEvent("create")))
+	}
+	assert.Len(s.T(), s.agent.queue, 1)
+	assert.Equal(s.T(), 1, s.agent.queued())
+
+	s.agent.Start(s.ctx, 1)
+	deliveries, err := s.store.ListDeliveries(s.ctx, subscription.ID)
+	require.NoError(s.T(), err)
+	for _, delivery := range deliveries {
+		s.waitForStatus(delivery.ID, data.DeliveryStatusDelivered)
+	}
+	assert.Equal(s.T(), 5, s.receivedCount())
+}
+
+func (s *WebhookAgentTestSuite) TestReplicasSendEachDeliveryOnce() {
+	replica := NewWebhookAgent(s.store)
+	replica.PollInterval = time.Millisecond
+	replica.Start(s.ctx, 4)
+	defer replica.Close()
+
+	subscription := s.subscribe("order")
+	for range 20 {
+		require.NoError(s.T(), s.agent.Publish(s.ctx, s.orderEvent("create")))
+	}
+
+	deliveries, err := s.store.ListDeliveries(s.ctx, subscription.ID)
+	require.NoError(s.T(), err)
+	for _, delivery := range deliveries {
+		s.waitForStatus(delivery.ID, data.DeliveryStatusDelivered)
+	}
+	assert.Equal(s.T(), 20, s.receivedCount())
+}
+
+func (s *WebhookAgentTestSuite) TestLeavesClaimedDeliveriesToTheirOwner() {
+	subscription := s.subscribe("order")
+	delivery := &data.WebhookDelivery{SubscriptionID: subscription.ID, EventType: "order.create", Payload: "{}", Status: data.DeliveryStatusPending}
+	require.NoError(s.T(), s.store.CreateDelivery(s.ctx, delivery))
+	s.store.Claim(delivery.ID, "replica", time.Now().Add(200*time.Millisecond))
+
+	s.agent.enqueue(delivery.ID)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(s.T(), 0, s.receivedCount())
+
+	// The claim of a replica that went away expires
+	s.waitForStatus(delivery.ID, data.DeliveryStatusDelivered)
+	assert.Equal(s.T(), 1, s.receivedCount())
+}
+
+func (s *WebhookAgentTestSuite) TestFiltersByEntityAndAction() {
+	orders := s.subscribe("order", "create")
+	products := s.subscribe("product")
+
+	require.NoError(s.T(), s.agent.Publish(s.ctx, s.orderEvent("update")))
+	require.NoError(s.T(), s.agent.Publish(s.ctx, s.orderEvent("create")))
+
+	s.waitForStatus(s.onlyDelivery(orders.ID).ID, data.DeliveryStatusDelivered)
+	deliveries, err := s.store.ListDeliveries(s.ctx, products.ID)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), deliveries)
+}
+
+func (s *WebhookAgentTestSuite) TestPublishesToSubscriptionsOfEventTenant() {
+	subscription := s.subscribe("order")
+	acme := &data.WebhookSubscription{Tenant: "acme", URL: s.server.URL, Entity: "order", Secret: "acme-secret"}
+	require.NoError(s.T(), s.agent.CreateSubscription(s.ctx, acme))
+
+	event := s.orderEvent("create")
+	event.Tenant = "acme"
+	require.NoError(s.T(), s.agent.Publish(s.ctx, event))
+
+	s.waitForStatus(s.onlyDelivery(acme.ID).ID, data.DeliveryStatusDelivered)
+	deliveries, err := s.store.ListDeliveries(s.ctx, subscription.ID)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), deliveries)
+
+	_, err = s.agent.Subscription(s.ctx, "", acme.ID)
+	assert.ErrorIs(s.T(), err, data.ErrNotFound)
+	subscriptions, err := s.agent.Subscriptions(s.ctx, "acme")
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), subscriptions, 1)
+}
+
+func (s *WebhookAgentTestSuite) TestShutdownFlushesQueuedDeliveries() {
+	subscription := s.subscribe("order")
+	for _, action := range []string{"create", "update", "delete"} {
+		require.NoError(s.T(), s.agent.Publish(s.ctx, s.orderEvent(action)))
+	}
+
+	require.NoError(s.T(), s.agent.Shutdown(s.ctx))
+
+	deliveries, err := s.store.ListDeliveries(s.ctx, subscription.ID)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), deliveries, 3)
+	for _, delivery := range deliveries {
+		assert.Equal(s.T(), data.DeliveryStatusDelivered, delivery.Status)
+	}
+}
+
+func (s *WebhookAgentTestSuite) TestShutdownLeavesRetryingDeliveriesPending() {
+	s.agent.BaseBackoff = time.Minute
+	s.agent.MaxBackoff = time.Minute
+	s.failNext(1)
+	subscription := s.subscribe("order")
+	require.NoError(s.T(), s.agent.Publish(s.ctx, s.orderEvent("create")))
+	require.Eventually(s.T(), func() bool { return s.onlyDelivery(subscription.ID).Attempts == 1 }, 2*time.Second, 5*time.Millisecond)
+
+	// A delivery waiting out its backoff does not hold up shutdown
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	require.NoError(s.T(), s.agent.Shutdown(ctx))
+	assert.Less(s.T(), time.Since(start), time.Second)
+
+	delivery := s.onlyDelivery(subscription.ID)
+	assert.Equal(s.T(), data.DeliveryStatusPending, delivery.Status)
+	assert.Equal(s.T(), 1, delivery.Attempts)
+}
+
+func (s *WebhookAgentTestSuite) TestRejectsInvalidSubscription() {
+	assert.Error(s.T(), s.agent.CreateSubscription(s.ctx, &data.WebhookSubscription{URL: "not a url"}))
+	assert.Error(s.T(), s.agent.CreateSubscription(s.ctx, &data.WebhookSubscription{URL: s.server.URL, Entity: "invoice"}))
+	assert.Error(s.T(), s.agent.CreateSubscription(s.ctx, &data.WebhookSubscription{URL: s.server.URL, Actions: []string{"read"}}))
+}
+
+func (s *WebhookAgentTestSuite) TestGeneratesSecretWhenMissing() {
+	subscription := &data.WebhookSubscription{URL: s.server.URL}
+	require.NoError(s.T(), s.agent.CreateSubscription(s.ctx, subscription))
+
+	assert.NotEmpty(s.T(), subscription.Secret)
+	assert.Equal(s.T(), "*", subscription.Entity)
+}
+
+func TestWebhookAgentTestSuite(t *testing.T) {
+	suite.Run(t, new(WebhookAgentTestSuite))
+}
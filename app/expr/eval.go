@@ -0,0 +1,514 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Eval evaluates the program against vars. Values are nil, bool, float64, string,
+// []interface{} and map[string]interface{}, as decoded from JSON; other numeric types are
+// read as float64.
+func (p *Program) Eval(vars map[string]interface{}) (interface{}, error) {
+	return p.root.eval(vars)
+}
+
+// EvalBool evaluates the program and requires a bool result
+func (p *Program) EvalBool(vars map[string]interface{}) (bool, error) {
+	value, err := p.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("%w: expression yields %s, not bool", ErrEvaluation, typeName(value))
+	}
+	return result, nil
+}
+
+type node interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+func evalError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrEvaluation, fmt.Sprintf(format, args...))
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (n *literal) eval(map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+type variable struct {
+	name string
+}
+
+func (n *variable) eval(vars map[string]interface{}) (interface{}, error) {
+	return normalize(vars[n.name]), nil
+}
+
+type list struct {
+	items []node
+}
+
+func (n *list) eval(vars map[string]interface{}) (interface{}, error) {
+	values := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		value, err := item.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+type selection struct {
+	operand node
+	field   string
+}
+
+func (n *selection) eval(vars map[string]interface{}) (interface{}, error) {
+	operand, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := operand.(map[string]interface{})
+	if !ok {
+		return nil, evalError("cannot read field %q of %s", n.field, typeName(operand))
+	}
+	value, exists := fields[n.field]
+	if !exists {
+		return nil, evalError("no such field %q", n.field)
+	}
+	return normalize(value), nil
+}
+
+type index struct {
+	operand node
+	key     node
+}
+
+func (n *index) eval(vars map[string]interface{}) (interface{}, error) {
+	operand, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.key.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch operand := operand.(type) {
+	case map[string]interface{}:
+		field, ok := key.(string)
+		if !ok {
+			return nil, evalError("map keys are strings, not %s", typeName(key))
+		}
+		value, exists := operand[field]
+		if !exists {
+			return nil, evalError("no such field %q", field)
+		}
+		return normalize(value), nil
+	case []interface{}:
+		position, ok := key.(float64)
+		if !ok || position != math.Trunc(position) {
+			return nil, evalError("list indexes are whole numbers, not %v", key)
+		}
+		if position < 0 || int(position) >= len(operand) {
+			return nil, evalError("index %v is out of range", position)
+		}
+		return normalize(operand[int(position)]), nil
+	}
+	return nil, evalError("cannot index %s", typeName(operand))
+}
+
+type unary struct {
+	operator string
+	operand  node
+}
+
+func (n *unary) eval(vars map[string]interface{}) (interface{}, error) {
+	operand, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch value := operand.(type) {
+	case bool:
+		if n.operator == "!" {
+			return !value, nil
+		}
+	case float64:
+		if n.operator == "-" {
+			return -value, nil
+		}
+	}
+	return nil, evalError("operator %s does not apply to %s", n.operator, typeName(operand))
+}
+
+type binary struct {
+	operator    string
+	left, right node
+}
+
+func (n *binary) eval(vars map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	// && and || stop at the left operand when it decides the result
+	if n.operator == "&&" || n.operator == "||" {
+		decided, ok := left.(bool)
+		if !ok {
+			return nil, evalError("operator %s does not apply to %s", n.operator, typeName(left))
+		}
+		if decided == (n.operator == "||") {
+			return decided, nil
+		}
+		right, err := n.right.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := right.(bool); !ok {
+			return nil, evalError("operator %s does not apply to %s", n.operator, typeName(right))
+		}
+		return right, nil
+	}
+
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.operator {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in":
+		switch container := right.(type) {
+		case []interface{}:
+			for _, item := range container {
+				if equal(left, item) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]interface{}:
+			key, ok := left.(string)
+			if !ok {
+				return nil, evalError("map keys are strings, not %s", typeName(left))
+			}
+			_, exists := container[key]
+			return exists, nil
+		}
+		return nil, evalError("operator in does not apply to %s", typeName(right))
+	}
+
+	switch left := left.(type) {
+	case float64:
+		if right, ok := right.(float64); ok {
+			return arithmetic(n.operator, left, right)
+		}
+	case string:
+		if right, ok := right.(string); ok {
+			switch n.operator {
+			case "+":
+				return left + right, nil
+			case "<":
+				return left < right, nil
+			case "<=":
+				return left <= right, nil
+			case ">":
+				return left > right, nil
+			case ">=":
+				return left >= right, nil
+			}
+		}
+	case []interface{}:
+		if right, ok := right.([]interface{}); ok && n.operator == "+" {
+			return append(append([]interface{}{}, left...), right...), nil
+		}
+	}
+	return nil, evalError("operator %s does not apply to %s and %s", n.operator, typeName(left), typeName(right))
+}
+
+func arithmetic(operator string, left, right float64) (interface{}, error) {
+	switch operator {
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/", "%":
+		if right == 0 {
+			return nil, evalError("division by zero")
+		}
+		if operator == "/" {
+			return left / right, nil
+		}
+		return math.Mod(left, right), nil
+	case "<":
+		return left < right, nil
+	case "<=":
+		return left <= right, nil
+	case ">":
+		return left > right, nil
+	case ">=":
+		return left >= right, nil
+	}
+	return nil, evalError("operator %s does not apply to numbers", operator)
+}
+
+type conditional struct {
+	condition, then, otherwise node
+}
+
+func (n *conditional) eval(vars map[string]interface{}) (interface{}, error) {
+	condition, err := n.condition.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	chosen, ok := condition.(bool)
+	if !ok {
+		return nil, evalError("condition of ?: yields %s, not bool", typeName(condition))
+	}
+	if chosen {
+		return n.then.eval(vars)
+	}
+	return n.otherwise.eval(vars)
+}
+
+// call is a built-in function or method; a method's receiver is its first argument
+type call struct {
+	name string
+	args []node
+	fn   func(args []interface{}) (interface{}, error)
+}
+
+func (n *call) eval(vars map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	return n.fn(args)
+}
+
+// presence implements has(a.b): whether a has the field b, without failing when it does not
+type presence struct {
+	field *selection
+}
+
+func (n *presence) eval(vars map[string]interface{}) (interface{}, error) {
+	operand, err := n.field.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := operand.(map[string]interface{})
+	if !ok {
+		return false, nil
+	}
+	_, exists := fields[n.field.field]
+	return exists, nil
+}
+
+func newFunction(name token, args []node) (node, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s takes one argument", name.text)
+	}
+	switch name.text {
+	case "has":
+		field, ok := args[0].(*selection)
+		if !ok {
+			return nil, fmt.Errorf("has takes a field selection such as has(record.status)")
+		}
+		return &presence{field: field}, nil
+	case "size":
+		return &call{name: name.text, args: args, fn: size}, nil
+	case "string":
+		return &call{name: name.text, args: args, fn: toString}, nil
+	case "double":
+		return &call{name: name.text, args: args, fn: toDouble}, nil
+	case "int":
+		return &call{name: name.text, args: args, fn: func(args []interface{}) (interface{}, error) {
+			value, err := toDouble(args)
+			if err != nil {
+				return nil, err
+			}
+			return math.Trunc(value.(float64)), nil
+		}}, nil
+	}
+	return nil, fmt.Errorf("unknown function %q", name.text)
+}
+
+// stringMethods take a string receiver and string arguments
+var stringMethods = map[string]struct {
+	arity int
+	fn    func(receiver string, args []string) (interface{}, error)
+}{
+	"startsWith": {1, func(s string, args []string) (interface{}, error) { return strings.HasPrefix(s, args[0]), nil }},
+	"endsWith":   {1, func(s string, args []string) (interface{}, error) { return strings.HasSuffix(s, args[0]), nil }},
+	"contains":   {1, func(s string, args []string) (interface{}, error) { return strings.Contains(s, args[0]), nil }},
+	"lowerAscii": {0, func(s string, args []string) (interface{}, error) { return strings.ToLower(s), nil }},
+	"upperAscii": {0, func(s string, args []string) (interface{}, error) { return strings.ToUpper(s), nil }},
+	"matches": {1, func(s string, args []string) (interface{}, error) {
+		pattern, err := regexp.Compile(args[0])
+		if err != nil {
+			return nil, evalError("invalid pattern %q: %v", args[0], err)
+		}
+		return pattern.MatchString(s), nil
+	}},
+}
+
+func newMethod(name token, receiver node, args []node) (node, error) {
+	if name.text == "size" && len(args) == 0 {
+		return &call{name: name.text, args: []node{receiver}, fn: size}, nil
+	}
+	method, exists := stringMethods[name.text]
+	if !exists {
+		return nil, fmt.Errorf("unknown method %q", name.text)
+	}
+	if len(args) != method.arity {
+		return nil, fmt.Errorf("%s takes %d arguments", name.text, method.arity)
+	}
+	if name.text == "matches" {
+		if pattern, ok := args[0].(*literal); ok {
+			if text, ok := pattern.value.(string); ok {
+				if _, err := regexp.Compile(text); err != nil {
+					return nil, fmt.Errorf("invalid pattern %q: %v", text, err)
+				}
+			}
+		}
+	}
+
+	return &call{name: name.text, args: append([]node{receiver}, args...), fn: func(values []interface{}) (interface{}, error) {
+		strs := make([]string, len(values))
+		for i, value := range values {
+			s, ok := value.(string)
+			if !ok {
+				return nil, evalError("%s applies to strings, not %s", name.text, typeName(value))
+			}
+			strs[i] = s
+		}
+		return method.fn(strs[0], strs[1:])
+	}}, nil
+}
+
+func size(args []interface{}) (interface{}, error) {
+	switch value := args[0].(type) {
+	case string:
+		return float64(len([]rune(value))), nil
+	case []interface{}:
+		return float64(len(value)), nil
+	case map[string]interface{}:
+		return float64(len(value)), nil
+	}
+	return nil, evalError("size does not apply to %s", typeName(args[0]))
+}
+
+func toString(args []interface{}) (interface{}, error) {
+	switch value := args[0].(type) {
+	case string:
+		return value, nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	}
+	return nil, evalError("cannot convert %s to string", typeName(args[0]))
+}
+
+func toDouble(args []interface{}) (interface{}, error) {
+	switch value := args[0].(type) {
+	case float64:
+		return value, nil
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, evalError("cannot convert %q to a number", value)
+		}
+		return number, nil
+	}
+	return nil, evalError("cannot convert %s to a number", typeName(args[0]))
+}
+
+// normalize reads the numeric and collection types Go code hands in as their JSON equivalents
+func normalize(value interface{}) interface{} {
+	switch value := value.(type) {
+	case nil, bool, float64, string, []interface{}, map[string]interface{}:
+		return value
+	case int:
+		return float64(value)
+	case int32:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case float32:
+		return float64(value)
+	case []string:
+		items := make([]interface{}, len(value))
+		for i, item := range value {
+			items[i] = item
+		}
+		return items
+	case map[string]string:
+		fields := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			fields[key] = item
+		}
+		return fields
+	}
+	return value
+}
+
+func equal(left, right interface{}) bool {
+	return reflect.DeepEqual(normalizeDeep(left), normalizeDeep(right))
+}
+
+func normalizeDeep(value interface{}) interface{} {
+	switch value := normalize(value).(type) {
+	case []interface{}:
+		items := make([]interface{}, len(value))
+		for i, item := range value {
+			items[i] = normalizeDeep(item)
+		}
+		return items
+	case map[string]interface{}:
+		fields := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			fields[key] = normalizeDeep(item)
+		}
+		return fields
+	default:
+		return value
+	}
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,132 @@
+package expr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type ExprTestSuite struct {
+	suite.Suite
+	vars map[string]interface{}
+}
+
+func (s *ExprTestSuite) SetupTest() {
+	s.vars = map[string]interface{}{
+		"user": map[string]interface{}{"id": "2", "roles": []string{"manager"}, "department": "sales"},
+		"data": map[string]interface{}{"status": "shipped", "quantity": 3},
+		"record": map[string]interface{}{
+			"id": 7, "status": "pending", "price": 12000.5, "department": "sales", "tags": []interface{}{"new"},
+		},
+	}
+}
+
+func (s *ExprTestSuite) eval(source string) (interface{}, error) {
+	program, err := Compile(source, "user", "data", "record")
+	require.NoError(s.T(), err, source)
+	return program.Eval(s.vars)
+}
+
+func (s *ExprTestSuite) TestEvaluates() {
+	cases := map[string]interface{}{
+		`record.status == "pending"`:                       true,
+		`record.department == user.department`:             true,
+		`record.price > 10000 && "admin" in user.roles`:    false,
+		`record.price <= 10000 || "manager" in user.roles`: true,
+		`!(record.status in ["shipped", "delivered"])`:     true,
+		`data.quantity * 2 + 1`:                            7.0,
+		`-data.quantity`:                                   -3.0,
+		`record.id == 7`:                                   true,
+		`string(record.id) == "7"`:                         true,
+		`int(record.price) == 12000`:                       true,
+		`double("2.5") > 2`:                                true,
+		`record["status"]`:                                 "pending",
+		`record.tags[0]`:                                   "new",
+		`size(record.tags) == 1 && user.id.size() == 1`:    true,
+		`has(record.status) && !has(record.owner)`:         true,
+		`"department" in record`:                           true,
+		`record.status.startsWith("pend")`:                 true,
+		`"Sales".lowerAscii() == user.department`:          true,
+		`record.status.matches("^p[a-z]+$")`:               true,
+		`record.price > 100 ? "high" : "low"`:              "high",
+		`[1, 2] + [3]`:                                     []interface{}{1.0, 2.0, 3.0},
+		`'single' + " quoted"`:                             "single quoted",
+	}
+
+	for source, expected := range cases {
+		value, err := s.eval(source)
+		if assert.NoError(s.T(), err, source) {
+			assert.Equal(s.T(), expected, value, source)
+		}
+	}
+}
+
+func (s *ExprTestSuite) TestShortCircuits() {
+	value, err := s.eval(`false && record.missing == 1`)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), false, value)
+
+	value, err = s.eval(`has(record.owner) && record.owner == user.id`)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), false, value)
+}
+
+func (s *ExprTestSuite) TestEvaluationErrors() {
+	cases := map[string]string{
+		`record.owner == "2"`:      `no such field "owner"`,
+		`record.status > 3`:        "operator > does not apply to string and number",
+		`data.quantity / 0`:        "division by zero",
+		`record.status && true`:    "operator && does not apply to string",
+		`record.tags[3]`:           "index 3 is out of range",
+		`record.status.size.other`: `cannot read field "size"`,
+	}
+	for source, message := range cases {
+		_, err := s.eval(source)
+		if assert.ErrorIs(s.T(), err, ErrEvaluation, source) {
+			assert.Contains(s.T(), err.Error(), message, source)
+		}
+	}
+
+	program, err := Compile(`record.status`, "record")
+	require.NoError(s.T(), err)
+	_, err = program.EvalBool(s.vars)
+	assert.ErrorContains(s.T(), err, "expression yields string, not bool")
+
+	_, err = program.Eval(map[string]interface{}{"record": nil})
+	assert.ErrorContains(s.T(), err, `cannot read field "status" of null`)
+}
+
+func (s *ExprTestSuite) TestSyntaxErrors() {
+	cases := map[string]string{
+		`record.status ==`:           "unexpected end of expression",
+		`recrod.status == "x"`:       `unknown variable "recrod"`,
+		`record.status == "x`:        "unterminated string",
+		`exec("rm")`:                 `unknown function "exec"`,
+		`record.status.split(",")`:   `unknown method "split"`,
+		`has(record)`:                "has takes a field selection",
+		`record.status.matches("(")`: "invalid pattern",
+		`record.status = "x"`:        "unexpected character '='",
+		`(record.status`:             `expected ")"`,
+	}
+	for source, message := range cases {
+		_, err := Compile(source, "user", "data", "record")
+		if assert.ErrorIs(s.T(), err, ErrSyntax, source) {
+			assert.Contains(s.T(), err.Error(), message, source)
+		}
+	}
+}
+
+func (s *ExprTestSuite) TestUses() {
+	program, err := Compile(`data.status == "x" || user.id == "1"`, "user", "data", "record")
+	require.NoError(s.T(), err)
+	assert.True(s.T(), program.Uses("data"))
+	assert.True(s.T(), program.Uses("user"))
+	assert.False(s.T(), program.Uses("record"))
+	assert.Equal(s.T(), `data.status == "x" || user.id == "1"`, program.String())
+}
+
+func TestExprTestSuite(t *testing.T) {
+	suite.Run(t, new(ExprTestSuite))
+}
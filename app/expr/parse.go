@@ -0,0 +1,395 @@
+// Package expr implements a small, side-effect free expression language in the style of CEL
+// for policy conditions. Expressions read variables such as user, data and record, compare
+// and combine their fields, and evaluate to a value, usually a bool. There are no loops,
+// assignments or user-defined functions, so evaluation is bounded by the expression's size.
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrSyntax     = errors.New("syntax error")
+	ErrEvaluation = errors.New("evaluation error")
+)
+
+// maxLength bounds the source of an expression
+const maxLength = 4096
+
+// Program is a compiled expression
+type Program struct {
+	source string
+	root   node
+	uses   map[string]bool
+}
+
+// Compile parses source, accepting only the given top-level variables
+func Compile(source string, variables ...string) (*Program, error) {
+	if len(source) > maxLength {
+		return nil, fmt.Errorf("%w: expression is longer than %d characters", ErrSyntax, maxLength)
+	}
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, variables: map[string]bool{}, uses: map[string]bool{}}
+	for _, variable := range variables {
+		p.variables[variable] = true
+	}
+	root, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind != tokenEOF {
+		return nil, p.errorf(next, "unexpected %s", next)
+	}
+	return &Program{source: source, root: root, uses: p.uses}, nil
+}
+
+// String returns the source of the program
+func (p *Program) String() string {
+	return p.source
+}
+
+// Uses reports whether the program reads variable
+func (p *Program) Uses(variable string) bool {
+	return p.uses[variable]
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return strconv.Quote(t.value.(string))
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// operators are listed longest first so that "<=" is not read as "<" and "="
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "+", "-", "*", "/", "%", "!", "(", ")", "[", "]", ".", ",", "?", ":"}
+
+func lex(source string) ([]token, error) {
+	var tokens []token
+	for pos := 0; pos < len(source); {
+		c := rune(source[pos])
+		switch {
+		case unicode.IsSpace(c):
+			pos++
+		case c >= '0' && c <= '9':
+			end := pos
+			for end < len(source) && (source[end] >= '0' && source[end] <= '9' || source[end] == '.') {
+				end++
+			}
+			number, err := strconv.ParseFloat(source[pos:end], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w at %d: invalid number %q", ErrSyntax, pos, source[pos:end])
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: source[pos:end], value: number, pos: pos})
+			pos = end
+		case c == '"' || c == '\'':
+			text, value, err := lexString(source, pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text, value: value, pos: pos})
+			pos += len(text)
+		case c == '_' || unicode.IsLetter(c):
+			end := pos
+			for end < len(source) && (source[end] == '_' || unicode.IsLetter(rune(source[end])) || unicode.IsDigit(rune(source[end]))) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: source[pos:end], pos: pos})
+			pos = end
+		default:
+			matched := false
+			for _, operator := range operators {
+				if strings.HasPrefix(source[pos:], operator) {
+					tokens = append(tokens, token{kind: tokenOperator, text: operator, pos: pos})
+					pos += len(operator)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("%w at %d: unexpected character %q", ErrSyntax, pos, c)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(source)}), nil
+}
+
+// lexString reads the quoted string starting at pos, returning its source text and value
+func lexString(source string, pos int) (string, string, error) {
+	quote := source[pos]
+	var value strings.Builder
+	for end := pos + 1; end < len(source); end++ {
+		switch c := source[end]; {
+		case c == quote:
+			return source[pos : end+1], value.String(), nil
+		case c == '\\' && end+1 < len(source):
+			end++
+			switch escaped := source[end]; escaped {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case '\\', '"', '\'':
+				value.WriteByte(escaped)
+			default:
+				return "", "", fmt.Errorf("%w at %d: unknown escape \\%c", ErrSyntax, end-1, escaped)
+			}
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", "", fmt.Errorf("%w at %d: unterminated string", ErrSyntax, pos)
+}
+
+type parser struct {
+	tokens    []token
+	pos       int
+	variables map[string]bool
+	uses      map[string]bool
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is one of the given operators
+func (p *parser) accept(operators ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokenOperator {
+		return "", false
+	}
+	for _, operator := range operators {
+		if t.text == operator {
+			p.pos++
+			return operator, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(operator string) error {
+	if _, ok := p.accept(operator); !ok {
+		return p.errorf(p.peek(), "expected %q, found %s", operator, p.peek())
+	}
+	return nil
+}
+
+func (p *parser) errorf(at token, format string, args ...interface{}) error {
+	return fmt.Errorf("%w at %d: %s", ErrSyntax, at.pos, fmt.Sprintf(format, args...))
+}
+
+// expression parses a conditional, the lowest precedence level
+func (p *parser) expression() (node, error) {
+	condition, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.accept("?"); !ok {
+		return condition, nil
+	}
+	then, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	return &conditional{condition: condition, then: then, otherwise: otherwise}, nil
+}
+
+// precedence lists the binary operators from loosest to tightest binding
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">=", "in"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) binary(level int) (node, error) {
+	if level == len(precedence) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		operator, ok := p.accept(precedence[level]...)
+		if !ok && containsString(precedence[level], "in") && p.peek().kind == tokenIdent && p.peek().text == "in" {
+			operator, ok = p.next().text, true
+		}
+		if !ok {
+			return left, nil
+		}
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{operator: operator, left: left, right: right}
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	if operator, ok := p.accept("!", "-"); ok {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{operator: operator, operand: operand}, nil
+	}
+	return p.member()
+}
+
+func (p *parser) member() (node, error) {
+	n, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("."); ok {
+			name := p.next()
+			if name.kind != tokenIdent {
+				return nil, p.errorf(name, "expected a field name, found %s", name)
+			}
+			if _, ok := p.accept("("); ok {
+				args, err := p.arguments(")")
+				if err != nil {
+					return nil, err
+				}
+				if n, err = newMethod(name, n, args); err != nil {
+					return nil, p.errorf(name, "%v", err)
+				}
+				continue
+			}
+			n = &selection{operand: n, field: name.text}
+		} else if _, ok := p.accept("["); ok {
+			key, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = &index{operand: n, key: key}
+		} else {
+			return n, nil
+		}
+	}
+}
+
+func (p *parser) primary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber, tokenString:
+		return &literal{value: t.value}, nil
+	case tokenIdent:
+		switch t.text {
+		case "true", "false":
+			return &literal{value: t.text == "true"}, nil
+		case "null":
+			return &literal{value: nil}, nil
+		}
+		if _, ok := p.accept("("); ok {
+			args, err := p.arguments(")")
+			if err != nil {
+				return nil, err
+			}
+			n, err := newFunction(t, args)
+			if err != nil {
+				return nil, p.errorf(t, "%v", err)
+			}
+			return n, nil
+		}
+		if !p.variables[t.text] {
+			return nil, p.errorf(t, "unknown variable %q", t.text)
+		}
+		p.uses[t.text] = true
+		return &variable{name: t.text}, nil
+	case tokenOperator:
+		switch t.text {
+		case "(":
+			n, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		case "[":
+			items, err := p.arguments("]")
+			if err != nil {
+				return nil, err
+			}
+			return &list{items: items}, nil
+		}
+	}
+	return nil, p.errorf(t, "unexpected %s", t)
+}
+
+// arguments parses a comma separated list of expressions up to the closing operator
+func (p *parser) arguments(closing string) ([]node, error) {
+	var args []node
+	if _, ok := p.accept(closing); ok {
+		return args, nil
+	}
+	for {
+		arg, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if _, ok := p.accept(closing); ok {
+			return args, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
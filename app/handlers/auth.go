@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"drm-app/app/drm"
+	"github.com/gofiber/fiber/v2"
+)
+
+type RegisterRequest struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	// Tenant defaults to the tenant registrations join
+	Tenant string `json:"tenant"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Register serves POST /auth/register
+func (h *Handler) Register(c *fiber.Ctx) error {
+	var req RegisterRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	user, err := h.Engine.AccountAgent.Register(c.UserContext(), req.Name, req.Email, req.Password)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	c.Status(fiber.StatusCreated)
+	return success(c, user)
+}
+
+// Login serves POST /auth/login
+func (h *Handler) Login(c *fiber.Ctx) error {
+	var req LoginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	tokens, err := h.Engine.AccountAgent.Login(c.UserContext(), req.Tenant, req.Email, req.Password)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	return success(c, tokens)
+}
+
+// Refresh serves POST /auth/refresh
+func (h *Handler) Refresh(c *fiber.Ctx) error {
+	var req RefreshRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	tokens, err := h.Engine.AccountAgent.Refresh(c.UserContext(), req.RefreshToken)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	return success(c, tokens)
+}
+
+// Logout serves POST /auth/logout, revoking the session of the bearer access token or, once
+// that has expired, of the refresh token in the body
+func (h *Handler) Logout(c *fiber.Ctx) error {
+	token := bearerToken(c)
+	if token == "" {
+		var req RefreshRequest
+		if err := c.BodyParser(&req); err != nil {
+			return errorJSON(c, fiber.StatusBadRequest, "Invalid request body")
+		}
+		token = req.RefreshToken
+	}
+
+	if err := h.Engine.AccountAgent.Logout(c.UserContext(), token); err != nil {
+		return errorResponse(c, err)
+	}
+	return success(c, fiber.Map{"logged_out": true})
+}
+
+// accountLocked answers 423 with a Retry-After header in whole seconds
+func accountLocked(c *fiber.Ctx, err error) error {
+	var lockedErr *drm.AccountLockedError
+	if errors.As(err, &lockedErr) {
+		seconds := int(math.Ceil(lockedErr.RetryAfter.Seconds()))
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(max(seconds, 1)))
+	}
+	return errorJSON(c, fiber.StatusLocked, err.Error())
+}
+
+// OIDCLogin serves GET /auth/oidc/login, redirecting the user to sign in at the OIDC provider
+func (h *Handler) OIDCLogin(c *fiber.Ctx) error {
+	if h.Engine.OIDCAgent == nil {
+		return errorResponse(c, drm.ErrOIDCNotConfigured)
+	}
+
+	target, err := h.Engine.OIDCAgent.AuthorizationURL(c.UserContext())
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	return c.Redirect(target, fiber.StatusFound)
+}
+
+// OIDCCallback serves GET /auth/oidc/callback, where the provider sends the user back to, and
+// answers with the tokens of a new session
+func (h *Handler) OIDCCallback(c *fiber.Ctx) error {
+	if h.Engine.OIDCAgent == nil {
+		return errorResponse(c, drm.ErrOIDCNotConfigured)
+	}
+	if providerErr := c.Query("error"); providerErr != "" {
+		reason := strings.TrimSpace(providerErr + " " + c.Query("error_description"))
+		return errorResponse(c, fmt.Errorf("%w: provider returned %s", drm.ErrOIDCLogin, reason))
+	}
+
+	tokens, err := h.Engine.OIDCAgent.Callback(c.UserContext(), c.Query("code"), c.Query("state"))
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	return success(c, tokens)
+}
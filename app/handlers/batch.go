@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"fmt"
+
+	"drm-app/app/data"
+	"drm-app/app/drm"
+	"github.com/gofiber/fiber/v2"
+)
+
+const maxBatchItems = 100
+
+type BatchRequestBody struct {
+	Token string             `json:"token"`
+	Items []BatchRequestItem `json:"items"`
+	// ActAs runs every item as the user it names, like act_as in /request bodies
+	ActAs string `json:"act_as"`
+}
+
+type BatchRequestItem struct {
+	Query          string `json:"query"`
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
+type BatchItemResult struct {
+	Status   string         `json:"status"`
+	Result   interface{}    `json:"result,omitempty"`
+	Error    string         `json:"error,omitempty"`
+	Page     *data.PageInfo `json:"page,omitempty"`
+	Replayed bool           `json:"replayed,omitempty"`
+}
+
+// HandleBatchRequest runs each item as its own request, in order. Items succeed or fail
+// independently, so a retried batch should give its creates idempotency keys.
+func (h *Handler) HandleBatchRequest(c *fiber.Ctx) error {
+	var req BatchRequestBody
+	if err := c.BodyParser(&req); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if req.Token == "" && !signed(c) {
+		return errorJSON(c, fiber.StatusBadRequest, "Token is required")
+	}
+
+	if len(req.Items) == 0 {
+		return errorJSON(c, fiber.StatusBadRequest, "Items are required")
+	}
+
+	if len(req.Items) > maxBatchItems {
+		return errorJSON(c, fiber.StatusBadRequest, fmt.Sprintf("A batch may contain at most %d items", maxBatchItems))
+	}
+
+	for i, item := range req.Items {
+		if item.Query == "" {
+			return errorJSON(c, fiber.StatusBadRequest, fmt.Sprintf("Item %d: query is required", i))
+		}
+		if err := drm.ValidateIdempotencyKey(item.IdempotencyKey); err != nil {
+			return errorJSON(c, fiber.StatusBadRequest, fmt.Sprintf("Item %d: %s", i, err))
+		}
+	}
+
+	results := make([]BatchItemResult, len(req.Items))
+	for i, item := range req.Items {
+		response, err := h.Engine.ProcessRequestWithOptions(c.UserContext(), item.Query, req.Token, drm.RequestOptions{
+			IdempotencyKey: item.IdempotencyKey,
+			ActAs:          req.ActAs,
+		})
+		if err != nil {
+			results[i] = BatchItemResult{Status: "error", Error: err.Error()}
+			continue
+		}
+		results[i] = BatchItemResult{Status: "success", Result: response.Result, Page: response.Page, Replayed: response.Replayed}
+	}
+
+	return success(c, results)
+}
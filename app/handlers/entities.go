@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"fmt"
+
+	"drm-app/app/data"
+	"drm-app/app/drm"
+	"github.com/gofiber/fiber/v2"
+)
+
+// collections maps REST collection names to entities
+var collections = map[string]string{
+	"users":    "user",
+	"products": "product",
+	"orders":   "order",
+}
+
+// ListEntities serves GET /entities/:entity, the REST form of "list <entity>". It takes the
+// bearer token, the X-Act-As header and the limit, cursor, include_total, fields and expand
+// query parameters; a q parameter makes it the REST form of "search <entity> <q>" instead.
+func (h *Handler) ListEntities(c *fiber.Ctx) error {
+	entity, exists := collections[c.Params("entity")]
+	if !exists {
+		return errorJSON(c, fiber.StatusNotFound, fmt.Sprintf("unknown entity: %s", c.Params("entity")))
+	}
+
+	command := &data.Command{Action: "read", Entity: entity, Data: map[string]interface{}{}}
+	if limit := c.Query(data.LimitKey); limit != "" {
+		command.Data[data.LimitKey] = limit
+	}
+	if cursor := c.Query(data.CursorKey); cursor != "" {
+		command.Data[data.CursorKey] = cursor
+	}
+	if fields := c.Query(data.FieldsKey); fields != "" {
+		command.Data[data.FieldsKey] = fields
+	}
+	if expand := c.Query(data.ExpandKey); expand != "" {
+		command.Data[data.ExpandKey] = expand
+	}
+	if c.QueryBool(data.IncludeTotalKey) {
+		command.Data[data.IncludeTotalKey] = true
+	}
+	if text := c.Query(data.SearchKey); text != "" {
+		command.Action = "search"
+		command.Data[data.SearchKey] = text
+	}
+
+	response, err := h.Engine.ProcessCommand(c.UserContext(), command, bearerToken(c), drm.RequestOptions{ActAs: c.Get(ActAsHeader)})
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	return requestSuccess(c, response)
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"drm-app/app/data"
+	"drm-app/app/drm"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	// ActAsHeader names the user the REST routes run a request as, like act_as in /request bodies
+	ActAsHeader = "X-Act-As"
+)
+
+type Handler struct {
+	Engine *drm.Engine
+}
+
+// Register mounts every DRM route on the given Fiber app
+func Register(app *fiber.App, engine *drm.Engine) {
+	h := &Handler{Engine: engine}
+
+	app.Use(RequestIDMiddleware)
+	app.Use(TracingMiddleware)
+	app.Use(RequestLogger)
+	app.Use(MetricsMiddleware)
+	app.Use(h.VerifySignature)
+
+	app.Get("/healthz", h.Healthz)
+	app.Get("/readyz", h.Readyz)
+	app.Get("/status", h.Status)
+	app.Get("/metrics", h.Metrics)
+
+	auth := app.Group("/auth")
+	auth.Post("/register", h.Register)
+	auth.Post("/login", h.Login)
+	auth.Post("/refresh", h.Refresh)
+	auth.Post("/logout", h.Logout)
+	auth.Get("/oidc/login", h.OIDCLogin)
+	auth.Get("/oidc/callback", h.OIDCCallback)
+
+	app.Post("/request", h.HandleRequest)
+	app.Post("/request/batch", h.HandleBatchRequest)
+
+	app.Get("/entities/:entity", h.ListEntities)
+	app.Post("/entities/:entity/import", h.ImportEntities)
+	app.Get("/entities/:entity/export", h.ExportEntities)
+
+	app.Get("/subscribe", h.Subscribe)
+
+	app.Get("/policy/check", h.CheckPolicy)
+	app.Get("/policy/matrix", h.PermissionMatrix)
+
+	webhooks := app.Group("/webhooks")
+	webhooks.Post("/", h.CreateWebhook)
+	webhooks.Get("/", h.ListWebhooks)
+	webhooks.Get("/:id", h.GetWebhook)
+	webhooks.Delete("/:id", h.DeleteWebhook)
+	webhooks.Get("/:id/deliveries", h.ListWebhookDeliveries)
+	webhooks.Get("/:id/deliveries/:deliveryId", h.GetWebhookDelivery)
+	webhooks.Post("/:id/deliveries/:deliveryId/redeliver", h.RedeliverWebhook)
+}
+
+type RequestBody struct {
+	Query string `json:"query"`
+	Token string `json:"token"`
+	// DryRun and its alias Explain return how the query would be handled without executing it
+	DryRun  bool `json:"dry_run"`
+	Explain bool `json:"explain"`
+	// ActAs runs the query as the user named as token:<id> or account:<id>, for callers allowed
+	// to impersonate them
+	ActAs string `json:"act_as"`
+}
+
+func (h *Handler) HandleRequest(c *fiber.Ctx) error {
+	var req RequestBody
+	if err := c.BodyParser(&req); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if req.Query == "" {
+		return errorJSON(c, fiber.StatusBadRequest, "Query is required")
+	}
+
+	if req.Token == "" && !signed(c) {
+		return errorJSON(c, fiber.StatusBadRequest, "Token is required")
+	}
+
+	idempotencyKey := c.Get(IdempotencyKeyHeader)
+	if err := drm.ValidateIdempotencyKey(idempotencyKey); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	response, err := h.Engine.ProcessRequestWithOptions(c.UserContext(), req.Query, req.Token, drm.RequestOptions{
+		IdempotencyKey: idempotencyKey,
+		DryRun:         req.DryRun || req.Explain,
+		ActAs:          req.ActAs,
+	})
+	if err != nil {
+		return requestError(c, err)
+	}
+
+	if response.Replayed {
+		c.Set(IdempotentReplayedHeader, "true")
+	}
+
+	return requestSuccess(c, response)
+}
+
+// requestSuccess renders an engine result, adding the page cursors of list reads
+func requestSuccess(c *fiber.Ctx, response *drm.RequestResult) error {
+	body := fiber.Map{
+		"result": response.Result,
+		"status": "success",
+	}
+	if response.Page != nil {
+		body["page"] = response.Page
+	}
+	if response.DryRun {
+		body["dry_run"] = true
+	}
+	return c.JSON(body)
+}
+
+// authorize checks the bearer token of an administrative request against the access policy
+func (h *Handler) authorize(c *fiber.Ctx, entity, action string) (*drm.User, error) {
+	return h.Engine.Authorize(c.UserContext(), bearerToken(c), entity, action)
+}
+
+func bearerToken(c *fiber.Ctx) string {
+	return strings.TrimSpace(strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "))
+}
+
+// requestError renders ProcessRequest failures. Unlike errorResponse it keeps the historic 500
+// for authentication, policy and validation failures that /request clients already rely on.
+func requestError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, drm.ErrShuttingDown):
+		return errorJSON(c, fiber.StatusServiceUnavailable, err.Error())
+	case errors.Is(err, drm.ErrRateLimited):
+		return rateLimited(c, err)
+	case errors.Is(err, drm.ErrIdempotencyConflict), errors.Is(err, data.ErrPreviewMismatch):
+		return errorJSON(c, fiber.StatusConflict, err.Error())
+	case errors.Is(err, data.ErrBulkLimitExceeded):
+		return errorJSON(c, fiber.StatusForbidden, err.Error())
+	case errors.Is(err, data.ErrInvalidPage), errors.Is(err, data.ErrInvalidProjection), errors.Is(err, data.ErrInvalidSearch),
+		errors.Is(err, drm.ErrInvalidActAs):
+		return errorJSON(c, fiber.StatusBadRequest, err.Error())
+	}
+	return errorJSON(c, fiber.StatusInternalServerError, err.Error())
+}
+
+// rateLimited answers 429 with a Retry-After header in whole seconds
+func rateLimited(c *fiber.Ctx, err error) error {
+	var limitErr *drm.RateLimitError
+	if errors.As(err, &limitErr) {
+		seconds := int(math.Ceil(limitErr.RetryAfter.Seconds()))
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(max(seconds, 1)))
+	}
+	return errorJSON(c, fiber.StatusTooManyRequests, err.Error())
+}
+
+func errorResponse(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, drm.ErrAccessDenied):
+		status = fiber.StatusForbidden
+	case errors.Is(err, drm.ErrAuthenticationFailed), errors.Is(err, drm.ErrInvalidCredentials), errors.Is(err, drm.ErrInvalidSession),
+		errors.Is(err, drm.ErrOIDCLogin), errors.Is(err, drm.ErrInvalidSignature):
+		status = fiber.StatusUnauthorized
+	case errors.Is(err, drm.ErrRegistrationClosed), errors.Is(err, drm.ErrNoRole):
+		status = fiber.StatusForbidden
+	case errors.Is(err, data.ErrEmailTaken), errors.Is(err, data.ErrDeliveryPending):
+		status = fiber.StatusConflict
+	case errors.Is(err, data.ErrNotFound), errors.Is(err, drm.ErrOIDCNotConfigured):
+		status = fiber.StatusNotFound
+	case errors.Is(err, drm.ErrOIDCProvider):
+		status = fiber.StatusBadGateway
+	case errors.Is(err, data.ErrInvalidPage), errors.Is(err, data.ErrInvalidProjection), errors.Is(err, data.ErrInvalidSearch),
+		errors.Is(err, data.ErrInvalidTransfer), errors.Is(err, data.ErrInvalidFilter), errors.Is(err, drm.ErrInvalidPolicyQuery),
+		errors.Is(err, drm.ErrInvalidAccount), errors.Is(err, drm.ErrInvalidActAs):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, drm.ErrShuttingDown):
+		status = fiber.StatusServiceUnavailable
+	case errors.Is(err, drm.ErrIdempotencyConflict), errors.Is(err, data.ErrPreviewMismatch):
+		status = fiber.StatusConflict
+	case errors.Is(err, data.ErrBulkLimitExceeded):
+		status = fiber.StatusForbidden
+	case errors.Is(err, drm.ErrRateLimited):
+		return rateLimited(c, err)
+	case errors.Is(err, drm.ErrAccountLocked):
+		return accountLocked(c, err)
+	}
+
+	return errorJSON(c, status, err.Error())
+}
+
+// errorJSON writes an error body carrying the request ID so failures can be matched to the logs
+func errorJSON(c *fiber.Ctx, status int, message string) error {
+	return c.Status(status).JSON(fiber.Map{
+		"error":      message,
+		"request_id": requestID(c),
+	})
+}
+
+// ErrorHandler renders errors that escape the handlers, such as unknown routes, in the same
+// shape as handler errors
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		status = fiberErr.Code
+	}
+	return errorJSON(c, status, err.Error())
+}
+
+func success(c *fiber.Ctx, result interface{}) error {
+	return c.JSON(fiber.Map{
+		"result": result,
+		"status": "success",
+	})
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"drm-app/app/drm"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Healthz reports that the process is alive without touching any dependency
+func (h *Handler) Healthz(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"status": drm.HealthOK,
+	})
+}
+
+// Readyz reports whether the app can serve traffic. A degraded optional
+// dependency still answers 200 so orchestrators keep routing to the instance.
+func (h *Handler) Readyz(c *fiber.Ctx) error {
+	report := h.Engine.Readiness(c.UserContext())
+	if report.Status == drm.HealthUnavailable {
+		c.Status(fiber.StatusServiceUnavailable)
+	}
+	return c.JSON(report)
+}
+
+func (h *Handler) Status(c *fiber.Ctx) error {
+	if _, err := h.authorize(c, "system", "read"); err != nil {
+		return errorResponse(c, err)
+	}
+
+	return success(c, h.Engine.Status(c.UserContext()))
+}
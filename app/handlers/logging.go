@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"log/slog"
+	"time"
+
+	"drm-app/app/data"
+	"drm-app/app/logging"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+
+	maxRequestIDLength = 128
+)
+
+// RequestIDMiddleware accepts the caller's X-Request-ID or generates one, echoes it in the
+// response and carries it in the request context so every log line can be correlated
+func RequestIDMiddleware(c *fiber.Ctx) error {
+	requestID := c.Get(RequestIDHeader)
+	if !validRequestID(requestID) {
+		requestID = data.NewEventID()
+	}
+
+	c.Locals("requestID", requestID)
+	c.Set(RequestIDHeader, requestID)
+	c.SetUserContext(logging.WithRequestID(c.UserContext(), requestID))
+
+	return c.Next()
+}
+
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range requestID {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func requestID(c *fiber.Ctx) string {
+	requestID, _ := c.Locals("requestID").(string)
+	return requestID
+}
+
+// RequestLogger writes one structured log line per HTTP request. The query string is left out
+// because subscription endpoints accept tokens there
+func RequestLogger(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+	status := responseStatus(c, err)
+
+	level := slog.LevelInfo
+	switch {
+	case status >= fiber.StatusInternalServerError:
+		level = slog.LevelError
+	case status >= fiber.StatusBadRequest:
+		level = slog.LevelWarn
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", c.Method()),
+		slog.String("route", routeTemplate(c, status)),
+		slog.String("path", c.Path()),
+		slog.Int("status", status),
+		slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+		slog.String("ip", c.IP()),
+		slog.String("user_agent", c.Get(fiber.HeaderUserAgent)),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	slog.LogAttrs(c.UserContext(), level, "http request", attrs...)
+
+	return err
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"drm-app/app/metrics"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsMiddleware records HTTP request counts and latencies labeled by route template,
+// so paths with IDs do not create a series per record
+func MetricsMiddleware(c *fiber.Ctx) error {
+	start := time.Now()
+	metrics.HTTPRequestsInFlight.Inc()
+	defer metrics.HTTPRequestsInFlight.Dec()
+
+	err := c.Next()
+
+	status := responseStatus(c, err)
+	route := routeTemplate(c, status)
+
+	metrics.HTTPRequests.WithLabelValues(c.Method(), route, strconv.Itoa(status)).Inc()
+	metrics.HTTPRequestDuration.WithLabelValues(c.Method(), route).Observe(time.Since(start).Seconds())
+
+	return err
+}
+
+// responseStatus returns the status the error handler will send for err, or the current response status
+func responseStatus(c *fiber.Ctx, err error) int {
+	if err == nil {
+		return c.Response().StatusCode()
+	}
+
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return fiberErr.Code
+	}
+	return fiber.StatusInternalServerError
+}
+
+// routeTemplate returns the matched route pattern. Requests that matched no route
+// keep the middleware's own route and are reported as "unmatched".
+func routeTemplate(c *fiber.Ctx, status int) string {
+	route := c.Route().Path
+	if status == fiber.StatusNotFound && route == "/" && c.Path() != "/" {
+		return "unmatched"
+	}
+	return route
+}
+
+var metricsHandler = adaptor.HTTPHandler(promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+
+func (h *Handler) Metrics(c *fiber.Ctx) error {
+	return metricsHandler(c)
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"drm-app/app/drm"
+	"github.com/gofiber/fiber/v2"
+)
+
+// CheckPolicy serves GET /policy/check, answering whether the role or user_id query parameter
+// may perform action on entity, optionally on the record id
+func (h *Handler) CheckPolicy(c *fiber.Ctx) error {
+	check, err := h.Engine.CheckPolicy(c.UserContext(), bearerToken(c), drm.PolicyQuery{
+		Role:     c.Query("role"),
+		UserID:   c.Query("user_id"),
+		Action:   c.Query("action"),
+		Entity:   c.Query("entity"),
+		RecordID: c.Query("id"),
+	})
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	return success(c, check)
+}
+
+// PermissionMatrix serves GET /policy/matrix, the effective permissions of every role
+func (h *Handler) PermissionMatrix(c *fiber.Ctx) error {
+	matrix, err := h.Engine.PermissionMatrix(c.UserContext(), bearerToken(c))
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	return success(c, matrix)
+}
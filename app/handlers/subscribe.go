@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"drm-app/app/drm"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+const subscriptionHeartbeat = 15 * time.Second
+
+// Subscribe opens a live change subscription. WebSocket upgrade requests are served over
+// WebSocket, everything else as Server-Sent Events. Browsers cannot set headers on either
+// transport, so the token may also be passed as a query parameter.
+func (h *Handler) Subscribe(c *fiber.Ctx) error {
+	filter, err := parseChangeFilter(c)
+	if err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	token := bearerToken(c)
+	if token == "" {
+		token = c.Query("token")
+	}
+
+	if websocket.IsWebSocketUpgrade(c) {
+		user, err := h.Engine.AuthorizeChanges(c.UserContext(), token, filter)
+		if err != nil {
+			return errorResponse(c, err)
+		}
+		// Subscribe once upgraded: a failed upgrade never runs the handler that would release
+		// the subscription
+		return websocket.New(func(conn *websocket.Conn) {
+			subscription, err := h.Engine.ChangeFeed.Subscribe(user, filter)
+			if err != nil {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()))
+				return
+			}
+			streamWebSocket(conn, subscription)
+		})(c)
+	}
+
+	subscription, err := h.Engine.SubscribeChanges(c.UserContext(), token, filter)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		streamServerSentEvents(w, subscription)
+	})
+	return nil
+}
+
+func parseChangeFilter(c *fiber.Ctx) (drm.ChangeFilter, error) {
+	// Copies, since WebSocket subscriptions outlive the request buffers
+	filter := drm.ChangeFilter{
+		Entity: strings.Clone(c.Query("entity")),
+	}
+
+	if actions := c.Query("actions"); actions != "" {
+		filter.Actions = strings.Split(strings.Clone(actions), ",")
+	}
+
+	if fields := c.Query("filter"); fields != "" {
+		if err := json.Unmarshal([]byte(fields), &filter.Fields); err != nil {
+			return filter, fmt.Errorf("invalid filter: %w", err)
+		}
+	}
+
+	return filter, nil
+}
+
+func streamServerSentEvents(w *bufio.Writer, subscription *drm.ChangeSubscription) {
+	defer subscription.Close()
+
+	heartbeat := time.NewTicker(subscriptionHeartbeat)
+	defer heartbeat.Stop()
+
+	// Flush the headers immediately so clients know the subscription is open
+	fmt.Fprint(w, ": subscribed\n\n")
+	if err := w.Flush(); err != nil {
+		return
+	}
+
+	for {
+		select {
+		case event, ok := <-subscription.Events:
+			if !ok {
+				return
+			}
+			payload, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, payload)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		}
+
+		// Flush fails once the client has gone away
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func streamWebSocket(conn *websocket.Conn, subscription *drm.ChangeSubscription) {
+	defer subscription.Close()
+
+	// Drain client frames so close messages are noticed
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(subscriptionHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case event, ok := <-subscription.Events:
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+				return
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
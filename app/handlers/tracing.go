@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"drm-app/app/tracing"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestHeaderCarrier adapts fasthttp request headers for OpenTelemetry propagators
+type requestHeaderCarrier struct {
+	header *fasthttp.RequestHeader
+}
+
+func (h requestHeaderCarrier) Get(key string) string {
+	return string(h.header.Peek(key))
+}
+
+func (h requestHeaderCarrier) Set(key, value string) {
+	h.header.Set(key, value)
+}
+
+func (h requestHeaderCarrier) Keys() []string {
+	var keys []string
+	h.header.VisitAll(func(key, _ []byte) {
+		keys = append(keys, string(key))
+	})
+	return keys
+}
+
+// TracingMiddleware starts a server span for every request, continuing the trace from an
+// incoming W3C traceparent header, and hands the span context to handlers via UserContext
+func TracingMiddleware(c *fiber.Ctx) error {
+	ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), requestHeaderCarrier{&c.Request().Header})
+	ctx, span := tracing.Tracer().Start(ctx, c.Method(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(c.Method()),
+			semconv.URLPath(c.Path()),
+			semconv.ClientAddress(c.IP()),
+		),
+	)
+	defer span.End()
+
+	c.SetUserContext(ctx)
+	err := c.Next()
+
+	status := responseStatus(c, err)
+	route := routeTemplate(c, status)
+	span.SetName(c.Method() + " " + route)
+	span.SetAttributes(
+		semconv.HTTPRoute(route),
+		semconv.HTTPResponseStatusCode(status),
+	)
+	if err != nil {
+		span.RecordError(err)
+	}
+	if status >= fiber.StatusInternalServerError {
+		span.SetStatus(codes.Error, fasthttp.StatusMessage(status))
+	}
+
+	return err
+}
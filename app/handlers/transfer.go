@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"time"
+
+	"drm-app/app/data"
+	"drm-app/app/drm"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ImportEntities serves POST /entities/:entity/import. The body is a CSV or JSON Lines file,
+// chosen by the format query parameter or the Content-Type; dry_run=true only validates it.
+func (h *Handler) ImportEntities(c *fiber.Ctx) error {
+	entity, exists := collections[c.Params("entity")]
+	if !exists {
+		return errorJSON(c, fiber.StatusNotFound, fmt.Sprintf("unknown entity: %s", c.Params("entity")))
+	}
+
+	report, err := h.Engine.Import(c.UserContext(), bearerToken(c), entity, requestBody(c), drm.ImportOptions{
+		Format: importFormat(c),
+		DryRun: c.QueryBool("dry_run"),
+	})
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	return success(c, report)
+}
+
+// ExportEntities serves GET /entities/:entity/export as a streamed CSV (the default) or JSON
+// Lines download. The where query parameter filters records like "where" in queries.
+func (h *Handler) ExportEntities(c *fiber.Ctx) error {
+	entity, exists := collections[c.Params("entity")]
+	if !exists {
+		return errorJSON(c, fiber.StatusNotFound, fmt.Sprintf("unknown entity: %s", c.Params("entity")))
+	}
+
+	conditions, err := drm.ParseConditions(c.Query("where"))
+	if err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	format := c.Query("format", data.FormatCSV)
+	export, err := h.Engine.NewExport(c.UserContext(), bearerToken(c), entity, format, conditions)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	c.Set(fiber.HeaderContentType, data.ContentType(format))
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s-%s.%s"`,
+		c.Params("entity"), time.Now().UTC().Format("20060102T150405Z"), format))
+
+	ctx := c.UserContext()
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// Headers are already sent, so a failure can only cut the download short
+		if _, err := export.WriteTo(ctx, w); err != nil {
+			slog.ErrorContext(ctx, "export failed", "entity", entity, "error", err)
+		}
+		w.Flush()
+	})
+	return nil
+}
+
+// importFormat reads the format query parameter, falling back to the Content-Type
+func importFormat(c *fiber.Ctx) string {
+	if format := c.Query("format"); format != "" {
+		return format
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	switch mediaType {
+	case "text/csv":
+		return data.FormatCSV
+	case "application/x-ndjson", "application/jsonl", "application/x-jsonlines":
+		return data.FormatJSONL
+	}
+	return mediaType
+}
+
+// requestBody streams the request body when the server is configured to, so large uploads
+// are not buffered in memory
+func requestBody(c *fiber.Ctx) io.Reader {
+	if stream := c.Context().RequestBodyStream(); stream != nil {
+		return stream
+	}
+	return bytes.NewReader(c.Body())
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"fmt"
+
+	"drm-app/app/data"
+	"github.com/gofiber/fiber/v2"
+)
+
+type WebhookRequest struct {
+	URL     string   `json:"url"`
+	Entity  string   `json:"entity"`
+	Actions []string `json:"actions"`
+	Secret  string   `json:"secret"`
+}
+
+// webhookSubscriptionResponse exposes the signing secret, which is only returned on creation
+type webhookSubscriptionResponse struct {
+	*data.WebhookSubscription
+	Secret string `json:"secret"`
+}
+
+type webhookDeliveryResponse struct {
+	*data.WebhookDelivery
+	DeliveryAttempts []data.WebhookAttempt `json:"delivery_attempts"`
+}
+
+func (h *Handler) CreateWebhook(c *fiber.Ctx) error {
+	user, err := h.authorize(c, "webhook", "create")
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	var req WebhookRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	subscription := &data.WebhookSubscription{
+		Tenant:  user.Tenant,
+		URL:     req.URL,
+		Entity:  req.Entity,
+		Actions: req.Actions,
+		Secret:  req.Secret,
+	}
+	if err := h.Engine.WebhookAgent.CreateSubscription(c.UserContext(), subscription); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	c.Status(fiber.StatusCreated)
+	return success(c, webhookSubscriptionResponse{subscription, subscription.Secret})
+}
+
+func (h *Handler) ListWebhooks(c *fiber.Ctx) error {
+	user, err := h.authorize(c, "webhook", "read")
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	subscriptions, err := h.Engine.WebhookAgent.Subscriptions(c.UserContext(), user.Tenant)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	return success(c, subscriptions)
+}
+
+func (h *Handler) GetWebhook(c *fiber.Ctx) error {
+	user, err := h.authorize(c, "webhook", "read")
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return invalidParam(c, "id")
+	}
+
+	subscription, err := h.Engine.WebhookAgent.Subscription(c.UserContext(), user.Tenant, id)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	return success(c, subscription)
+}
+
+func (h *Handler) DeleteWebhook(c *fiber.Ctx) error {
+	user, err := h.authorize(c, "webhook", "delete")
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return invalidParam(c, "id")
+	}
+
+	if err := h.Engine.WebhookAgent.DeleteSubscription(c.UserContext(), user.Tenant, id); err != nil {
+		return errorResponse(c, err)
+	}
+
+	return success(c, fiber.Map{"message": "webhook deleted successfully"})
+}
+
+func (h *Handler) ListWebhookDeliveries(c *fiber.Ctx) error {
+	user, err := h.authorize(c, "webhook", "read")
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return invalidParam(c, "id")
+	}
+
+	if _, err := h.Engine.WebhookAgent.Subscription(c.UserContext(), user.Tenant, id); err != nil {
+		return errorResponse(c, err)
+	}
+
+	deliveries, err := h.Engine.WebhookAgent.Deliveries(c.UserContext(), id)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	return success(c, deliveries)
+}
+
+func (h *Handler) GetWebhookDelivery(c *fiber.Ctx) error {
+	user, err := h.authorize(c, "webhook", "read")
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	delivery, err := h.lookupDelivery(c, user.Tenant)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	attempts, err := h.Engine.WebhookAgent.Attempts(c.UserContext(), delivery.ID)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	return success(c, webhookDeliveryResponse{delivery, attempts})
+}
+
+func (h *Handler) RedeliverWebhook(c *fiber.Ctx) error {
+	user, err := h.authorize(c, "webhook", "update")
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	delivery, err := h.lookupDelivery(c, user.Tenant)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	delivery, err = h.Engine.WebhookAgent.Redeliver(c.UserContext(), delivery.ID)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	c.Status(fiber.StatusAccepted)
+	return success(c, delivery)
+}
+
+// lookupDelivery resolves the delivery in the route and checks it belongs to the subscription
+// in the route, which belongs to tenant
+func (h *Handler) lookupDelivery(c *fiber.Ctx, tenant string) (*data.WebhookDelivery, error) {
+	subscriptionID, err := c.ParamsInt("id")
+	if err != nil {
+		return nil, fmt.Errorf("webhook subscription %q: %w", c.Params("id"), data.ErrNotFound)
+	}
+	if _, err := h.Engine.WebhookAgent.Subscription(c.UserContext(), tenant, subscriptionID); err != nil {
+		return nil, err
+	}
+
+	deliveryID, err := c.ParamsInt("deliveryId")
+	if err != nil {
+		return nil, fmt.Errorf("webhook delivery %q: %w", c.Params("deliveryId"), data.ErrNotFound)
+	}
+
+	delivery, err := h.Engine.WebhookAgent.Delivery(c.UserContext(), deliveryID)
+	if err != nil {
+		return nil, err
+	}
+
+	if delivery.SubscriptionID != subscriptionID {
+		return nil, fmt.Errorf("webhook delivery %d: %w", deliveryID, data.ErrNotFound)
+	}
+
+	return delivery, nil
+}
+
+func invalidParam(c *fiber.Ctx, name string) error {
+	return errorJSON(c, fiber.StatusBadRequest, fmt.Sprintf("invalid %s parameter", name))
+}
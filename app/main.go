@@ -1,71 +1,140 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"drm-app/app/config"
+	"drm-app/app/db"
 	"drm-app/app/drm"
+	"drm-app/app/handlers"
+	"drm-app/app/logging"
+	"drm-app/app/tracing"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/fiber/v2/middleware/logger"
 )
 
 var engine *drm.Engine
 
 func main() {
-	var err error
-	engine, err = drm.NewEngine()
+	args := os.Args[1:]
+	if len(args) >= 2 && args[0] == "config" && args[1] == "print" {
+		os.Exit(printConfig(args[2:]))
+	}
+
+	cfg, err := config.Load(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err == nil {
+		err = cfg.Validate()
+	}
 	if err != nil {
-		log.Fatalf("Failed to initialize engine: %v", err)
+		slog.Error("Failed to load configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if err := logging.Setup(cfg.Logging.Level); err != nil {
+		slog.Error("Failed to initialize logging", "error", err)
+		os.Exit(1)
+	}
+	db.LogConfig(cfg.Database)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), drm.Version)
+	if err != nil {
+		slog.Error("Failed to initialize tracing", "error", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
+
+	engine, err = drm.NewEngine(cfg)
+	if err != nil {
+		slog.Error("Failed to initialize engine", "error", err)
+		os.Exit(1)
 	}
-	defer engine.Close()
 
 	app := fiber.New(fiber.Config{
-		AppName: "DRM Core v1.0.0",
+		AppName:      cfg.Server.AppName + " v" + drm.Version,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+		ErrorHandler: handlers.ErrorHandler,
+		// Imports read their body as a stream instead of buffering whole files
+		StreamRequestBody: true,
 	})
 
-	app.Use(logger.New())
 	app.Use(cors.New())
 
-	app.Post("/request", handleRequest)
+	handlers.Register(app, engine)
 
-	log.Println("Starting DRM (Declarative-Relation Mapping) Core server on :8080")
-	log.Fatal(app.Listen(":8080"))
-}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-type RequestBody struct {
-	Query string `json:"query"`
-	Token string `json:"token"`
-}
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- app.Listen(cfg.Server.Addr)
+	}()
+	slog.Info("Starting DRM (Declarative-Relation Mapping) Core server", "addr", cfg.Server.Addr)
 
-func handleRequest(c *fiber.Ctx) error {
-	var req RequestBody
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
+	select {
+	case err := <-listenErr:
+		slog.Error("Server stopped", "error", err)
+		engine.Close()
+		os.Exit(1)
+	case <-ctx.Done():
 	}
 
-	if req.Query == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Query is required",
-		})
-	}
+	// A second signal terminates immediately
+	stop()
+	shutdown(app, engine, cfg.Server)
+}
+
+// shutdown flips readiness, stops accepting connections and drains in-flight requests before
+// the engine flushes queued webhook deliveries and closes the database
+func shutdown(app *fiber.App, engine *drm.Engine, cfg config.ServerConfig) {
+	slog.Info("Shutting down", "delay", cfg.ShutdownDelay.String(), "timeout", cfg.ShutdownTimeout.String())
+	engine.BeginShutdown()
+	time.Sleep(cfg.ShutdownDelay)
 
-	if req.Token == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Token is required",
-		})
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := app.ShutdownWithContext(ctx); err != nil {
+		slog.Warn("HTTP server did not drain cleanly", "error", err)
+	}
+	if err := engine.Shutdown(ctx); err != nil {
+		slog.Warn("Engine did not drain cleanly", "error", err)
 	}
 
-	result, err := engine.ProcessRequest(c.Context(), req.Query, req.Token)
+	slog.Info("Shutdown complete")
+}
+
+// printConfig implements `config print`: it writes the effective configuration with secrets
+// masked and exits non-zero if the configuration is invalid
+func printConfig(args []string) int {
+	cfg, err := config.Load(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+		fmt.Fprintln(os.Stderr, err)
+		return 1
 	}
 
-	return c.JSON(fiber.Map{
-		"result": result,
-		"status": "success",
-	})
+	if err := cfg.Print(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
 }
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const namespace = "drm"
+
+// Registry holds every DRM metric; it is served by the /metrics endpoint
+var Registry = prometheus.NewRegistry()
+
+var (
+	EngineRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "engine",
+		Name:      "requests_total",
+		Help:      "Requests processed by Engine.ProcessRequest.",
+	}, []string{"entity", "action", "outcome"})
+
+	EngineRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "engine",
+		Name:      "request_duration_seconds",
+		Help:      "End-to-end duration of Engine.ProcessRequest.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"entity", "action", "outcome"})
+
+	EngineStageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "engine",
+		Name:      "stage_duration_seconds",
+		Help:      "Duration of each Engine.ProcessRequest stage (auth, parse, policy, validation, execution).",
+		Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 2.5, 5, 10},
+	}, []string{"stage", "entity", "action", "outcome"})
+
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "engine",
+		Name:      "rate_limited_total",
+		Help:      "Requests rejected by rate limits and quotas, by role and limit.",
+	}, []string{"role", "limit"})
+
+	RateLimitStoreErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "engine",
+		Name:      "rate_limit_store_errors_total",
+		Help:      "Rate limit and quota checks let through because the store failed, by limit.",
+	}, []string{"limit"})
+
+	ImpersonationAuditErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "engine",
+		Name:      "impersonation_audit_errors_total",
+		Help:      "Impersonated requests whose audit entry could not be written.",
+	})
+
+	LLMRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "llm",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of Ollama generate calls.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2, 3, 5, 10},
+	}, []string{"outcome"})
+
+	LLMFallbacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "llm",
+		Name:      "fallbacks_total",
+		Help:      "Commands executed without LLM guidance, by reason.",
+	}, []string{"reason"})
+
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests handled, by route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request duration, by route template.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	HTTPRequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_in_flight",
+		Help:      "HTTP requests currently being served.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		EngineRequests,
+		EngineRequestDuration,
+		EngineStageDuration,
+		RateLimited,
+		RateLimitStoreErrors,
+		ImpersonationAuditErrors,
+		LLMRequestDuration,
+		LLMFallbacks,
+		HTTPRequests,
+		HTTPRequestDuration,
+		HTTPRequestsInFlight,
+	)
+}
@@ -0,0 +1,64 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type AggregateAPITestSuite struct {
+	suite.Suite
+	testApp *TestApp
+}
+
+func (s *AggregateAPITestSuite) SetupTest() {
+	s.testApp = NewTestApp(s.T())
+
+	for _, query := range []string{
+		`create order json:{"user_id":"2","status":"pending","total_amount":100,"items":[{"product_id":"1","quantity":1}]}`,
+		`create order json:{"user_id":"2","status":"pending","total_amount":50.5,"items":[{"product_id":"2","quantity":2}]}`,
+		`create order json:{"user_id":"1","status":"shipped","total_amount":20,"items":[{"product_id":"2","quantity":1}]}`,
+	} {
+		AssertSuccessResponse(s.T(), s.testApp.PostRequest(query, AdminToken))
+	}
+}
+
+func (s *AggregateAPITestSuite) TestCountWithConditions() {
+	obj := AssertSuccessResponse(s.T(), s.testApp.PostRequest("count orders where status = pending", AdminToken))
+	result := obj.Value("result").Object()
+	result.Value("function").IsEqual("count")
+	result.Value("value").IsEqual(2)
+
+	obj = AssertSuccessResponse(s.T(), s.testApp.PostRequest("count orders where status = pending and total_amount > 60", AdminToken))
+	obj.Value("result").Object().Value("value").IsEqual(1)
+}
+
+func (s *AggregateAPITestSuite) TestGroupedSum() {
+	obj := AssertSuccessResponse(s.T(), s.testApp.PostRequest("sum total_amount of orders group by status", AdminToken))
+
+	groups := obj.Value("result").Object().Value("groups").Array()
+	groups.Length().IsEqual(2)
+	groups.Value(0).Object().Value("key").IsEqual("pending")
+	groups.Value(0).Object().Value("value").IsEqual(150.5)
+	groups.Value(1).Object().Value("key").IsEqual("shipped")
+	groups.Value(1).Object().Value("value").IsEqual(20)
+}
+
+func (s *AggregateAPITestSuite) TestAverageOfEmptySet() {
+	obj := AssertSuccessResponse(s.T(), s.testApp.PostRequest("avg total_amount of orders where status = cancelled", AdminToken))
+
+	obj.Value("result").Object().NotContainsKey("value")
+}
+
+func (s *AggregateAPITestSuite) TestRequiresAggregatePermission() {
+	AssertAccessDeniedError(s.T(), s.testApp.PostRequest("count orders", UserToken))
+}
+
+func (s *AggregateAPITestSuite) TestRejectsInvalidAggregation() {
+	AssertValidationError(s.T(), s.testApp.PostRequest("sum status of orders", AdminToken))
+	AssertValidationError(s.T(), s.testApp.PostRequest("count orders where items = x", AdminToken))
+}
+
+func TestAggregateAPITestSuite(t *testing.T) {
+	suite.Run(t, new(AggregateAPITestSuite))
+}
@@ -0,0 +1,119 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	"drm-app/app/config"
+	"drm-app/app/data"
+	"drm-app/app/drm"
+	"github.com/gavv/httpexpect/v2"
+	"github.com/stretchr/testify/suite"
+)
+
+type AuthAPITestSuite struct {
+	suite.Suite
+	testApp *TestApp
+}
+
+func (s *AuthAPITestSuite) SetupTest() {
+	s.testApp = NewTestApp(s.T())
+
+	accounts := config.Default().Auth.Accounts
+	accounts.Registration = true
+	accounts.MaxFailedLogins = 3
+	store := data.NewTestCredentialStore(s.testApp.Engine.DataAgent.(*data.TestDataAgent))
+	s.testApp.Engine.AccountAgent = drm.NewAccountAgent(store, accounts)
+}
+
+func (s *AuthAPITestSuite) register(email, password string) *httpexpect.Response {
+	return s.testApp.Client.POST("/auth/register").
+		WithJSON(map[string]string{"name": "Ada", "email": email, "password": password}).
+		Expect()
+}
+
+func (s *AuthAPITestSuite) login(email, password string) *httpexpect.Response {
+	return s.testApp.Client.POST("/auth/login").
+		WithJSON(map[string]string{"email": email, "password": password}).
+		Expect()
+}
+
+func (s *AuthAPITestSuite) signIn() (accessToken, refreshToken string) {
+	s.register("ada@example.com", "correct horse battery").Status(http.StatusCreated)
+	tokens := AssertSuccessResponse(s.T(), s.login("ada@example.com", "correct horse battery")).Value("result").Object()
+	return tokens.Value("access_token").String().Raw(), tokens.Value("refresh_token").String().Raw()
+}
+
+func (s *AuthAPITestSuite) TestRegisterAndSignIn() {
+	user := s.register("ada@example.com", "correct horse battery").
+		Status(http.StatusCreated).
+		JSON().Object().Value("result").Object()
+	user.Value("id").IsEqual("3")
+	user.Value("roles").IsEqual([]string{"user"})
+
+	tokens := AssertSuccessResponse(s.T(), s.login("ada@example.com", "correct horse battery")).Value("result").Object()
+	tokens.Value("token_type").IsEqual("Bearer")
+	tokens.Value("expires_in").IsEqual(900)
+	accessToken := tokens.Value("access_token").String().Raw()
+
+	obj := AssertSuccessResponse(s.T(), s.testApp.PostRequest(`read user json:{"id":"3"}`, accessToken))
+	obj.Value("result").Object().Value("email").IsEqual("ada@example.com")
+
+	// The session authenticates administrative endpoints too, with the registered role
+	AssertErrorResponse(s.T(), s.testApp.Client.GET("/policy/matrix").
+		WithHeader("Authorization", "Bearer "+accessToken).Expect(), http.StatusForbidden, "access denied")
+}
+
+func (s *AuthAPITestSuite) TestRegistrationErrors() {
+	s.register("ada@example.com", "correct horse battery").Status(http.StatusCreated)
+
+	AssertErrorResponse(s.T(), s.register("ADA@example.com", "correct horse battery"), http.StatusConflict, "already registered")
+	AssertErrorResponse(s.T(), s.register("grace@example.com", "short"), http.StatusBadRequest, "at least 10 characters")
+
+	s.testApp = NewTestApp(s.T())
+	AssertErrorResponse(s.T(), s.register("grace@example.com", "correct horse battery"), http.StatusForbidden, "registration is closed")
+}
+
+func (s *AuthAPITestSuite) TestFailedLoginsLockAccount() {
+	s.register("ada@example.com", "correct horse battery").Status(http.StatusCreated)
+
+	AssertErrorResponse(s.T(), s.login("ada@example.com", "wrong password"), http.StatusUnauthorized, "invalid email or password")
+	AssertErrorResponse(s.T(), s.login("ada@example.com", "wrong password"), http.StatusUnauthorized, "invalid email or password")
+
+	resp := s.login("ada@example.com", "wrong password")
+	AssertErrorResponse(s.T(), resp, http.StatusLocked, "account is locked")
+	resp.Header("Retry-After").IsEqual("900")
+
+	AssertErrorResponse(s.T(), s.login("ada@example.com", "correct horse battery"), http.StatusLocked, "account is locked")
+	AssertErrorResponse(s.T(), s.login("nobody@example.com", "wrong password"), http.StatusUnauthorized, "invalid email or password")
+}
+
+func (s *AuthAPITestSuite) TestRefreshRotatesTokens() {
+	accessToken, refreshToken := s.signIn()
+
+	tokens := AssertSuccessResponse(s.T(), s.testApp.Client.POST("/auth/refresh").
+		WithJSON(map[string]string{"refresh_token": refreshToken}).Expect()).Value("result").Object()
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest(TestQueries.ListProducts, tokens.Value("access_token").String().Raw()))
+	AssertAuthError(s.T(), s.testApp.PostRequest(TestQueries.ListProducts, accessToken))
+
+	AssertErrorResponse(s.T(), s.testApp.Client.POST("/auth/refresh").
+		WithJSON(map[string]string{"refresh_token": refreshToken}).Expect(), http.StatusUnauthorized, "invalid or expired session")
+}
+
+func (s *AuthAPITestSuite) TestLogoutRevokesSession() {
+	accessToken, refreshToken := s.signIn()
+
+	obj := AssertSuccessResponse(s.T(), s.testApp.Client.POST("/auth/logout").
+		WithHeader("Authorization", "Bearer "+accessToken).Expect())
+	obj.Value("result").Object().Value("logged_out").IsEqual(true)
+
+	AssertAuthError(s.T(), s.testApp.PostRequest(TestQueries.ListProducts, accessToken))
+	AssertErrorResponse(s.T(), s.testApp.Client.GET("/webhooks").
+		WithHeader("Authorization", "Bearer "+accessToken).Expect(), http.StatusUnauthorized, "authentication failed")
+	AssertErrorResponse(s.T(), s.testApp.Client.POST("/auth/refresh").
+		WithJSON(map[string]string{"refresh_token": refreshToken}).Expect(), http.StatusUnauthorized, "invalid or expired session")
+}
+
+func TestAuthAPITestSuite(t *testing.T) {
+	suite.Run(t, new(AuthAPITestSuite))
+}
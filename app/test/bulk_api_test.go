@@ -0,0 +1,142 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"drm-app/app/config"
+	"drm-app/app/data"
+	"drm-app/app/drm"
+	"github.com/stretchr/testify/suite"
+)
+
+type BulkAPITestSuite struct {
+	suite.Suite
+	testApp *TestApp
+}
+
+func (s *BulkAPITestSuite) SetupTest() {
+	s.testApp = NewTestApp(s.T())
+
+	for _, query := range []string{
+		`create order json:{"user_id":"2","status":"pending","total_amount":100,"items":[{"product_id":"1","quantity":1}]}`,
+		`create order json:{"user_id":"2","status":"pending","total_amount":20,"items":[{"product_id":"2","quantity":1}]}`,
+		`create order json:{"user_id":"1","status":"cancelled","total_amount":30,"items":[{"product_id":"2","quantity":1}]}`,
+	} {
+		AssertSuccessResponse(s.T(), s.testApp.PostRequest(query, AdminToken))
+	}
+}
+
+func (s *BulkAPITestSuite) TestUpsertCreatesThenUpdates() {
+	obj := AssertSuccessResponse(s.T(), s.testApp.PostRequest(`upsert product json:{"name":"Keyboard","price":49.99}`, AdminToken))
+	obj.Value("result").Object().Value("operation").IsEqual("create")
+	obj.Value("result").Object().Value("record").Object().Value("id").IsEqual("3")
+
+	obj = AssertSuccessResponse(s.T(), s.testApp.PostRequest(`upsert product json:{"name":"keyboard","price":39.99}`, AdminToken))
+	result := obj.Value("result").Object()
+	result.Value("operation").IsEqual("update")
+	result.Value("record").Object().Value("id").IsEqual("3")
+	result.Value("record").Object().Value("price").IsEqual(39.99)
+
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest("list products with total", AdminToken)).
+		Value("page").Object().Value("total").IsEqual(3)
+}
+
+func (s *BulkAPITestSuite) TestUpsertNeedsKeyAndFullRecord() {
+	AssertErrorResponse(s.T(), s.testApp.PostRequest(`upsert user json:{"name":"ann"}`, AdminToken),
+		http.StatusInternalServerError, "upsert of user needs email")
+	AssertErrorResponse(s.T(), s.testApp.PostRequest(`upsert product json:{"name":"lamp"}`, AdminToken),
+		http.StatusInternalServerError, "product price must be positive")
+	AssertAccessDeniedError(s.T(), s.testApp.PostRequest(`upsert order json:{"status":"pending"}`, AdminToken))
+}
+
+func (s *BulkAPITestSuite) TestBulkUpdatePreviewThenConfirm() {
+	obj := AssertSuccessResponse(s.T(), s.testApp.PostRequest("update orders where status = pending set status = shipped", AdminToken))
+	obj.Value("result").Object().IsEqual(map[string]interface{}{"preview": true, "matched": 2, "affected": 0, "max_affected": 1000})
+
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest("count orders where status = shipped", AdminToken)).
+		Value("result").Object().Value("value").IsEqual(0)
+
+	obj = AssertSuccessResponse(s.T(), s.testApp.PostRequest("update orders where status = pending set status = shipped confirm 2", AdminToken))
+	obj.Value("result").Object().Value("affected").IsEqual(2)
+
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest("count orders where status = shipped", AdminToken)).
+		Value("result").Object().Value("value").IsEqual(2)
+}
+
+// events waits for n change events and fails if another arrives
+func (s *BulkAPITestSuite) events(subscription *drm.ChangeSubscription, n int) []data.ChangeEvent {
+	var events []data.ChangeEvent
+	for range n {
+		select {
+		case event := <-subscription.Events:
+			events = append(events, event)
+		case <-time.After(2 * time.Second):
+			s.FailNow("missing change event", "got %d of %d", len(events), n)
+		}
+	}
+	select {
+	case event := <-subscription.Events:
+		s.Failf("unexpected change event", "%s %s", event.Type, event.RecordID)
+	case <-time.After(100 * time.Millisecond):
+	}
+	return events
+}
+
+func (s *BulkAPITestSuite) TestBulkChangesArePublishedPerRecord() {
+	admin := &drm.User{ID: "1", Tenant: config.DefaultTenant, Roles: []string{"admin"}}
+	subscription, err := s.testApp.Engine.ChangeFeed.Subscribe(admin, drm.ChangeFilter{Entity: "order"})
+	s.Require().NoError(err)
+	defer subscription.Close()
+
+	// Previews change nothing and publish nothing
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest("update orders where status = pending set status = shipped", AdminToken))
+	s.events(subscription, 0)
+
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest("update orders where status = pending set status = shipped confirm 2", AdminToken))
+	updated := s.events(subscription, 2)
+	for i, id := range []string{"1", "2"} {
+		s.Equal("order.update", updated[i].Type)
+		s.Equal(id, updated[i].RecordID)
+		s.Equal("shipped", updated[i].Data.(map[string]interface{})["status"])
+	}
+
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest("delete orders where total_amount < 50 confirm 2", AdminToken))
+	deleted := s.events(subscription, 2)
+	for i, id := range []string{"2", "3"} {
+		s.Equal("order.delete", deleted[i].Type)
+		s.Equal(id, deleted[i].RecordID)
+	}
+}
+
+func (s *BulkAPITestSuite) TestBulkDeleteRejectsStalePreview() {
+	resp := s.testApp.PostRequest("delete orders where total_amount < 50 confirm 1", AdminToken)
+	AssertErrorResponse(s.T(), resp, http.StatusConflict, "2 now match, 1 confirmed")
+
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest("count orders", AdminToken)).
+		Value("result").Object().Value("value").IsEqual(3)
+
+	obj := AssertSuccessResponse(s.T(), s.testApp.PostRequest("delete orders where total_amount < 50 confirm 2", AdminToken))
+	obj.Value("result").Object().Value("affected").IsEqual(2)
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest("count orders", AdminToken)).
+		Value("result").Object().Value("value").IsEqual(1)
+}
+
+func (s *BulkAPITestSuite) TestBulkLimitAndPermissions() {
+	resp := s.testApp.PostRequest("delete orders where status = pending confirm 1001", AdminToken)
+	AssertErrorResponse(s.T(), resp, http.StatusForbidden, "bulk limit exceeded: 1001 records, at most 1000 allowed")
+
+	AssertAccessDeniedError(s.T(), s.testApp.PostRequest("update orders where status = pending set status = shipped", UserToken))
+}
+
+func (s *BulkAPITestSuite) TestBulkNeedsValidFilterAndValues() {
+	AssertValidationError(s.T(), s.testApp.PostRequest("update orders where items = x set status = shipped", AdminToken))
+	AssertValidationError(s.T(), s.testApp.PostRequest("update orders where status = pending set colour = red", AdminToken))
+	AssertValidationError(s.T(), s.testApp.PostRequest("update orders where status = pending set total_amount = lots", AdminToken))
+	AssertValidationError(s.T(), s.testApp.PostRequest("update orders where status = pending", AdminToken))
+}
+
+func TestBulkAPITestSuite(t *testing.T) {
+	suite.Run(t, new(BulkAPITestSuite))
+}
@@ -0,0 +1,100 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"drm-app/app/data"
+	"drm-app/app/db"
+	"github.com/gavv/httpexpect/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type ExplainAPITestSuite struct {
+	suite.Suite
+	testApp *TestApp
+}
+
+func (s *ExplainAPITestSuite) SetupTest() {
+	s.testApp = NewTestApp(s.T())
+}
+
+func (s *ExplainAPITestSuite) postDryRun(flag, query, token string) *httpexpect.Object {
+	resp := s.testApp.Client.POST("/request").
+		WithJSON(map[string]interface{}{"query": query, "token": token, flag: true}).
+		Expect()
+	obj := AssertSuccessResponse(s.T(), resp)
+	obj.Value("dry_run").IsEqual(true)
+	return obj.Value("result").Object()
+}
+
+func (s *ExplainAPITestSuite) TestDryRunDoesNotWrite() {
+	result := s.postDryRun("dry_run", `create user json:{"name":"Ann","email":"ann@example.com"}`, AdminToken)
+
+	command := result.Value("command").Object()
+	command.Value("action").IsEqual("create")
+	command.Value("entity").IsEqual("user")
+	command.Value("data").Object().Value("name").IsEqual("ann")
+	result.Value("user").Object().Value("roles").Array().IsEqual([]string{"admin"})
+	result.Value("policy").Object().IsEqual(map[string]interface{}{"allowed": true, "rule": "policy.roles.admin.user: create"})
+	result.Value("validation").Object().IsEqual(map[string]interface{}{"valid": true})
+	result.Value("note").IsEqual("the data agent does not report SQL")
+
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest("count users", AdminToken)).
+		Value("result").Object().Value("value").IsEqual(2)
+}
+
+func (s *ExplainAPITestSuite) TestExplainReportsDenialsAndValidationErrors() {
+	result := s.postDryRun("explain", `delete user json:{"id":"1"}`, GuestToken)
+	result.Value("policy").Object().IsEqual(map[string]interface{}{"allowed": false, "reason": `role "guest" has no permissions on user`})
+	result.Value("note").IsEqual("not executed: access denied")
+
+	result = s.postDryRun("explain", `create product json:{"name":"lamp","price":-5}`, AdminToken)
+	result.Value("policy").Object().Value("allowed").IsEqual(true)
+	validation := result.Value("validation").Object()
+	validation.Value("valid").IsEqual(false)
+	validation.Value("error").String().Contains("price")
+	result.Value("note").IsEqual("not executed: validation failed")
+}
+
+func (s *ExplainAPITestSuite) TestExplainShowsEffectiveLimits() {
+	result := s.postDryRun("explain", "list products", GuestToken)
+	result.Value("command").Object().Value("data").Object().Value("limit").IsEqual(20)
+}
+
+func (s *ExplainAPITestSuite) TestDryRunReportsEveryStatement() {
+	server := NewPostgresServer(s.T(), func(sql string) ([]string, [][]string) {
+		if strings.HasPrefix(sql, "SELECT count(*)") {
+			return []string{"count"}, [][]string{{"2"}}
+		}
+		return nil, nil
+	})
+	database, err := db.NewDatabase(server.Config)
+	require.NoError(s.T(), err)
+	defer database.Close()
+	s.testApp.Engine.DataAgent = data.NewPostgresDataAgent(database)
+
+	result := s.postDryRun("dry_run", "list 2 products with total", GuestToken)
+	result.NotContainsKey("note")
+	statements := result.Value("statements").Array()
+	statements.Length().IsEqual(2)
+	statements.Value(0).Object().Value("sql").String().HasPrefix("SELECT").Contains("FROM products").Contains("LIMIT 3")
+	statements.Value(1).Object().Value("sql").IsEqual("SELECT count(*) FROM products")
+
+	ran := server.Statements()
+	s.Contains(ran, "SELECT count(*) FROM products")
+	s.Contains(ran, "rollback")
+	s.NotContains(ran, "commit")
+}
+
+func (s *ExplainAPITestSuite) TestDryRunStillNeedsValidQuery() {
+	resp := s.testApp.Client.POST("/request").
+		WithJSON(map[string]interface{}{"query": "invalid query", "token": AdminToken, "dry_run": true}).
+		Expect()
+	AssertParsingError(s.T(), resp)
+}
+
+func TestExplainAPITestSuite(t *testing.T) {
+	suite.Run(t, new(ExplainAPITestSuite))
+}
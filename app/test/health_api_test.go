@@ -0,0 +1,85 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"drm-app/app/db"
+	"github.com/stretchr/testify/suite"
+)
+
+type HealthAPITestSuite struct {
+	suite.Suite
+	testApp *TestApp
+}
+
+func (s *HealthAPITestSuite) SetupSuite() {
+	s.testApp = NewTestApp(s.T())
+}
+
+func (s *HealthAPITestSuite) TestLiveness() {
+	s.testApp.Client.GET("/healthz").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status").String().IsEqual("ok")
+}
+
+func (s *HealthAPITestSuite) TestReadiness() {
+	obj := s.testApp.Client.GET("/readyz").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+
+	obj.Value("status").String().IsEqual("ok")
+	obj.ContainsKey("components")
+}
+
+func (s *HealthAPITestSuite) TestStatusForAdmin() {
+	result := s.testApp.Client.GET("/status").
+		WithHeader("Authorization", "Bearer "+AdminToken).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("result").Object()
+
+	result.Value("status").String().IsEqual("ok")
+	result.Value("versions").Object().ContainsKey("drm")
+	result.ContainsKey("uptime")
+}
+
+func (s *HealthAPITestSuite) TestStatusRequiresAdmin() {
+	s.testApp.Client.GET("/status").
+		WithHeader("Authorization", "Bearer "+UserToken).
+		Expect().
+		Status(http.StatusForbidden)
+}
+
+func (s *HealthAPITestSuite) TestMigrationsRunUnderAdvisoryLock() {
+	server := NewPostgresServer(s.T(), func(sql string) ([]string, [][]string) { return nil, nil })
+	database, err := db.NewDatabase(server.Config)
+	s.Require().NoError(err)
+	defer database.Close()
+
+	s.Require().NoError(database.Migrate(context.Background()))
+
+	// Replicas starting together wait on the lock, so every migration runs while it is held
+	var locked, applied, unlocked int
+	for i, statement := range server.Statements() {
+		switch {
+		case strings.Contains(statement, "pg_advisory_lock"):
+			locked = i + 1
+		case strings.Contains(statement, "INSERT INTO schema_migrations"):
+			applied = i + 1
+		case strings.Contains(statement, "pg_advisory_unlock"):
+			unlocked = i + 1
+		}
+	}
+	s.NotZero(locked)
+	s.Greater(applied, locked)
+	s.Greater(unlocked, applied)
+}
+
+func TestHealthAPITestSuite(t *testing.T) {
+	suite.Run(t, new(HealthAPITestSuite))
+}
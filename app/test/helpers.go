@@ -1,10 +1,12 @@
 package test
 
 import (
+	"net"
 	"net/http"
 	"testing"
 
 	"drm-app/app/drm"
+	"drm-app/app/handlers"
 	"github.com/gavv/httpexpect/v2"
 	"github.com/gofiber/fiber/v2"
 )
@@ -19,10 +21,11 @@ func NewTestApp(t *testing.T) *TestApp {
 	engine := drm.NewTestEngine()
 
 	app := fiber.New(fiber.Config{
-		AppName: "DRM Core Test v1.0.0",
+		AppName:      "DRM Core Test v1.0.0",
+		ErrorHandler: handlers.ErrorHandler,
 	})
 
-	app.Post("/request", createHandleRequest(engine))
+	handlers.Register(app, engine)
 
 	client := httpexpect.WithConfig(httpexpect.Config{
 		Client: &http.Client{
@@ -47,48 +50,22 @@ func NewTestApp(t *testing.T) *TestApp {
 	return testApp
 }
 
-func createHandleRequest(engine *drm.Engine) fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		return handleRequest(c, engine)
-	}
-}
-
-type RequestBody struct {
-	Query string `json:"query"`
-	Token string `json:"token"`
-}
-
-func handleRequest(c *fiber.Ctx, engine *drm.Engine) error {
-	var req RequestBody
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
-	}
-
-	if req.Query == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Query is required",
-		})
-	}
-
-	if req.Token == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Token is required",
-		})
-	}
-
-	result, err := engine.ProcessRequest(c.Context(), req.Query, req.Token)
+// Listen serves the app on a random local port for tests that need a real connection,
+// such as streaming responses, and returns its address
+func (ta *TestApp) Listen(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+		t.Fatalf("failed to listen: %v", err)
 	}
 
-	return c.JSON(fiber.Map{
-		"result": result,
-		"status": "success",
+	go ta.App.Listener(listener)
+	t.Cleanup(func() {
+		// End open subscription streams first; Shutdown waits for them to finish
+		ta.Engine.ChangeFeed.Close()
+		ta.App.Shutdown()
 	})
+
+	return listener.Addr().String()
 }
 
 func (ta *TestApp) PostRequest(query, token string) *httpexpect.Response {
@@ -0,0 +1,130 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"drm-app/app/data"
+	"drm-app/app/db"
+	"github.com/gavv/httpexpect/v2"
+	"github.com/stretchr/testify/suite"
+)
+
+type IdempotencyAPITestSuite struct {
+	suite.Suite
+	testApp *TestApp
+}
+
+func (s *IdempotencyAPITestSuite) SetupTest() {
+	s.testApp = NewTestApp(s.T())
+}
+
+func (s *IdempotencyAPITestSuite) postWithKey(query, key string) *httpexpect.Response {
+	return s.testApp.Client.POST("/request").
+		WithHeader("Idempotency-Key", key).
+		WithJSON(map[string]string{
+			"query": query,
+			"token": AdminToken,
+		}).
+		Expect()
+}
+
+func (s *IdempotencyAPITestSuite) TestReplaysCreateWithSameKey() {
+	first := s.postWithKey(TestQueries.CreateProduct, "create-product-1")
+	id := AssertSuccessResponse(s.T(), first).Value("result").Object().Value("id").Raw()
+	first.Header("Idempotent-Replayed").IsEmpty()
+
+	second := s.postWithKey(TestQueries.CreateProduct, "create-product-1")
+	AssertSuccessResponse(s.T(), second).Value("result").Object().Value("id").IsEqual(id)
+	second.Header("Idempotent-Replayed").IsEqual("true")
+}
+
+func (s *IdempotencyAPITestSuite) TestDifferentPayloadReturnsConflict() {
+	AssertSuccessResponse(s.T(), s.postWithKey(TestQueries.CreateProduct, "create-product-1"))
+
+	resp := s.postWithKey(`create product json:{"name":"Other Product","price":5}`, "create-product-1")
+
+	AssertErrorResponse(s.T(), resp, http.StatusConflict, "idempotency key conflict")
+}
+
+func (s *IdempotencyAPITestSuite) TestInvalidKeyIsRejected() {
+	resp := s.postWithKey(TestQueries.CreateProduct, "not a valid key")
+
+	AssertBadRequestError(s.T(), resp, "idempotency key must be printable ASCII without spaces")
+}
+
+func (s *IdempotencyAPITestSuite) TestFailedCreateCanBeRetried() {
+	AssertValidationError(s.T(), s.postWithKey(TestQueries.CreateUserNoName, "create-user-1"))
+
+	AssertSuccessResponse(s.T(), s.postWithKey(TestQueries.CreateUser, "create-user-1"))
+}
+
+func (s *IdempotencyAPITestSuite) TestBatchItemsReplay() {
+	batch := map[string]interface{}{
+		"token": AdminToken,
+		"items": []map[string]string{
+			{"query": TestQueries.CreateProduct, "idempotency_key": "batch-product-1"},
+			{"query": TestQueries.ListProducts},
+			{"query": TestQueries.InvalidQuery},
+		},
+	}
+
+	first := AssertSuccessResponse(s.T(), s.testApp.Client.POST("/request/batch").WithJSON(batch).Expect())
+	items := first.Value("result").Array()
+	items.Length().IsEqual(3)
+	items.Value(0).Object().Value("status").IsEqual("success")
+	items.Value(0).Object().NotContainsKey("replayed")
+	items.Value(1).Object().Value("status").IsEqual("success")
+	items.Value(2).Object().Value("status").IsEqual("error")
+	id := items.Value(0).Object().Value("result").Object().Value("id").Raw()
+
+	second := AssertSuccessResponse(s.T(), s.testApp.Client.POST("/request/batch").WithJSON(batch).Expect())
+	replayed := second.Value("result").Array().Value(0).Object()
+	replayed.Value("replayed").IsEqual(true)
+	replayed.Value("result").Object().Value("id").IsEqual(id)
+}
+
+func (s *IdempotencyAPITestSuite) TestBatchRequiresItems() {
+	resp := s.testApp.Client.POST("/request/batch").
+		WithJSON(map[string]interface{}{"token": AdminToken}).
+		Expect()
+
+	AssertBadRequestError(s.T(), resp, "Items are required")
+}
+
+func (s *IdempotencyAPITestSuite) TestPostgresStoreSweepsExpiredKeys() {
+	server := NewPostgresServer(s.T(), func(sql string) ([]string, [][]string) {
+		if strings.HasPrefix(strings.TrimSpace(sql), "INSERT INTO idempotency_keys") {
+			return []string{"key"}, [][]string{{"k"}}
+		}
+		return nil, nil
+	})
+	database, err := db.NewDatabase(server.Config)
+	s.Require().NoError(err)
+	defer database.Close()
+
+	store := data.NewPostgresIdempotencyStore(database)
+	now := time.Now()
+	for _, key := range []string{"k-1", "k-2", "k-3"} {
+		record := &data.IdempotencyRecord{Scope: "acme/token/1", Key: key, Fingerprint: "f", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+		existing, err := store.Reserve(context.Background(), record, now.Add(-time.Minute))
+		s.Require().NoError(err)
+		s.Nil(existing)
+	}
+
+	// Keys that are never sent again are deleted once expired, once per interval
+	sweeps := 0
+	for _, statement := range server.Statements() {
+		if strings.Contains(statement, "DELETE FROM idempotency_keys WHERE expires_at") {
+			sweeps++
+		}
+	}
+	s.Equal(1, sweeps)
+}
+
+func TestIdempotencyAPITestSuite(t *testing.T) {
+	suite.Run(t, new(IdempotencyAPITestSuite))
+}
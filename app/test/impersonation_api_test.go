@@ -0,0 +1,276 @@
+package test
+
+import (
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"drm-app/app/config"
+	"drm-app/app/data"
+	"drm-app/app/drm"
+	"drm-app/app/handlers"
+	"drm-app/app/logging"
+	"github.com/gavv/httpexpect/v2"
+	"github.com/stretchr/testify/suite"
+)
+
+const OpsAdminToken = "ops-admin-token"
+
+type ImpersonationAPITestSuite struct {
+	suite.Suite
+	testApp  *TestApp
+	logs     *logBuffer
+	previous *slog.Logger
+}
+
+func (s *ImpersonationAPITestSuite) SetupTest() {
+	s.logs = &logBuffer{}
+	logger, err := logging.NewLogger(s.logs, "info")
+	s.Require().NoError(err)
+	s.previous = slog.Default()
+	slog.SetDefault(logger)
+
+	s.testApp = NewTestApp(s.T())
+
+	auth := config.Default().Auth
+	auth.Tokens = append(auth.Tokens,
+		config.TokenConfig{Token: OpsAdminToken, UserID: "5", Name: "Ops", Role: "admin"},
+		config.TokenConfig{Token: AcmeAdminToken, UserID: "10", Name: "Acme Admin", Tenant: "acme", Role: "admin"},
+		config.TokenConfig{Token: AcmeUserToken, UserID: "11", Name: "Acme User", Tenant: "acme", Role: "user"},
+	)
+	s.testApp.Engine.AuthAgent = drm.NewAuthAgentFromConfig(auth)
+}
+
+func (s *ImpersonationAPITestSuite) TearDownTest() {
+	slog.SetDefault(s.previous)
+}
+
+func (s *ImpersonationAPITestSuite) actAs(query, token, user string) *httpexpect.Response {
+	return s.testApp.Client.POST("/request").
+		WithJSON(map[string]string{"query": query, "token": token, "act_as": user}).
+		Expect()
+}
+
+func (s *ImpersonationAPITestSuite) TestRunsPolicyAsUser() {
+	AssertSuccessResponse(s.T(), s.actAs(TestQueries.ListProducts, AdminToken, "token:3"))
+	AssertAccessDeniedError(s.T(), s.actAs(TestQueries.ListUsers, AdminToken, "token:3"))
+	AssertAccessDeniedError(s.T(), s.actAs(TestQueries.CreateProduct, AdminToken, "token:2"))
+
+	obj := AssertSuccessResponse(s.T(), s.actAs(TestQueries.ListProducts, AdminToken, "token:3"))
+	obj.Value("page").Object().Value("limit").IsEqual(20)
+}
+
+func (s *ImpersonationAPITestSuite) TestNeedsImpersonatePermission() {
+	AssertErrorResponse(s.T(), s.actAs(TestQueries.ListProducts, UserToken, "token:3"), http.StatusInternalServerError,
+		"access denied for action impersonate on entity user")
+}
+
+func (s *ImpersonationAPITestSuite) TestCannotTargetAdmins() {
+	AssertErrorResponse(s.T(), s.actAs(TestQueries.ListProducts, AdminToken, "token:5"), http.StatusInternalServerError,
+		"users who may impersonate cannot be impersonated")
+	AssertErrorResponse(s.T(), s.actAs(TestQueries.ListProducts, AdminToken, "token:1"), http.StatusInternalServerError,
+		"users who may impersonate cannot be impersonated")
+}
+
+func (s *ImpersonationAPITestSuite) TestNeedsIdentitySource() {
+	for _, user := range []string{"3", "token:", "service:3"} {
+		AssertErrorResponse(s.T(), s.actAs(TestQueries.ListProducts, AdminToken, user), http.StatusBadRequest, "identity source")
+	}
+	s.testApp.Client.GET("/entities/products").
+		WithHeader("Authorization", "Bearer "+AdminToken).
+		WithHeader(handlers.ActAsHeader, "3").
+		Expect().
+		Status(http.StatusBadRequest)
+}
+
+func (s *ImpersonationAPITestSuite) TestCannotTargetUsersWhoInheritImpersonate() {
+	// In acme, leads inherit a conditional impersonate permission from support
+	policy := config.Default().Policy
+	policy.Tenants = map[string]config.TenantPolicyConfig{"acme": {
+		Roles: map[string]map[string][]string{
+			"admin":   {"*": {"*"}},
+			"user":    {"product": {"read"}},
+			"support": {"user": {"impersonate"}},
+			"lead":    {"product": {"read"}},
+		},
+		Inherits:   map[string][]string{"lead": {"support"}},
+		Conditions: map[string]map[string]map[string]string{"support": {"user": {"impersonate": `data.id != user.id`}}},
+	}}
+	s.testApp.Engine.AccessPolicyAgent = drm.NewAccessPolicyAgentFromConfig(policy)
+
+	auth := config.Default().Auth
+	auth.Tokens = append(auth.Tokens,
+		config.TokenConfig{Token: AcmeAdminToken, UserID: "10", Name: "Acme Admin", Tenant: "acme", Role: "admin"},
+		config.TokenConfig{Token: AcmeUserToken, UserID: "11", Name: "Acme User", Tenant: "acme", Role: "user"},
+		config.TokenConfig{Token: "acme-lead-token", UserID: "12", Name: "Acme Lead", Tenant: "acme", Role: "lead"},
+	)
+	s.testApp.Engine.AuthAgent = drm.NewAuthAgentFromConfig(auth)
+
+	AssertErrorResponse(s.T(), s.actAs(TestQueries.ListProducts, AcmeAdminToken, "token:12"), http.StatusInternalServerError,
+		"users who may impersonate cannot be impersonated")
+	AssertSuccessResponse(s.T(), s.actAs(TestQueries.ListProducts, AcmeAdminToken, "token:11"))
+}
+
+func (s *ImpersonationAPITestSuite) TestTargetsOnlyKnownUsersOfTenant() {
+	AssertErrorResponse(s.T(), s.actAs(TestQueries.ListProducts, AdminToken, "token:99"), http.StatusInternalServerError, "no such token user")
+	AssertErrorResponse(s.T(), s.actAs(TestQueries.ListProducts, AdminToken, "token:11"), http.StatusInternalServerError, "no such token user")
+	AssertErrorResponse(s.T(), s.actAs(TestQueries.ListProducts, AdminToken, "account:3"), http.StatusInternalServerError, "no such account user")
+}
+
+func (s *ImpersonationAPITestSuite) TestActsAsAccounts() {
+	accounts := config.Default().Auth.Accounts
+	accounts.Registration = true
+	accounts.Tenant = "acme"
+	store := data.NewTestCredentialStore(s.testApp.Engine.DataAgent.(*data.TestDataAgent))
+	s.testApp.Engine.AccountAgent = drm.NewAccountAgent(store, accounts)
+
+	s.testApp.Client.POST("/auth/register").
+		WithJSON(map[string]string{"name": "Ada", "email": "ada@example.com", "password": "correct horse battery"}).
+		Expect().Status(http.StatusCreated)
+
+	// Registered users are found by the ID of their user record
+	obj := AssertSuccessResponse(s.T(), s.actAs(`read user json:{"id":"1"}`, AcmeAdminToken, "account:1"))
+	obj.Value("result").Object().Value("email").IsEqual("ada@example.com")
+	AssertAccessDeniedError(s.T(), s.actAs(TestQueries.CreateProduct, AcmeAdminToken, "account:1"))
+}
+
+func (s *ImpersonationAPITestSuite) TestAuditsBothIdentities() {
+	subscription, err := s.testApp.Engine.ChangeFeed.Subscribe(&drm.User{ID: "1", Tenant: config.DefaultTenant, Roles: []string{"admin"}}, drm.ChangeFilter{Entity: "order"})
+	s.Require().NoError(err)
+	defer subscription.Close()
+
+	resp := s.testApp.Client.POST("/request").
+		WithHeader(handlers.RequestIDHeader, "impersonated").
+		WithJSON(map[string]string{"query": TestQueries.CreateOrder, "token": AdminToken, "act_as": "token:2"}).
+		Expect()
+	AssertSuccessResponse(s.T(), resp)
+
+	select {
+	case event := <-subscription.Events:
+		s.Equal("2", event.UserID)
+		s.Equal("1", event.ImpersonatorID)
+	case <-time.After(2 * time.Second):
+		s.Fail("no change event")
+	}
+
+	var processed, allowed map[string]interface{}
+	for _, entry := range s.logs.entries() {
+		if entry["request_id"] != "impersonated" {
+			continue
+		}
+		switch entry["msg"] {
+		case "request processed":
+			processed = entry
+		case "impersonation allowed":
+			allowed = entry
+		}
+	}
+	s.Require().NotNil(processed)
+	s.Equal("2", processed["user_id"])
+	s.Equal("user", processed["role"])
+	s.Equal("1", processed["impersonator_id"])
+	s.Require().NotNil(allowed)
+	s.Equal("1", allowed["impersonator_id"])
+	s.Equal("2", allowed["user_id"])
+
+	// Refusals are logged too
+	AssertAccessDeniedError(s.T(), s.actAs(TestQueries.ListProducts, UserToken, "token:3"))
+	var denied map[string]interface{}
+	for _, entry := range s.logs.entries() {
+		if entry["msg"] == "impersonation denied" {
+			denied = entry
+		}
+	}
+	s.Require().NotNil(denied)
+	s.Equal("2", denied["impersonator_id"])
+	s.Equal("3", denied["user_id"])
+}
+
+func (s *ImpersonationAPITestSuite) TestRecordsEveryAttemptInAuditTrail() {
+	AssertSuccessResponse(s.T(), s.actAs(TestQueries.ListProducts, AdminToken, "token:3"))
+	AssertAccessDeniedError(s.T(), s.actAs(TestQueries.ListUsers, AdminToken, "token:3"))
+	AssertAccessDeniedError(s.T(), s.actAs(TestQueries.ListProducts, UserToken, "token:3"))
+
+	entries := s.testApp.Engine.Impersonations.(*data.TestImpersonationAuditStore).Entries()
+	s.Require().Len(entries, 3)
+
+	read := entries[0]
+	s.Equal(config.DefaultTenant, read.Tenant)
+	s.Equal("1", read.ImpersonatorID)
+	s.Equal(drm.SourceToken, read.ImpersonatorSource)
+	s.Equal("3", read.UserID)
+	s.Equal(drm.SourceToken, read.UserSource)
+	s.Equal("product", read.Entity)
+	s.Equal("read", read.Action)
+	s.Equal("success", read.Outcome)
+	s.Empty(read.Error)
+
+	denied := entries[1]
+	s.Equal("user", denied.Entity)
+	s.Equal("policy", denied.Stage)
+	s.Equal("denied", denied.Outcome)
+	s.Contains(denied.Error, "access denied")
+
+	refused := entries[2]
+	s.Equal("2", refused.ImpersonatorID)
+	s.Equal("3", refused.UserID)
+	s.Equal(drm.SourceToken, refused.UserSource)
+	s.Equal("unknown", refused.Action)
+	s.Equal("impersonation", refused.Stage)
+	s.Equal("denied", refused.Outcome)
+
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest(TestQueries.ListProducts, AdminToken))
+	s.Len(s.testApp.Engine.Impersonations.(*data.TestImpersonationAuditStore).Entries(), 3)
+}
+
+func (s *ImpersonationAPITestSuite) TestIdempotencyKeysIncludeImpersonator() {
+	create := func(token, actAs string) *httpexpect.Response {
+		return s.testApp.Client.POST("/request").
+			WithHeader(handlers.IdempotencyKeyHeader, "order-1").
+			WithJSON(map[string]string{"query": TestQueries.CreateOrder, "token": token, "act_as": actAs}).
+			Expect()
+	}
+
+	own := create(UserToken, "")
+	AssertSuccessResponse(s.T(), own)
+	own.Header(handlers.IdempotentReplayedHeader).IsEmpty()
+
+	impersonated := create(AdminToken, "token:2")
+	AssertSuccessResponse(s.T(), impersonated)
+	impersonated.Header(handlers.IdempotentReplayedHeader).IsEmpty()
+
+	other := create(OpsAdminToken, "token:2")
+	AssertSuccessResponse(s.T(), other)
+	other.Header(handlers.IdempotentReplayedHeader).IsEmpty()
+
+	create(AdminToken, "token:2").Header(handlers.IdempotentReplayedHeader).IsEqual("true")
+	create(UserToken, "").Header(handlers.IdempotentReplayedHeader).IsEqual("true")
+}
+
+func (s *ImpersonationAPITestSuite) TestDryRunShowsImpersonator() {
+	resp := s.testApp.Client.POST("/request").
+		WithJSON(map[string]interface{}{"query": TestQueries.ListUsers, "token": AdminToken, "act_as": "token:2", "dry_run": true}).
+		Expect()
+	user := AssertSuccessResponse(s.T(), resp).Value("result").Object().Value("user").Object()
+	user.Value("id").IsEqual("2")
+	user.Value("impersonator").Object().Value("id").IsEqual("1")
+}
+
+func (s *ImpersonationAPITestSuite) TestRESTRoutesTakeHeader() {
+	s.testApp.Client.GET("/entities/users").
+		WithHeader("Authorization", "Bearer "+AdminToken).
+		WithHeader(handlers.ActAsHeader, "token:3").
+		Expect().
+		Status(http.StatusForbidden)
+
+	s.testApp.Client.GET("/entities/products").
+		WithHeader("Authorization", "Bearer "+AdminToken).
+		WithHeader(handlers.ActAsHeader, "token:3").
+		Expect().
+		Status(http.StatusOK)
+}
+
+func TestImpersonationAPITestSuite(t *testing.T) {
+	suite.Run(t, new(ImpersonationAPITestSuite))
+}
@@ -0,0 +1,173 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"drm-app/app/handlers"
+	"drm-app/app/logging"
+	"github.com/stretchr/testify/suite"
+)
+
+// logBuffer collects log output that background goroutines may write concurrently
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) entries() []map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		var entry map[string]interface{}
+		if json.Unmarshal([]byte(line), &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func (b *logBuffer) raw() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+type LoggingAPITestSuite struct {
+	suite.Suite
+	testApp  *TestApp
+	logs     *logBuffer
+	previous *slog.Logger
+}
+
+func (s *LoggingAPITestSuite) SetupTest() {
+	s.logs = &logBuffer{}
+	logger, err := logging.NewLogger(s.logs, "debug")
+	s.Require().NoError(err)
+
+	s.previous = slog.Default()
+	slog.SetDefault(logger)
+	s.testApp = NewTestApp(s.T())
+}
+
+func (s *LoggingAPITestSuite) TearDownTest() {
+	slog.SetDefault(s.previous)
+}
+
+func (s *LoggingAPITestSuite) messages(requestID string) map[string]map[string]interface{} {
+	messages := map[string]map[string]interface{}{}
+	for _, entry := range s.logs.entries() {
+		if entry["request_id"] == requestID {
+			messages[entry["msg"].(string)] = entry
+		}
+	}
+	return messages
+}
+
+func (s *LoggingAPITestSuite) TestGeneratesRequestID() {
+	resp := s.testApp.PostRequest(TestQueries.ListProducts, GuestToken)
+	AssertSuccessResponse(s.T(), resp)
+
+	requestID := resp.Header(handlers.RequestIDHeader).NotEmpty().Raw()
+	s.Contains(s.messages(requestID), "http request")
+}
+
+func (s *LoggingAPITestSuite) TestAcceptsIncomingRequestID() {
+	s.testApp.Client.POST("/request").
+		WithHeader(handlers.RequestIDHeader, "client-req-42").
+		WithJSON(map[string]string{"query": TestQueries.ListProducts, "token": GuestToken}).
+		Expect().
+		Status(http.StatusOK).
+		Header(handlers.RequestIDHeader).IsEqual("client-req-42")
+}
+
+func (s *LoggingAPITestSuite) TestReplacesUnsafeRequestID() {
+	unsafe := "bad id\nwith newline"
+	requestID := s.testApp.Client.GET("/healthz").
+		WithHeader(handlers.RequestIDHeader, unsafe).
+		Expect().
+		Status(http.StatusOK).
+		Header(handlers.RequestIDHeader).NotEmpty().Raw()
+
+	s.NotEqual(unsafe, requestID)
+}
+
+func (s *LoggingAPITestSuite) TestErrorBodyCarriesRequestID() {
+	resp := s.testApp.Client.POST("/request").
+		WithHeader(handlers.RequestIDHeader, "failing-request").
+		WithJSON(map[string]string{"query": TestQueries.ListUsers}).
+		Expect()
+
+	AssertBadRequestError(s.T(), resp, "Token is required")
+	resp.JSON().Object().Value("request_id").String().IsEqual("failing-request")
+}
+
+func (s *LoggingAPITestSuite) TestUnknownRouteCarriesRequestID() {
+	s.testApp.Client.GET("/does-not-exist").
+		WithHeader(handlers.RequestIDHeader, "missing-route").
+		Expect().
+		Status(http.StatusNotFound).
+		JSON().Object().
+		Value("request_id").String().IsEqual("missing-route")
+}
+
+func (s *LoggingAPITestSuite) TestCorrelatesEngineLogs() {
+	s.testApp.Client.POST("/request").
+		WithHeader(handlers.RequestIDHeader, "correlated").
+		WithJSON(map[string]string{"query": TestQueries.CreateProduct, "token": AdminToken}).
+		Expect().
+		Status(http.StatusOK)
+
+	messages := s.messages("correlated")
+	s.Require().Contains(messages, "command parsed")
+	s.Require().Contains(messages, "request processed")
+	s.Require().Contains(messages, "http request")
+
+	processed := messages["request processed"]
+	s.Equal("product", processed["entity"])
+	s.Equal("create", processed["action"])
+	s.Equal("success", processed["outcome"])
+
+	request := messages["http request"]
+	s.Equal("/request", request["route"])
+	s.Equal(float64(http.StatusOK), request["status"])
+}
+
+func (s *LoggingAPITestSuite) TestRedactsSensitiveFields() {
+	query := `create user json:{"name":"Secret Keeper","email":"keeper@example.com","password":"hunter2"}`
+	s.testApp.PostRequest(query, AdminToken)
+
+	s.NotContains(s.logs.raw(), "hunter2")
+	s.NotContains(s.logs.raw(), AdminToken)
+
+	parsed := s.messages(s.lastRequestID())["command parsed"]
+	s.Require().NotNil(parsed)
+	s.Equal(logging.RedactedValue, parsed["data"].(map[string]interface{})["password"])
+}
+
+func (s *LoggingAPITestSuite) lastRequestID() string {
+	entries := s.logs.entries()
+	for i := len(entries) - 1; i >= 0; i-- {
+		if requestID, ok := entries[i]["request_id"].(string); ok {
+			return requestID
+		}
+	}
+	return ""
+}
+
+func TestLoggingAPITestSuite(t *testing.T) {
+	suite.Run(t, new(LoggingAPITestSuite))
+}
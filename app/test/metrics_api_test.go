@@ -0,0 +1,48 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MetricsAPITestSuite struct {
+	suite.Suite
+	testApp *TestApp
+}
+
+func (s *MetricsAPITestSuite) SetupSuite() {
+	s.testApp = NewTestApp(s.T())
+}
+
+func (s *MetricsAPITestSuite) TestExposesEngineAndHTTPMetrics() {
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest(TestQueries.ListProducts, GuestToken))
+
+	body := s.testApp.Client.GET("/metrics").
+		Expect().
+		Status(http.StatusOK).
+		Body()
+
+	body.Contains(`drm_engine_requests_total{action="read",entity="product",outcome="success"}`)
+	body.Contains(`drm_engine_stage_duration_seconds_bucket{action="read",entity="product",outcome="success",stage="execution"`)
+	body.Contains(`drm_http_requests_total{method="POST",route="/request",status="200"}`)
+}
+
+func (s *MetricsAPITestSuite) TestRouteTemplateIsUsedAsLabel() {
+	s.testApp.Client.GET("/webhooks/42").
+		WithHeader("Authorization", "Bearer "+AdminToken).
+		Expect().
+		Status(http.StatusNotFound)
+
+	s.testApp.Client.GET("/metrics").
+		Expect().
+		Status(http.StatusOK).
+		Body().
+		Contains(`route="/webhooks/:id",status="404"`).
+		NotContains(`route="/webhooks/42"`)
+}
+
+func TestMetricsAPITestSuite(t *testing.T) {
+	suite.Run(t, new(MetricsAPITestSuite))
+}
@@ -0,0 +1,176 @@
+package test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"drm-app/app/config"
+	"drm-app/app/data"
+	"drm-app/app/drm"
+	"github.com/gavv/httpexpect/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type OIDCAPITestSuite struct {
+	suite.Suite
+	testApp  *TestApp
+	provider *OIDCProvider
+	cfg      config.OIDCConfig
+	store    *data.TestCredentialStore
+}
+
+func (s *OIDCAPITestSuite) SetupTest() {
+	s.testApp = NewTestApp(s.T())
+	s.provider = NewOIDCProvider(s.T())
+	s.provider.Claims = map[string]interface{}{
+		"sub":            "ada-1815",
+		"name":           "Ada Lovelace",
+		"email":          "Ada@Example.com",
+		"email_verified": true,
+		"groups":         []string{"engineering", "drm-admins"},
+	}
+
+	s.cfg = s.provider.Config()
+	s.cfg.Roles = map[string][]string{"drm-admins": {"admin"}, "drm-users": {"user"}}
+
+	engine := s.testApp.Engine
+	s.store = data.NewTestCredentialStore(engine.DataAgent.(*data.TestDataAgent))
+	engine.AccountAgent = drm.NewAccountAgent(s.store, config.Default().Auth.Accounts)
+	engine.OIDCAgent = drm.NewOIDCAgent(s.cfg, s.store, engine.AccountAgent)
+}
+
+// startLogin asks the app for the provider's authorization URL
+func (s *OIDCAPITestSuite) startLogin() *url.URL {
+	location := s.testApp.Client.GET("/auth/oidc/login").
+		WithRedirectPolicy(httpexpect.DontFollowRedirects).
+		Expect().
+		Status(http.StatusFound).
+		Header("Location").Raw()
+	authorizationURL, err := url.Parse(location)
+	require.NoError(s.T(), err)
+	return authorizationURL
+}
+
+func (s *OIDCAPITestSuite) callback(query string) *httpexpect.Response {
+	return s.testApp.Client.GET("/auth/oidc/callback").WithQueryString(query).Expect()
+}
+
+// signIn goes through the whole flow and returns the callback's response
+func (s *OIDCAPITestSuite) signIn() *httpexpect.Response {
+	return s.callback(s.provider.Authorize(s.T(), s.startLogin().String()).RawQuery)
+}
+
+func (s *OIDCAPITestSuite) accessToken(resp *httpexpect.Response) string {
+	return AssertSuccessResponse(s.T(), resp).Value("result").Object().Value("access_token").String().Raw()
+}
+
+func (s *OIDCAPITestSuite) TestLoginRedirectsToProvider() {
+	authorizationURL := s.startLogin()
+	assert.Equal(s.T(), s.provider.Server.URL+"/authorize", authorizationURL.Scheme+"://"+authorizationURL.Host+authorizationURL.Path)
+
+	query := authorizationURL.Query()
+	assert.Equal(s.T(), "code", query.Get("response_type"))
+	assert.Equal(s.T(), "drm", query.Get("client_id"))
+	assert.Equal(s.T(), "http://drm.test/auth/oidc/callback", query.Get("redirect_uri"))
+	assert.Equal(s.T(), "openid profile email", query.Get("scope"))
+	assert.Equal(s.T(), "S256", query.Get("code_challenge_method"))
+	assert.NotEmpty(s.T(), query.Get("code_challenge"))
+	assert.NotEmpty(s.T(), query.Get("nonce"))
+	assert.NotEqual(s.T(), query.Get("state"), s.startLogin().Query().Get("state"))
+}
+
+func (s *OIDCAPITestSuite) TestSignInProvisionsUser() {
+	tokens := AssertSuccessResponse(s.T(), s.signIn()).Value("result").Object()
+	tokens.Value("token_type").IsEqual("Bearer")
+	accessToken := tokens.Value("access_token").String().Raw()
+
+	obj := AssertSuccessResponse(s.T(), s.testApp.PostRequest(`read user json:{"id":"3"}`, accessToken))
+	user := obj.Value("result").Object()
+	user.Value("name").IsEqual("Ada Lovelace")
+	user.Value("email").IsEqual("ada@example.com")
+
+	// The drm-admins group maps to the admin role
+	AssertSuccessResponse(s.T(), s.testApp.Client.GET("/policy/matrix").
+		WithHeader("Authorization", "Bearer "+accessToken).Expect())
+
+	// Signing in again reuses the provisioned user
+	accessToken = s.accessToken(s.signIn())
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest(`read user json:{"id":"3"}`, accessToken))
+	AssertErrorResponse(s.T(), s.testApp.PostRequest(`read user json:{"id":"4"}`, accessToken), http.StatusInternalServerError, "not found")
+}
+
+func (s *OIDCAPITestSuite) TestVerifiedEmailLinksExistingUser() {
+	s.provider.Claims["email"] = "john@example.com"
+	accessToken := s.accessToken(s.signIn())
+
+	obj := AssertSuccessResponse(s.T(), s.testApp.PostRequest(`read user json:{"id":"1"}`, accessToken))
+	obj.Value("result").Object().Value("name").IsEqual("John Doe")
+
+	s.provider.Claims["sub"] = "someone-else"
+	s.provider.Claims["email_verified"] = false
+	AssertErrorResponse(s.T(), s.signIn(), http.StatusConflict, "already registered")
+}
+
+func (s *OIDCAPITestSuite) TestGroupsWithoutRoleAreRefused() {
+	s.provider.Claims["groups"] = []string{"engineering"}
+	AssertErrorResponse(s.T(), s.signIn(), http.StatusForbidden, "no role is mapped")
+
+	s.provider.Claims["groups"] = "drm-users"
+	accessToken := s.accessToken(s.signIn())
+	AssertErrorResponse(s.T(), s.testApp.Client.GET("/policy/matrix").
+		WithHeader("Authorization", "Bearer "+accessToken).Expect(), http.StatusForbidden, "access denied")
+}
+
+func (s *OIDCAPITestSuite) TestStateIsSingleUse() {
+	callback := s.provider.Authorize(s.T(), s.startLogin().String())
+
+	AssertErrorResponse(s.T(), s.callback("code=forged&state=unknown"), http.StatusUnauthorized, "unknown or expired state")
+	AssertSuccessResponse(s.T(), s.callback(callback.RawQuery))
+	AssertErrorResponse(s.T(), s.callback(callback.RawQuery), http.StatusUnauthorized, "unknown or expired state")
+}
+
+func (s *OIDCAPITestSuite) TestCallbackReachesAnotherInstance() {
+	callback := s.provider.Authorize(s.T(), s.startLogin().String())
+
+	// Another replica shares the store but has its own agent
+	engine := s.testApp.Engine
+	engine.OIDCAgent = drm.NewOIDCAgent(s.cfg, s.store, engine.AccountAgent)
+	AssertSuccessResponse(s.T(), s.callback(callback.RawQuery))
+}
+
+func (s *OIDCAPITestSuite) TestProviderCodeRequiresVerifier() {
+	callback := s.provider.Authorize(s.T(), s.startLogin().String())
+
+	// A code only works with the verifier of the sign-in it was issued to
+	other := s.provider.Authorize(s.T(), s.startLogin().String())
+	query := other.Query()
+	query.Set("code", callback.Query().Get("code"))
+	AssertErrorResponse(s.T(), s.callback(query.Encode()), http.StatusUnauthorized, "invalid_grant")
+}
+
+func (s *OIDCAPITestSuite) TestRejectsInvalidIDTokens() {
+	tampered := map[string]func(claims map[string]interface{}){
+		"not issued for this client": func(claims map[string]interface{}) { claims["aud"] = "another-client" },
+		"nonce does not match":       func(claims map[string]interface{}) { claims["nonce"] = "replayed" },
+		"has expired":                func(claims map[string]interface{}) { claims["exp"] = 1 },
+		"issuer":                     func(claims map[string]interface{}) { claims["iss"] = "https://evil.example.com" },
+	}
+	for reason, tamper := range tampered {
+		s.provider.Tamper = tamper
+		AssertErrorResponse(s.T(), s.signIn(), http.StatusUnauthorized, reason)
+	}
+}
+
+func (s *OIDCAPITestSuite) TestProviderErrors() {
+	AssertErrorResponse(s.T(), s.callback("error=access_denied&state=x"), http.StatusUnauthorized, "provider returned access_denied")
+
+	s.testApp.Engine.OIDCAgent = nil
+	AssertErrorResponse(s.T(), s.testApp.Client.GET("/auth/oidc/login").Expect(), http.StatusNotFound, "not configured")
+}
+
+func TestOIDCAPITestSuite(t *testing.T) {
+	suite.Run(t, new(OIDCAPITestSuite))
+}
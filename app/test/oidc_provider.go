@@ -0,0 +1,214 @@
+package test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"drm-app/app/config"
+)
+
+const (
+	oidcClientID     = "drm"
+	oidcClientSecret = "drm-secret"
+	oidcRedirectURL  = "http://drm.test/auth/oidc/callback"
+)
+
+var (
+	oidcKeyOnce sync.Once
+	oidcKey     *rsa.PrivateKey
+)
+
+// OIDCProvider is a minimal OpenID Connect provider served in-process, so sign-in tests run
+// without a network. Its authorize endpoint signs the user with Claims in without asking.
+type OIDCProvider struct {
+	Server *httptest.Server
+	// Claims are those of the user signing in next; iss, aud, exp, iat and nonce are added
+	Claims map[string]interface{}
+	// Tamper, if set, changes the claims of ID tokens after they are complete
+	Tamper func(claims map[string]interface{})
+
+	key   *rsa.PrivateKey
+	mu    sync.Mutex
+	codes map[string]oidcAuthorization
+}
+
+type oidcAuthorization struct {
+	redirectURI string
+	nonce       string
+	challenge   string
+	claims      map[string]interface{}
+}
+
+func NewOIDCProvider(t *testing.T) *OIDCProvider {
+	oidcKeyOnce.Do(func() {
+		var err error
+		if oidcKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatalf("failed to generate provider key: %v", err)
+		}
+	})
+
+	p := &OIDCProvider{
+		key:   oidcKey,
+		codes: make(map[string]oidcAuthorization),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /authorize", p.authorize)
+	mux.HandleFunc("POST /token", p.token)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Server.Close)
+	return p
+}
+
+// Config returns the OIDC settings of a client registered with the provider
+func (p *OIDCProvider) Config() config.OIDCConfig {
+	cfg := config.Default().Auth.OIDC
+	cfg.Enabled = true
+	cfg.Issuer = p.Server.URL
+	cfg.ClientID = oidcClientID
+	cfg.ClientSecret = oidcClientSecret
+	cfg.RedirectURL = oidcRedirectURL
+	return cfg
+}
+
+// Authorize follows the authorization URL the app redirected to and returns the callback URL
+// the provider sends the user back to
+func (p *OIDCProvider) Authorize(t *testing.T, authorizationURL string) *url.URL {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authorizationURL)
+	if err != nil {
+		t.Fatalf("failed to authorize: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize returned %d", resp.StatusCode)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid callback URL: %v", err)
+	}
+	return callback
+}
+
+func (p *OIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.Server.URL,
+		"authorization_endpoint":                p.Server.URL + "/authorize",
+		"token_endpoint":                        p.Server.URL + "/token",
+		"jwks_uri":                              p.Server.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *OIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("response_type") != "code" || query.Get("client_id") != oidcClientID ||
+		query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	code := base64.RawURLEncoding.EncodeToString(randomBytes())
+	p.mu.Lock()
+	p.codes[code] = oidcAuthorization{
+		redirectURI: query.Get("redirect_uri"),
+		nonce:       query.Get("nonce"),
+		challenge:   query.Get("code_challenge"),
+		claims:      p.Claims,
+	}
+	p.mu.Unlock()
+
+	callback, _ := url.Parse(query.Get("redirect_uri"))
+	params := url.Values{"code": {code}, "state": {query.Get("state")}}
+	callback.RawQuery = params.Encode()
+	http.Redirect(w, r, callback.String(), http.StatusFound)
+}
+
+func (p *OIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok || clientID != oidcClientID || secret != oidcClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	p.mu.Lock()
+	authorization, exists := p.codes[r.FormValue("code")]
+	delete(p.codes, r.FormValue("code"))
+	p.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !exists || r.FormValue("grant_type") != "authorization_code" || r.FormValue("redirect_uri") != authorization.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(verifier[:]) != authorization.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":   p.Server.URL,
+		"aud":   oidcClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": authorization.nonce,
+	}
+	for name, value := range authorization.claims {
+		claims[name] = value
+	}
+	if p.Tamper != nil {
+		p.Tamper(claims)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": base64.RawURLEncoding.EncodeToString(randomBytes()),
+		"token_type":   "Bearer",
+		"id_token":     p.sign(claims),
+	})
+}
+
+func (p *OIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": "test-key",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (p *OIDCProvider) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "test-key"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func randomBytes() []byte {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return buf
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,124 @@
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gavv/httpexpect/v2"
+	"github.com/stretchr/testify/suite"
+)
+
+type PaginationAPITestSuite struct {
+	suite.Suite
+	testApp *TestApp
+}
+
+func (s *PaginationAPITestSuite) SetupTest() {
+	s.testApp = NewTestApp(s.T())
+
+	for i := 1; i <= 3; i++ {
+		query := fmt.Sprintf(`create product json:{"name":"Product %d","price":%d}`, i, i)
+		AssertSuccessResponse(s.T(), s.testApp.PostRequest(query, AdminToken))
+	}
+}
+
+func (s *PaginationAPITestSuite) ids(obj *httpexpect.Object) []interface{} {
+	var ids []interface{}
+	for _, item := range obj.Value("result").Array().Iter() {
+		ids = append(ids, item.Object().Value("id").Raw())
+	}
+	return ids
+}
+
+func (s *PaginationAPITestSuite) TestWalksPagesForwardAndBack() {
+	first := AssertSuccessResponse(s.T(), s.testApp.PostRequest("list 2 products with total", GuestToken))
+	s.Equal([]interface{}{"1", "2"}, s.ids(first))
+	page := first.Value("page").Object()
+	page.Value("limit").IsEqual(2)
+	page.Value("total").IsEqual(5)
+	page.NotContainsKey("prev")
+	next := page.Value("next").String().Raw()
+
+	second := AssertSuccessResponse(s.T(), s.testApp.PostRequest("list 2 products cursor "+next, GuestToken))
+	s.Equal([]interface{}{"3", "4"}, s.ids(second))
+	second.Value("page").Object().NotContainsKey("total")
+	next = second.Value("page").Object().Value("next").String().Raw()
+
+	last := AssertSuccessResponse(s.T(), s.testApp.PostRequest("list 2 products cursor "+next, GuestToken))
+	s.Equal([]interface{}{"5"}, s.ids(last))
+	last.Value("page").Object().NotContainsKey("next")
+	prev := last.Value("page").Object().Value("prev").String().Raw()
+
+	back := AssertSuccessResponse(s.T(), s.testApp.PostRequest("list 2 products cursor "+prev, GuestToken))
+	s.Equal([]interface{}{"3", "4"}, s.ids(back))
+	prev = back.Value("page").Object().Value("prev").String().Raw()
+
+	start := AssertSuccessResponse(s.T(), s.testApp.PostRequest("list 2 products cursor "+prev, GuestToken))
+	s.Equal([]interface{}{"1", "2"}, s.ids(start))
+	start.Value("page").Object().NotContainsKey("prev")
+}
+
+func (s *PaginationAPITestSuite) TestPageSizeIsCappedPerRole() {
+	guest := AssertSuccessResponse(s.T(), s.testApp.PostRequest("list products limit 500", GuestToken))
+	guest.Value("page").Object().Value("limit").IsEqual(50)
+
+	admin := AssertSuccessResponse(s.T(), s.testApp.PostRequest("list products limit 500", AdminToken))
+	admin.Value("page").Object().Value("limit").IsEqual(500)
+
+	defaults := AssertSuccessResponse(s.T(), s.testApp.PostRequest(TestQueries.ListProducts, GuestToken))
+	defaults.Value("page").Object().Value("limit").IsEqual(20)
+}
+
+func (s *PaginationAPITestSuite) TestRESTListing() {
+	resp := s.testApp.Client.GET("/entities/products").
+		WithHeader("Authorization", "Bearer "+GuestToken).
+		WithQuery("limit", 3).
+		WithQuery("include_total", true).
+		Expect()
+
+	obj := AssertSuccessResponse(s.T(), resp)
+	s.Equal([]interface{}{"1", "2", "3"}, s.ids(obj))
+	obj.Value("page").Object().Value("total").IsEqual(5)
+	next := obj.Value("page").Object().Value("next").String().Raw()
+
+	resp = s.testApp.Client.GET("/entities/products").
+		WithHeader("Authorization", "Bearer "+GuestToken).
+		WithQuery("limit", 3).
+		WithQuery("cursor", next).
+		Expect()
+
+	s.Equal([]interface{}{"4", "5"}, s.ids(AssertSuccessResponse(s.T(), resp)))
+}
+
+func (s *PaginationAPITestSuite) TestRESTListingChecksPolicy() {
+	resp := s.testApp.Client.GET("/entities/users").
+		WithHeader("Authorization", "Bearer "+GuestToken).
+		Expect()
+
+	AssertErrorResponse(s.T(), resp, http.StatusForbidden, "access denied")
+
+	resp = s.testApp.Client.GET("/entities/widgets").
+		WithHeader("Authorization", "Bearer "+AdminToken).
+		Expect()
+
+	AssertErrorResponse(s.T(), resp, http.StatusNotFound, "unknown entity")
+}
+
+func (s *PaginationAPITestSuite) TestInvalidCursor() {
+	users := AssertSuccessResponse(s.T(), s.testApp.PostRequest("list 1 users", AdminToken))
+	userCursor := users.Value("page").Object().Value("next").String().Raw()
+
+	resp := s.testApp.PostRequest("list products cursor "+userCursor, AdminToken)
+	AssertBadRequestError(s.T(), resp, "invalid page request")
+
+	resp = s.testApp.Client.GET("/entities/products").
+		WithHeader("Authorization", "Bearer "+AdminToken).
+		WithQuery("cursor", "not-a-cursor").
+		Expect()
+	AssertBadRequestError(s.T(), resp, "invalid page request")
+}
+
+func TestPaginationAPITestSuite(t *testing.T) {
+	suite.Run(t, new(PaginationAPITestSuite))
+}
@@ -0,0 +1,114 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	"drm-app/app/config"
+	"drm-app/app/drm"
+	"github.com/gavv/httpexpect/v2"
+	"github.com/stretchr/testify/suite"
+)
+
+type PolicyAPITestSuite struct {
+	suite.Suite
+	testApp *TestApp
+}
+
+func (s *PolicyAPITestSuite) SetupTest() {
+	s.testApp = NewTestApp(s.T())
+}
+
+func (s *PolicyAPITestSuite) check(token string, params map[string]string) *httpexpect.Response {
+	req := s.testApp.Client.GET("/policy/check").WithHeader("Authorization", "Bearer "+token)
+	for key, value := range params {
+		req = req.WithQuery(key, value)
+	}
+	return req.Expect()
+}
+
+func (s *PolicyAPITestSuite) TestCheckRole() {
+	obj := AssertSuccessResponse(s.T(), s.check(AdminToken, map[string]string{"role": "user", "action": "create", "entity": "order"}))
+	obj.Value("result").Object().Value("decision").Object().
+		IsEqual(map[string]interface{}{"allowed": true, "rule": "policy.roles.user.order: create"})
+
+	obj = AssertSuccessResponse(s.T(), s.check(AdminToken, map[string]string{"role": "user", "action": "delete", "entity": "order", "id": "7"}))
+	result := obj.Value("result").Object()
+	result.Value("query").Object().Value("record_id").IsEqual("7")
+	result.Value("decision").Object().
+		IsEqual(map[string]interface{}{"allowed": false, "reason": `role "user" may not delete order`})
+}
+
+func (s *PolicyAPITestSuite) TestCheckReportsInheritedRule() {
+	obj := AssertSuccessResponse(s.T(), s.check(AdminToken, map[string]string{"role": "admin", "action": "search", "entity": "product"}))
+	obj.Value("result").Object().Value("decision").Object().
+		IsEqual(map[string]interface{}{"allowed": true, "rule": "policy.roles.guest.product: search"})
+}
+
+func (s *PolicyAPITestSuite) TestCheckUser() {
+	obj := AssertSuccessResponse(s.T(), s.check(AdminToken, map[string]string{"user_id": "3", "action": "read", "entity": "user"}))
+	result := obj.Value("result").Object()
+	result.Value("query").Object().Value("roles").Array().IsEqual([]string{"guest"})
+	result.Value("decision").Object().Value("reason").IsEqual(`role "guest" has no permissions on user`)
+
+	AssertErrorResponse(s.T(), s.check(AdminToken, map[string]string{"user_id": "42", "action": "read", "entity": "user"}),
+		http.StatusNotFound, "user 42")
+}
+
+func (s *PolicyAPITestSuite) TestCheckNeedsCompleteQuery() {
+	AssertBadRequestError(s.T(), s.check(AdminToken, map[string]string{"role": "user", "entity": "order"}),
+		"action and entity are required")
+	AssertBadRequestError(s.T(), s.check(AdminToken, map[string]string{"action": "read", "entity": "order"}),
+		"a role or a user_id is required")
+	AssertBadRequestError(s.T(), s.check(AdminToken, map[string]string{"role": "user", "user_id": "2", "action": "read", "entity": "order"}),
+		"not both")
+}
+
+func (s *PolicyAPITestSuite) TestPolicyEndpointsAreAdminOnly() {
+	AssertErrorResponse(s.T(), s.check(UserToken, map[string]string{"role": "user", "action": "read", "entity": "order"}),
+		http.StatusForbidden, `access denied for action read on entity policy: role "user" has no permissions on policy`)
+
+	s.testApp.Client.GET("/policy/matrix").WithHeader("Authorization", "Bearer "+GuestToken).
+		Expect().Status(http.StatusForbidden)
+}
+
+func (s *PolicyAPITestSuite) TestMatrix() {
+	resp := s.testApp.Client.GET("/policy/matrix").WithHeader("Authorization", "Bearer "+AdminToken).Expect()
+	matrix := AssertSuccessResponse(s.T(), resp).Value("result").Object()
+
+	matrix.Value("roles").Array().IsEqual([]string{"admin", "guest", "user"})
+	matrix.Value("entities").Array().ContainsAll("user", "product", "order", "webhook", "system", "policy")
+	guest := matrix.Value("allowed").Object().Value("guest").Object()
+	guest.IsEqual(map[string]interface{}{"product": []string{"read", "search"}})
+	matrix.Value("allowed").Object().Value("user").Object().Value("order").Array().IsEqual([]string{"create", "read"})
+}
+
+func (s *PolicyAPITestSuite) TestConditionsReadStoredRecord() {
+	policy := config.Default().Policy
+	policy.Roles["user"]["product"] = []string{"update"}
+	policy.Conditions = map[string]map[string]map[string]string{
+		"user": {"product": {"update": "record.price < 100"}},
+	}
+	s.testApp.Engine.AccessPolicyAgent = drm.NewAccessPolicyAgentFromConfig(policy)
+
+	obj := AssertSuccessResponse(s.T(), s.check(AdminToken, map[string]string{"user_id": "2", "action": "update", "entity": "product", "id": "2"}))
+	obj.Value("result").Object().Value("decision").Object().IsEqual(map[string]interface{}{
+		"allowed": true, "rule": "policy.roles.user.product: update", "condition": "record.price < 100",
+	})
+
+	AssertErrorResponse(s.T(), s.testApp.PostRequest(`update product json:{"id":"1","price":5}`, UserToken),
+		http.StatusInternalServerError, "condition of policy.roles.user.product: update not met: record.price < 100")
+
+	resp := s.testApp.Client.GET("/policy/matrix").WithHeader("Authorization", "Bearer "+AdminToken).Expect()
+	AssertSuccessResponse(s.T(), resp).Value("result").Object().Value("conditions").Object().
+		Value("user").Object().Value("product").Object().IsEqual(map[string]interface{}{"update": "record.price < 100"})
+}
+
+func (s *PolicyAPITestSuite) TestDeniedRequestsGiveReason() {
+	AssertErrorResponse(s.T(), s.testApp.PostRequest(`delete product json:{"id":"1"}`, GuestToken),
+		http.StatusInternalServerError, `access denied for action delete on entity product: role "guest" may not delete product`)
+}
+
+func TestPolicyAPITestSuite(t *testing.T) {
+	suite.Run(t, new(PolicyAPITestSuite))
+}
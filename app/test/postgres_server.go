@@ -0,0 +1,208 @@
+package test
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"drm-app/app/config"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+const textOID = 25
+
+var parameterPattern = regexp.MustCompile(`\$(\d+)`)
+
+// PostgresServer is a minimal PostgreSQL server speaking the wire protocol in-process, so the
+// PostgreSQL data agent can be tested without a database. It answers every statement with the
+// text columns and rows of Rows and records the statements it runs.
+type PostgresServer struct {
+	Config config.DatabaseConfig
+
+	rows       func(sql string) ([]string, [][]string)
+	mu         sync.Mutex
+	statements []string
+}
+
+// NewPostgresServer serves on a random local port until the test ends. rows returns the
+// columns and rows of a statement; statements without columns complete without rows.
+func NewPostgresServer(t *testing.T, rows func(sql string) ([]string, [][]string)) *PostgresServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &PostgresServer{
+		Config: config.DatabaseConfig{
+			Host:           "127.0.0.1",
+			Port:           listener.Addr().(*net.TCPAddr).Port,
+			User:           "drm",
+			Password:       "drm",
+			Name:           "drm",
+			SSLMode:        "disable",
+			MaxConns:       2,
+			ConnectTimeout: 5 * time.Second,
+			ConnectRetries: 1,
+		},
+		rows: rows,
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+// Statements returns the statements run so far, including transaction control, in order
+func (s *PostgresServer) Statements() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.statements...)
+}
+
+func (s *PostgresServer) serve(conn net.Conn) {
+	defer conn.Close()
+	backend := pgproto3.NewBackend(conn, conn)
+
+	startup, err := backend.ReceiveStartupMessage()
+	if _, ok := startup.(*pgproto3.SSLRequest); ok {
+		if _, err := conn.Write([]byte("N")); err != nil {
+			return
+		}
+		startup, err = backend.ReceiveStartupMessage()
+	}
+	if _, ok := startup.(*pgproto3.StartupMessage); !ok || err != nil {
+		return
+	}
+	backend.Send(&pgproto3.AuthenticationOk{})
+	backend.Send(&pgproto3.ParameterStatus{Name: "server_version", Value: "16.0"})
+	backend.Send(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"})
+	backend.Send(&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "on"})
+	backend.Send(&pgproto3.BackendKeyData{ProcessID: 1, SecretKey: 1})
+
+	status := byte('I')
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: status})
+	if backend.Flush() != nil {
+		return
+	}
+
+	prepared := map[string]string{}
+	portals := map[string]string{}
+	for {
+		message, err := backend.Receive()
+		if err != nil {
+			return
+		}
+
+		switch message := message.(type) {
+		case *pgproto3.Query:
+			status = transactionStatus(message.String, status)
+			if sql := strings.TrimSpace(message.String); sql == "" || strings.HasPrefix(sql, "--") {
+				backend.Send(&pgproto3.EmptyQueryResponse{})
+			} else {
+				s.execute(backend, message.String, true)
+			}
+			backend.Send(&pgproto3.ReadyForQuery{TxStatus: status})
+		case *pgproto3.Parse:
+			prepared[message.Name] = message.Query
+			backend.Send(&pgproto3.ParseComplete{})
+		case *pgproto3.Describe:
+			sql := portals[message.Name]
+			if message.ObjectType == 'S' {
+				sql = prepared[message.Name]
+				backend.Send(&pgproto3.ParameterDescription{ParameterOIDs: parameterOIDs(sql)})
+			}
+			s.describe(backend, sql)
+		case *pgproto3.Bind:
+			portals[message.DestinationPortal] = prepared[message.PreparedStatement]
+			backend.Send(&pgproto3.BindComplete{})
+		case *pgproto3.Execute:
+			s.execute(backend, portals[message.Portal], false)
+		case *pgproto3.Close:
+			backend.Send(&pgproto3.CloseComplete{})
+		case *pgproto3.Sync:
+			backend.Send(&pgproto3.ReadyForQuery{TxStatus: status})
+		case *pgproto3.Flush:
+		case *pgproto3.Terminate:
+			return
+		}
+
+		if backend.Flush() != nil {
+			return
+		}
+	}
+}
+
+// describe sends the row description of sql, or NoData for statements without columns
+func (s *PostgresServer) describe(backend *pgproto3.Backend, sql string) {
+	columns, _ := s.rows(sql)
+	if len(columns) == 0 {
+		backend.Send(&pgproto3.NoData{})
+		return
+	}
+	fields := make([]pgproto3.FieldDescription, len(columns))
+	for i, column := range columns {
+		fields[i] = pgproto3.FieldDescription{Name: []byte(column), DataTypeOID: textOID, DataTypeSize: -1, TypeModifier: -1}
+	}
+	backend.Send(&pgproto3.RowDescription{Fields: fields})
+}
+
+// execute records sql and sends its rows, preceded by their description for simple queries
+func (s *PostgresServer) execute(backend *pgproto3.Backend, sql string, simple bool) {
+	s.mu.Lock()
+	s.statements = append(s.statements, sql)
+	s.mu.Unlock()
+
+	if simple {
+		if columns, _ := s.rows(sql); len(columns) > 0 {
+			s.describe(backend, sql)
+		}
+	}
+	_, rows := s.rows(sql)
+	for _, row := range rows {
+		values := make([][]byte, len(row))
+		for i, value := range row {
+			values[i] = []byte(value)
+		}
+		backend.Send(&pgproto3.DataRow{Values: values})
+	}
+
+	tag := strings.ToUpper(strings.Fields(sql)[0])
+	if tag == "SELECT" {
+		tag = "SELECT " + strconv.Itoa(len(rows))
+	}
+	backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(tag)})
+}
+
+// parameterOIDs leaves the types of the parameters of sql unspecified, so clients send them as
+// text whatever their Go type
+func parameterOIDs(sql string) []uint32 {
+	count := 0
+	for _, match := range parameterPattern.FindAllStringSubmatch(sql, -1) {
+		if n, _ := strconv.Atoi(match[1]); n > count {
+			count = n
+		}
+	}
+	return make([]uint32, count)
+}
+
+func transactionStatus(sql string, status byte) byte {
+	sql = strings.ToLower(strings.TrimSpace(sql))
+	switch {
+	case sql == "begin" || strings.HasPrefix(sql, "begin "):
+		return 'T'
+	case sql == "commit" || sql == "rollback":
+		return 'I'
+	}
+	return status
+}
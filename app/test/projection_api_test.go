@@ -0,0 +1,75 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ProjectionAPITestSuite struct {
+	suite.Suite
+	testApp *TestApp
+}
+
+func (s *ProjectionAPITestSuite) SetupTest() {
+	s.testApp = NewTestApp(s.T())
+
+	query := `create order json:{"user_id":"2","status":"pending","items":[{"product_id":"1","quantity":1},{"product_id":"2","quantity":3}]}`
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest(query, AdminToken))
+}
+
+func (s *ProjectionAPITestSuite) TestProjectsFields() {
+	obj := AssertSuccessResponse(s.T(), s.testApp.PostRequest("list users fields name", AdminToken))
+
+	user := obj.Value("result").Array().Value(0).Object()
+	user.Keys().ContainsOnly("id", "name")
+	user.Value("name").IsEqual("John Doe")
+}
+
+func (s *ProjectionAPITestSuite) TestExpandsOrderRelations() {
+	obj := AssertSuccessResponse(s.T(), s.testApp.PostRequest("list orders fields status expand user,items.product", UserToken))
+
+	order := obj.Value("result").Array().Value(0).Object()
+	order.Keys().ContainsOnly("id", "status", "user", "items")
+	order.Value("user").Object().Value("name").IsEqual("Jane Smith")
+
+	items := order.Value("items").Array()
+	items.Length().IsEqual(2)
+	items.Value(0).Object().Value("product").Object().Value("name").IsEqual("Laptop")
+	items.Value(1).Object().Value("product").Object().Value("name").IsEqual("Mouse")
+	items.Value(1).Object().Value("quantity").IsEqual(3)
+}
+
+func (s *ProjectionAPITestSuite) TestExpandsSingleRecord() {
+	obj := AssertSuccessResponse(s.T(), s.testApp.PostRequest(`read order expand user json:{"id":"1"}`, AdminToken))
+
+	obj.Value("result").Object().Value("user").Object().Value("email").IsEqual("jane@example.com")
+}
+
+func (s *ProjectionAPITestSuite) TestRESTFieldsAndExpand() {
+	resp := s.testApp.Client.GET("/entities/orders").
+		WithHeader("Authorization", "Bearer "+AdminToken).
+		WithQuery("fields", "status").
+		WithQuery("expand", "user").
+		Expect()
+
+	order := AssertSuccessResponse(s.T(), resp).Value("result").Array().Value(0).Object()
+	order.Keys().ContainsOnly("id", "status", "user")
+	order.Value("user").Object().Value("id").IsEqual("2")
+}
+
+func (s *ProjectionAPITestSuite) TestUnknownFieldOrRelation() {
+	resp := s.testApp.PostRequest("list products fields colour", GuestToken)
+	AssertBadRequestError(s.T(), resp, `unknown field "colour" for product`)
+
+	resp = s.testApp.Client.GET("/entities/products").
+		WithHeader("Authorization", "Bearer "+GuestToken).
+		WithQuery("expand", "orders").
+		Expect()
+	AssertErrorResponse(s.T(), resp, http.StatusBadRequest, `product has no relation "orders"`)
+}
+
+func TestProjectionAPITestSuite(t *testing.T) {
+	suite.Run(t, new(ProjectionAPITestSuite))
+}
@@ -0,0 +1,88 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"drm-app/app/config"
+	"drm-app/app/data"
+	"drm-app/app/db"
+	"drm-app/app/drm"
+	"github.com/stretchr/testify/suite"
+)
+
+type RateLimitAPITestSuite struct {
+	suite.Suite
+	testApp *TestApp
+}
+
+func (s *RateLimitAPITestSuite) SetupTest() {
+	s.testApp = NewTestApp(s.T())
+	s.testApp.Engine.RateLimiter = drm.NewRateLimiter(data.NewMemoryRateLimitStore(), drm.NewAccessPolicyAgentFromConfig(config.PolicyConfig{Limits: map[string]config.RateLimitConfig{
+		"guest": {RequestsPerMinute: 1, Burst: 2},
+		"user":  {RequestsPerMinute: 100, Burst: 100, DailyCreates: 1},
+	}}))
+}
+
+func (s *RateLimitAPITestSuite) TestReturnsTooManyRequestsWithRetryAfter() {
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest(TestQueries.ListProducts, GuestToken))
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest(TestQueries.ListProducts, GuestToken))
+
+	resp := s.testApp.PostRequest(TestQueries.ListProducts, GuestToken)
+
+	AssertErrorResponse(s.T(), resp, http.StatusTooManyRequests, "rate limit exceeded")
+	resp.Header("Retry-After").IsEqual("60")
+}
+
+func (s *RateLimitAPITestSuite) TestDailyCreateQuota() {
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest(TestQueries.CreateOrder, UserToken))
+
+	resp := s.testApp.PostRequest(TestQueries.CreateOrder, UserToken)
+
+	AssertErrorResponse(s.T(), resp, http.StatusTooManyRequests, "daily quota of 1 creates used")
+	resp.Header("Retry-After").NotEmpty()
+
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest(TestQueries.ListOrders, UserToken))
+}
+
+func (s *RateLimitAPITestSuite) TestAdminIsNotLimited() {
+	for i := 0; i < 10; i++ {
+		AssertSuccessResponse(s.T(), s.testApp.PostRequest(TestQueries.ListProducts, AdminToken))
+	}
+}
+
+func (s *RateLimitAPITestSuite) TestPostgresStoreSweepsOldRows() {
+	server := NewPostgresServer(s.T(), func(sql string) ([]string, [][]string) {
+		if strings.HasPrefix(strings.TrimSpace(sql), "INSERT INTO usage_quotas") {
+			return []string{"used"}, [][]string{{"1"}}
+		}
+		return nil, nil
+	})
+	database, err := db.NewDatabase(server.Config)
+	s.Require().NoError(err)
+	defer database.Close()
+
+	store := data.NewPostgresRateLimitStore(database)
+	for i := 0; i < 3; i++ {
+		allowed, err := store.ConsumeQuota(context.Background(), "creates:acme/token/1", "2026-10-19", 10)
+		s.Require().NoError(err)
+		s.True(allowed)
+	}
+
+	// Refilled buckets and earlier days are deleted once per interval, not on every request
+	sweeps := map[string]int{}
+	for _, statement := range server.Statements() {
+		for _, table := range []string{"rate_limit_buckets", "usage_quotas"} {
+			if strings.Contains(statement, "DELETE FROM "+table) {
+				sweeps[table]++
+			}
+		}
+	}
+	s.Equal(map[string]int{"rate_limit_buckets": 1, "usage_quotas": 1}, sweeps)
+}
+
+func TestRateLimitAPITestSuite(t *testing.T) {
+	suite.Run(t, new(RateLimitAPITestSuite))
+}
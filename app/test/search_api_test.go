@@ -0,0 +1,81 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type SearchAPITestSuite struct {
+	suite.Suite
+	testApp *TestApp
+}
+
+func (s *SearchAPITestSuite) SetupTest() {
+	s.testApp = NewTestApp(s.T())
+
+	for _, query := range []string{
+		`create product json:{"name":"Keyboard","price":49.99,"description":"Wireless keyboard with mouse pad"}`,
+		`create product json:{"name":"Mouse Pad","price":9.99,"description":"Cloth pad"}`,
+	} {
+		AssertSuccessResponse(s.T(), s.testApp.PostRequest(query, AdminToken))
+	}
+}
+
+func (s *SearchAPITestSuite) TestRanksAndHighlights() {
+	obj := AssertSuccessResponse(s.T(), s.testApp.PostRequest(`search products "wireless mouse"`, GuestToken))
+
+	hits := obj.Value("result").Array()
+	hits.Length().IsEqual(2)
+
+	mouse := hits.Value(0).Object()
+	mouse.Value("name").IsEqual("Mouse")
+	mouse.Value("snippet").IsEqual("<mark>Mouse</mark> <mark>Wireless</mark> <mark>mouse</mark>")
+	hits.Value(1).Object().Value("name").IsEqual("keyboard")
+	mouse.Value("rank").Number().Gt(hits.Value(1).Object().Value("rank").Number().Raw())
+}
+
+func (s *SearchAPITestSuite) TestPaginates() {
+	obj := AssertSuccessResponse(s.T(), s.testApp.PostRequest("search products pad first 1 with total", UserToken))
+
+	obj.Value("result").Array().Length().IsEqual(1)
+	page := obj.Value("page").Object()
+	page.Value("total").IsEqual(2)
+	page.NotContainsKey("prev")
+	next := page.Value("next").String().Raw()
+
+	obj = AssertSuccessResponse(s.T(), s.testApp.PostRequest("search products pad first 1 cursor "+next, UserToken))
+	obj.Value("result").Array().Length().IsEqual(1)
+	obj.Value("page").Object().NotContainsKey("next")
+	obj.Value("page").Object().ContainsKey("prev")
+}
+
+func (s *SearchAPITestSuite) TestRESTSearch() {
+	resp := s.testApp.Client.GET("/entities/products").
+		WithHeader("Authorization", "Bearer "+GuestToken).
+		WithQuery("q", "laptop").
+		Expect()
+
+	hits := AssertSuccessResponse(s.T(), resp).Value("result").Array()
+	hits.Length().IsEqual(1)
+	hits.Value(0).Object().Value("name").IsEqual("Laptop")
+}
+
+func (s *SearchAPITestSuite) TestRejectsInvalidSearches() {
+	AssertBadRequestError(s.T(), s.testApp.PostRequest("search products", GuestToken), "search text is required")
+
+	list := AssertSuccessResponse(s.T(), s.testApp.PostRequest("list products first 1", GuestToken))
+	listCursor := list.Value("page").Object().Value("next").String().Raw()
+
+	resp := s.testApp.PostRequest("search products mouse cursor "+listCursor, GuestToken)
+	AssertErrorResponse(s.T(), resp, http.StatusBadRequest, "cursor is malformed or belongs to another list")
+}
+
+func (s *SearchAPITestSuite) TestRequiresSearchPermission() {
+	AssertAccessDeniedError(s.T(), s.testApp.PostRequest("search users john", UserToken))
+}
+
+func TestSearchAPITestSuite(t *testing.T) {
+	suite.Run(t, new(SearchAPITestSuite))
+}
@@ -0,0 +1,52 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ShutdownAPITestSuite struct {
+	suite.Suite
+	testApp *TestApp
+}
+
+func (s *ShutdownAPITestSuite) SetupTest() {
+	s.testApp = NewTestApp(s.T())
+}
+
+func (s *ShutdownAPITestSuite) TestReadinessFlipsAtShutdownStart() {
+	s.testApp.Engine.BeginShutdown()
+
+	obj := s.testApp.Client.GET("/readyz").
+		Expect().
+		Status(http.StatusServiceUnavailable).
+		JSON().Object()
+
+	obj.Value("status").String().IsEqual("unavailable")
+	obj.Value("components").Object().ContainsKey("server")
+
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest(TestQueries.ListProducts, GuestToken))
+}
+
+func (s *ShutdownAPITestSuite) TestRefusesNewSubscriptions() {
+	s.testApp.Engine.BeginShutdown()
+
+	s.testApp.Client.GET("/subscribe").
+		WithHeader("Authorization", "Bearer "+AdminToken).
+		Expect().
+		Status(http.StatusServiceUnavailable)
+}
+
+func (s *ShutdownAPITestSuite) TestRejectsRequestsWhileDraining() {
+	s.Require().NoError(s.testApp.Engine.Shutdown(context.Background()))
+
+	resp := s.testApp.PostRequest(TestQueries.ListProducts, GuestToken)
+	AssertErrorResponse(s.T(), resp, http.StatusServiceUnavailable, "shutting down")
+}
+
+func TestShutdownAPITestSuite(t *testing.T) {
+	suite.Run(t, new(ShutdownAPITestSuite))
+}
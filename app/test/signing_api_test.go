@@ -0,0 +1,139 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"drm-app/app/config"
+	"drm-app/app/data"
+	"drm-app/app/db"
+	"drm-app/app/drm"
+	"github.com/gavv/httpexpect/v2"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	billingKeyID  = "billing"
+	billingSecret = "billing-secret-0123456789abcdefghij"
+)
+
+type SigningAPITestSuite struct {
+	suite.Suite
+	testApp *TestApp
+}
+
+func (s *SigningAPITestSuite) SetupTest() {
+	s.testApp = NewTestApp(s.T())
+
+	auth := config.Default().Auth
+	auth.Signing.Keys = []config.ServiceKeyConfig{
+		{KeyID: billingKeyID, Secret: billingSecret, UserID: "900", Name: "Billing", Role: "user"},
+	}
+	s.testApp.Engine.AuthAgent = drm.NewAuthAgentFromConfig(auth)
+}
+
+// sign adds the headers of a request the billing service signed with secret
+func (s *SigningAPITestSuite) sign(req *httpexpect.Request, secret, method, path, nonce string, body []byte) *httpexpect.Request {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	return req.
+		WithHeader(drm.SignatureKeyIDHeader, billingKeyID).
+		WithHeader(drm.SignatureTimestampHeader, timestamp).
+		WithHeader(drm.SignatureNonceHeader, nonce).
+		WithHeader(drm.SignatureHeader, "sha256="+drm.SignRequest(secret, method, path, timestamp, nonce, body))
+}
+
+func (s *SigningAPITestSuite) post(query, nonce string) *httpexpect.Response {
+	body, err := json.Marshal(map[string]string{"query": query})
+	s.Require().NoError(err)
+	req := s.testApp.Client.POST("/request").
+		WithHeader("Content-Type", "application/json").
+		WithBytes(body)
+	return s.sign(req, billingSecret, "POST", "/request", nonce, body).Expect()
+}
+
+func (s *SigningAPITestSuite) TestSignedRequestsNeedNoToken() {
+	AssertSuccessResponse(s.T(), s.post(TestQueries.ListProducts, "n-1"))
+	AssertSuccessResponse(s.T(), s.post(TestQueries.CreateOrder, "n-2"))
+
+	// The service acts with the role of its key
+	AssertAccessDeniedError(s.T(), s.post(TestQueries.CreateProduct, "n-3"))
+}
+
+func (s *SigningAPITestSuite) TestSignsRESTRoutes() {
+	req := s.testApp.Client.GET("/entities/products").WithQuery("limit", 1)
+	s.sign(req, billingSecret, "GET", "/entities/products?limit=1", "n-rest", nil).
+		Expect().
+		Status(http.StatusOK)
+}
+
+func (s *SigningAPITestSuite) TestRejectsReplays() {
+	AssertSuccessResponse(s.T(), s.post(TestQueries.ListProducts, "n-once"))
+	AssertErrorResponse(s.T(), s.post(TestQueries.ListProducts, "n-once"), http.StatusUnauthorized, "nonce was already used")
+}
+
+func (s *SigningAPITestSuite) TestRejectsReplaysAcrossInstances() {
+	// The server lets the first claim of a nonce insert its row, as the primary key would
+	var server *PostgresServer
+	server = NewPostgresServer(s.T(), func(sql string) ([]string, [][]string) {
+		if !strings.HasPrefix(strings.TrimSpace(sql), "INSERT INTO request_nonces") {
+			return nil, nil
+		}
+		claims := 0
+		for _, statement := range server.Statements() {
+			if strings.Contains(statement, "INSERT INTO request_nonces") {
+				claims++
+			}
+		}
+		if claims > 1 {
+			return []string{"nonce"}, nil
+		}
+		return []string{"nonce"}, [][]string{{"n-shared"}}
+	})
+	database, err := db.NewDatabase(server.Config)
+	s.Require().NoError(err)
+	defer database.Close()
+
+	auth := config.Default().Auth
+	auth.Signing.Keys = []config.ServiceKeyConfig{
+		{KeyID: billingKeyID, Secret: billingSecret, UserID: "900", Name: "Billing", Role: "user"},
+	}
+	s.testApp.Engine.AuthAgent = drm.NewAuthAgentWithNonceStore(auth, data.NewPostgresNonceStore(database))
+	AssertSuccessResponse(s.T(), s.post(TestQueries.ListProducts, "n-shared"))
+
+	// Another replica has its own agent but the same table
+	s.testApp.Engine.AuthAgent = drm.NewAuthAgentWithNonceStore(auth, data.NewPostgresNonceStore(database))
+	AssertErrorResponse(s.T(), s.post(TestQueries.ListProducts, "n-shared"), http.StatusUnauthorized, "nonce was already used")
+}
+
+func (s *SigningAPITestSuite) TestRejectsInvalidSignatures() {
+	body := []byte(`{"query":"list products"}`)
+	tampered := []byte(`{"query":"delete product 1"}`)
+	resp := s.sign(s.testApp.Client.POST("/request").WithHeader("Content-Type", "application/json").WithBytes(tampered),
+		billingSecret, "POST", "/request", "n-body", body).Expect()
+	AssertErrorResponse(s.T(), resp, http.StatusUnauthorized, "signature does not match")
+
+	resp = s.sign(s.testApp.Client.POST("/request").WithHeader("Content-Type", "application/json").WithBytes(body),
+		"wrong-secret-0123456789abcdefghijkl", "POST", "/request", "n-secret", body).Expect()
+	AssertErrorResponse(s.T(), resp, http.StatusUnauthorized, "signature does not match")
+
+	resp = s.testApp.Client.POST("/request").
+		WithHeader(drm.SignatureKeyIDHeader, billingKeyID).
+		WithHeader(drm.SignatureTimestampHeader, strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)).
+		WithHeader(drm.SignatureNonceHeader, "n-stale").
+		WithHeader(drm.SignatureHeader, "sha256=00").
+		WithJSON(map[string]string{"query": TestQueries.ListProducts}).
+		Expect()
+	AssertErrorResponse(s.T(), resp, http.StatusUnauthorized, "away from the server's clock")
+
+	// Without a signature the request still needs a token
+	resp = s.testApp.Client.POST("/request").WithJSON(map[string]string{"query": TestQueries.ListProducts}).Expect()
+	AssertErrorResponse(s.T(), resp, http.StatusBadRequest, "Token is required")
+}
+
+func TestSigningAPITestSuite(t *testing.T) {
+	suite.Run(t, new(SigningAPITestSuite))
+}
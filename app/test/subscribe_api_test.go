@@ -0,0 +1,145 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"drm-app/app/data"
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/suite"
+)
+
+type SubscribeAPITestSuite struct {
+	suite.Suite
+	testApp *TestApp
+	addr    string
+}
+
+func (s *SubscribeAPITestSuite) SetupTest() {
+	s.testApp = NewTestApp(s.T())
+	s.addr = s.testApp.Listen(s.T())
+}
+
+func (s *SubscribeAPITestSuite) openEventStream(query string) (*bufio.Scanner, func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+s.addr+"/subscribe?"+query, nil)
+	s.Require().NoError(err)
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := http.DefaultClient.Do(req)
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+	s.Require().Equal("text/event-stream", resp.Header.Get("Content-Type"))
+
+	scanner := bufio.NewScanner(resp.Body)
+	s.Require().True(scanner.Scan())
+	s.Require().Equal(": subscribed", scanner.Text())
+
+	return scanner, func() {
+		cancel()
+		resp.Body.Close()
+	}
+}
+
+func (s *SubscribeAPITestSuite) nextEvent(scanner *bufio.Scanner) (string, data.ChangeEvent) {
+	var eventType string
+	var event data.ChangeEvent
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			s.Require().NoError(json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+		case line == "" && eventType != "":
+			return eventType, event
+		}
+	}
+	s.T().Fatalf("event stream ended: %v", scanner.Err())
+	return "", event
+}
+
+func (s *SubscribeAPITestSuite) TestServerSentEventsDeliverChanges() {
+	scanner, closeStream := s.openEventStream("entity=order&token=" + UserToken)
+	defer closeStream()
+
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest(TestQueries.CreateProduct, AdminToken))
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest(TestQueries.CreateOrder, UserToken))
+
+	eventType, event := s.nextEvent(scanner)
+	s.Equal("order.create", eventType)
+	s.Equal("order", event.Entity)
+	s.NotEmpty(event.RecordID)
+}
+
+func (s *SubscribeAPITestSuite) TestServerSentEventsApplyAccessPolicy() {
+	scanner, closeStream := s.openEventStream("token=" + GuestToken)
+	defer closeStream()
+
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest(TestQueries.CreateUser, AdminToken))
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest(TestQueries.CreateProduct, AdminToken))
+
+	eventType, _ := s.nextEvent(scanner)
+	s.Equal("product.create", eventType)
+}
+
+func (s *SubscribeAPITestSuite) TestWebSocketDeliversChanges() {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+AdminToken)
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+s.addr+"/subscribe?entity=user&actions=update", header)
+	s.Require().NoError(err)
+	defer conn.Close()
+
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest(TestQueries.CreateUser, AdminToken))
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest(TestQueries.UpdateUser, AdminToken))
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event data.ChangeEvent
+	s.Require().NoError(conn.ReadJSON(&event))
+	s.Equal("user.update", event.Type)
+	s.Equal("1", event.RecordID)
+}
+
+func (s *SubscribeAPITestSuite) TestFailedUpgradeLeavesNoSubscription() {
+	// Without a Sec-WebSocket-Key the upgrade is refused
+	s.testApp.Client.GET("/subscribe").
+		WithQuery("token", AdminToken).
+		WithHeader("Connection", "Upgrade").
+		WithHeader("Upgrade", "websocket").
+		Expect().
+		Status(http.StatusUpgradeRequired)
+
+	s.Zero(s.testApp.Engine.ChangeFeed.Subscribers())
+}
+
+func (s *SubscribeAPITestSuite) TestRejectsForbiddenEntity() {
+	s.testApp.Client.GET("/subscribe").
+		WithQuery("entity", "user").
+		WithQuery("token", GuestToken).
+		Expect().
+		Status(http.StatusForbidden)
+}
+
+func (s *SubscribeAPITestSuite) TestRejectsInvalidToken() {
+	s.testApp.Client.GET("/subscribe").
+		WithQuery("token", "invalid-token").
+		Expect().
+		Status(http.StatusUnauthorized)
+}
+
+func (s *SubscribeAPITestSuite) TestRejectsInvalidFilter() {
+	s.testApp.Client.GET("/subscribe").
+		WithQuery("token", AdminToken).
+		WithQuery("filter", "{not json").
+		Expect().
+		Status(http.StatusBadRequest)
+}
+
+func TestSubscribeAPITestSuite(t *testing.T) {
+	suite.Run(t, new(SubscribeAPITestSuite))
+}
@@ -0,0 +1,90 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	"drm-app/app/config"
+	"drm-app/app/drm"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	AcmeAdminToken = "acme-admin-token"
+	AcmeUserToken  = "acme-user-token"
+)
+
+type TenantAPITestSuite struct {
+	suite.Suite
+	testApp *TestApp
+}
+
+func (s *TenantAPITestSuite) SetupTest() {
+	s.testApp = NewTestApp(s.T())
+
+	auth := config.Default().Auth
+	auth.Tokens = append(auth.Tokens,
+		config.TokenConfig{Token: AcmeAdminToken, UserID: "10", Name: "Acme Admin", Tenant: "acme", Role: "admin"},
+		config.TokenConfig{Token: AcmeUserToken, UserID: "11", Name: "Acme User", Tenant: "acme", Role: "user"},
+	)
+	s.testApp.Engine.AuthAgent = drm.NewAuthAgentFromConfig(auth)
+}
+
+func (s *TenantAPITestSuite) TestRecordsAreIsolated() {
+	obj := AssertSuccessResponse(s.T(), s.testApp.PostRequest(TestQueries.ListProducts, AcmeUserToken))
+	obj.Value("result").Array().IsEmpty()
+
+	obj = AssertSuccessResponse(s.T(), s.testApp.PostRequest(`create product json:{"name":"anvil","price":75}`, AcmeAdminToken))
+	obj.Value("result").Object().Value("id").IsEqual("1")
+
+	// The same id names a different record in each tenant
+	obj = AssertSuccessResponse(s.T(), s.testApp.PostRequest(`read product json:{"id":"1"}`, AcmeUserToken))
+	obj.Value("result").Object().Value("name").IsEqual("anvil")
+	obj = AssertSuccessResponse(s.T(), s.testApp.PostRequest(`read product json:{"id":"1"}`, UserToken))
+	obj.Value("result").Object().Value("name").IsEqual("Laptop")
+
+	obj = AssertSuccessResponse(s.T(), s.testApp.PostRequest(TestQueries.ListProducts, GuestToken))
+	obj.Value("result").Array().Length().IsEqual(2)
+
+	AssertErrorResponse(s.T(), s.testApp.PostRequest(`read user json:{"id":"2"}`, AcmeAdminToken),
+		http.StatusInternalServerError, "item not found")
+}
+
+func (s *TenantAPITestSuite) TestExportsOnlyOwnTenant() {
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest(`create product json:{"name":"anvil","price":75}`, AcmeAdminToken))
+
+	body := s.testApp.Client.GET("/entities/products/export").
+		WithHeader("Authorization", "Bearer "+AcmeAdminToken).
+		WithQuery("format", "csv").
+		Expect().
+		Status(http.StatusOK).
+		Body()
+	body.Contains("anvil")
+	body.NotContains("Laptop")
+}
+
+func (s *TenantAPITestSuite) TestWebhooksAreIsolated() {
+	id := s.testApp.Client.POST("/webhooks").
+		WithHeader("Authorization", "Bearer "+AdminToken).
+		WithJSON(map[string]interface{}{"url": "https://example.com/hook", "entity": "order"}).
+		Expect().
+		Status(http.StatusCreated).
+		JSON().Object().Value("result").Object().Value("id").Number().Raw()
+
+	obj := AssertSuccessResponse(s.T(), s.testApp.Client.GET("/webhooks").
+		WithHeader("Authorization", "Bearer "+AcmeAdminToken).Expect())
+	obj.Value("result").Array().IsEmpty()
+
+	AssertErrorResponse(s.T(), s.testApp.Client.GET("/webhooks/{id}", id).
+		WithHeader("Authorization", "Bearer "+AcmeAdminToken).Expect(), http.StatusNotFound, "not found")
+	AssertErrorResponse(s.T(), s.testApp.Client.DELETE("/webhooks/{id}", id).
+		WithHeader("Authorization", "Bearer "+AcmeAdminToken).Expect(), http.StatusNotFound, "not found")
+
+	obj = AssertSuccessResponse(s.T(), s.testApp.Client.GET("/webhooks/{id}", id).
+		WithHeader("Authorization", "Bearer "+AdminToken).Expect())
+	obj.Value("result").Object().Value("tenant").IsEqual(config.DefaultTenant)
+}
+
+func TestTenantAPITestSuite(t *testing.T) {
+	suite.Run(t, new(TenantAPITestSuite))
+}
@@ -0,0 +1,67 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"drm-app/app/tracing"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	incomingTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	incomingSpanID  = "00f067aa0ba902b7"
+)
+
+type TracingAPITestSuite struct {
+	suite.Suite
+	testApp  *TestApp
+	recorder *tracetest.SpanRecorder
+	previous trace.TracerProvider
+}
+
+func (s *TracingAPITestSuite) SetupTest() {
+	_, err := tracing.Setup(context.Background(), "test")
+	s.Require().NoError(err)
+
+	s.recorder = tracetest.NewSpanRecorder()
+	s.previous = otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(s.recorder)))
+	s.testApp = NewTestApp(s.T())
+}
+
+func (s *TracingAPITestSuite) TearDownTest() {
+	otel.SetTracerProvider(s.previous)
+}
+
+func (s *TracingAPITestSuite) TestContinuesIncomingTraceparent() {
+	s.testApp.Client.POST("/request").
+		WithHeader("traceparent", "00-"+incomingTraceID+"-"+incomingSpanID+"-01").
+		WithJSON(map[string]string{"query": TestQueries.ListProducts, "token": GuestToken}).
+		Expect().
+		Status(http.StatusOK)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range s.recorder.Ended() {
+		spans[span.Name()] = span
+	}
+
+	server, ok := spans["POST /request"]
+	s.Require().True(ok)
+	s.Equal(incomingTraceID, server.SpanContext().TraceID().String())
+	s.Equal(incomingSpanID, server.Parent().SpanID().String())
+	s.Equal(trace.SpanKindServer, server.SpanKind())
+
+	engine, ok := spans["Engine.ProcessRequest"]
+	s.Require().True(ok)
+	s.Equal(server.SpanContext().SpanID(), engine.Parent().SpanID())
+}
+
+func TestTracingAPITestSuite(t *testing.T) {
+	suite.Run(t, new(TracingAPITestSuite))
+}
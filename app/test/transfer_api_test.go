@@ -0,0 +1,176 @@
+package test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"drm-app/app/config"
+	"drm-app/app/drm"
+	"github.com/gavv/httpexpect/v2"
+	"github.com/stretchr/testify/suite"
+)
+
+type TransferAPITestSuite struct {
+	suite.Suite
+	testApp *TestApp
+}
+
+func (s *TransferAPITestSuite) SetupTest() {
+	s.testApp = NewTestApp(s.T())
+}
+
+func (s *TransferAPITestSuite) importFile(entity, query, contentType, body, token string) *httpexpect.Response {
+	return s.testApp.Client.POST("/entities/"+entity+"/import").
+		WithHeader("Authorization", "Bearer "+token).
+		WithHeader("Content-Type", contentType).
+		WithQueryString(query).
+		WithText(body).
+		Expect()
+}
+
+func (s *TransferAPITestSuite) export(entity, query, token string) *httpexpect.Response {
+	return s.testApp.Client.GET("/entities/"+entity+"/export").
+		WithHeader("Authorization", "Bearer "+token).
+		WithQueryString(query).
+		Expect()
+}
+
+func (s *TransferAPITestSuite) TestImportsCSVAndReportsRowErrors() {
+	csv := "name,price,description\n" +
+		"Monitor,199.5,\"27\"\" display, IPS\"\n" +
+		"Cable,-3,USB-C\n" +
+		"Desk,cheap,Oak\n" +
+		"Chair,89\n" +
+		"Lamp,25,LED\n"
+
+	obj := AssertSuccessResponse(s.T(), s.importFile("products", "", "text/csv", csv, AdminToken))
+
+	report := obj.Value("result").Object()
+	report.Value("rows").IsEqual(5)
+	report.Value("valid").IsEqual(2)
+	report.Value("imported").IsEqual(2)
+	report.Value("failed").IsEqual(3)
+	errors := report.Value("errors").Array()
+	errors.Length().IsEqual(3)
+	errors.Value(0).Object().IsEqual(map[string]interface{}{"row": 2, "error": "product price must be positive"})
+	errors.Value(1).Object().IsEqual(map[string]interface{}{"row": 3, "error": "price must be a number"})
+	errors.Value(2).Object().Value("row").IsEqual(4)
+
+	obj = AssertSuccessResponse(s.T(), s.testApp.PostRequest(`read product json:{"id":"3"}`, AdminToken))
+	obj.Value("result").Object().Value("description").IsEqual(`27" display, IPS`)
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest("list products with total", AdminToken)).
+		Value("page").Object().Value("total").IsEqual(4)
+}
+
+func (s *TransferAPITestSuite) TestPublishesImportedRecords() {
+	admin := &drm.User{ID: "1", Tenant: config.DefaultTenant, Roles: []string{"admin"}}
+	subscription, err := s.testApp.Engine.ChangeFeed.Subscribe(admin, drm.ChangeFilter{Entity: "product"})
+	s.Require().NoError(err)
+	defer subscription.Close()
+
+	csv := "name,price\nMonitor,199.5\nCable,-3\nLamp,25\n"
+	AssertSuccessResponse(s.T(), s.importFile("products", "", "text/csv", csv, AdminToken))
+
+	var names []string
+	for range 2 {
+		select {
+		case event := <-subscription.Events:
+			s.Equal("product.create", event.Type)
+			s.Equal("1", event.UserID)
+			s.NotEmpty(event.RecordID)
+			names = append(names, event.Data.(map[string]interface{})["name"].(string))
+		case <-time.After(2 * time.Second):
+			s.FailNow("missing change event")
+		}
+	}
+	s.Equal([]string{"Monitor", "Lamp"}, names)
+
+	// Dry runs publish nothing
+	AssertSuccessResponse(s.T(), s.importFile("products", "dry_run=true", "text/csv", csv, AdminToken))
+	select {
+	case event := <-subscription.Events:
+		s.Failf("unexpected change event", "%s %s", event.Type, event.RecordID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func (s *TransferAPITestSuite) TestDryRunWritesNothing() {
+	jsonl := `{"name":"Ann","email":"ann@example.com"}` + "\n" +
+		`{"name":"","email":"nobody@example.com"}` + "\n" +
+		`not json` + "\n"
+
+	obj := AssertSuccessResponse(s.T(), s.importFile("users", "format=jsonl&dry_run=true", "application/octet-stream", jsonl, AdminToken))
+
+	report := obj.Value("result").Object()
+	report.Value("dry_run").IsEqual(true)
+	report.Value("valid").IsEqual(1)
+	report.Value("imported").IsEqual(0)
+	report.Value("errors").Array().Value(1).Object().IsEqual(map[string]interface{}{"row": 3, "error": "invalid JSON object"})
+
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest("list users with total", AdminToken)).
+		Value("page").Object().Value("total").IsEqual(2)
+}
+
+func (s *TransferAPITestSuite) TestImportsJSONLOrders() {
+	jsonl := `{"user_id":2,"items":[{"product_id":"1","quantity":1}],"total_amount":999.99}` + "\n" +
+		`{"user_id":1,"items":[],"status":"shipped"}` + "\n"
+
+	obj := AssertSuccessResponse(s.T(), s.importFile("orders", "", "application/x-ndjson", jsonl, AdminToken))
+	obj.Value("result").Object().Value("imported").IsEqual(1)
+	obj.Value("result").Object().Value("errors").Array().Value(0).Object().
+		IsEqual(map[string]interface{}{"row": 2, "error": "order must have at least one item"})
+
+	order := AssertSuccessResponse(s.T(), s.testApp.PostRequest(`read order json:{"id":"1"}`, AdminToken)).Value("result").Object()
+	order.Value("user_id").IsEqual("2")
+	order.Value("total_amount").IsEqual(999.99)
+}
+
+func (s *TransferAPITestSuite) TestRejectsInvalidImports() {
+	resp := s.importFile("products", "", "text/csv", "name,colour\nLamp,red\n", AdminToken)
+	AssertErrorResponse(s.T(), resp, http.StatusBadRequest, `unknown column "colour" for product`)
+
+	resp = s.importFile("products", "", "application/xml", "<products/>", AdminToken)
+	AssertErrorResponse(s.T(), resp, http.StatusBadRequest, `unsupported format "application/xml"`)
+
+	resp = s.importFile("products", "", "text/csv", "name,price\nLamp,25\n", UserToken)
+	AssertErrorResponse(s.T(), resp, http.StatusForbidden, "access denied")
+}
+
+func (s *TransferAPITestSuite) TestExportsCSVWithFilter() {
+	resp := s.export("products", "where=price+>+100", AdminToken)
+
+	resp.Status(http.StatusOK)
+	resp.Header("Content-Type").IsEqual("text/csv; charset=utf-8")
+	resp.Header("Content-Disposition").HasPrefix(`attachment; filename="products-`)
+
+	lines := strings.Split(strings.TrimSpace(resp.Body().Raw()), "\n")
+	s.Require().Len(lines, 2)
+	s.Equal("id,name,price,description,created_at,updated_at", lines[0])
+	s.True(strings.HasPrefix(lines[1], "1,Laptop,999.99,"), lines[1])
+}
+
+func (s *TransferAPITestSuite) TestExportsJSONLinesForReimport() {
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest(`create order json:{"user_id":"2","items":[{"product_id":"2","quantity":2}]}`, AdminToken))
+
+	resp := s.export("orders", "format=jsonl", AdminToken)
+	resp.Status(http.StatusOK)
+	resp.Header("Content-Type").IsEqual("application/x-ndjson")
+	body := resp.Body().Raw()
+	s.Contains(body, `"items":[{"product_id":"2","quantity":2}]`)
+
+	obj := AssertSuccessResponse(s.T(), s.importFile("orders", "format=jsonl", "", body, AdminToken))
+	obj.Value("result").Object().Value("imported").IsEqual(1)
+}
+
+func (s *TransferAPITestSuite) TestRejectsInvalidExports() {
+	AssertErrorResponse(s.T(), s.export("products", "format=xlsx", AdminToken), http.StatusBadRequest, `unsupported format "xlsx"`)
+	AssertErrorResponse(s.T(), s.export("orders", "where=items+%3D+x", AdminToken), http.StatusBadRequest, `order cannot be filtered by "items"`)
+	AssertErrorResponse(s.T(), s.export("orders", "where=total", AdminToken), http.StatusBadRequest, "invalid condition")
+	AssertErrorResponse(s.T(), s.export("users", "", GuestToken), http.StatusForbidden, "access denied")
+}
+
+func TestTransferAPITestSuite(t *testing.T) {
+	suite.Run(t, new(TransferAPITestSuite))
+}
@@ -0,0 +1,114 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type WebhookAPITestSuite struct {
+	suite.Suite
+	testApp  *TestApp
+	receiver *httptest.Server
+	received atomic.Int32
+}
+
+func (s *WebhookAPITestSuite) SetupTest() {
+	s.testApp = NewTestApp(s.T())
+	s.received.Store(0)
+	s.receiver = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.received.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+func (s *WebhookAPITestSuite) TearDownTest() {
+	s.receiver.Close()
+}
+
+func (s *WebhookAPITestSuite) createWebhook() int {
+	resp := s.testApp.Client.POST("/webhooks").
+		WithHeader("Authorization", "Bearer "+AdminToken).
+		WithJSON(map[string]interface{}{
+			"url":     s.receiver.URL,
+			"entity":  "order",
+			"actions": []string{"create"},
+			"secret":  "s3cret",
+		}).
+		Expect().
+		Status(http.StatusCreated)
+
+	result := resp.JSON().Object().Value("result").Object()
+	result.Value("secret").String().IsEqual("s3cret")
+	return int(result.Value("id").Number().Raw())
+}
+
+func (s *WebhookAPITestSuite) TestOrderCreateIsDeliveredAndRedeliverable() {
+	id := s.createWebhook()
+
+	AssertSuccessResponse(s.T(), s.testApp.PostRequest(TestQueries.CreateOrder, UserToken))
+	s.Eventually(func() bool { return s.received.Load() == 1 }, 2*time.Second, 5*time.Millisecond)
+
+	deliveries := s.testApp.Client.GET("/webhooks/{id}/deliveries", id).
+		WithHeader("Authorization", "Bearer "+AdminToken).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("result").Array()
+	deliveries.Length().IsEqual(1)
+	deliveryID := int(deliveries.Value(0).Object().Value("id").Number().Raw())
+
+	s.Eventually(func() bool {
+		resp := s.testApp.Client.GET("/webhooks/{id}/deliveries/{deliveryId}", id, deliveryID).
+			WithHeader("Authorization", "Bearer "+AdminToken).
+			Expect().
+			Status(http.StatusOK).
+			JSON().Object().Value("result").Object()
+		return resp.Value("status").String().Raw() == "delivered"
+	}, 2*time.Second, 5*time.Millisecond)
+
+	s.testApp.Client.POST("/webhooks/{id}/deliveries/{deliveryId}/redeliver", id, deliveryID).
+		WithHeader("Authorization", "Bearer "+AdminToken).
+		Expect().
+		Status(http.StatusAccepted)
+	s.Eventually(func() bool { return s.received.Load() == 2 }, 2*time.Second, 5*time.Millisecond)
+}
+
+func (s *WebhookAPITestSuite) TestListDoesNotExposeSecret() {
+	s.createWebhook()
+
+	s.testApp.Client.GET("/webhooks").
+		WithHeader("Authorization", "Bearer "+AdminToken).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("result").Array().Value(0).Object().NotContainsKey("secret")
+}
+
+func (s *WebhookAPITestSuite) TestNonAdminIsForbidden() {
+	s.testApp.Client.GET("/webhooks").
+		WithHeader("Authorization", "Bearer "+UserToken).
+		Expect().
+		Status(http.StatusForbidden)
+}
+
+func (s *WebhookAPITestSuite) TestMissingTokenIsUnauthorized() {
+	s.testApp.Client.GET("/webhooks").
+		Expect().
+		Status(http.StatusUnauthorized)
+}
+
+func (s *WebhookAPITestSuite) TestUnknownDeliveryIsNotFound() {
+	id := s.createWebhook()
+
+	s.testApp.Client.POST("/webhooks/{id}/deliveries/{deliveryId}/redeliver", id, 999).
+		WithHeader("Authorization", "Bearer "+AdminToken).
+		Expect().
+		Status(http.StatusNotFound)
+}
+
+func TestWebhookAPITestSuite(t *testing.T) {
+	suite.Run(t, new(WebhookAPITestSuite))
+}